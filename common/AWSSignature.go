@@ -0,0 +1,111 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWSCredentials are the access key, and the session token of temporary credentials, requests to AWS are signed with.
+type AWSCredentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// SignAWSRequest adds the AWS Signature Version 4 headers to request for service in region. Unless the request
+// already carries the hash of its payload in x-amz-content-sha256, the payload is not signed.
+func SignAWSRequest(request *http.Request, credentials AWSCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + region + "/" + service + "/aws4_request"
+
+	payloadHash := request.Header.Get("x-amz-content-sha256")
+	if payloadHash == "" {
+		payloadHash = "UNSIGNED-PAYLOAD"
+	}
+
+	request.Header.Set("x-amz-date", amzDate)
+	request.Header.Set("x-amz-content-sha256", payloadHash)
+	if credentials.SessionToken != "" {
+		request.Header.Set("x-amz-security-token", credentials.SessionToken)
+	}
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name := range request.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(request.Header.Get(name))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		canonicalQuery(request.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := []byte("AWS4" + credentials.SecretKey)
+	for _, part := range []string{amzDate[:8], region, service, "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+credentials.AccessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// canonicalQuery encodes query sorted by name and then value, with every parameter written as name=value, as
+// Signature Version 4 requires.
+func canonicalQuery(query url.Values) string {
+	var parameters [][2]string
+	for name, values := range query {
+		for _, value := range values {
+			parameters = append(parameters, [2]string{awsEscape(name), awsEscape(value)})
+		}
+	}
+	sort.Slice(parameters, func(i, j int) bool {
+		if parameters[i][0] != parameters[j][0] {
+			return parameters[i][0] < parameters[j][0]
+		}
+		return parameters[i][1] < parameters[j][1]
+	})
+
+	encoded := make([]string, len(parameters))
+	for i, parameter := range parameters {
+		encoded[i] = parameter[0] + "=" + parameter[1]
+	}
+
+	return strings.Join(encoded, "&")
+}
+
+// awsEscape percent-encodes everything but the unreserved characters A-Z, a-z, 0-9, '-', '.', '_' and '~'.
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,182 @@
+package common
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/mholt/archiver/v4"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+)
+
+// An archive built with ArchiveOptions.StoreCompressed is an uncompressed tar holding two archives: a plain tar
+// of the files that are already compressed, followed by a tar compressed with the selected codec holding
+// everything else. The stored archive always comes first, so its name at the start of the stream identifies
+// the layout when extracting.
+const (
+	storedArchiveName     = ".exepy-stored.tar"
+	compressedArchiveName = ".exepy-compressed.tar"
+)
+
+// compressedExtensions lists file types that are compressed already and gain nothing from compressing again.
+var compressedExtensions = map[string]bool{
+	".whl": true, ".zip": true, ".jar": true, ".egg": true, ".nupkg": true,
+	".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".lz4": true, ".7z": true, ".rar": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true,
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".opus": true, ".flac": true,
+	".mp4": true, ".m4v": true, ".mkv": true, ".webm": true, ".mov": true, ".avi": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".ods": true,
+}
+
+const (
+	// entropySampleSize is how much of a file with an unknown extension is sampled to judge if it is compressed.
+	entropySampleSize = 64 * 1024
+	// compressedEntropy is the entropy, in bits per byte, above which a sample is considered compressed.
+	compressedEntropy = 7.5
+)
+
+// isCompressedFile reports whether file is already compressed, judging by its extension or, for larger files of
+// other types, by the entropy of its first bytes.
+func isCompressedFile(file archiver.File) bool {
+	if compressedExtensions[strings.ToLower(path.Ext(file.NameInArchive))] {
+		return true
+	}
+
+	if file.Size() < entropySampleSize {
+		return false
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		return false
+	}
+	defer reader.Close()
+
+	sample := make([]byte, entropySampleSize)
+	if _, err := io.ReadFull(reader, sample); err != nil {
+		return false
+	}
+
+	return byteEntropy(sample) > compressedEntropy
+}
+
+// byteEntropy returns the Shannon entropy of data in bits per byte.
+func byteEntropy(data []byte) float64 {
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+
+	entropy := 0.0
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(len(data))
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy
+}
+
+// writeAdaptiveArchive writes files to output as a stored archive of the compressed files and an archive of the
+// rest compressed with format.
+func writeAdaptiveArchive(ctx context.Context, output io.Writer, format archiver.CompressedArchive, files []archiver.File) error {
+	var stored, compressed []archiver.File
+	for _, file := range files {
+		if !file.IsDir() && file.Mode().IsRegular() && isCompressedFile(file) {
+			stored = append(stored, file)
+		} else {
+			compressed = append(compressed, file)
+		}
+	}
+
+	tw := tar.NewWriter(output)
+
+	if err := writeNestedArchive(ctx, tw, storedArchiveName, archiver.CompressedArchive{Archival: archiver.Tar{}}, stored); err != nil {
+		return err
+	}
+
+	if err := writeNestedArchive(ctx, tw, compressedArchiveName, format, compressed); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// writeNestedArchive archives files with format to a temporary file and adds it to tw as name.
+func writeNestedArchive(ctx context.Context, tw *tar.Writer, name string, format archiver.CompressedArchive, files []archiver.File) error {
+	spool, err := os.CreateTemp("", "exepy-*"+name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	if err := format.Archive(ctx, spool, files); err != nil {
+		return err
+	}
+
+	size, err := spool.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, spool)
+	return err
+}
+
+// extractArchive extracts an archive written by CompressDirToStreamWithOptions, with or without
+// ArchiveOptions.StoreCompressed, calling handler for each file.
+func extractArchive(ctx context.Context, format archiver.CompressedArchive, input io.Reader, handler archiver.FileHandler) error {
+	buffered := bufio.NewReader(input)
+
+	if !isAdaptiveArchive(buffered) {
+		return format.Extract(ctx, buffered, nil, handler)
+	}
+
+	tr := tar.NewReader(buffered)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch header.Name {
+		case storedArchiveName:
+			err = archiver.Tar{}.Extract(ctx, tr, nil, handler)
+		case compressedArchiveName:
+			err = format.Extract(ctx, tr, nil, handler)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// isAdaptiveArchive reports whether the stream starts with the stored archive of an adaptive archive.
+func isAdaptiveArchive(input *bufio.Reader) bool {
+	block, err := input.Peek(512)
+	if err != nil {
+		return false
+	}
+
+	header, err := tar.NewReader(bytes.NewReader(block)).Next()
+	return err == nil && header.Name == storedArchiveName
+}
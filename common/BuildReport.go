@@ -0,0 +1,89 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// BuildReportFilename is written next to the built installer.
+const BuildReportFilename = "build-report.json"
+
+// BuildReport describes a finished build, so release tooling can track the size and build time of installers
+// and fail builds that exceed their budgets.
+type BuildReport struct {
+	Installer       string                      `json:"installer"`
+	InstallerSize   int64                       `json:"installerSize"`
+	DurationSeconds float64                     `json:"durationSeconds"`
+	Attachments     map[string]AttachmentReport `json:"attachments"`
+	CreatedAt       time.Time                   `json:"createdAt"`
+	ToolVersion     string                      `json:"toolVersion"`
+}
+
+// AttachmentReport describes one embedded attachment of a build.
+type AttachmentReport struct {
+	// Size is the embedded size in bytes.
+	Size int64 `json:"size"`
+	// UncompressedSize is the total size of the files in an archive, or 0 if it is unknown.
+	UncompressedSize int64 `json:"uncompressedSize,omitempty"`
+	// Files is the number of files in an archive built from a directory, or 0 if it is unknown.
+	Files int `json:"files,omitempty"`
+}
+
+// BuildLimits are the budgets a build must stay within. Zero values are not checked.
+type BuildLimits struct {
+	MaxInstallerSizeMB float64 `json:"maxInstallerSizeMB,omitempty"`
+	// MaxAttachmentSizeMB limits the embedded size of attachments by name, such as payload or wheels.
+	MaxAttachmentSizeMB map[string]float64 `json:"maxAttachmentSizeMB,omitempty"`
+	MaxPayloadFiles     int                `json:"maxPayloadFiles,omitempty"`
+	MaxDurationSeconds  float64            `json:"maxDurationSeconds,omitempty"`
+}
+
+// Check returns an error describing every limit the build in report exceeds, or nil if it is within all of them.
+func (limits BuildLimits) Check(report BuildReport) error {
+	var violations []error
+
+	if limits.MaxInstallerSizeMB > 0 && megabytes(report.InstallerSize) > limits.MaxInstallerSizeMB {
+		violations = append(violations, fmt.Errorf("installer is %.1f MB, over the limit of %.1f MB", megabytes(report.InstallerSize), limits.MaxInstallerSizeMB))
+	}
+
+	names := make([]string, 0, len(limits.MaxAttachmentSizeMB))
+	for name := range limits.MaxAttachmentSizeMB {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		limit := limits.MaxAttachmentSizeMB[name]
+		if attachment, ok := report.Attachments[name]; ok && limit > 0 && megabytes(attachment.Size) > limit {
+			violations = append(violations, fmt.Errorf("%s is %.1f MB, over the limit of %.1f MB", name, megabytes(attachment.Size), limit))
+		}
+	}
+
+	if payload := report.Attachments[PayloadFilename]; limits.MaxPayloadFiles > 0 && payload.Files > limits.MaxPayloadFiles {
+		violations = append(violations, fmt.Errorf("payload has %d files, over the limit of %d", payload.Files, limits.MaxPayloadFiles))
+	}
+
+	if limits.MaxDurationSeconds > 0 && report.DurationSeconds > limits.MaxDurationSeconds {
+		violations = append(violations, fmt.Errorf("build took %.0f seconds, over the limit of %.0f seconds", report.DurationSeconds, limits.MaxDurationSeconds))
+	}
+
+	return errors.Join(violations...)
+}
+
+// SaveBuildReport writes report as indented JSON to filename.
+func SaveBuildReport(filename string, report BuildReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, data, 0644)
+}
+
+func megabytes(size int64) float64 {
+	return float64(size) / (1024 * 1024)
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// exitCodeInterrupted is the conventional exit code of a process stopped by Ctrl+C.
+const exitCodeInterrupted = 130
+
+var (
+	cleanupMu     sync.Mutex
+	cleanupNextID int
+	cleanups      = map[int]func(){}
+)
+
+// AddCleanup registers fn to be run by RunCleanups, so temporary files are removed even if the run fails or is
+// interrupted. The returned function unregisters fn once its owner has cleaned up normally.
+func AddCleanup(fn func()) (remove func()) {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+
+	id := cleanupNextID
+	cleanupNextID++
+	cleanups[id] = fn
+
+	return func() {
+		cleanupMu.Lock()
+		defer cleanupMu.Unlock()
+		delete(cleanups, id)
+	}
+}
+
+// RunCleanups runs every registered cleanup that has not been removed, most recently registered first.
+func RunCleanups() {
+	cleanupMu.Lock()
+	pending := make([]func(), 0, len(cleanups))
+	for id := cleanupNextID - 1; id >= 0; id-- {
+		if fn, ok := cleanups[id]; ok {
+			pending = append(pending, fn)
+			delete(cleanups, id)
+		}
+	}
+	cleanupMu.Unlock()
+
+	for _, fn := range pending {
+		fn()
+	}
+}
+
+// CleanupOnInterrupt runs the registered cleanups and exits when the process is interrupted.
+func CleanupOnInterrupt() {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+
+	go func() {
+		<-interrupts
+		fmt.Println("Interrupted. Removing temporary files...")
+		RunCleanups()
+		os.Exit(exitCodeInterrupted)
+	}()
+}
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"fmt"
+	"github.com/mholt/archiver/v4"
+	"slices"
+	"sync"
+)
+
+// CompressorFactory returns a new compression codec for archives. The codec compresses the tar stream when
+// the creator builds an archive and decompresses it when the bootstrap extracts one, so both must be built
+// with the same codecs registered.
+type CompressorFactory func() archiver.Compression
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]CompressorFactory{
+		CompressionBz2:  func() archiver.Compression { return archiver.Bz2{} },
+		CompressionZstd: func() archiver.Compression { return archiver.Zstd{} },
+		CompressionXz:   func() archiver.Compression { return archiver.Xz{} },
+		CompressionGzip: func() archiver.Compression { return archiver.Gz{} },
+	}
+)
+
+// RegisterCompressor makes a compression codec available under name, which can then be selected with the
+// compressionFormat setting and is recorded in attachment metadata. Register codecs from an init function so
+// they are available before settings are read. It panics if name is empty or already registered, or if
+// factory is nil.
+func RegisterCompressor(name string, factory CompressorFactory) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+
+	if name == "" {
+		panic("common: RegisterCompressor name is empty")
+	}
+	if factory == nil {
+		panic("common: RegisterCompressor factory is nil for " + name)
+	}
+	if _, exists := compressors[name]; exists {
+		panic("common: RegisterCompressor called twice for " + name)
+	}
+
+	compressors[name] = factory
+}
+
+// CompressionFormats returns the names of the registered compression codecs, sorted.
+func CompressionFormats() []string {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+
+	names := make([]string, 0, len(compressors))
+	for name := range compressors {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// newCompressor returns a new codec for the compression format name.
+func newCompressor(name string) (archiver.Compression, error) {
+	compressorsMu.RLock()
+	factory, ok := compressors[name]
+	compressorsMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported compression format: %s (registered: %v)", name, CompressionFormats())
+	}
+
+	return factory(), nil
+}
@@ -0,0 +1,76 @@
+package common
+
+import "strings"
+
+// quoteCreateProcessArg quotes s so that CommandLineToArgvW and the Microsoft C runtime parse it back
+// as a single argument. Arguments without whitespace or quotes are returned unchanged. Programs started with
+// os/exec are quoted by Go itself; this is the base of QuoteCmdArg.
+func quoteCreateProcessArg(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\v\"") {
+		return s
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+
+	backslashes := 0
+	for _, r := range s {
+		switch r {
+		case '\\':
+			backslashes++
+			continue
+		case '"':
+			// backslashes before a quote are escaped, and so is the quote itself
+			b.WriteString(strings.Repeat(`\`, backslashes*2+1))
+		default:
+			b.WriteString(strings.Repeat(`\`, backslashes))
+		}
+
+		backslashes = 0
+		b.WriteRune(r)
+	}
+
+	// backslashes before the closing quote must be escaped so they do not escape it
+	b.WriteString(strings.Repeat(`\`, backslashes*2))
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// QuoteCmdArg quotes s for a cmd.exe command line. Double quotes protect everything except %, ! and quotes,
+// so when any of those are present every cmd.exe metacharacter is escaped with ^ as well.
+func QuoteCmdArg(s string) string {
+	quoted := quoteCreateProcessArg(s)
+
+	if !strings.ContainsAny(s, `%!"`) {
+		return quoted
+	}
+
+	var b strings.Builder
+	for _, r := range quoted {
+		if strings.ContainsRune(`()%!^"<>&|`, r) {
+			b.WriteByte('^')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// QuotePowerShellLiteral returns s as a single-quoted PowerShell string, in which nothing is expanded.
+// PowerShell also treats the typographic single quotes as quote characters, so those are doubled too.
+func QuotePowerShellLiteral(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+
+	for _, r := range s {
+		switch r {
+		case '\'', '‘', '’', '‚', '‛':
+			b.WriteRune(r)
+		}
+		b.WriteRune(r)
+	}
+
+	b.WriteByte('\'')
+	return b.String()
+}
@@ -0,0 +1,140 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// splitCreateProcessArgs splits a command line into arguments by the rules of CommandLineToArgvW and the
+// Microsoft C runtime: 2n backslashes before a quote become n and the quote toggles quoting, 2n+1 backslashes
+// before a quote become n and a literal quote, and other backslashes are literal.
+func splitCreateProcessArgs(commandLine string) []string {
+	var args []string
+	var current strings.Builder
+	inQuotes, inArg := false, false
+	backslashes := 0
+
+	for _, r := range commandLine {
+		switch {
+		case r == '\\':
+			backslashes++
+			inArg = true
+			continue
+		case r == '"':
+			current.WriteString(strings.Repeat(`\`, backslashes/2))
+			if backslashes%2 == 1 {
+				current.WriteRune('"')
+			} else {
+				inQuotes = !inQuotes
+			}
+			inArg = true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			current.WriteString(strings.Repeat(`\`, backslashes))
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteString(strings.Repeat(`\`, backslashes))
+			current.WriteRune(r)
+			inArg = true
+		}
+		backslashes = 0
+	}
+
+	current.WriteString(strings.Repeat(`\`, backslashes))
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args
+}
+
+func TestQuoteCreateProcessArg(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{`simple`, `simple`},
+		{``, `""`},
+		{`C:\Python\python.exe`, `C:\Python\python.exe`},
+		{`C:\Program Files\My App (x64)\`, `"C:\Program Files\My App (x64)\\"`},
+		{`C:\Program Files\My App (x64)\main.py`, `"C:\Program Files\My App (x64)\main.py"`},
+		{`C:\Program Files\My App (x64)\\`, `"C:\Program Files\My App (x64)\\\\"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`a\"b`, `"a\\\"b"`},
+		{"tab\there", "\"tab\there\""},
+		{`C:\Users\Zoë\Données\app`, `C:\Users\Zoë\Données\app`},
+		{`C:\Users\Zoë\Mes Données\`, `"C:\Users\Zoë\Mes Données\\"`},
+	}
+
+	for _, test := range tests {
+		if got := quoteCreateProcessArg(test.arg); got != test.want {
+			t.Errorf("quoteCreateProcessArg(%q) = %q, want %q", test.arg, got, test.want)
+		}
+	}
+}
+
+func TestQuoteCreateProcessArgRoundTrip(t *testing.T) {
+	args := []string{
+		`C:\Program Files\My App (x64)\python\python.exe`,
+		`C:\Program Files\My App (x64)\`,
+		`--out=C:\Program Files\My App (x64)\out dir\`,
+		``,
+		`"quoted"`,
+		`trailing\\`,
+		`a b\" c`,
+		`100%`,
+		`Zoë's files`,
+	}
+
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteCreateProcessArg(arg)
+	}
+
+	if got := splitCreateProcessArgs(strings.Join(quoted, " ")); !reflect.DeepEqual(got, args) {
+		t.Errorf("command line %q parses as %q, want %q", strings.Join(quoted, " "), got, args)
+	}
+}
+
+func TestQuoteCmdArg(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{`bootstrap.exe`, `bootstrap.exe`},
+		{`C:\Program Files\My App (x64)\`, `"C:\Program Files\My App (x64)\\"`},
+		{`C:\Program Files\My App (x64)\setup.exe`, `"C:\Program Files\My App (x64)\setup.exe"`},
+		{`100%`, `100^%`},
+		{`C:\My App (x64)\50% off!`, `^"C:\My App ^(x64^)\50^% off^!^"`},
+		{`say "hi"`, `^"say \^"hi\^"^"`},
+	}
+
+	for _, test := range tests {
+		if got := QuoteCmdArg(test.arg); got != test.want {
+			t.Errorf("QuoteCmdArg(%q) = %q, want %q", test.arg, got, test.want)
+		}
+	}
+}
+
+func TestQuotePowerShellLiteral(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{`C:\Program Files\My App (x64)\`, `'C:\Program Files\My App (x64)\'`},
+		{`$env:PATH`, `'$env:PATH'`},
+		{`it's`, `'it''s'`},
+		{`it’s`, `'it’’s'`},
+		{``, `''`},
+	}
+
+	for _, test := range tests {
+		if got := QuotePowerShellLiteral(test.arg); got != test.want {
+			t.Errorf("QuotePowerShellLiteral(%q) = %q, want %q", test.arg, got, test.want)
+		}
+	}
+}
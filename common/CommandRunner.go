@@ -1,17 +1,63 @@
 package common
 
 import (
+	"errors"
 	"os"
 	"os/exec"
 )
 
+// consoleStdout and consoleStderr are the standard output and error the process started with. Commands write to
+// them rather than to os.Stdout and os.Stderr, which bootstrap replaces to copy its own output into the install
+// log, so interactive programs keep a real console.
+var consoleStdout, consoleStderr = os.Stdout, os.Stderr
+
 func RunCommand(command string, args []string) error {
+	cmd := newCommand(command, args)
+
+	println("Running command:", cmd.String())
+	return cmd.Run()
+}
+
+// CommandUsage describes how a command run by RunCommandMeasured ended.
+type CommandUsage struct {
+	ExitCode int
+	// PeakMemory is the peak memory in bytes used by the command, including its children where the
+	// platform allows it, or 0 if it could not be measured.
+	PeakMemory uint64
+}
+
+// RunCommandMeasured runs a command like RunCommand and reports its exit code and peak memory use.
+// A non-zero exit code is reported both in the usage and as the returned error.
+func RunCommandMeasured(command string, args []string) (CommandUsage, error) {
+	cmd := newCommand(command, args)
+
+	println("Running command:", cmd.String())
+
+	peakMemory, err := startMeasured(cmd)
+	if err != nil {
+		return CommandUsage{ExitCode: -1}, err
+	}
+
+	err = cmd.Wait()
+
+	usage := CommandUsage{PeakMemory: peakMemory()}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		usage.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		usage.ExitCode = -1
+	}
+
+	return usage, err
+}
+
+func newCommand(command string, args []string) *exec.Cmd {
 	cmd := exec.Command(command, args...)
 
 	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = consoleStdout
+	cmd.Stderr = consoleStderr
 
-	println("Running command:", cmd.String())
-	return cmd.Run()
+	return cmd
 }
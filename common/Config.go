@@ -3,20 +3,299 @@ package common
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"strings"
 )
 
+// Policies applied by bootstrap when the executable hash differs from the previously accepted hash.
+const (
+	HashPolicyAlwaysPrompt        = "always-prompt"
+	HashPolicyDenyAndExit         = "deny-and-exit"
+	HashPolicyAllowIfSigned       = "allow-if-signed"
+	HashPolicyAllowWithAdminToken = "allow-with-admin-token"
+)
+
+// Policies for payload files that were modified after installation and differ from the installer's version.
+const (
+	ConflictPolicyPrompt    = "prompt"
+	ConflictPolicyKeep      = "keep"
+	ConflictPolicyOverwrite = "overwrite"
+	ConflictPolicyBackup    = "backup"
+)
+
+// Policies for entries of the scripts directory that cannot be archived as regular files, such as symbolic links,
+// junctions, special files, and files that cannot be read.
+const (
+	UnsupportedEntryPolicyError  = "error"
+	UnsupportedEntryPolicyFollow = "follow"
+	UnsupportedEntryPolicySkip   = "skip"
+)
+
+// Modes of the antivirus check before first time setup extracts files.
+const (
+	// AntivirusCheckWarn prints guidance when real-time scanning slows down extraction.
+	AntivirusCheckWarn = "warn"
+	// AntivirusCheckPause also waits for the user to add an exclusion and checks again.
+	AntivirusCheckPause = "pause"
+)
+
+// Capabilities declares what the payload does beyond running inside its installation directory.
+// Bootstrap shows the declaration before first time setup and records that it was accepted.
+type Capabilities struct {
+	Network                 bool   `json:"network,omitempty"`
+	WritesOutsideInstallDir bool   `json:"writesOutsideInstallDir,omitempty"`
+	LaunchesPrograms        bool   `json:"launchesPrograms,omitempty"`
+	Notes                   string `json:"notes,omitempty"`
+}
+
+// Descriptions returns a sentence for each declared capability.
+func (capabilities Capabilities) Descriptions() []string {
+	var descriptions []string
+
+	if capabilities.Network {
+		descriptions = append(descriptions, "access the network")
+	}
+	if capabilities.WritesOutsideInstallDir {
+		descriptions = append(descriptions, "write files outside its installation directory")
+	}
+	if capabilities.LaunchesPrograms {
+		descriptions = append(descriptions, "launch other programs")
+	}
+	if capabilities.Notes != "" {
+		descriptions = append(descriptions, capabilities.Notes)
+	}
+
+	return descriptions
+}
+
+// ContentPolicy lists files that must not be packaged. The creator checks the scripts and recovery directories
+// against it before building and fails with a report of every file that breaks a rule.
+type ContentPolicy struct {
+	// Deny lists glob patterns matched against the name and the relative path of every file.
+	Deny []string `json:"deny,omitempty"`
+	// Allow lists glob patterns of files that are packaged even if they match Deny.
+	Allow []string `json:"allow,omitempty"`
+	// MaxFileSizeMB rejects files larger than this many megabytes. 0 allows files of any size.
+	MaxFileSizeMB int64 `json:"maxFileSizeMB,omitempty"`
+	// AllowAbsolutePaths stops configuration files from being checked for absolute paths.
+	AllowAbsolutePaths bool `json:"allowAbsolutePaths,omitempty"`
+}
+
+// DefaultContentPolicy is applied when settings do not set a content policy. It keeps credentials and keys out
+// of installers.
+var DefaultContentPolicy = ContentPolicy{
+	Deny: []string{".env", ".env.*", "*.pem", "*.key", "*.pfx", "*.p12", "id_rsa*", "id_ed25519*", ".netrc", ".pypirc", ".git"},
+}
+
+// Secret is a value the payload needs, such as an API key, that is not embedded in the installer. Bootstrap asks
+// for it at install time and passes it to the setup and main scripts as the environment variable Name.
+type Secret struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Optional secrets may be left empty.
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Stages at which a plugin runs.
+const (
+	PluginPreInstall  = "pre-install"
+	PluginOnFailure   = "on-failure"
+	PluginOnUninstall = "on-uninstall"
+)
+
+// Plugin is an auxiliary executable or script embedded in the installer and run by bootstrap at a stage of the
+// installation, so installers can be customised without changing the stub.
+type Plugin struct {
+	// Name identifies the plugin and its attachment.
+	Name string `json:"name"`
+	// Path is the file embedded at build time. Its extension is kept when the plugin is extracted.
+	Path string `json:"path"`
+	// When is the stage the plugin runs at: pre-install, on-failure or on-uninstall.
+	When string   `json:"when"`
+	Args []string `json:"args,omitempty"`
+}
+
+// Parameter value types.
+const (
+	ParameterString = "string"
+	ParameterInt    = "int"
+	ParameterBool   = "bool"
+	ParameterPath   = "path"
+	ParameterChoice = "choice"
+)
+
+// Stages at which a parameter is asked for.
+const (
+	ParameterWhenInstall = "install"
+	ParameterWhenRun     = "run"
+)
+
+// Parameter is an input the payload takes, such as an input folder, that bootstrap asks for or accepts with
+// --param NAME=value, and passes to the payload as an argument or environment variable.
+type Parameter struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Type is string (the default), int, bool, path (an existing file or directory) or choice (one of Choices).
+	Type    string   `json:"type,omitempty"`
+	Choices []string `json:"choices,omitempty"`
+	// Pattern is a regular expression that string values must match as a whole.
+	Pattern string `json:"pattern,omitempty"`
+	// Default is offered when asking, and used when nobody answers.
+	Default string `json:"default,omitempty"`
+	// Optional parameters may be left empty, and are then not passed.
+	Optional bool `json:"optional,omitempty"`
+	// When is run (the default) to ask at every launch, or install to ask once during first time setup and keep
+	// the answer.
+	When string `json:"when,omitempty"`
+	// Arg is the argument the value is passed to the main script after, such as --input. Bool parameters pass
+	// only Arg, when true.
+	Arg string `json:"arg,omitempty"`
+	// Env is the environment variable the value is set as. Without Arg or Env, it is set as Name.
+	Env string `json:"env,omitempty"`
+}
+
+// Notification channel types.
+const (
+	NotificationToast   = "toast"
+	NotificationWebhook = "webhook"
+	NotificationSlack   = "slack"
+)
+
+// Notification is a channel told when first time setup finishes, for long installs users start and walk away from.
+type Notification struct {
+	// Type is toast for a Windows toast notification, webhook for a JSON POST of the result, or slack for a
+	// message to a Slack incoming webhook.
+	Type string `json:"type"`
+	// URL is the endpoint of webhook and slack notifications.
+	URL string `json:"url,omitempty"`
+	// OnlyOnFailure skips the notification when setup succeeds.
+	OnlyOnFailure bool `json:"onlyOnFailure,omitempty"`
+	// MinDurationSeconds skips the notification when setup finished sooner.
+	MinDurationSeconds int `json:"minDurationSeconds,omitempty"`
+}
+
+// OverrideConfig points bootstrap at an organization-level override document, fetched at install time and applied
+// over the embedded settings. The document must be signed by a key of the trust store holding the overrides role.
+type OverrideConfig struct {
+	// Location is an http(s) URL or a file path, including UNC paths, of the signed override document.
+	Location string `json:"location"`
+	// Required stops the installation when the document cannot be fetched or verified, instead of continuing
+	// with the embedded settings.
+	Required bool `json:"required,omitempty"`
+}
+
+// ScriptUpdateConfig points installations at a channel of scripts-only updates, fetched when the installed
+// product is launched and applied without touching Python or the wheels.
+type ScriptUpdateConfig struct {
+	// Location is an http(s) URL or a file path, including UNC paths, of the latest script update bundle.
+	Location string `json:"location"`
+	// AllowDowngrade applies updates with a lower version than the update already applied. Otherwise they are
+	// skipped, so an old signed bundle cannot roll back a fix.
+	AllowDowngrade bool `json:"allowDowngrade,omitempty"`
+	// SmokeTest is the arguments to run the installed Python with once an update is in place, such as
+	// ["main.py", "--self-test"]. The update is rolled back if it exits with an error.
+	SmokeTest []string `json:"smokeTest,omitempty"`
+}
+
+// Signing configures the Authenticode signing of the built installer. Embedding the attachments invalidates any
+// signature of the stub, so the finished installer is signed as the last build step.
+type Signing struct {
+	// Tool is signtool (the default) or an osslsigncode-compatible signer, by name or path.
+	Tool string `json:"tool,omitempty"`
+	// CertificateThumbprint selects a certificate from the Windows certificate store. signtool only.
+	CertificateThumbprint string `json:"certificateThumbprint,omitempty"`
+	// CertificateFile is a PKCS#12 (.pfx) file holding the certificate and its private key.
+	CertificateFile string `json:"certificateFile,omitempty"`
+	// PasswordEnv names the environment variable holding the password of CertificateFile.
+	PasswordEnv string `json:"passwordEnv,omitempty"`
+	// TimestampURL is an RFC 3161 timestamp server, so the signature outlives the certificate.
+	TimestampURL string `json:"timestampURL,omitempty"`
+	// Args are passed to the signer before the file to sign.
+	Args []string `json:"args,omitempty"`
+	// CSP is the cryptographic service provider holding KeyContainer. signtool only.
+	CSP string `json:"csp,omitempty"`
+	// KeyContainer is the private key container to sign with, with CertificateFile holding the certificate
+	// without its key, such as a hardware token's. signtool only.
+	KeyContainer string `json:"keyContainer,omitempty"`
+}
+
+// Prerequisite is an external installer run during first time setup, before Python packages are installed.
+type Prerequisite struct {
+	Name         string   `json:"name"`
+	Path         string   `json:"path"`
+	Args         []string `json:"args,omitempty"`
+	ExitCodes    []int    `json:"exitCodes,omitempty"`
+	SkipIfExists string   `json:"skipIfExists,omitempty"`
+}
+
+// AttachmentSource supplies a prebuilt attachment instead of preparing it locally.
+// Location is an http(s) URL, a local file path, or "-" for standard input.
+type AttachmentSource struct {
+	Location string `json:"location"`
+	Checksum string `json:"checksum"`
+}
+
 type PythonSetupSettings struct {
-	PythonDownloadURL string `json:"pythonDownloadURL"`
-	PipDownloadURL    string `json:"pipDownloadURL"`
-	PythonDownloadZip string `json:"pythonDownloadFile"`
-	PythonExtractDir  string `json:"pythonExtractDir"`
-	PthFile           string `json:"pthFile"`
-	PythonInteriorZip string `json:"pythonInteriorZip"`
-	RequirementsFile  string `json:"requirementsFile"`
-	ScriptDir         string `json:"scriptDir"`
-	SetupScript       string `json:"setupScript"`
-	MainScript        string `json:"mainScript"`
+	PythonDownloadURL      string                      `json:"pythonDownloadURL"`
+	PipDownloadURL         string                      `json:"pipDownloadURL"`
+	PythonDownloadZip      string                      `json:"pythonDownloadFile"`
+	PythonExtractDir       string                      `json:"pythonExtractDir"`
+	PthFile                string                      `json:"pthFile"`
+	PythonInteriorZip      string                      `json:"pythonInteriorZip"`
+	RequirementsFile       string                      `json:"requirementsFile"`
+	ScriptDir              string                      `json:"scriptDir"`
+	SetupScript            string                      `json:"setupScript"`
+	MainScript             string                      `json:"mainScript"`
+	MainScriptArgs         []string                    `json:"mainScriptArgs,omitempty"`
+	Environment            map[string]string           `json:"environment,omitempty"`
+	RecoveryScriptDir      string                      `json:"recoveryScriptDir,omitempty"`
+	RecoveryScript         string                      `json:"recoveryScript,omitempty"`
+	PowerShellModule       string                      `json:"powerShellModule,omitempty"`
+	ProductName            string                      `json:"productName,omitempty"`
+	InstallLockTimeout     int                         `json:"installLockTimeout,omitempty"`
+	Prerequisites          []Prerequisite              `json:"prerequisites,omitempty"`
+	HashChangePolicy       string                      `json:"hashChangePolicy,omitempty"`
+	AdminTokenHash         string                      `json:"adminTokenHash,omitempty"`
+	AttachmentSources      map[string]AttachmentSource `json:"attachmentSources,omitempty"`
+	ConflictPolicy         string                      `json:"conflictPolicy,omitempty"`
+	BackupRetention        int                         `json:"backupRetention,omitempty"`
+	UsageLog               string                      `json:"usageLog,omitempty"`
+	RuntimeComponents      []string                    `json:"runtimeComponents,omitempty"`
+	ComponentSourceDir     string                      `json:"componentSourceDir,omitempty"`
+	CompressionFormat      string                      `json:"compressionFormat,omitempty"`
+	EventLogSource         string                      `json:"eventLogSource,omitempty"`
+	TempDir                string                      `json:"tempDir,omitempty"`
+	UnsupportedEntryPolicy string                      `json:"unsupportedEntryPolicy,omitempty"`
+	Capabilities           *Capabilities               `json:"capabilities,omitempty"`
+	BuildCacheDir          string                      `json:"buildCacheDir,omitempty"`
+	PythonVersion          string                      `json:"pythonVersion,omitempty"`
+	PythonChecksum         string                      `json:"pythonChecksum,omitempty"`
+	IconFile               string                      `json:"iconFile,omitempty"`
+	FileVersion            string                      `json:"fileVersion,omitempty"`
+	CompanyName            string                      `json:"companyName,omitempty"`
+	LicenseFile            string                      `json:"licenseFile,omitempty"`
+	LicenseEndpoint        string                      `json:"licenseEndpoint,omitempty"`
+	Signing                *Signing                    `json:"signing,omitempty"`
+	StoreCompressedFiles   bool                        `json:"storeCompressedFiles,omitempty"`
+	ContentPolicy          *ContentPolicy              `json:"contentPolicy,omitempty"`
+	Secrets                []Secret                    `json:"secrets,omitempty"`
+	InstallLog             string                      `json:"installLog,omitempty"`
+	Plugins                []Plugin                    `json:"plugins,omitempty"`
+	DirectWheelInstall     bool                        `json:"directWheelInstall,omitempty"`
+	HardenPermissions      bool                        `json:"hardenPermissions,omitempty"`
+	OverrideConfig         *OverrideConfig             `json:"overrideConfig,omitempty"`
+	TrustStore             *TrustStore                 `json:"trustStore,omitempty"`
+	BuildLimits            *BuildLimits                `json:"buildLimits,omitempty"`
+	Notifications          []Notification              `json:"notifications,omitempty"`
+	PreserveAttributes     bool                        `json:"preserveAttributes,omitempty"`
+	AntivirusCheck         string                      `json:"antivirusCheck,omitempty"`
+	DiscardModTimes        bool                        `json:"discardModTimes,omitempty"`
+	ScriptUpdates          *ScriptUpdateConfig         `json:"scriptUpdates,omitempty"`
+	Portable               bool                        `json:"portable,omitempty"`
+	Parameters             []Parameter                 `json:"parameters,omitempty"`
+	TrustedSigners         []string                    `json:"trustedSigners,omitempty"`
 }
 
 func loadSettings(filename string) (*PythonSetupSettings, error) {
@@ -34,6 +313,35 @@ func loadSettings(filename string) (*PythonSetupSettings, error) {
 	return &settings, nil
 }
 
+// CheckPythonExtractDir returns an error unless dir names a directory below the installation directory. First time
+// setup replaces that directory, so an empty name, ".", or a path outside the installation would replace the
+// installation itself, or something else entirely.
+func CheckPythonExtractDir(dir string) error {
+	clean := filepath.Clean(filepath.FromSlash(dir))
+	if dir == "" || clean == "." || filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" || strings.HasPrefix(clean, string(filepath.Separator)) ||
+		clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("pythonExtractDir must name a folder inside the installation directory, not %q", dir)
+	}
+	return nil
+}
+
+// DecodeSettings decodes the settings embedded in an installer and fills in the settings derived from
+// pythonVersion, which installers built before the creator embedded its resolved settings leave unset.
+func DecodeSettings(data []byte) (PythonSetupSettings, error) {
+	var settings PythonSetupSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return settings, err
+	}
+
+	err := ApplyPythonVersion(&settings)
+	return settings, err
+}
+
+// EncodeSettings encodes settings for embedding in an installer.
+func EncodeSettings(settings *PythonSetupSettings) ([]byte, error) {
+	return json.MarshalIndent(settings, "", "  ")
+}
+
 func saveSettings(filename string, settings *PythonSetupSettings) error {
 	data, err := json.MarshalIndent(settings, "", "  ")
 	if err != nil {
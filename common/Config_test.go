@@ -0,0 +1,53 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestDecodeSettingsAppliesPythonVersion(t *testing.T) {
+	// an installer built from a settings file that only names the Python version
+	raw := []byte(`{"pythonVersion": "3.12.4", "scriptDir": "scripts", "mainScript": "main.py"}`)
+
+	settings, err := DecodeSettings(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.PythonExtractDir != "python" || settings.PthFile != "python312._pth" || settings.PythonInteriorZip != "python312.zip" {
+		t.Errorf("DecodeSettings() = %+v, want the layout of Python 3.12", settings)
+	}
+
+	// the creator embeds the resolved settings, which must decode to the same settings
+	encoded, err := EncodeSettings(&settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeSettings(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.PythonExtractDir != settings.PythonExtractDir || decoded.PythonDownloadURL != settings.PythonDownloadURL || decoded.PipDownloadURL != settings.PipDownloadURL || decoded.MainScript != "main.py" {
+		t.Errorf("round trip = %+v, want %+v", decoded, settings)
+	}
+}
+
+func TestDecodeSettingsRejectsInvalidVersion(t *testing.T) {
+	if _, err := DecodeSettings([]byte(`{"pythonVersion": "3.12"}`)); err == nil {
+		t.Errorf("DecodeSettings() accepted a pythonVersion without a patch version")
+	}
+}
+
+func TestCheckPythonExtractDir(t *testing.T) {
+	valid := []string{"python", "python-embed", "runtime/python", "./python"}
+	for _, dir := range valid {
+		if err := CheckPythonExtractDir(dir); err != nil {
+			t.Errorf("CheckPythonExtractDir(%q) = %v", dir, err)
+		}
+	}
+
+	invalid := []string{"", ".", "./", "python/..", "..", "../python", "/opt/python"}
+	for _, dir := range invalid {
+		if err := CheckPythonExtractDir(dir); err == nil {
+			t.Errorf("CheckPythonExtractDir(%q) accepted it", dir)
+		}
+	}
+}
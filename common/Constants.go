@@ -6,13 +6,26 @@ const PythonFilename = "python"
 const PayloadFilename = "payload"
 const WheelsFilename = "wheels"
 const HashesEmbedName = "hashes"
+const RecoveryFilename = "recovery"
+const MetadataEmbedName = "metadata"
+const LicenseEmbedName = "license"
+
+const pluginEmbedPrefix = "plugin-"
 
 const pipFilename = "pip.pyz"
 
+// Version identifies the exepy build. Release builds set it with -ldflags "-X lukasolson.net/common.Version=<version>".
+var Version = "dev"
+
 func GetConfigEmbedName() string {
 	return "settings.json"
 }
 
+// PluginEmbedName returns the name of the attachment holding the plugin called name.
+func PluginEmbedName(name string) string {
+	return pluginEmbedPrefix + name
+}
+
 func GetPipName(extractDir string) string {
 	return filepath.Join(extractDir, pipFilename)
 }
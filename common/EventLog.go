@@ -0,0 +1,34 @@
+package common
+
+// EventType is the severity of an event log entry.
+type EventType uint16
+
+// Values match the EVENTLOG_*_TYPE constants of the Windows API.
+const (
+	EventError       EventType = 0x0001
+	EventWarning     EventType = 0x0002
+	EventInformation EventType = 0x0004
+)
+
+// EventLog writes entries to the Windows Event Log under a single source.
+// A nil EventLog discards everything written to it.
+type EventLog struct {
+	source string
+	handle uintptr
+}
+
+// Write adds an entry with the given type, event ID and message.
+func (log *EventLog) Write(eventType EventType, eventID uint32, message string) error {
+	if log == nil {
+		return nil
+	}
+	return log.write(eventType, eventID, message)
+}
+
+// Close releases the event source.
+func (log *EventLog) Close() error {
+	if log == nil {
+		return nil
+	}
+	return log.close()
+}
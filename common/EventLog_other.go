@@ -0,0 +1,16 @@
+//go:build !windows
+
+package common
+
+// OpenEventLog returns an EventLog that discards its entries, since the Windows Event Log is not available.
+func OpenEventLog(source string) (*EventLog, error) {
+	return &EventLog{source: source}, nil
+}
+
+func (log *EventLog) write(eventType EventType, eventID uint32, message string) error {
+	return nil
+}
+
+func (log *EventLog) close() error {
+	return nil
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procRegisterEventSourceW  = syscall.NewLazyDLL("advapi32.dll").NewProc("RegisterEventSourceW")
+	procReportEventW          = syscall.NewLazyDLL("advapi32.dll").NewProc("ReportEventW")
+	procDeregisterEventSource = syscall.NewLazyDLL("advapi32.dll").NewProc("DeregisterEventSource")
+)
+
+// OpenEventLog registers source with the Application log of the local machine. Sources that have not been
+// registered under HKLM\SYSTEM\CurrentControlSet\Services\EventLog\Application are still written, but Event
+// Viewer notes that the description is missing before showing the message.
+func OpenEventLog(source string) (*EventLog, error) {
+	sourcePtr, err := syscall.UTF16PtrFromString(source)
+	if err != nil {
+		return nil, err
+	}
+
+	handle, _, err := procRegisterEventSourceW.Call(0, uintptr(unsafe.Pointer(sourcePtr)))
+	if handle == 0 {
+		return nil, err
+	}
+
+	return &EventLog{source: source, handle: handle}, nil
+}
+
+func (log *EventLog) write(eventType EventType, eventID uint32, message string) error {
+	messagePtr, err := syscall.UTF16PtrFromString(message)
+	if err != nil {
+		return err
+	}
+
+	messages := []*uint16{messagePtr}
+
+	ok, _, err := procReportEventW.Call(log.handle, uintptr(eventType), 0, uintptr(eventID), 0,
+		uintptr(len(messages)), 0, uintptr(unsafe.Pointer(&messages[0])), 0)
+	if ok == 0 {
+		return err
+	}
+
+	return nil
+}
+
+func (log *EventLog) close() error {
+	ok, _, err := procDeregisterEventSource.Call(log.handle)
+	if ok == 0 {
+		return err
+	}
+
+	return nil
+}
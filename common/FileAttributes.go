@@ -0,0 +1,66 @@
+package common
+
+import (
+	"archive/tar"
+	"fmt"
+	"github.com/mholt/archiver/v4"
+	"io/fs"
+	"path/filepath"
+)
+
+// Extended attributes and alternate data streams are stored as PAX records of the entry they belong to, so
+// archives that carry them still extract with any tar reader.
+const (
+	// paxXattrPrefix is the record prefix GNU tar and bsdtar use for extended attributes.
+	paxXattrPrefix = "SCHILY.xattr."
+	// paxStreamPrefix prefixes the records holding NTFS alternate data streams, by stream name.
+	paxStreamPrefix = "EXEPY.ads."
+)
+
+// maxAttributeSize is the largest extended attribute or alternate data stream that is archived. Attributes are
+// held in memory while the archive is written and read.
+const maxAttributeSize = 1 << 20
+
+// withFileAttributes attaches the extended attributes or alternate data streams of the files archived from
+// directoryPath to their archive entries, which must have come from clearFileAttributes.
+func withFileAttributes(files []archiver.File, directoryPath string) error {
+	for i, file := range files {
+		if file.Mode()&fs.ModeSymlink != 0 {
+			continue
+		}
+
+		diskPath := filepath.Join(directoryPath, filepath.FromSlash(file.NameInArchive))
+		records, err := readFileAttributes(diskPath)
+		if err != nil {
+			return fmt.Errorf("reading attributes of %s: %w", diskPath, err)
+		}
+
+		for key, value := range records {
+			if len(value) > maxAttributeSize {
+				return fmt.Errorf("attribute %s of %s is larger than %d bytes", key, diskPath, maxAttributeSize)
+			}
+		}
+
+		if info, ok := file.FileInfo.(archivedFileInfo); ok && len(records) > 0 {
+			info.header = &tar.Header{PAXRecords: records}
+			files[i].FileInfo = info
+		}
+	}
+
+	return nil
+}
+
+// restoreFileAttributes writes the extended attributes or alternate data streams recorded in the archive entry
+// described by header to the extracted file at outPath. Records for the other platform are ignored.
+func restoreFileAttributes(header any, outPath string) error {
+	tarHeader, ok := header.(*tar.Header)
+	if !ok || len(tarHeader.PAXRecords) == 0 {
+		return nil
+	}
+
+	if err := writeFileAttributes(outPath, tarHeader.PAXRecords); err != nil {
+		return fmt.Errorf("restoring attributes of %s: %w", outPath, err)
+	}
+
+	return nil
+}
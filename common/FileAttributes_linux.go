@@ -0,0 +1,73 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"syscall"
+)
+
+// userXattrPrefix is the namespace of extended attributes that are archived. The security, system and trusted
+// namespaces belong to the machine and need privileges to set.
+const userXattrPrefix = "user."
+
+// readFileAttributes returns the user extended attributes of path as PAX records.
+func readFileAttributes(path string) (map[string]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, ignoreUnsupportedXattr(err)
+	}
+
+	names := make([]byte, size)
+	size, err = syscall.Listxattr(path, names)
+	if err != nil {
+		return nil, ignoreUnsupportedXattr(err)
+	}
+
+	records := make(map[string]string)
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if !strings.HasPrefix(string(name), userXattrPrefix) {
+			continue
+		}
+
+		valueSize, err := syscall.Getxattr(path, string(name), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		value := make([]byte, valueSize)
+		valueSize, err = syscall.Getxattr(path, string(name), value)
+		if err != nil {
+			return nil, err
+		}
+
+		records[paxXattrPrefix+string(name)] = string(value[:valueSize])
+	}
+
+	return records, nil
+}
+
+// writeFileAttributes sets the user extended attributes in records on path.
+func writeFileAttributes(path string, records map[string]string) error {
+	for key, value := range records {
+		name, isXattr := strings.CutPrefix(key, paxXattrPrefix)
+		if !isXattr || !strings.HasPrefix(name, userXattrPrefix) {
+			continue
+		}
+
+		if err := syscall.Setxattr(path, name, []byte(value), 0); err != nil {
+			return ignoreUnsupportedXattr(err)
+		}
+	}
+
+	return nil
+}
+
+// ignoreUnsupportedXattr treats a filesystem without extended attributes as a file without any.
+func ignoreUnsupportedXattr(err error) error {
+	if errors.Is(err, syscall.ENOTSUP) {
+		return nil
+	}
+
+	return err
+}
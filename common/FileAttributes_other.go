@@ -0,0 +1,13 @@
+//go:build !linux && !windows
+
+package common
+
+// readFileAttributes returns no attributes on platforms without a supported attribute API.
+func readFileAttributes(path string) (map[string]string, error) {
+	return nil, nil
+}
+
+// writeFileAttributes is a no-op on platforms without a supported attribute API.
+func writeFileAttributes(path string, records map[string]string) error {
+	return nil
+}
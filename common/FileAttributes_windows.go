@@ -0,0 +1,105 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procFindFirstStreamW = syscall.NewLazyDLL("kernel32.dll").NewProc("FindFirstStreamW")
+	procFindNextStreamW  = syscall.NewLazyDLL("kernel32.dll").NewProc("FindNextStreamW")
+)
+
+const (
+	findStreamInfoStandard = 0
+	errorHandleEOF         = syscall.Errno(38)
+	// defaultStreamName is the unnamed stream holding the file's contents.
+	defaultStreamName = "::$DATA"
+)
+
+// systemStreams are alternate data streams Windows and file sharing manage themselves, which are not archived or
+// restored: Zone.Identifier is the Mark of the Web, and restoring it would mark installed files as downloaded.
+var systemStreams = []string{"Zone.Identifier", "SmartScreen", "AFP_AfpInfo", "AFP_Resource"}
+
+// isSystemStream reports whether the stream called name is one of systemStreams.
+func isSystemStream(name string) bool {
+	for _, systemStream := range systemStreams {
+		if strings.EqualFold(name, systemStream) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// win32FindStreamData is WIN32_FIND_STREAM_DATA.
+type win32FindStreamData struct {
+	StreamSize int64
+	StreamName [syscall.MAX_PATH + 36]uint16
+}
+
+// readFileAttributes returns the named alternate data streams of path as PAX records, except systemStreams.
+func readFileAttributes(path string) (map[string]string, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var data win32FindStreamData
+	handle, _, err := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(pathPtr)), findStreamInfoStandard, uintptr(unsafe.Pointer(&data)), 0)
+	if syscall.Handle(handle) == syscall.InvalidHandle {
+		if errors.Is(err, errorHandleEOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer syscall.FindClose(syscall.Handle(handle))
+
+	records := make(map[string]string)
+	for {
+		// stream names have the form :name:$DATA
+		streamName := syscall.UTF16ToString(data.StreamName[:])
+		name := strings.TrimSuffix(strings.TrimPrefix(streamName, ":"), ":$DATA")
+		if streamName != defaultStreamName && !isSystemStream(name) {
+			// larger streams are rejected before they are read into memory
+			if data.StreamSize > maxAttributeSize {
+				return nil, fmt.Errorf("alternate data stream %s is larger than %d bytes", streamName, maxAttributeSize)
+			}
+
+			value, err := os.ReadFile(path + ":" + name)
+			if err != nil {
+				return nil, err
+			}
+			records[paxStreamPrefix+name] = string(value)
+		}
+
+		ok, _, err := procFindNextStreamW.Call(handle, uintptr(unsafe.Pointer(&data)))
+		if ok == 0 {
+			if errors.Is(err, errorHandleEOF) {
+				return records, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// writeFileAttributes writes the alternate data streams in records to path. systemStreams recorded by earlier
+// releases are not written.
+func writeFileAttributes(path string, records map[string]string) error {
+	for key, value := range records {
+		name, isStream := strings.CutPrefix(key, paxStreamPrefix)
+		if !isStream || isSystemStream(name) {
+			continue
+		}
+
+		if err := os.WriteFile(path+":"+name, []byte(value), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,31 +1,134 @@
 package common
 
 import (
-	"bytes"
+	"archive/tar"
 	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/mholt/archiver/v4"
+	"hash"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
-func getFormat() archiver.CompressedArchive {
+// Name recorded in attachment metadata for the archive format returned by getFormat.
+const archiveFormatName = "tar"
+
+// Compression formats built in to exepy. More can be added with RegisterCompressor.
+const (
+	CompressionBz2  = "bz2"
+	CompressionZstd = "zstd"
+	CompressionXz   = "xz"
+	CompressionGzip = "gzip"
+)
+
+// DefaultCompressionFormat is used when settings do not name a compression format.
+const DefaultCompressionFormat = CompressionBz2
+
+// CompressionFormatName returns the canonical name of compression, resolving an empty name to the default.
+func CompressionFormatName(compression string) string {
+	if compression == "" {
+		return DefaultCompressionFormat
+	}
+	return compression
+}
+
+func getFormat(compression string) (archiver.CompressedArchive, error) {
 	format := archiver.CompressedArchive{
-		Compression: archiver.Bz2{},
-		Archival:    archiver.Tar{},
+		Archival: archiver.Tar{},
+	}
+
+	codec, err := newCompressor(CompressionFormatName(compression))
+	if err != nil {
+		return format, err
+	}
+	format.Compression = codec
+
+	return format, nil
+}
+
+// CheckCompressionFormat returns an error if compression is not a supported compression format.
+func CheckCompressionFormat(compression string) error {
+	_, err := getFormat(compression)
+	return err
+}
+
+// SpooledArchive is an archive written to a temporary file so it does not have to be held in memory.
+// Close removes the file.
+type SpooledArchive struct {
+	*os.File
+	removeCleanup func()
+
+	// Compression is the name of the compression format of the archive.
+	Compression string
+	// UncompressedSize is the total size of the files in the archive.
+	UncompressedSize int64
+	// FileCount is the number of files in the archive.
+	FileCount int
+}
+
+// ArchiveOptions control which entries of a directory are archived.
+type ArchiveOptions struct {
+	// FollowSymlinks archives the targets of symbolic links and junctions instead of the links themselves.
+	FollowSymlinks bool
+	// Exclude lists paths below the directory, as found when walking it, that are left out with everything below them.
+	Exclude []string
+	// StoreCompressed stores files that are already compressed, such as wheels, zips and media, without
+	// compressing them again. Only the remaining files are compressed.
+	StoreCompressed bool
+	// PreserveAttributes archives the extended attributes in the user namespace of each file on Linux, or its
+	// alternate data streams on Windows. They are restored by DecompressIOStreamWithAttributes.
+	PreserveAttributes bool
+}
+
+// Close closes and removes the temporary file.
+func (archive *SpooledArchive) Close() error {
+	archive.removeCleanup()
+
+	closeErr := archive.File.Close()
+	if err := os.Remove(archive.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if errors.Is(closeErr, os.ErrClosed) {
+		return nil
 	}
-	return format
+	return closeErr
+}
+
+// CompressDirToStream archives directoryPath into a temporary file and returns it positioned at the start.
+// The caller must Close the returned archive to remove the file.
+func CompressDirToStream(directoryPath string, compression string) (*SpooledArchive, error) {
+	return CompressDirToStreamWithOptions(directoryPath, compression, ArchiveOptions{})
 }
 
-func CompressDirToStream(directoryPath string) (io.ReadSeeker, error) {
+// CompressDirToStreamWithOptions is CompressDirToStream with control over the archived entries.
+func CompressDirToStreamWithOptions(directoryPath string, compression string, options ArchiveOptions) (*SpooledArchive, error) {
+	return CompressDirToStreamContext(context.Background(), directoryPath, compression, options)
+}
+
+// CompressDirToStreamContext is CompressDirToStreamWithOptions that stops when ctx is cancelled, checking between
+// chunks of each file. The partly written archive is removed.
+func CompressDirToStreamContext(ctx context.Context, directoryPath string, compression string, options ArchiveOptions) (*SpooledArchive, error) {
+	format, err := getFormat(compression)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get the list of files and directories in the specified folder
 	FromDiskOptions := &archiver.FromDiskOptions{
-		FollowSymlinks:  false,
-		ClearAttributes: true,
+		FollowSymlinks: options.FollowSymlinks,
 	}
 
 	// map the files to the archive
-	pathMap, err := mapFilesAndDirectories(directoryPath)
+	pathMap, err := mapFilesAndDirectories(directoryPath, options.Exclude)
 	if err != nil {
 		return nil, err
 	}
@@ -35,29 +138,271 @@ func CompressDirToStream(directoryPath string) (io.ReadSeeker, error) {
 	if err != nil {
 		return nil, err
 	}
+	clearFileAttributes(files)
 
-	// create a buffer to hold the compressed data
-	buf := new(bytes.Buffer)
+	if options.PreserveAttributes {
+		if err := withFileAttributes(files, directoryPath); err != nil {
+			return nil, err
+		}
+	}
+
+	return spoolArchive(ctx, format, compression, files, options.StoreCompressed)
+}
 
-	format := getFormat()
+// CompressFSToStream archives the files of fsys, such as an embed.FS, a zip.Reader or an in-memory file system,
+// into a temporary file like CompressDirToStreamContext, without staging them in a directory first. Exclude lists
+// paths within fsys; FollowSymlinks and PreserveAttributes do not apply, and entries other than regular files
+// and directories are rejected.
+func CompressFSToStream(ctx context.Context, fsys fs.FS, compression string, options ArchiveOptions) (*SpooledArchive, error) {
+	format, err := getFormat(compression)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := filesFromFS(fsys, options.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	return spoolArchive(ctx, format, compression, files, options.StoreCompressed)
+}
+
+// filesFromFS lists the files of fsys to archive, with the empty directories, as mapFilesAndDirectories does for
+// a directory on disk.
+func filesFromFS(fsys fs.FS, exclude []string) ([]archiver.File, error) {
+	var files []archiver.File
+
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		for _, excluded := range exclude {
+			if name == excluded {
+				if entry.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+		}
+
+		if name == "." {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.IsDir():
+			entries, err := fs.ReadDir(fsys, name)
+			if err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				files = append(files, archiver.File{FileInfo: archivedFileInfo{FileInfo: info}, NameInArchive: name + "/"})
+			}
+		case info.Mode().IsRegular():
+			files = append(files, archiver.File{
+				FileInfo:      archivedFileInfo{FileInfo: info},
+				NameInArchive: name,
+				Open:          func() (io.ReadCloser, error) { return fsys.Open(name) },
+			})
+		default:
+			return fmt.Errorf("%s: unsupported file type %s", name, info.Mode().Type())
+		}
+
+		return nil
+	})
+
+	return files, err
+}
+
+// spoolArchive writes files to a new temporary file archived with format, stopping when ctx is cancelled.
+func spoolArchive(ctx context.Context, format archiver.CompressedArchive, compression string, files []archiver.File, storeCompressed bool) (*SpooledArchive, error) {
+	// spool the compressed data to disk rather than memory, since payloads can be several gigabytes
+	spoolFile, err := os.CreateTemp("", "exepy-*.tar."+CompressionFormatName(compression))
+	if err != nil {
+		return nil, err
+	}
+	archive := &SpooledArchive{File: spoolFile, Compression: CompressionFormatName(compression)}
+	for _, file := range files {
+		if !file.IsDir() {
+			archive.UncompressedSize += file.Size()
+			archive.FileCount++
+		}
+	}
+
+	archive.removeCleanup = AddCleanup(func() { archive.Close() })
 
 	// create the archive
-	err = format.Archive(context.Background(), buf, files)
+	output := contextWriter{ctx: ctx, writer: archive}
+	if storeCompressed {
+		err = writeAdaptiveArchive(ctx, output, format, files)
+	} else {
+		err = format.Archive(ctx, output, files)
+	}
+	if err == nil {
+		_, err = archive.Seek(0, io.SeekStart)
+	}
+
 	if err != nil {
+		archive.Close()
 		return nil, err
 	}
 
-	// convert the buffer to an io.ReadSeeker
-	readSeeker := bytes.NewReader(buf.Bytes())
+	return archive, nil
+}
+
+// archivedFileInfo is the information about a file on disk that is archived: its type, permissions, size and
+// modification time, but not its owner or special mode bits, which mean nothing on the machine it is extracted
+// on. The tar writer copies the PAX records of header, when there is one.
+type archivedFileInfo struct {
+	fs.FileInfo
+	header *tar.Header
+}
+
+func (info archivedFileInfo) Mode() fs.FileMode {
+	return info.FileInfo.Mode() & (fs.ModeType | fs.ModePerm)
+}
+
+func (info archivedFileInfo) Sys() any {
+	// a nil *tar.Header in the interface would be taken for a header
+	if info.header == nil {
+		return nil
+	}
+
+	return info.header
+}
+
+// clearFileAttributes reduces the information about files read from disk to what is archived.
+func clearFileAttributes(files []archiver.File) {
+	for i := range files {
+		files[i].FileInfo = archivedFileInfo{FileInfo: files[i].FileInfo}
+	}
+}
+
+// CompressPathsToFile archives the files and directories in paths, keyed by their path on disk and mapped to their
+// name in the archive, into a new file at outputPath.
+func CompressPathsToFile(paths map[string]string, outputPath string, compression string) error {
+	format, err := getFormat(compression)
+	if err != nil {
+		return err
+	}
+
+	files, err := archiver.FilesFromDisk(nil, paths)
+	if err != nil {
+		return err
+	}
+	clearFileAttributes(files)
+
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
 
-	return readSeeker, nil
+	if err := format.Archive(context.Background(), output, files); err != nil {
+		return err
+	}
+
+	return output.Close()
 }
 
-func DecompressIOStream(IOReader io.Reader, outputDir string) error {
+func DecompressIOStream(IOReader io.Reader, outputDir string, compression string) error {
+	return DecompressIOStreamWithConflicts(IOReader, outputDir, compression, nil, "")
+}
 
-	format := getFormat()
+// DecompressIOStreamFiltered is DecompressIOStream that only extracts the entries kept by filter. The rest of
+// the stream is read but not written.
+func DecompressIOStreamFiltered(IOReader io.Reader, outputDir string, compression string, filter EntryFilter) error {
+	return decompressIOStream(context.Background(), IOReader, outputDir, compression, nil, "", filter.Matches, ExtractOptions{})
+}
+
+// DecompressIOStreamNames is DecompressIOStream that only extracts the entries with the given names, without
+// interpreting them as patterns.
+func DecompressIOStreamNames(IOReader io.Reader, outputDir string, compression string, names []string) error {
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		selected[name] = true
+	}
+
+	return decompressIOStream(context.Background(), IOReader, outputDir, compression, nil, "", func(name string) bool { return selected[name] }, ExtractOptions{})
+}
+
+// ExtractOptions control what is restored from an archive besides the contents of its files.
+type ExtractOptions struct {
+	// RestoreAttributes restores the extended attributes or alternate data streams archived with
+	// ArchiveOptions.PreserveAttributes.
+	RestoreAttributes bool
+	// DiscardModTimes leaves extracted files and directories with the time they were written, instead of the
+	// modification time recorded in the archive.
+	DiscardModTimes bool
+	// Hashes, if not nil, receives the hash computed with HashAlgorithm of every file written, as it is read from
+	// the archive, keyed by its name in the archive, so the written files can be checked with CheckStagedTree
+	// without reading the archive a second time.
+	Hashes map[string]string
+}
+
+// DecompressIOStreamWithAttributes is DecompressIOStream that also restores the extended attributes or
+// alternate data streams archived with ArchiveOptions.PreserveAttributes.
+func DecompressIOStreamWithAttributes(IOReader io.Reader, outputDir string, compression string) error {
+	return DecompressIOStreamWithOptions(IOReader, outputDir, compression, ExtractOptions{RestoreAttributes: true})
+}
+
+// DecompressIOStreamWithOptions is DecompressIOStream with control over what is restored.
+func DecompressIOStreamWithOptions(IOReader io.Reader, outputDir string, compression string, options ExtractOptions) error {
+	return DecompressIOStreamContext(context.Background(), IOReader, outputDir, compression, options)
+}
+
+// DecompressIOStreamContext is DecompressIOStreamWithOptions that stops when ctx is cancelled, checking between
+// chunks of the stream. The file being written when it stops is removed; files already extracted are left for
+// the caller to clean up.
+func DecompressIOStreamContext(ctx context.Context, IOReader io.Reader, outputDir string, compression string, options ExtractOptions) error {
+	return decompressIOStream(ctx, IOReader, outputDir, compression, nil, "", nil, options)
+}
+
+// ConflictAction is what to do with an existing file whose contents differ from the archived version.
+type ConflictAction int
+
+const (
+	ConflictOverwrite ConflictAction = iota
+	ConflictKeep
+	ConflictBackup
+)
+
+// ConflictResolver chooses the action for an existing file at path that differs from the archive.
+type ConflictResolver func(path string) ConflictAction
+
+// DecompressIOStreamWithConflicts extracts like DecompressIOStream, but consults resolver before replacing an existing
+// file with different contents. Files that are backed up are moved below backupDir, keeping their archive path.
+func DecompressIOStreamWithConflicts(IOReader io.Reader, outputDir string, compression string, resolver ConflictResolver, backupDir string) error {
+	return decompressIOStream(context.Background(), IOReader, outputDir, compression, resolver, backupDir, nil, ExtractOptions{})
+}
+
+// decompressIOStream extracts the entries of the stream for which keep returns true, or every entry if keep is nil.
+// Extracted files and directories get the modification time and, if options ask for them, the attributes
+// recorded in the archive.
+func decompressIOStream(ctx context.Context, IOReader io.Reader, outputDir string, compression string, resolver ConflictResolver, backupDir string, keep func(name string) bool, options ExtractOptions) error {
+
+	format, err := getFormat(compression)
+	if err != nil {
+		return err
+	}
+
+	// directory times are set once everything inside them is written, since writing a child changes them
+	var directories []extractedDirectory
 
 	handler := func(ctx context.Context, archivedFile archiver.File) error {
+		if keep != nil && !keep(archivedFile.NameInArchive) {
+			return nil
+		}
+
+		if err := checkArchivedPath(archivedFile); err != nil {
+			return err
+		}
 
 		outPath := filepath.Join(outputDir, archivedFile.NameInArchive)
 
@@ -67,6 +412,12 @@ func DecompressIOStream(IOReader io.Reader, outputDir string) error {
 				return err
 			}
 
+			directories = append(directories, extractedDirectory{path: outPath, modTime: archivedFile.ModTime()})
+
+			if options.RestoreAttributes {
+				return restoreFileAttributes(archivedFile.Header, outPath)
+			}
+
 			return nil
 		} else {
 			dir := filepath.Dir(outPath)
@@ -77,41 +428,410 @@ func DecompressIOStream(IOReader io.Reader, outputDir string) error {
 			}
 		}
 
-		// Create the outputFileStream
-		outputFileStream, err := os.Create(outPath)
-		if err != nil {
+		if resolver != nil && DoesPathExist(outPath) {
+			return extractConflictingFile(archivedFile, outPath, resolver, filepath.Join(backupDir, archivedFile.NameInArchive), options)
+		}
+
+		var digest hash.Hash
+		if options.Hashes != nil {
+			digest, _ = newHash(HashAlgorithm)
+		}
+
+		if err := writeArchivedFile(archivedFile, outPath, digest); err != nil {
 			return err
 		}
 
-		defer outputFileStream.Close()
+		if digest != nil {
+			options.Hashes[strings.TrimSuffix(archivedFile.NameInArchive, "/")] = hex.EncodeToString(digest.Sum(nil))
+		}
 
-		archivedFileStream, err := archivedFile.Open()
-		if err != nil {
+		return restoreArchivedMetadata(archivedFile, outPath, options)
+	}
+
+	err = extractArchive(ctx, format, contextReader{ctx: ctx, reader: IOReader}, handler)
+	if err != nil {
+		return err
+	}
+
+	if options.DiscardModTimes {
+		return nil
+	}
+
+	// deepest first, so setting a directory's time is not undone by setting one below it
+	sort.Slice(directories, func(i, j int) bool {
+		return len(directories[i].path) > len(directories[j].path)
+	})
+	for _, directory := range directories {
+		if err := setModTime(directory.path, directory.modTime); err != nil {
 			return err
 		}
-		defer archivedFileStream.Close()
+	}
+
+	return nil
+}
 
-		// Write the outputFileStream
-		_, err = io.Copy(outputFileStream, archivedFileStream)
+// checkArchivedPath returns an error for an entry that would be written outside the directory it is extracted to:
+// one whose name is absolute or climbs out with .., or a link that points outside it. Names and targets starting
+// with a drive letter are rejected on every platform, since installers are unpacked on Windows.
+func checkArchivedPath(archivedFile archiver.File) error {
+	name := strings.TrimSuffix(archivedFile.NameInArchive, "/")
+	if !isLocalZipPath(name) || hasDriveLetter(name) {
+		return fmt.Errorf("unsafe path in archive: %s", archivedFile.NameInArchive)
+	}
 
-		if err != nil {
+	target := archivedFile.LinkTarget
+	if target == "" {
+		return nil
+	}
+
+	// symbolic links are relative to the directory holding them, hard links to the root of the archive
+	resolved := target
+	if archivedFile.Mode()&fs.ModeSymlink != 0 {
+		resolved = path.Join(path.Dir(name), target)
+	}
+
+	if path.IsAbs(target) || filepath.IsAbs(filepath.FromSlash(target)) || filepath.VolumeName(filepath.FromSlash(target)) != "" || hasDriveLetter(target) || !isLocalZipPath(resolved) {
+		return fmt.Errorf("unsafe link in archive: %s -> %s", archivedFile.NameInArchive, target)
+	}
+
+	return nil
+}
+
+// hasDriveLetter reports whether name starts with a Windows drive letter, such as C: or C:/.
+func hasDriveLetter(name string) bool {
+	return len(name) >= 2 && name[1] == ':' && ('a' <= name[0] && name[0] <= 'z' || 'A' <= name[0] && name[0] <= 'Z')
+}
+
+// extractedDirectory is a directory entry of an archive whose modification time is set after extraction.
+type extractedDirectory struct {
+	path    string
+	modTime time.Time
+}
+
+// restoreArchivedMetadata applies the modification time and, if options ask for them, the attributes recorded
+// for archivedFile to the file written from it at outPath.
+func restoreArchivedMetadata(archivedFile archiver.File, outPath string, options ExtractOptions) error {
+	if options.RestoreAttributes {
+		if err := restoreFileAttributes(archivedFile.Header, outPath); err != nil {
 			return err
 		}
+	}
+
+	if options.DiscardModTimes {
+		return nil
+	}
 
+	return setModTime(outPath, archivedFile.ModTime())
+}
+
+// setModTime sets the access and modification times of path to modTime, unless the archive did not record one.
+func setModTime(path string, modTime time.Time) error {
+	if modTime.IsZero() {
 		return nil
 	}
 
-	ctx := context.Background()
+	return os.Chtimes(path, modTime, modTime)
+}
+
+// extractConflictingFile writes the archived file alongside the existing one, and only replaces it
+// if the contents differ and the resolver allows it.
+func extractConflictingFile(archivedFile archiver.File, outPath string, resolver ConflictResolver, backupPath string, options ExtractOptions) error {
+	newPath := outPath + ".exepy-new"
 
-	err := format.Extract(ctx, IOReader, nil, handler)
+	if err := writeArchivedFile(archivedFile, newPath, nil); err != nil {
+		return err
+	}
+
+	if err := restoreArchivedMetadata(archivedFile, newPath, options); err != nil {
+		os.Remove(newPath)
+		return err
+	}
+
+	return resolveConflict(newPath, outPath, resolver, backupPath)
+}
+
+// resolveConflict moves the file at newPath over the existing file at outPath if their contents differ and
+// the resolver allows it, backing the existing file up first if asked to. Otherwise newPath is removed.
+func resolveConflict(newPath, outPath string, resolver ConflictResolver, backupPath string) error {
+	newHash, err := HashFile(newPath)
+	if err != nil {
+		return err
+	}
+
+	existingHash, err := HashFile(outPath)
 	if err != nil {
 		return err
 	}
 
+	if newHash == existingHash {
+		return os.Remove(newPath)
+	}
+
+	switch resolver(outPath) {
+	case ConflictKeep:
+		fmt.Println("Kept modified file:", outPath)
+		return os.Remove(newPath)
+	case ConflictBackup:
+		if err := os.MkdirAll(filepath.Dir(backupPath), os.ModePerm); err != nil {
+			return err
+		}
+
+		if err := os.Rename(outPath, backupPath); err != nil {
+			return err
+		}
+
+		fmt.Println("Backed up modified file:", outPath, "->", backupPath)
+	}
+
+	return os.Rename(newPath, outPath)
+}
+
+// preallocateThreshold is the size from which extracted files are preallocated. Below it the extra system calls
+// slow down extracting many small files more than they save, and a small file is unlikely to fill the disk.
+const preallocateThreshold = 1 << 20 // 1 MiB
+
+// writeArchivedFile writes the contents of archivedFile to outPath, and to digest if it is not nil.
+func writeArchivedFile(archivedFile archiver.File, outPath string, digest hash.Hash) error {
+	// Create the outputFileStream
+	outputFileStream, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+
+	defer outputFileStream.Close()
+
+	// Reserve the space of large files up front so a full disk fails here rather than partway through the copy
+	if size := archivedFile.FileInfo.Size(); size >= preallocateThreshold {
+		if err := preallocateFile(outputFileStream, size); err != nil {
+			return err
+		}
+	}
+
+	archivedFileStream, err := archivedFile.Open()
+	if err != nil {
+		return err
+	}
+	defer archivedFileStream.Close()
+
+	// Write the outputFileStream
+	var output io.Writer = outputFileStream
+	if digest != nil {
+		output = io.MultiWriter(outputFileStream, digest)
+	}
+	_, err = io.Copy(output, archivedFileStream)
+
+	if err != nil {
+		// a partly written file would otherwise look extracted
+		outputFileStream.Close()
+		os.Remove(outPath)
+		return err
+	}
+
 	return nil
 }
 
-func mapFilesAndDirectories(directoryPath string) (map[string]string, error) {
+// contextReader fails reads once ctx is cancelled, so a long copy stops at the next chunk.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (reader contextReader) Read(p []byte) (int, error) {
+	if err := reader.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return reader.reader.Read(p)
+}
+
+// contextWriter fails writes once ctx is cancelled.
+type contextWriter struct {
+	ctx    context.Context
+	writer io.Writer
+}
+
+func (writer contextWriter) Write(p []byte) (int, error) {
+	if err := writer.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return writer.writer.Write(p)
+}
+
+// ListArchive returns the names of the entries in a stream produced by CompressDirToStream without writing anything to disk.
+func ListArchive(IOReader io.Reader, compression string) ([]string, error) {
+	format, err := getFormat(compression)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	handler := func(ctx context.Context, archivedFile archiver.File) error {
+		names = append(names, archivedFile.NameInArchive)
+		return nil
+	}
+
+	err = extractArchive(context.Background(), format, IOReader, handler)
+	if err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// WalkArchive calls visit with every entry of a stream produced by CompressDirToStream or CompressPathsToFile, in
+// the order they are stored, without writing anything to disk, so files can be kept in memory, checked or piped
+// onward. contents reads the data of a regular file and is nil for anything else; it is only valid until visit
+// returns. An error from visit stops the walk and is returned.
+func WalkArchive(ctx context.Context, IOReader io.Reader, compression string, visit func(name string, info fs.FileInfo, contents io.Reader) error) error {
+	format, err := getFormat(compression)
+	if err != nil {
+		return err
+	}
+
+	handler := func(ctx context.Context, archivedFile archiver.File) error {
+		if !archivedFile.Mode().IsRegular() {
+			return visit(archivedFile.NameInArchive, archivedFile.FileInfo, nil)
+		}
+
+		reader, err := archivedFile.Open()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		return visit(archivedFile.NameInArchive, archivedFile.FileInfo, contextReader{ctx: ctx, reader: reader})
+	}
+
+	return extractArchive(ctx, format, contextReader{ctx: ctx, reader: IOReader}, handler)
+}
+
+// ExtractToWriters writes every regular file of a stream produced by CompressDirToStream or CompressPathsToFile
+// to the writer create returns for it, instead of to a directory. create returns a nil writer to skip a file.
+// Each writer is closed once its file is written.
+func ExtractToWriters(ctx context.Context, IOReader io.Reader, compression string, create func(name string, info fs.FileInfo) (io.WriteCloser, error)) error {
+	return WalkArchive(ctx, IOReader, compression, func(name string, info fs.FileInfo, contents io.Reader) error {
+		if contents == nil {
+			return nil
+		}
+
+		writer, err := create(name, info)
+		if err != nil || writer == nil {
+			return err
+		}
+
+		if _, err := io.Copy(writer, contents); err != nil {
+			writer.Close()
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		return writer.Close()
+	})
+}
+
+// ReadArchiveFile returns the contents of the file called name in a stream produced by CompressDirToStream or
+// CompressPathsToFile, without writing anything to disk.
+func ReadArchiveFile(IOReader io.Reader, compression string, name string) ([]byte, error) {
+	var contents []byte
+	found := false
+
+	err := WalkArchive(context.Background(), IOReader, compression, func(entryName string, info fs.FileInfo, reader io.Reader) error {
+		if entryName != name || reader == nil {
+			return nil
+		}
+
+		var err error
+		found = true
+		contents, err = io.ReadAll(reader)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("archive has no %s: %w", name, os.ErrNotExist)
+	}
+
+	return contents, nil
+}
+
+// HashArchive returns the hash of every file in a stream produced by CompressDirToStream, keyed by its name in the
+// archive, without writing anything to disk.
+func HashArchive(IOReader io.Reader, compression string) (map[string]string, error) {
+	hashes := make(map[string]string)
+
+	err := WalkArchive(context.Background(), IOReader, compression, func(name string, info fs.FileInfo, contents io.Reader) error {
+		if contents == nil {
+			return nil
+		}
+
+		var err error
+		hashes[name], err = HashReader(contents)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// ArchiveReport describes a stream read through by VerifyArchive.
+type ArchiveReport struct {
+	Files       int `json:"files"`
+	Directories int `json:"directories"`
+	// Size is the total size of the files, as read.
+	Size int64 `json:"size"`
+	// Problems lists the entries that would not extract cleanly, such as unsafe paths, names that appear twice
+	// and entry types that are not files or directories.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// VerifyArchive reads a stream produced by CompressDirToStream or CompressPathsToFile through to the end without
+// writing anything to disk, so the checksums of the compression format are checked, and reports what it holds.
+// An error means the stream itself is damaged; problems with single entries are listed in the report.
+func VerifyArchive(IOReader io.Reader, compression string) (ArchiveReport, error) {
+	var report ArchiveReport
+	seen := make(map[string]bool)
+
+	err := WalkArchive(context.Background(), IOReader, compression, func(name string, info fs.FileInfo, contents io.Reader) error {
+		cleanName := strings.TrimSuffix(name, "/")
+
+		switch {
+		case !isLocalZipPath(cleanName):
+			report.Problems = append(report.Problems, name+": unsafe path")
+		case seen[cleanName]:
+			report.Problems = append(report.Problems, name+": listed more than once")
+		}
+		seen[cleanName] = true
+
+		switch {
+		case info.IsDir():
+			report.Directories++
+		case contents == nil:
+			report.Problems = append(report.Problems, name+": unsupported entry type "+info.Mode().Type().String())
+		default:
+			size, err := io.Copy(io.Discard, contents)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			if size != info.Size() {
+				report.Problems = append(report.Problems, fmt.Sprintf("%s: %d bytes read, %d recorded", name, size, info.Size()))
+			}
+
+			report.Files++
+			report.Size += size
+		}
+
+		return nil
+	})
+
+	return report, err
+}
+
+func mapFilesAndDirectories(directoryPath string, exclude []string) (map[string]string, error) {
 
 	pathSeperator := string(os.PathSeparator)
 
@@ -129,6 +849,15 @@ func mapFilesAndDirectories(directoryPath string) (map[string]string, error) {
 			return err
 		}
 
+		for _, excluded := range exclude {
+			if path == excluded {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
 		if d.IsDir() {
 
 			// Skip the root directory
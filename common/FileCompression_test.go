@@ -0,0 +1,48 @@
+package common
+
+import (
+	"archive/tar"
+	"github.com/mholt/archiver/v4"
+	"testing"
+)
+
+func TestCheckArchivedPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   tar.Header
+		wantSafe bool
+	}{
+		{name: "file", header: tar.Header{Name: "pkg/module.py", Typeflag: tar.TypeReg}, wantSafe: true},
+		{name: "directory", header: tar.Header{Name: "pkg/", Typeflag: tar.TypeDir}, wantSafe: true},
+		{name: "dot dot inside the root", header: tar.Header{Name: "pkg/../main.py", Typeflag: tar.TypeReg}, wantSafe: true},
+		{name: "parent directory", header: tar.Header{Name: "../evil.py", Typeflag: tar.TypeReg}},
+		{name: "climbs out through a subdirectory", header: tar.Header{Name: "pkg/../../evil.py", Typeflag: tar.TypeReg}},
+		{name: "dot dot alone", header: tar.Header{Name: "..", Typeflag: tar.TypeDir}},
+		{name: "absolute", header: tar.Header{Name: "/etc/passwd", Typeflag: tar.TypeReg}},
+		{name: "drive letter", header: tar.Header{Name: "C:/Windows/evil.dll", Typeflag: tar.TypeReg}},
+		{name: "drive relative", header: tar.Header{Name: "c:evil.dll", Typeflag: tar.TypeReg}},
+		{name: "backslashes", header: tar.Header{Name: `..\evil.py`, Typeflag: tar.TypeReg}},
+		{name: "UNC path", header: tar.Header{Name: `\\server\share\evil.py`, Typeflag: tar.TypeReg}},
+		{name: "symlink to a sibling", header: tar.Header{Name: "pkg/link", Typeflag: tar.TypeSymlink, Linkname: "module.py"}, wantSafe: true},
+		{name: "symlink to a parent inside the root", header: tar.Header{Name: "pkg/link", Typeflag: tar.TypeSymlink, Linkname: "../main.py"}, wantSafe: true},
+		{name: "symlink escaping the root", header: tar.Header{Name: "pkg/link", Typeflag: tar.TypeSymlink, Linkname: "../../etc/passwd"}},
+		{name: "symlink at the root escaping it", header: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../outside"}},
+		{name: "absolute symlink", header: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}},
+		{name: "symlink to a drive", header: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "C:/Windows"}},
+		{name: "symlink with backslashes", header: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: `..\..\Windows`}},
+		{name: "hardlink inside the root", header: tar.Header{Name: "pkg/copy.py", Typeflag: tar.TypeLink, Linkname: "main.py"}, wantSafe: true},
+		{name: "hardlink escaping the root", header: tar.Header{Name: "copy.py", Typeflag: tar.TypeLink, Linkname: "../secret"}},
+		// hard link targets are relative to the root, so one that would stay inside as a symlink still escapes
+		{name: "hardlink escaping from a subdirectory", header: tar.Header{Name: "pkg/sub/copy.py", Typeflag: tar.TypeLink, Linkname: "../main.py"}},
+		{name: "absolute hardlink", header: tar.Header{Name: "copy.py", Typeflag: tar.TypeLink, Linkname: "/etc/shadow"}},
+	}
+
+	for _, test := range tests {
+		header := test.header
+		file := archiver.File{FileInfo: header.FileInfo(), Header: &header, NameInArchive: header.Name, LinkTarget: header.Linkname}
+
+		if err := checkArchivedPath(file); (err == nil) != test.wantSafe {
+			t.Errorf("%s: checkArchivedPath(%q -> %q) = %v, want safe %v", test.name, header.Name, header.Linkname, err, test.wantSafe)
+		}
+	}
+}
@@ -28,19 +28,21 @@ func CopyFile(src, dst string) error {
 
 	from, err := os.Open(src)
 	if err != nil {
-		fmt.Println("Error opening requirements file:", err)
+		fmt.Println("Error opening file:", err)
 		return err
 	}
+	defer from.Close()
 
 	to, err := os.Create(dst)
 	if err != nil {
-		fmt.Println("Error creating requirements file:", err)
+		fmt.Println("Error creating file:", err)
 		return err
 	}
+	defer to.Close()
 
 	_, err = io.Copy(to, from)
 	if err != nil {
-		fmt.Println("Error copying requirements file:", err)
+		fmt.Println("Error copying file:", err)
 		return err
 	}
 
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// EntryFilter selects archive entries by glob patterns of their names in the archive. Patterns use "/" as the
+// separator and the syntax of path.Match, where "**" also matches any number of directories. A pattern without
+// "/" matches the base name at any depth, and a pattern ending in "/" matches a directory and everything in it.
+type EntryFilter struct {
+	// Include lists the patterns of the entries to keep. If it is empty, every entry is kept.
+	Include []string
+	// Exclude lists the patterns of entries to leave out, even if they are included.
+	Exclude []string
+}
+
+// IsZero reports whether the filter keeps every entry.
+func (filter EntryFilter) IsZero() bool {
+	return len(filter.Include) == 0 && len(filter.Exclude) == 0
+}
+
+// Validate returns an error for the first malformed pattern.
+func (filter EntryFilter) Validate() error {
+	for _, pattern := range append(append([]string{}, filter.Include...), filter.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// Matches reports whether the entry name is kept by the filter.
+func (filter EntryFilter) Matches(name string) bool {
+	for _, pattern := range filter.Exclude {
+		if MatchGlob(pattern, name) {
+			return false
+		}
+	}
+
+	if len(filter.Include) == 0 {
+		return true
+	}
+
+	for _, pattern := range filter.Include {
+		if MatchGlob(pattern, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchGlob reports whether the entry name matches pattern, as described for EntryFilter.
+func MatchGlob(pattern, name string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(strings.Trim(name, "/"), "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for skip := 0; skip <= len(name); skip++ {
+				if matchSegments(pattern[1:], name[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], name[0]); !matched {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
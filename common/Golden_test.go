@@ -0,0 +1,85 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The files in testdata/golden were written by earlier releases and pin the formats this build must keep reading.
+// They must never be regenerated: when a format changes, add files written by the new release next to them.
+
+// goldenTree is the directory every golden archive was built from.
+var goldenTree = map[string]string{
+	"main.py":         "print('hello from a golden installer')\n",
+	"pkg/__init__.py": "",
+	"pkg/data.txt":    "golden data\n",
+}
+
+func TestGoldenStreams(t *testing.T) {
+	tests := []struct {
+		file        string
+		compression string
+	}{
+		{file: "payload.tar.gz", compression: CompressionGzip},
+		{file: "payload.tar.bz2", compression: CompressionBz2},
+		{file: "payload.tar.xz", compression: CompressionXz},
+		{file: "payload.tar.zst", compression: CompressionZstd},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join("testdata", "golden", "streams", test.file)
+
+		stream, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := VerifyArchive(stream, test.compression)
+		stream.Close()
+		if err != nil || report.Files != len(goldenTree) {
+			t.Errorf("%s: VerifyArchive() = %+v, %v, want %d files", test.file, report, err, len(goldenTree))
+			continue
+		}
+
+		stream, err = os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := t.TempDir()
+		err = DecompressIOStream(stream, dir, test.compression)
+		stream.Close()
+		if err != nil {
+			t.Errorf("%s: DecompressIOStream() = %v", test.file, err)
+			continue
+		}
+
+		for name, want := range goldenTree {
+			got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+			if err != nil || string(got) != want {
+				t.Errorf("%s: %s = %q, %v, want %q", test.file, name, got, err, want)
+			}
+		}
+	}
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata AttachmentMetadata
+		wantErr  bool
+	}{
+		{name: "current version", metadata: AttachmentMetadata{FormatVersion: MetadataFormatVersion, Format: archiveFormatName, Compression: CompressionBz2}},
+		{name: "written before the version was recorded", metadata: AttachmentMetadata{Format: archiveFormatName, Compression: CompressionGzip}},
+		{name: "newer version", metadata: AttachmentMetadata{FormatVersion: MetadataFormatVersion + 1, Format: archiveFormatName, Compression: CompressionBz2}, wantErr: true},
+		{name: "newer version of a plain file", metadata: AttachmentMetadata{FormatVersion: MetadataFormatVersion + 1, Format: "json", Compression: "none"}, wantErr: true},
+		{name: "plain file", metadata: AttachmentMetadata{FormatVersion: MetadataFormatVersion, Format: "json", Compression: "none"}},
+		{name: "unknown compression", metadata: AttachmentMetadata{Format: archiveFormatName, Compression: "lzma"}, wantErr: true},
+		{name: "unknown archive format", metadata: AttachmentMetadata{Format: "zip", Compression: CompressionGzip}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		if err := test.metadata.CheckCompatibility(); (err != nil) != test.wantErr {
+			t.Errorf("%s: CheckCompatibility() = %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+}
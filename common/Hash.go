@@ -2,7 +2,11 @@ package common
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
@@ -10,22 +14,114 @@ import (
 	"strings"
 )
 
+// Supported hash algorithms. MD5 is only used to verify installers and hash files written by earlier releases.
+const (
+	HashAlgorithmMD5    = "md5"
+	HashAlgorithmSHA256 = "sha256"
+)
+
+// HashAlgorithm names the algorithm used for new hashes by the functions in this file.
+const HashAlgorithm = HashAlgorithmSHA256
+
+// HashManifest lists the hash of every attachment of an installer and the algorithm they were computed with.
+type HashManifest struct {
+	Algorithm string            `json:"algorithm"`
+	Hashes    map[string]string `json:"hashes"`
+}
+
+// NewHashManifest returns a manifest of hashes computed with HashAlgorithm.
+func NewHashManifest(hashes map[string]string) HashManifest {
+	return HashManifest{Algorithm: HashAlgorithm, Hashes: hashes}
+}
+
+// ReadHashManifest parses a hash manifest. Manifests written before the algorithm was recorded are a plain map
+// of attachment names to MD5 hashes, and are only accepted if allowLegacy is set, which callers do for installers
+// without a metadata attachment. Every installer with metadata records its algorithm and uses SHA-256, so an MD5
+// manifest in one is an attempt to downgrade it and is rejected, whichever form it takes.
+func ReadHashManifest(data []byte, allowLegacy bool) (HashManifest, error) {
+	var manifest HashManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, err
+	}
+
+	if manifest.Algorithm == "" {
+		if !allowLegacy {
+			return manifest, fmt.Errorf("hash manifest does not record its algorithm, which is only accepted in installers built by earlier releases")
+		}
+
+		manifest.Algorithm = HashAlgorithmMD5
+		manifest.Hashes = nil
+
+		if err := json.Unmarshal(data, &manifest.Hashes); err != nil {
+			return manifest, err
+		}
+	} else if manifest.Algorithm != HashAlgorithmSHA256 {
+		return manifest, fmt.Errorf("hash manifest uses %s, but installers that record the algorithm use %s", manifest.Algorithm, HashAlgorithmSHA256)
+	}
+
+	if _, err := newHash(manifest.Algorithm); err != nil {
+		return manifest, err
+	}
+
+	return manifest, nil
+}
+
+// HashAlgorithmOf returns the algorithm a hex-encoded hash was most likely computed with, based on its length.
+// It is used for hashes recorded without their algorithm, such as hash.txt.
+func HashAlgorithmOf(hexHash string) string {
+	if len(strings.TrimSpace(hexHash)) == hex.EncodedLen(md5.Size) {
+		return HashAlgorithmMD5
+	}
+	return HashAlgorithmSHA256
+}
+
+// CheckSHA256Checksum returns an error unless checksum is a hex-encoded SHA-256 hash. Checksums that pin
+// downloads and prebuilt archives must use SHA-256; MD5 is only accepted where earlier releases recorded it.
+func CheckSHA256Checksum(checksum string) error {
+	if decoded, err := hex.DecodeString(strings.TrimSpace(checksum)); err != nil || len(decoded) != sha256.Size {
+		return fmt.Errorf("checksum %q is not a SHA-256 hash", checksum)
+	}
+	return nil
+}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case HashAlgorithmSHA256:
+		return sha256.New(), nil
+	case HashAlgorithmMD5:
+		return md5.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %q", algorithm)
+	}
+}
+
+// HashFile returns the hash of the file at filePath, computed with HashAlgorithm.
+func HashFile(filePath string) (string, error) {
+	return HashFileWith(HashAlgorithm, filePath)
+}
+
 // https://stackoverflow.com/a/40436529 CC BY-SA 4.0
-func Md5SumFile(filePath string) (string, error) {
+func HashFileWith(algorithm, filePath string) (string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return "", err
 	}
 	defer file.Close()
 
-	hash := md5.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(hash.Sum(nil)), nil
+	return HashReaderWith(algorithm, file)
 }
 
-func Md5sumDirectory(dirPath string) (string, error) {
+// Md5SumFile returns the MD5 hash of the file at filePath. Use HashFile for new hashes.
+func Md5SumFile(filePath string) (string, error) {
+	return HashFileWith(HashAlgorithmMD5, filePath)
+}
+
+// HashDirectory returns a single hash covering the contents of every file below dirPath, computed with HashAlgorithm.
+func HashDirectory(dirPath string) (string, error) {
+	return HashDirectoryWith(HashAlgorithm, dirPath)
+}
+
+func HashDirectoryWith(algorithm, dirPath string) (string, error) {
 	var hashes []string
 
 	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
@@ -34,18 +130,12 @@ func Md5sumDirectory(dirPath string) (string, error) {
 		}
 
 		if !info.IsDir() {
-			file, err := os.Open(path)
+			fileHash, err := HashFileWith(algorithm, path)
 			if err != nil {
 				return err
 			}
-			defer file.Close()
-
-			hash := md5.New()
-			if _, err := io.Copy(hash, file); err != nil {
-				return err
-			}
 
-			hashes = append(hashes, hex.EncodeToString(hash.Sum(nil)))
+			hashes = append(hashes, fileHash)
 		}
 
 		return nil
@@ -62,20 +152,44 @@ func Md5sumDirectory(dirPath string) (string, error) {
 	combined := strings.Join(hashes, "")
 
 	// Hash the combined string
-	finalHash := md5.Sum([]byte(combined))
+	return HashReaderWith(algorithm, strings.NewReader(combined))
+}
+
+// Md5sumDirectory returns the MD5 hash of the directory. Use HashDirectory for new hashes.
+func Md5sumDirectory(dirPath string) (string, error) {
+	return HashDirectoryWith(HashAlgorithmMD5, dirPath)
+}
 
-	return hex.EncodeToString(finalHash[:]), nil
+func HashReader(r io.Reader) (string, error) {
+	return HashReaderWith(HashAlgorithm, r)
+}
+
+func HashReaderWith(algorithm string, r io.Reader) (string, error) {
+	hash, err := newHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 func HashReadSeeker(rs io.ReadSeeker) (string, error) {
+	return HashReadSeekerWith(HashAlgorithm, rs)
+}
+
+func HashReadSeekerWith(algorithm string, rs io.ReadSeeker) (string, error) {
 	// Save the current position
 	startPos, err := rs.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return "", err
 	}
 
-	hash := md5.New()
-	if _, err := io.Copy(hash, rs); err != nil {
+	hash, err := HashReaderWith(algorithm, rs)
+	if err != nil {
 		return "", err
 	}
 
@@ -85,5 +199,5 @@ func HashReadSeeker(rs io.ReadSeeker) (string, error) {
 		return "", err
 	}
 
-	return hex.EncodeToString(hash.Sum(nil)), nil
+	return hash, nil
 }
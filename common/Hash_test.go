@@ -0,0 +1,58 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestReadHashManifest(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		allowLegacy bool
+		want        string
+		wantErr     bool
+	}{
+		{name: "sha256", data: `{"algorithm": "sha256", "hashes": {"payload": "ab"}}`, want: HashAlgorithmSHA256},
+		{name: "sha256 in a legacy installer", data: `{"algorithm": "sha256", "hashes": {"payload": "ab"}}`, allowLegacy: true, want: HashAlgorithmSHA256},
+		{name: "legacy map", data: `{"payload": "ab"}`, allowLegacy: true, want: HashAlgorithmMD5},
+		{name: "legacy map downgrade", data: `{"payload": "ab"}`, wantErr: true},
+		{name: "explicit md5", data: `{"algorithm": "md5", "hashes": {"payload": "ab"}}`, wantErr: true},
+		{name: "explicit md5 in a legacy installer", data: `{"algorithm": "md5", "hashes": {"payload": "ab"}}`, allowLegacy: true, wantErr: true},
+		{name: "unknown algorithm", data: `{"algorithm": "crc32", "hashes": {"payload": "ab"}}`, wantErr: true},
+		{name: "invalid json", data: `{"payload"`, allowLegacy: true, wantErr: true},
+	}
+
+	for _, test := range tests {
+		manifest, err := ReadHashManifest([]byte(test.data), test.allowLegacy)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: ReadHashManifest() error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if test.wantErr {
+			continue
+		}
+
+		if manifest.Algorithm != test.want || manifest.Hashes["payload"] != "ab" || len(manifest.Hashes) != 1 {
+			t.Errorf("%s: ReadHashManifest() = %+v, want %s hashes of payload", test.name, manifest, test.want)
+		}
+	}
+}
+
+func TestCheckSHA256Checksum(t *testing.T) {
+	tests := []struct {
+		checksum string
+		wantErr  bool
+	}{
+		{checksum: "5d7b5313d81195e4caf90aa52719f240eb93d50d2b38288a85ef6724af80c97a"},
+		{checksum: "5D7B5313D81195E4CAF90AA52719F240EB93D50D2B38288A85EF6724AF80C97A"},
+		{checksum: "0123456789abcdef0123456789abcdef", wantErr: true},
+		{checksum: "not a hash", wantErr: true},
+		{checksum: "", wantErr: true},
+	}
+
+	for _, test := range tests {
+		if err := CheckSHA256Checksum(test.checksum); (err != nil) != test.wantErr {
+			t.Errorf("CheckSHA256Checksum(%q) error = %v, want error %v", test.checksum, err, test.wantErr)
+		}
+	}
+}
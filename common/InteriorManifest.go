@@ -0,0 +1,198 @@
+package common
+
+import (
+	"archive/zip"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// InteriorManifestFilename is written to the Python directory at build time, listing the files extracted from
+// the interior standard library zip of the Python distribution.
+const InteriorManifestFilename = "exepy-interior.json"
+
+// InteriorManifest records the files of the interior standard library zip, so bootstrap can detect damaged
+// files, which otherwise show up as baffling errors when Python starts.
+type InteriorManifest struct {
+	Zip       string `json:"zip"`
+	Algorithm string `json:"algorithm"`
+	// Files maps the path of every file, relative to the Python directory and with forward slashes, to its size
+	// and hash.
+	Files map[string]InteriorFile `json:"files"`
+}
+
+// InteriorFile is the size and hash of one file of the interior zip.
+type InteriorFile struct {
+	Size int64  `json:"size"`
+	Hash string `json:"hash"`
+}
+
+// NewInteriorManifest hashes every file in the zip at zipPath.
+func NewInteriorManifest(zipPath string) (*InteriorManifest, error) {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	manifest := &InteriorManifest{Zip: filepath.Base(zipPath), Algorithm: HashAlgorithm, Files: make(map[string]InteriorFile)}
+
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+
+		hash, err := newHash(HashAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+
+		contents, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := io.Copy(hash, contents)
+		contents.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		manifest.Files[file.Name] = InteriorFile{Size: size, Hash: hex.EncodeToString(hash.Sum(nil))}
+	}
+
+	return manifest, nil
+}
+
+// LoadInteriorManifest reads an interior manifest written by SaveInteriorManifest.
+func LoadInteriorManifest(filename string) (*InteriorManifest, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest InteriorManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// SaveInteriorManifest writes manifest as JSON to filename.
+func SaveInteriorManifest(filename string, manifest *InteriorManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, data, 0644)
+}
+
+// FileStatus is how a file compares with the InteriorManifest it is listed in.
+type FileStatus string
+
+const (
+	FileOK       FileStatus = "ok"
+	FileModified FileStatus = "modified"
+	FileMissing  FileStatus = "missing"
+	// FileUnreadable is a file that exists but could not be read to check it, such as one locked by another
+	// process or without read permission.
+	FileUnreadable FileStatus = "unreadable"
+)
+
+// FileCheck is the result of checking one file of an InteriorManifest.
+type FileCheck struct {
+	Name   string
+	Status FileStatus
+	// Err is why the file is unreadable, or nil.
+	Err error
+	// Checked is how many files have been checked so far, including this one, out of Total.
+	Checked int
+	Total   int
+}
+
+// CheckFiles checks the files of the manifest below dir in name order, and calls visit with each result as soon as
+// it is known, so callers can show progress over thousands of files. Sizes are always compared and hashes only
+// when checkHashes is set. A file that cannot be checked is reported as unreadable rather than stopping the
+// check. Returning false from visit stops the check, for callers that only need to know whether anything is
+// damaged.
+func (manifest *InteriorManifest) CheckFiles(dir string, checkHashes bool, visit func(check FileCheck) bool) {
+	names := make([]string, 0, len(manifest.Files))
+	for name := range manifest.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		status, err := manifest.fileStatus(dir, name, checkHashes)
+
+		if !visit(FileCheck{Name: name, Status: status, Err: err, Checked: i + 1, Total: len(names)}) {
+			return
+		}
+	}
+}
+
+// fileStatus compares the file called name below dir with the manifest, returning the error that made it
+// unreadable if it is.
+func (manifest *InteriorManifest) fileStatus(dir, name string, checkHashes bool) (FileStatus, error) {
+	expected := manifest.Files[name]
+	filePath := filepath.Join(dir, filepath.FromSlash(name))
+
+	info, err := os.Stat(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return FileMissing, nil
+	}
+	if err != nil {
+		return FileUnreadable, err
+	}
+	if !info.Mode().IsRegular() || info.Size() != expected.Size {
+		return FileModified, nil
+	}
+
+	if !checkHashes {
+		return FileOK, nil
+	}
+
+	hash, err := HashFileWith(manifest.Algorithm, filePath)
+	if err != nil {
+		return FileUnreadable, err
+	}
+	if hash != expected.Hash {
+		return FileModified, nil
+	}
+
+	return FileOK, nil
+}
+
+// DamagedFiles returns the files of the manifest below dir that are not ok, sorted by name.
+// Sizes are always compared and hashes only when checkHashes is set.
+func (manifest *InteriorManifest) DamagedFiles(dir string, checkHashes bool) []string {
+	var damaged []string
+
+	manifest.CheckFiles(dir, checkHashes, func(check FileCheck) bool {
+		if check.Status != FileOK {
+			damaged = append(damaged, check.Name)
+		}
+		return true
+	})
+
+	return damaged
+}
+
+// IsIntact reports whether every file of the manifest below dir matches it, stopping at the first that does not.
+func (manifest *InteriorManifest) IsIntact(dir string, checkHashes bool) bool {
+	intact := true
+
+	manifest.CheckFiles(dir, checkHashes, func(check FileCheck) bool {
+		intact = check.Status == FileOK
+		return intact
+	})
+
+	return intact
+}
@@ -0,0 +1,85 @@
+package common
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var ErrLocked = errors.New("lock is held by another process")
+
+// InstallLock is a machine-wide lock scoped to a product name. The operating system releases it
+// if the holding process exits without calling Release.
+type InstallLock struct {
+	file *os.File
+}
+
+// AcquireInstallLock takes the named lock, waiting up to timeout for another holder to release it.
+// It returns ErrLocked if the lock is still held once the timeout has passed.
+func AcquireInstallLock(name string, timeout time.Duration) (*InstallLock, error) {
+	return AcquireFileLock(filepath.Join(lockDir(), lockFileName(name)), timeout)
+}
+
+// lockFileName returns the name of the lock file for the product called name. Characters that are not allowed or
+// would leave the lock directory, such as path separators, are replaced, so any product name gives a file in it.
+func lockFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(" .-_", r) {
+			return r
+		}
+		return '_'
+	}, name)
+
+	if strings.Trim(name, ". ") == "" {
+		name = "exepy" + name
+	}
+
+	// names of devices such as CON cannot be used for files on Windows, whatever their extension
+	switch strings.ToUpper(name) {
+	case "CON", "PRN", "AUX", "NUL", "COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+		"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9":
+		name += "_"
+	}
+
+	return name + ".lock"
+}
+
+// AcquireFileLock takes the lock held by the file at lockPath, which can be on a network share to lock across
+// machines. It waits up to timeout for another holder to release it, and returns ErrLocked if the lock is still
+// held once the timeout has passed.
+func AcquireFileLock(lockPath string, timeout time.Duration) (*InstallLock, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		file, err := tryLockFile(lockPath)
+		if err == nil {
+			return &InstallLock{file: file}, nil
+		}
+
+		if !errors.Is(err, ErrLocked) || time.Now().After(deadline) {
+			return nil, err
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+func (l *InstallLock) Release() error {
+	if l == nil {
+		return nil
+	}
+
+	return l.file.Close()
+}
+
+// lockDir returns a directory shared by all users of the machine.
+func lockDir() string {
+	if dir := os.Getenv("ProgramData"); dir != "" {
+		return dir
+	}
+
+	return os.TempDir()
+}
@@ -0,0 +1,28 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestLockFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "Example App", want: "Example App.lock"},
+		{name: "données-1.2_beta", want: "données-1.2_beta.lock"},
+		{name: `..\..\Windows\System32\evil`, want: ".._.._Windows_System32_evil.lock"},
+		{name: "../etc/passwd", want: ".._etc_passwd.lock"},
+		{name: "C:app", want: "C_app.lock"},
+		{name: "a*b?c|d<e>f\"g", want: "a_b_c_d_e_f_g.lock"},
+		{name: "", want: "exepy.lock"},
+		{name: "..", want: "exepy...lock"},
+		{name: "con", want: "con_.lock"},
+	}
+
+	for _, test := range tests {
+		if got := lockFileName(test.name); got != test.want {
+			t.Errorf("lockFileName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
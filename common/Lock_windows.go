@@ -0,0 +1,69 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const errorSharingViolation syscall.Errno = 32
+
+// lockFileSecurity is the access control list lock files are created with: full control for SYSTEM and
+// administrators and modify for every user, so another user's installer can open the lock once it is released.
+// Without it the file inherits the ACL of ProgramData, where only its creator may write it.
+const lockFileSecurity = "D:(A;;FA;;;SY)(A;;FA;;;BA)(A;;0x1301bf;;;BU)"
+
+const sddlRevision1 = 1
+
+var procConvertStringSecurityDescriptorToSecurityDescriptorW = syscall.NewLazyDLL("advapi32.dll").NewProc("ConvertStringSecurityDescriptorToSecurityDescriptorW")
+
+// tryLockFile opens path without sharing, so any other process opening it fails until the handle is closed.
+// Only a sharing violation means the lock is held. Access being denied is reported as an error, since waiting
+// would not help: a lock file created by an earlier release may only be writable by the user who created it.
+func tryLockFile(path string) (*os.File, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	security, err := lockSecurityAttributes()
+	if err != nil {
+		return nil, err
+	}
+	defer procLocalFree.Call(security.SecurityDescriptor)
+
+	handle, err := syscall.CreateFile(pathPtr, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, security, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if errors.Is(err, errorSharingViolation) {
+			return nil, ErrLocked
+		}
+		if errors.Is(err, syscall.ERROR_ACCESS_DENIED) {
+			return nil, fmt.Errorf("cannot open lock file %s, which may have been created by another user with an earlier release and must be deleted by an administrator: %w", path, err)
+		}
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(handle), path), nil
+}
+
+// lockSecurityAttributes returns the security attributes for lockFileSecurity. The caller frees the security
+// descriptor with LocalFree.
+func lockSecurityAttributes() (*syscall.SecurityAttributes, error) {
+	sddl, err := syscall.UTF16PtrFromString(lockFileSecurity)
+	if err != nil {
+		return nil, err
+	}
+
+	var descriptor uintptr
+	ret, _, err := procConvertStringSecurityDescriptorToSecurityDescriptorW.Call(uintptr(unsafe.Pointer(sddl)), sddlRevision1, uintptr(unsafe.Pointer(&descriptor)), 0)
+	if ret == 0 {
+		return nil, err
+	}
+
+	security := &syscall.SecurityAttributes{SecurityDescriptor: descriptor}
+	security.Length = uint32(unsafe.Sizeof(*security))
+
+	return security, nil
+}
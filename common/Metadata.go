@@ -0,0 +1,126 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// Attachment types recorded in the metadata attachment.
+const (
+	AttachmentTypePython   = "python-runtime"
+	AttachmentTypePayload  = "payload"
+	AttachmentTypeWheels   = "wheels"
+	AttachmentTypeSettings = "settings"
+	AttachmentTypeRecovery = "recovery"
+	AttachmentTypeLicense  = "license"
+	AttachmentTypePlugin   = "plugin"
+)
+
+// MetadataFormatVersion is the version of the attachment layout written by this build. It is raised whenever
+// attachments change in a way earlier releases cannot read.
+const MetadataFormatVersion = 1
+
+// AttachmentMetadata describes an embedded attachment so readers do not have to infer it from the name.
+type AttachmentMetadata struct {
+	// FormatVersion is the MetadataFormatVersion of the build that wrote the attachment. Installers built before
+	// it was recorded leave it 0 and use the layout of version 1.
+	FormatVersion    int       `json:"formatVersion,omitempty"`
+	Type             string    `json:"type"`
+	Format           string    `json:"format"`
+	Compression      string    `json:"compression"`
+	UncompressedSize int64     `json:"uncompressedSize"`
+	CreatedAt        time.Time `json:"createdAt"`
+	ToolVersion      string    `json:"toolVersion"`
+}
+
+// NewArchiveMetadata describes an attachment produced by CompressDirToStream.
+func NewArchiveMetadata(attachmentType string, archive *SpooledArchive) AttachmentMetadata {
+	return AttachmentMetadata{
+		FormatVersion:    MetadataFormatVersion,
+		Type:             attachmentType,
+		Format:           archiveFormatName,
+		Compression:      archive.Compression,
+		UncompressedSize: archive.UncompressedSize,
+		CreatedAt:        time.Now().UTC(),
+		ToolVersion:      Version,
+	}
+}
+
+// NewPrebuiltArchiveMetadata describes an archive that was built elsewhere, so its uncompressed size is unknown.
+func NewPrebuiltArchiveMetadata(attachmentType, compression string) AttachmentMetadata {
+	return AttachmentMetadata{
+		FormatVersion: MetadataFormatVersion,
+		Type:          attachmentType,
+		Format:        archiveFormatName,
+		Compression:   CompressionFormatName(compression),
+		CreatedAt:     time.Now().UTC(),
+		ToolVersion:   Version,
+	}
+}
+
+// NewFileMetadata describes an attachment embedded as-is.
+func NewFileMetadata(attachmentType, format string, size int64) AttachmentMetadata {
+	return AttachmentMetadata{
+		FormatVersion:    MetadataFormatVersion,
+		Type:             attachmentType,
+		Format:           format,
+		Compression:      "none",
+		UncompressedSize: size,
+		CreatedAt:        time.Now().UTC(),
+		ToolVersion:      Version,
+	}
+}
+
+// CheckCompatibility returns an error if this build cannot unpack an attachment described by metadata.
+func (metadata AttachmentMetadata) CheckCompatibility() error {
+	if metadata.FormatVersion > MetadataFormatVersion {
+		return fmt.Errorf("unsupported format version %d (created by exepy %s), this build reads up to version %d", metadata.FormatVersion, metadata.ToolVersion, MetadataFormatVersion)
+	}
+
+	if metadata.Compression == "none" {
+		return nil
+	}
+
+	if metadata.Format != archiveFormatName || CheckCompressionFormat(metadata.Compression) != nil {
+		return fmt.Errorf("unsupported archive %s/%s (created by exepy %s)", metadata.Format, metadata.Compression, metadata.ToolVersion)
+	}
+
+	return nil
+}
+
+func ReadMetadata(r io.Reader) (map[string]AttachmentMetadata, error) {
+	var metadata map[string]AttachmentMetadata
+	if err := json.NewDecoder(r).Decode(&metadata); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// DirectorySize returns the total size of the regular files below dirPath.
+func DirectorySize(dirPath string) (int64, error) {
+	var size int64
+
+	err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			size += info.Size()
+		}
+
+		return nil
+	})
+
+	return size, err
+}
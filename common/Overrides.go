@@ -0,0 +1,142 @@
+package common
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Overrides are the settings an organization can override at install time. Only these can be changed, so an
+// override document cannot change what is installed or how it is verified.
+type Overrides struct {
+	// Product is the product name of the installers the document applies to, so a document signed for one
+	// product cannot be replayed against another that trusts the same key.
+	Product string `json:"product"`
+	// Proxy is set as HTTP_PROXY and HTTPS_PROXY for the installer, pip and the script.
+	Proxy string `json:"proxy,omitempty"`
+	// NoProxy is set as NO_PROXY.
+	NoProxy string `json:"noProxy,omitempty"`
+	// LicenseEndpoint replaces the endpoint license acceptances are reported to.
+	LicenseEndpoint string `json:"licenseEndpoint,omitempty"`
+	// TempDir, InstallLog and UsageLog replace the settings of the same name.
+	TempDir    string `json:"tempDir,omitempty"`
+	InstallLog string `json:"installLog,omitempty"`
+	UsageLog   string `json:"usageLog,omitempty"`
+	// Environment is merged into the environment of the settings, replacing variables of the same name.
+	Environment map[string]string `json:"environment,omitempty"`
+	// Expires is when the document stops being accepted. It is required, so a leaked document is not valid forever.
+	Expires time.Time `json:"expires"`
+}
+
+// SignedOverrides is an override document as published: the overrides, and the base64-encoded Ed25519 signature
+// of their compact JSON encoding, so the document can be reformatted without invalidating it.
+type SignedOverrides struct {
+	Overrides json.RawMessage `json:"overrides"`
+	Signature string          `json:"signature"`
+}
+
+// GenerateOverrideKey returns a new base64-encoded Ed25519 key pair for signing override documents.
+func GenerateOverrideKey() (publicKey, privateKey string, err error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(public), base64.StdEncoding.EncodeToString(private), nil
+}
+
+// SignOverrides signs the overrides in data with the base64-encoded Ed25519 private key and returns the
+// signed document.
+func SignOverrides(data []byte, privateKey string) ([]byte, error) {
+	// reject documents bootstrap would not accept before they are published
+	var overrides Overrides
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, err
+	}
+	if overrides.Product == "" {
+		return nil, errors.New("overrides must name the product they apply to")
+	}
+	if overrides.Expires.IsZero() {
+		return nil, errors.New("overrides must have an expiry time")
+	}
+
+	compact, signature, err := signJSON(data, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(SignedOverrides{Overrides: compact, Signature: signature}, "", "  ")
+}
+
+// VerifyOverrides checks that the signed override document in data is signed by a key of store holding the
+// overrides role, and returns its overrides, unless they are for another product than product or have expired.
+func VerifyOverrides(data []byte, store *TrustStore, product string) (*Overrides, error) {
+	var signed SignedOverrides
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, err
+	}
+
+	if _, err := store.Verify(signed.Overrides, signed.Signature, TrustRoleOverrides, time.Now()); err != nil {
+		return nil, fmt.Errorf("override signature is not valid: %w", err)
+	}
+
+	var overrides Overrides
+	if err := json.Unmarshal(signed.Overrides, &overrides); err != nil {
+		return nil, err
+	}
+
+	if overrides.Product != product {
+		return nil, fmt.Errorf("overrides are for %q, not %q", overrides.Product, product)
+	}
+
+	if overrides.Expires.IsZero() {
+		return nil, errors.New("overrides have no expiry time")
+	}
+	if time.Now().After(overrides.Expires) {
+		return nil, fmt.Errorf("overrides expired on %s", overrides.Expires.Format(time.RFC3339))
+	}
+
+	return &overrides, nil
+}
+
+// ParseOverrideKey decodes a base64-encoded Ed25519 public key.
+func ParseOverrideKey(publicKey string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid Ed25519 public key")
+	}
+
+	return ed25519.PublicKey(key), nil
+}
+
+// signJSON returns the compact encoding of the JSON document in data and its base64-encoded Ed25519 signature
+// with the base64-encoded private key.
+func signJSON(data []byte, privateKey string) (json.RawMessage, string, error) {
+	key, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		return nil, "", errors.New("invalid Ed25519 private key")
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		return nil, "", err
+	}
+
+	return compact.Bytes(), base64.StdEncoding.EncodeToString(ed25519.Sign(ed25519.PrivateKey(key), compact.Bytes())), nil
+}
+
+// verifyJSON reports whether signature is a valid signature by key of the compact encoding of document.
+func verifyJSON(document json.RawMessage, signature string, key ed25519.PublicKey) bool {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, document); err != nil {
+		return false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	return err == nil && ed25519.Verify(key, compact.Bytes(), decoded)
+}
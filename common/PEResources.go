@@ -0,0 +1,401 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// PEResources are the icon and version information written into a Windows executable, as shown by Explorer.
+type PEResources struct {
+	// Icon is the contents of an .ico file.
+	Icon []byte
+	// Version is the file and product version, e.g. "1.2.3" or "1.2.3.4".
+	Version string
+	// Strings are the version information strings, such as CompanyName and ProductName.
+	Strings map[string]string
+}
+
+// Resource types and the language resources are written in.
+const (
+	rtIcon      = 3
+	rtGroupIcon = 14
+	rtVersion   = 16
+
+	resourceLanguage = 0x0409 // English (United States)
+	resourceCodePage = 0x04b0 // Unicode
+)
+
+// Offsets in the PE headers, see https://learn.microsoft.com/windows/win32/debug/pe-format.
+const (
+	peSectionHeaderSize   = 40
+	peResourceDirectory   = 2
+	peScnInitializedData  = 0x00000040
+	peScnMemRead          = 0x40000000
+	peSubdirectoryFlag    = 0x80000000
+	peOptionalMagicPE32   = 0x10b
+	peOptionalMagicPE32P  = 0x20b
+	peDataDirectoriesPE32 = 96
+	peDataDirectoriesPE64 = 112
+)
+
+var errNotPE = errors.New("resources can only be added to Windows executables")
+
+// ParseFileVersion parses a version of up to four dot-separated numbers. Missing parts are zero.
+func ParseFileVersion(version string) ([4]uint16, error) {
+	var parts [4]uint16
+
+	fields := strings.Split(version, ".")
+	if len(fields) > 4 {
+		return parts, fmt.Errorf("version %q has more than four parts", version)
+	}
+
+	for i, field := range fields {
+		value, err := strconv.ParseUint(field, 10, 16)
+		if err != nil {
+			return parts, fmt.Errorf("version %q: %w", version, err)
+		}
+		parts[i] = uint16(value)
+	}
+
+	return parts, nil
+}
+
+// AddPEResources returns a copy of the executable exe with a resource section holding resources appended.
+// Any resource directory the executable already has is replaced.
+func AddPEResources(exe []byte, resources PEResources) ([]byte, error) {
+	var entries []peResource
+
+	if len(resources.Icon) > 0 {
+		images, group, err := parseIcon(resources.Icon)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, image := range images {
+			entries = append(entries, peResource{typeID: rtIcon, nameID: uint16(i + 1), data: image})
+		}
+		entries = append(entries, peResource{typeID: rtGroupIcon, nameID: 1, data: group})
+	}
+
+	if resources.Version != "" || len(resources.Strings) > 0 {
+		var version [4]uint16
+		if resources.Version != "" {
+			var err error
+			if version, err = ParseFileVersion(resources.Version); err != nil {
+				return nil, err
+			}
+		}
+
+		entries = append(entries, peResource{typeID: rtVersion, nameID: 1, data: versionInfo(version, resources.Strings)})
+	}
+
+	if len(entries) == 0 {
+		return exe, nil
+	}
+
+	return appendResourceSection(exe, entries)
+}
+
+type peResource struct {
+	typeID uint16
+	nameID uint16
+	data   []byte
+}
+
+// appendResourceSection adds a .rsrc section at the end of the file and points the resource directory at it.
+func appendResourceSection(exe []byte, entries []peResource) ([]byte, error) {
+	le := binary.LittleEndian
+
+	if len(exe) < 0x40 || exe[0] != 'M' || exe[1] != 'Z' {
+		return nil, errNotPE
+	}
+
+	peOffset := int(le.Uint32(exe[0x3c:]))
+	if peOffset+24 > len(exe) || !bytes.Equal(exe[peOffset:peOffset+4], []byte("PE\x00\x00")) {
+		return nil, errNotPE
+	}
+
+	coff := peOffset + 4
+	sectionCount := int(le.Uint16(exe[coff+2:]))
+	optional := coff + 20
+	sectionTable := optional + int(le.Uint16(exe[coff+16:]))
+
+	var dataDirectories int
+	switch le.Uint16(exe[optional:]) {
+	case peOptionalMagicPE32:
+		dataDirectories = optional + peDataDirectoriesPE32
+	case peOptionalMagicPE32P:
+		dataDirectories = optional + peDataDirectoriesPE64
+	default:
+		return nil, errNotPE
+	}
+
+	if int(le.Uint32(exe[dataDirectories-4:])) <= peResourceDirectory {
+		return nil, errors.New("executable has no resource directory entry")
+	}
+
+	sectionAlignment := le.Uint32(exe[optional+32:])
+	fileAlignment := le.Uint32(exe[optional+36:])
+	sizeOfHeaders := le.Uint32(exe[optional+60:])
+
+	// the new section header must fit between the existing headers and the first section's data
+	newHeader := sectionTable + sectionCount*peSectionHeaderSize
+	firstData := sizeOfHeaders
+	var imageEnd uint32
+
+	for i := 0; i < sectionCount; i++ {
+		header := exe[sectionTable+i*peSectionHeaderSize:]
+		virtualSize, virtualAddress := le.Uint32(header[8:]), le.Uint32(header[12:])
+		rawSize, rawPointer := le.Uint32(header[16:]), le.Uint32(header[20:])
+
+		if rawPointer != 0 && rawPointer < firstData {
+			firstData = rawPointer
+		}
+		imageEnd = max(imageEnd, virtualAddress+max(virtualSize, rawSize))
+	}
+
+	if uint32(newHeader+peSectionHeaderSize) > firstData {
+		return nil, errors.New("no room for another section header in the executable")
+	}
+
+	sectionRVA := alignUp(imageEnd, sectionAlignment)
+	section := buildResourceSection(entries, sectionRVA)
+	rawSize := alignUp(uint32(len(section)), fileAlignment)
+
+	output := make([]byte, alignUp(uint32(len(exe)), fileAlignment))
+	copy(output, exe)
+	rawPointer := uint32(len(output))
+	output = append(output, section...)
+	output = append(output, make([]byte, rawSize-uint32(len(section)))...)
+
+	header := output[newHeader : newHeader+peSectionHeaderSize]
+	copy(header, ".rsrc\x00\x00\x00")
+	le.PutUint32(header[8:], uint32(len(section)))
+	le.PutUint32(header[12:], sectionRVA)
+	le.PutUint32(header[16:], rawSize)
+	le.PutUint32(header[20:], rawPointer)
+	le.PutUint32(header[36:], peScnInitializedData|peScnMemRead)
+
+	le.PutUint16(output[coff+2:], uint16(sectionCount+1))
+	le.PutUint32(output[optional+8:], le.Uint32(output[optional+8:])+rawSize)                      // SizeOfInitializedData
+	le.PutUint32(output[optional+56:], alignUp(sectionRVA+uint32(len(section)), sectionAlignment)) // SizeOfImage
+	le.PutUint32(output[optional+64:], 0)                                                          // CheckSum, only required for drivers
+
+	directory := dataDirectories + peResourceDirectory*8
+	le.PutUint32(output[directory:], sectionRVA)
+	le.PutUint32(output[directory+4:], uint32(len(section)))
+
+	return output, nil
+}
+
+// buildResourceSection lays out the resource directory tree (type, name, language) followed by the resource
+// data. Offsets in the tree are relative to the section; data entries hold the RVA of their data.
+func buildResourceSection(entries []peResource, sectionRVA uint32) []byte {
+	le := binary.LittleEndian
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].typeID != entries[j].typeID {
+			return entries[i].typeID < entries[j].typeID
+		}
+		return entries[i].nameID < entries[j].nameID
+	})
+
+	var types []uint16
+	namesOfType := make(map[uint16][]int)
+	for i, entry := range entries {
+		if len(namesOfType[entry.typeID]) == 0 {
+			types = append(types, entry.typeID)
+		}
+		namesOfType[entry.typeID] = append(namesOfType[entry.typeID], i)
+	}
+
+	// first pass: offsets of every directory, data entry and data blob
+	offset := uint32(16 + 8*len(types))
+	typeOffsets := make(map[uint16]uint32)
+	for _, typeID := range types {
+		typeOffsets[typeID] = offset
+		offset += uint32(16 + 8*len(namesOfType[typeID]))
+	}
+
+	nameOffsets := make([]uint32, len(entries))
+	for i := range entries {
+		nameOffsets[i] = offset
+		offset += 16 + 8
+	}
+
+	dataEntryOffsets := make([]uint32, len(entries))
+	for i := range entries {
+		dataEntryOffsets[i] = offset
+		offset += 16
+	}
+
+	dataOffsets := make([]uint32, len(entries))
+	for i, entry := range entries {
+		offset = alignUp(offset, 8)
+		dataOffsets[i] = offset
+		offset += uint32(len(entry.data))
+	}
+
+	section := make([]byte, offset)
+
+	writeDirectory := func(at uint32, ids []uint16, targets []uint32) {
+		le.PutUint16(section[at+14:], uint16(len(ids)))
+		for i, id := range ids {
+			entry := at + 16 + uint32(8*i)
+			le.PutUint32(section[entry:], uint32(id))
+			le.PutUint32(section[entry+4:], targets[i])
+		}
+	}
+
+	var typeTargets []uint32
+	for _, typeID := range types {
+		typeTargets = append(typeTargets, typeOffsets[typeID]|peSubdirectoryFlag)
+
+		var ids []uint16
+		var targets []uint32
+		for _, i := range namesOfType[typeID] {
+			ids = append(ids, entries[i].nameID)
+			targets = append(targets, nameOffsets[i]|peSubdirectoryFlag)
+		}
+		writeDirectory(typeOffsets[typeID], ids, targets)
+	}
+	writeDirectory(0, types, typeTargets)
+
+	for i, entry := range entries {
+		writeDirectory(nameOffsets[i], []uint16{resourceLanguage}, []uint32{dataEntryOffsets[i]})
+
+		le.PutUint32(section[dataEntryOffsets[i]:], sectionRVA+dataOffsets[i])
+		le.PutUint32(section[dataEntryOffsets[i]+4:], uint32(len(entry.data)))
+		copy(section[dataOffsets[i]:], entry.data)
+	}
+
+	return section
+}
+
+// parseIcon splits an .ico file into its images and the RT_GROUP_ICON directory that refers to them by ID,
+// starting at 1.
+func parseIcon(ico []byte) (images [][]byte, group []byte, err error) {
+	le := binary.LittleEndian
+
+	if len(ico) < 6 || le.Uint16(ico[0:]) != 0 || le.Uint16(ico[2:]) != 1 {
+		return nil, nil, errors.New("not an .ico file")
+	}
+
+	count := int(le.Uint16(ico[4:]))
+	if count == 0 || len(ico) < 6+16*count {
+		return nil, nil, errors.New("icon file has no images")
+	}
+
+	group = make([]byte, 6, 6+14*count)
+	le.PutUint16(group[2:], 1)
+	le.PutUint16(group[4:], uint16(count))
+
+	for i := 0; i < count; i++ {
+		entry := ico[6+16*i : 6+16*(i+1)]
+		size, offset := le.Uint32(entry[8:]), le.Uint32(entry[12:])
+
+		if uint64(offset)+uint64(size) > uint64(len(ico)) {
+			return nil, nil, fmt.Errorf("icon image %d extends past the end of the file", i+1)
+		}
+		images = append(images, ico[offset:offset+size])
+
+		// GRPICONDIRENTRY is ICONDIRENTRY with the image offset replaced by a 16-bit resource ID
+		group = append(group, entry[:12]...)
+		group = le.AppendUint16(group, uint16(i+1))
+	}
+
+	return images, group, nil
+}
+
+// versionInfo encodes a VS_VERSIONINFO resource with the given file and product version and strings.
+func versionInfo(version [4]uint16, values map[string]string) []byte {
+	le := binary.LittleEndian
+
+	ms := uint32(version[0])<<16 | uint32(version[1])
+	ls := uint32(version[2])<<16 | uint32(version[3])
+
+	fixed := make([]byte, 52)
+	for i, value := range []uint32{
+		0xfeef04bd, // signature
+		0x00010000, // structure version
+		ms, ls,     // file version
+		ms, ls, // product version
+		0x3f,       // file flags mask
+		0,          // file flags
+		0x00040004, // VOS_NT_WINDOWS32
+		0x00000001, // VFT_APP
+	} {
+		le.PutUint32(fixed[4*i:], value)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var stringBlocks [][]byte
+	for _, key := range keys {
+		value := utf16String(values[key])
+		stringBlocks = append(stringBlocks, versionBlock(key, 1, value, uint16(len(value)/2), nil))
+	}
+
+	language := fmt.Sprintf("%04x%04x", resourceLanguage, resourceCodePage)
+	stringTable := versionBlock(language, 1, nil, 0, stringBlocks)
+	stringFileInfo := versionBlock("StringFileInfo", 1, nil, 0, [][]byte{stringTable})
+
+	translation := le.AppendUint16(le.AppendUint16(nil, resourceLanguage), resourceCodePage)
+	varFileInfo := versionBlock("VarFileInfo", 1, nil, 0, [][]byte{versionBlock("Translation", 0, translation, uint16(len(translation)), nil)})
+
+	return versionBlock("VS_VERSION_INFO", 0, fixed, uint16(len(fixed)), [][]byte{stringFileInfo, varFileInfo})
+}
+
+// versionBlock encodes one node of a version resource: its length, value length, type and key, the value and
+// the children, each aligned to 32 bits. valueLength is in words for text values and in bytes otherwise.
+func versionBlock(key string, valueType uint16, value []byte, valueLength uint16, children [][]byte) []byte {
+	le := binary.LittleEndian
+
+	block := make([]byte, 6)
+	le.PutUint16(block[2:], valueLength)
+	le.PutUint16(block[4:], valueType)
+	block = append(block, utf16String(key)...)
+	block = padTo32Bits(block)
+	block = append(block, value...)
+
+	for _, child := range children {
+		block = padTo32Bits(block)
+		block = append(block, child...)
+	}
+
+	le.PutUint16(block[0:], uint16(len(block)))
+	return block
+}
+
+// utf16String encodes s as NUL-terminated little-endian UTF-16.
+func utf16String(s string) []byte {
+	var encoded []byte
+	for _, unit := range utf16.Encode([]rune(s + "\x00")) {
+		encoded = binary.LittleEndian.AppendUint16(encoded, unit)
+	}
+	return encoded
+}
+
+func padTo32Bits(block []byte) []byte {
+	for len(block)%4 != 0 {
+		block = append(block, 0)
+	}
+	return block
+}
+
+func alignUp(value, alignment uint32) uint32 {
+	if alignment == 0 {
+		return value
+	}
+	return (value + alignment - 1) / alignment * alignment
+}
@@ -0,0 +1,227 @@
+package common
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseFileVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    [4]uint16
+		wantErr bool
+	}{
+		{version: "1", want: [4]uint16{1, 0, 0, 0}},
+		{version: "1.2.3", want: [4]uint16{1, 2, 3, 0}},
+		{version: "1.2.3.4", want: [4]uint16{1, 2, 3, 4}},
+		{version: "65535.0.0.1", want: [4]uint16{65535, 0, 0, 1}},
+		{version: "1.2.3.4.5", wantErr: true},
+		{version: "65536", wantErr: true},
+		{version: "1.x", wantErr: true},
+		{version: "1..2", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseFileVersion(test.version)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseFileVersion(%q) error = %v, want error %v", test.version, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && got != test.want {
+			t.Errorf("ParseFileVersion(%q) = %v, want %v", test.version, got, test.want)
+		}
+	}
+}
+
+func TestVersionBlock(t *testing.T) {
+	// the Translation block of an English (United States) Unicode resource, as written by the resource compiler
+	want := []byte{
+		0x24, 0x00, 0x04, 0x00, 0x00, 0x00,
+		'T', 0, 'r', 0, 'a', 0, 'n', 0, 's', 0, 'l', 0, 'a', 0, 't', 0, 'i', 0, 'o', 0, 'n', 0, 0, 0,
+		0x00, 0x00,
+		0x09, 0x04, 0xb0, 0x04,
+	}
+
+	got := versionBlock("Translation", 0, []byte{0x09, 0x04, 0xb0, 0x04}, 4, nil)
+	if !bytes.Equal(got, want) {
+		t.Errorf("versionBlock() = % x, want % x", got, want)
+	}
+}
+
+// testExecutable returns a PE32+ image with headers and a single empty .text section, as a linker would lay
+// them out.
+func testExecutable() []byte {
+	le := binary.LittleEndian
+	exe := make([]byte, 0x400)
+
+	copy(exe, "MZ")
+	le.PutUint32(exe[0x3c:], 0x40)
+	copy(exe[0x40:], "PE\x00\x00")
+
+	coff := 0x44
+	le.PutUint16(exe[coff:], 0x8664)  // Machine: AMD64
+	le.PutUint16(exe[coff+2:], 1)     // NumberOfSections
+	le.PutUint16(exe[coff+16:], 240)  // SizeOfOptionalHeader
+	le.PutUint16(exe[coff+18:], 0x22) // Characteristics: executable, large address aware
+
+	optional := coff + 20
+	le.PutUint16(exe[optional:], peOptionalMagicPE32P)
+	le.PutUint32(exe[optional+8:], 0)       // SizeOfInitializedData
+	le.PutUint32(exe[optional+16:], 0x1000) // AddressOfEntryPoint
+	le.PutUint32(exe[optional+32:], 0x1000) // SectionAlignment
+	le.PutUint32(exe[optional+36:], 0x200)  // FileAlignment
+	le.PutUint32(exe[optional+56:], 0x2000) // SizeOfImage
+	le.PutUint32(exe[optional+60:], 0x200)  // SizeOfHeaders
+	le.PutUint16(exe[optional+68:], 3)      // Subsystem: console
+	le.PutUint32(exe[optional+108:], 16)    // NumberOfRvaAndSizes
+
+	text := optional + 240
+	copy(exe[text:], ".text")
+	le.PutUint32(exe[text+8:], 0x10)        // VirtualSize
+	le.PutUint32(exe[text+12:], 0x1000)     // VirtualAddress
+	le.PutUint32(exe[text+16:], 0x200)      // SizeOfRawData
+	le.PutUint32(exe[text+20:], 0x200)      // PointerToRawData
+	le.PutUint32(exe[text+36:], 0x60000020) // code, execute, read
+
+	return exe
+}
+
+// testIcon returns an .ico file with two images of the given contents.
+func testIcon(first, second []byte) []byte {
+	le := binary.LittleEndian
+
+	ico := le.AppendUint16(nil, 0)
+	ico = le.AppendUint16(ico, 1)
+	ico = le.AppendUint16(ico, 2)
+
+	offset := uint32(6 + 2*16)
+	for i, image := range [][]byte{first, second} {
+		entry := []byte{byte(16 * (i + 1)), byte(16 * (i + 1)), 0, 0, 1, 0, 32, 0}
+		entry = le.AppendUint32(entry, uint32(len(image)))
+		entry = le.AppendUint32(entry, offset)
+		ico = append(ico, entry...)
+		offset += uint32(len(image))
+	}
+
+	ico = append(ico, first...)
+	return append(ico, second...)
+}
+
+// findResource walks the type, name and language levels of the resource directory in section, which is mapped
+// at sectionRVA, and returns the data of the first language of typeID/nameID.
+func findResource(t *testing.T, section []byte, sectionRVA uint32, typeID, nameID uint32) []byte {
+	t.Helper()
+	le := binary.LittleEndian
+
+	lookup := func(directory, id uint32) uint32 {
+		named, numbered := uint32(le.Uint16(section[directory+12:])), uint32(le.Uint16(section[directory+14:]))
+		for i := uint32(0); i < named+numbered; i++ {
+			entry := directory + 16 + 8*i
+			if le.Uint32(section[entry:]) == id {
+				return le.Uint32(section[entry+4:])
+			}
+		}
+		t.Fatalf("resource %d/%d: no entry %d in directory at %#x", typeID, nameID, id, directory)
+		return 0
+	}
+
+	names := lookup(0, typeID)
+	languages := lookup(names&^peSubdirectoryFlag, nameID)
+	if names&peSubdirectoryFlag == 0 || languages&peSubdirectoryFlag == 0 {
+		t.Fatalf("resource %d/%d: directory entry points at data", typeID, nameID)
+	}
+
+	directory := languages &^ peSubdirectoryFlag
+	if le.Uint16(section[directory+14:]) != 1 || le.Uint32(section[directory+16:]) != resourceLanguage {
+		t.Fatalf("resource %d/%d is not in a single language %#x", typeID, nameID, resourceLanguage)
+	}
+
+	dataEntry := le.Uint32(section[directory+20:])
+	rva, size := le.Uint32(section[dataEntry:]), le.Uint32(section[dataEntry+4:])
+	return section[rva-sectionRVA : rva-sectionRVA+size]
+}
+
+func TestAddPEResources(t *testing.T) {
+	le := binary.LittleEndian
+	small, large := []byte("small image"), []byte("larger icon image")
+
+	output, err := AddPEResources(testExecutable(), PEResources{
+		Icon:    testIcon(small, large),
+		Version: "1.2.3",
+		Strings: map[string]string{"ProductName": "Demo"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := pe.NewFile(bytes.NewReader(output))
+	if err != nil {
+		t.Fatalf("output is not a valid PE file: %v", err)
+	}
+
+	if len(file.Sections) != 2 || file.Sections[1].Name != ".rsrc" {
+		t.Fatalf("sections = %v, want .text and .rsrc", file.Sections)
+	}
+	rsrc := file.Sections[1]
+	if rsrc.VirtualAddress != 0x2000 || rsrc.Offset != 0x400 {
+		t.Errorf(".rsrc at RVA %#x, offset %#x, want RVA 0x2000 and offset 0x400", rsrc.VirtualAddress, rsrc.Offset)
+	}
+
+	header := file.OptionalHeader.(*pe.OptionalHeader64)
+	if directory := header.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_RESOURCE]; directory.VirtualAddress != rsrc.VirtualAddress || directory.Size != rsrc.VirtualSize {
+		t.Errorf("resource directory = %+v, want the .rsrc section", directory)
+	}
+	if header.SizeOfImage != alignUp(rsrc.VirtualAddress+rsrc.VirtualSize, 0x1000) {
+		t.Errorf("SizeOfImage = %#x does not cover .rsrc", header.SizeOfImage)
+	}
+
+	section, err := rsrc.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := findResource(t, section, rsrc.VirtualAddress, rtIcon, 1); !bytes.Equal(got, small) {
+		t.Errorf("icon 1 = %q, want %q", got, small)
+	}
+	if got := findResource(t, section, rsrc.VirtualAddress, rtIcon, 2); !bytes.Equal(got, large) {
+		t.Errorf("icon 2 = %q, want %q", got, large)
+	}
+
+	group := findResource(t, section, rsrc.VirtualAddress, rtGroupIcon, 1)
+	if len(group) != 6+2*14 || le.Uint16(group[4:]) != 2 || le.Uint16(group[6+12:]) != 1 || le.Uint16(group[6+14+12:]) != 2 {
+		t.Errorf("icon group = % x, want two entries referring to icons 1 and 2", group)
+	}
+
+	version := findResource(t, section, rsrc.VirtualAddress, rtVersion, 1)
+	if int(le.Uint16(version)) != len(version) {
+		t.Errorf("version resource length field = %d, want %d", le.Uint16(version), len(version))
+	}
+	// VS_FIXEDFILEINFO follows the 6 byte header and the padded "VS_VERSION_INFO" key
+	fixed := version[40:]
+	if le.Uint32(fixed) != 0xfeef04bd || le.Uint32(fixed[8:]) != 0x00010002 || le.Uint32(fixed[12:]) != 0x00030000 {
+		t.Errorf("VS_FIXEDFILEINFO = % x, want version 1.2.3.0", fixed[:16])
+	}
+	if !bytes.Contains(version, append(utf16String("ProductName"), 0, 0)) || !bytes.Contains(version, utf16String("Demo")) {
+		t.Errorf("version resource does not hold ProductName=Demo")
+	}
+}
+
+func TestAddPEResourcesRejects(t *testing.T) {
+	if _, err := AddPEResources([]byte("#!/bin/sh\n"), PEResources{Version: "1.0"}); err != errNotPE {
+		t.Errorf("AddPEResources() of a script: %v, want errNotPE", err)
+	}
+
+	if _, err := AddPEResources(testExecutable(), PEResources{Icon: []byte("not an icon")}); err == nil {
+		t.Errorf("AddPEResources() accepted an invalid icon")
+	}
+
+	// a section header table that already reaches the first section's data leaves no room for .rsrc
+	exe := testExecutable()
+	binary.LittleEndian.PutUint32(exe[0x44+20+60:], 0x170)
+	binary.LittleEndian.PutUint32(exe[0x44+20+240+20:], 0x170)
+	if _, err := AddPEResources(exe, PEResources{Version: "1.0"}); err == nil {
+		t.Errorf("AddPEResources() added a section header over section data")
+	}
+}
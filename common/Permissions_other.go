@@ -0,0 +1,29 @@
+//go:build !windows
+
+package common
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// HardenPermissions removes write permission for the group and other users from path and everything below it,
+// so only the owner can change the files.
+func HardenPermissions(path string) error {
+	return filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		return os.Chmod(path, info.Mode().Perm()&^0022)
+	})
+}
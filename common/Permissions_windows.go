@@ -0,0 +1,21 @@
+package common
+
+// Well-known security identifiers, which do not depend on the language of Windows.
+const (
+	sidAdministrators = "*S-1-5-32-544"
+	sidSystem         = "*S-1-5-18"
+	sidUsers          = "*S-1-5-32-545"
+)
+
+// HardenPermissions replaces the access control list of path, and of everything below it, so administrators and
+// SYSTEM have full control and other users can only read and execute. Inherited entries are removed.
+func HardenPermissions(path string) error {
+	return RunCommand("icacls", []string{
+		path,
+		"/inheritance:r",
+		"/grant:r", sidAdministrators + ":(OI)(CI)F",
+		"/grant:r", sidSystem + ":(OI)(CI)F",
+		"/grant:r", sidUsers + ":(OI)(CI)RX",
+		"/T", "/C", "/Q",
+	})
+}
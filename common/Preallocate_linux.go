@@ -0,0 +1,22 @@
+package common
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// preallocateFile reserves size bytes on disk for f using fallocate.
+// Filesystems that do not support fallocate are silently skipped.
+func preallocateFile(f *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !windows
+
+package common
+
+import "os"
+
+// preallocateFile is a no-op on platforms without a supported preallocation call.
+func preallocateFile(f *os.File, size int64) error {
+	return nil
+}
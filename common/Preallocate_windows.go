@@ -0,0 +1,25 @@
+package common
+
+import (
+	"os"
+	"syscall"
+)
+
+var procSetFileValidData = syscall.NewLazyDLL("kernel32.dll").NewProc("SetFileValidData")
+
+// preallocateFile reserves size bytes on disk for f by extending the end of file.
+// When the process holds SeManageVolumePrivilege, SetFileValidData is also used to skip zero-filling.
+func preallocateFile(f *os.File, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+
+	// SetFileValidData fails without the privilege; the file is still correctly sized in that case
+	_, _, _ = procSetFileValidData.Call(f.Fd(), uintptr(size))
+
+	return nil
+}
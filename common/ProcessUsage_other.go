@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package common
+
+import "os/exec"
+
+// startMeasured starts cmd without measuring it on platforms that do not report memory use.
+func startMeasured(cmd *exec.Cmd) (func() uint64, error) {
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return func() uint64 { return 0 }, nil
+}
@@ -0,0 +1,33 @@
+//go:build unix
+
+package common
+
+import (
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// startMeasured starts cmd and returns a function reporting its peak resident set size once it has exited.
+func startMeasured(cmd *exec.Cmd) (func() uint64, error) {
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return func() uint64 {
+		if cmd.ProcessState == nil {
+			return 0
+		}
+
+		rusage, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage)
+		if !ok {
+			return 0
+		}
+
+		// macOS reports bytes, other systems report kilobytes
+		if runtime.GOOS == "darwin" {
+			return uint64(rusage.Maxrss)
+		}
+		return uint64(rusage.Maxrss) * 1024
+	}, nil
+}
@@ -0,0 +1,81 @@
+package common
+
+import (
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procCreateJobObjectW          = syscall.NewLazyDLL("kernel32.dll").NewProc("CreateJobObjectW")
+	procAssignProcessToJobObject  = syscall.NewLazyDLL("kernel32.dll").NewProc("AssignProcessToJobObject")
+	procQueryInformationJobObject = syscall.NewLazyDLL("kernel32.dll").NewProc("QueryInformationJobObject")
+)
+
+const (
+	jobObjectExtendedLimitInformationClass = 9
+	processSetQuota                        = 0x0100
+	processTerminate                       = 0x0001
+)
+
+type jobObjectBasicLimitInformation struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+}
+
+type ioCounters struct {
+	ReadOperationCount  uint64
+	WriteOperationCount uint64
+	OtherOperationCount uint64
+	ReadTransferCount   uint64
+	WriteTransferCount  uint64
+	OtherTransferCount  uint64
+}
+
+type jobObjectExtendedLimitInformation struct {
+	BasicLimitInformation jobObjectBasicLimitInformation
+	IoInfo                ioCounters
+	ProcessMemoryLimit    uintptr
+	JobMemoryLimit        uintptr
+	PeakProcessMemoryUsed uintptr
+	PeakJobMemoryUsed     uintptr
+}
+
+// startMeasured starts cmd inside a Job Object and returns a function reporting the peak memory committed by the
+// job, which includes any child processes started after the command itself.
+func startMeasured(cmd *exec.Cmd) (func() uint64, error) {
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	// measuring is best effort; the command keeps running even if the job cannot be set up
+	job, _, _ := procCreateJobObjectW.Call(0, 0)
+	if job == 0 {
+		return func() uint64 { return 0 }, nil
+	}
+
+	process, err := syscall.OpenProcess(processSetQuota|processTerminate, false, uint32(cmd.Process.Pid))
+	if err == nil {
+		_, _, _ = procAssignProcessToJobObject.Call(job, uintptr(process))
+		_ = syscall.CloseHandle(process)
+	}
+
+	return func() uint64 {
+		defer syscall.CloseHandle(syscall.Handle(job))
+
+		var info jobObjectExtendedLimitInformation
+		result, _, _ := procQueryInformationJobObject.Call(job, jobObjectExtendedLimitInformationClass, uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info), 0)
+		if result == 0 {
+			return 0
+		}
+
+		return uint64(info.PeakJobMemoryUsed)
+	}, nil
+}
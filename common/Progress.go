@@ -0,0 +1,100 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	progressBarWidth    = 30
+	progressRedrawDelay = 200 * time.Millisecond
+)
+
+// ProgressReader reports how much of an underlying reader has been consumed as a console progress bar.
+// The bar is redrawn in place at most every progressRedrawDelay; call Finish once the reader is no longer used.
+type ProgressReader struct {
+	reader io.Reader
+	output io.Writer
+	label  string
+	total  int64
+
+	done     int64
+	started  time.Time
+	lastDraw time.Time
+}
+
+// NewProgressReader wraps reader, which is expected to yield total bytes, and draws its progress to output
+// under label.
+func NewProgressReader(reader io.Reader, total int64, label string, output io.Writer) *ProgressReader {
+	return &ProgressReader{reader: reader, output: output, label: label, total: total, started: time.Now()}
+}
+
+func (progress *ProgressReader) Read(p []byte) (int, error) {
+	n, err := progress.reader.Read(p)
+	progress.done += int64(n)
+
+	if time.Since(progress.lastDraw) >= progressRedrawDelay {
+		progress.draw()
+	}
+
+	return n, err
+}
+
+// Finish draws the final state of the bar and ends its line. A nil err marks the reader as complete, since
+// decompressors may stop before reading trailing padding.
+func (progress *ProgressReader) Finish(err error) {
+	if err == nil && progress.done < progress.total {
+		progress.done = progress.total
+	}
+
+	progress.draw()
+	fmt.Fprintln(progress.output)
+}
+
+func (progress *ProgressReader) draw() {
+	progress.lastDraw = time.Now()
+
+	fraction := 1.0
+	if progress.total > 0 && progress.done < progress.total {
+		fraction = float64(progress.done) / float64(progress.total)
+	}
+
+	filled := int(fraction * progressBarWidth)
+	bar := strings.Repeat("#", filled) + strings.Repeat(".", progressBarWidth-filled)
+
+	fmt.Fprintf(progress.output, "\r%-10s [%s] %3.0f%%  %s / %s  %s", progress.label, bar, fraction*100,
+		FormatBytes(progress.done), FormatBytes(progress.total), progress.eta(fraction))
+}
+
+// eta estimates the remaining time from the average rate so far.
+func (progress *ProgressReader) eta(fraction float64) string {
+	if fraction >= 1 {
+		return "done    "
+	}
+
+	elapsed := time.Since(progress.started)
+	if fraction <= 0 || elapsed < time.Second {
+		return "ETA --:--"
+	}
+
+	remaining := time.Duration(float64(elapsed) * (1 - fraction) / fraction).Round(time.Second)
+	return fmt.Sprintf("ETA %02d:%02d", int(remaining.Minutes()), int(remaining.Seconds())%60)
+}
+
+// FormatBytes formats a size in bytes with a binary unit, e.g. "12.3 MiB".
+func FormatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
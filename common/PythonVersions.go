@@ -0,0 +1,175 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// PythonRelease describes how the embeddable distribution of a Python minor version is laid out,
+// and which pip.pyz is known to work with it.
+type PythonRelease struct {
+	Version        string
+	PipDownloadURL string
+	PthFile        string
+	InteriorZip    string
+}
+
+// SupportedPythonReleases lists the embeddable Python minor versions exepy has been verified with.
+// Add a version here only after building and running an installer with it.
+var SupportedPythonReleases = []PythonRelease{
+	{Version: "3.8", PipDownloadURL: "https://bootstrap.pypa.io/pip/3.8/pip.pyz", PthFile: "python38._pth", InteriorZip: "python38.zip"},
+	{Version: "3.9", PipDownloadURL: "https://bootstrap.pypa.io/pip/pip.pyz", PthFile: "python39._pth", InteriorZip: "python39.zip"},
+	{Version: "3.10", PipDownloadURL: "https://bootstrap.pypa.io/pip/pip.pyz", PthFile: "python310._pth", InteriorZip: "python310.zip"},
+	{Version: "3.11", PipDownloadURL: "https://bootstrap.pypa.io/pip/pip.pyz", PthFile: "python311._pth", InteriorZip: "python311.zip"},
+	{Version: "3.12", PipDownloadURL: "https://bootstrap.pypa.io/pip/pip.pyz", PthFile: "python312._pth", InteriorZip: "python312.zip"},
+}
+
+var embeddableVersionPattern = regexp.MustCompile(`python-(\d+\.\d+)\.(\d+)-embed-`)
+
+// PythonVersionFromURL extracts the full version, e.g. "3.11.7", from an embeddable distribution URL.
+func PythonVersionFromURL(url string) (string, error) {
+	match := embeddableVersionPattern.FindStringSubmatch(url)
+	if match == nil {
+		return "", fmt.Errorf("cannot determine Python version from %q", url)
+	}
+
+	return match[1] + "." + match[2], nil
+}
+
+// LookupPythonRelease finds the supported release matching the minor version of version.
+func LookupPythonRelease(version string) (PythonRelease, bool) {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return PythonRelease{}, false
+	}
+
+	minor := parts[0] + "." + parts[1]
+	for _, release := range SupportedPythonReleases {
+		if release.Version == minor {
+			return release, true
+		}
+	}
+
+	return PythonRelease{}, false
+}
+
+var fullVersionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+// EmbeddableDistributionURL returns the python.org download URL of the 64-bit embeddable distribution of version.
+func EmbeddableDistributionURL(version string) string {
+	return fmt.Sprintf("https://www.python.org/ftp/python/%[1]s/python-%[1]s-embed-amd64.zip", version)
+}
+
+// ApplyPythonVersion fills in the download, pip and layout settings for settings.PythonVersion from the support
+// matrix. Settings that are already set are kept, and checked against the version by the creator.
+func ApplyPythonVersion(settings *PythonSetupSettings) error {
+	if settings.PythonVersion == "" {
+		return nil
+	}
+
+	if !fullVersionPattern.MatchString(settings.PythonVersion) {
+		return fmt.Errorf("pythonVersion must be a full version such as \"3.12.4\", not %q", settings.PythonVersion)
+	}
+
+	if settings.PythonDownloadURL == "" {
+		settings.PythonDownloadURL = EmbeddableDistributionURL(settings.PythonVersion)
+	} else if version, err := PythonVersionFromURL(settings.PythonDownloadURL); err == nil && version != settings.PythonVersion {
+		return fmt.Errorf("pythonVersion is %s but pythonDownloadURL is for Python %s", settings.PythonVersion, version)
+	}
+
+	if settings.PythonDownloadZip == "" {
+		settings.PythonDownloadZip = "python-" + settings.PythonVersion + "-embed-amd64.zip"
+	}
+
+	if settings.PythonExtractDir == "" {
+		settings.PythonExtractDir = "python"
+	}
+
+	release, ok := LookupPythonRelease(settings.PythonVersion)
+	if !ok {
+		// an unverified version keeps whatever layout settings were given
+		return nil
+	}
+
+	if settings.PthFile == "" {
+		settings.PthFile = release.PthFile
+	}
+
+	if settings.PythonInteriorZip == "" {
+		settings.PythonInteriorZip = release.InteriorZip
+	}
+
+	if settings.PipDownloadURL == "" {
+		settings.PipDownloadURL = release.PipDownloadURL
+	}
+
+	return nil
+}
+
+// pythonReleaseAPI is the python.org downloads API, which publishes the checksums of every release file.
+const pythonReleaseAPI = "https://www.python.org/api/v2/downloads/"
+
+type pythonReleaseFile struct {
+	URL       string `json:"url"`
+	MD5Sum    string `json:"md5_sum"`
+	SHA256Sum string `json:"sha256_sum"`
+}
+
+// PublishedChecksum returns the SHA-256 checksum python.org publishes for the release file at downloadURL of Python
+// version. Releases that only list an MD5 checksum are not looked up.
+func PublishedChecksum(version, downloadURL string) (string, error) {
+	client := http.Client{Timeout: 30 * time.Second}
+
+	var releases []struct {
+		ResourceURI string `json:"resource_uri"`
+	}
+	if err := getJSON(client, pythonReleaseAPI+"release/?name="+url.QueryEscape("Python "+version), &releases); err != nil {
+		return "", err
+	}
+
+	if len(releases) == 0 {
+		return "", fmt.Errorf("python.org does not list Python %s", version)
+	}
+
+	releaseID := strings.TrimSuffix(releases[0].ResourceURI, "/")
+	releaseID = releaseID[strings.LastIndex(releaseID, "/")+1:]
+
+	var files []pythonReleaseFile
+	if err := getJSON(client, pythonReleaseAPI+"release_file/?release="+url.QueryEscape(releaseID), &files); err != nil {
+		return "", err
+	}
+
+	for _, file := range files {
+		if file.URL != downloadURL {
+			continue
+		}
+
+		if file.SHA256Sum != "" {
+			return strings.ToLower(file.SHA256Sum), nil
+		}
+		if file.MD5Sum != "" {
+			return "", fmt.Errorf("python.org only publishes an MD5 checksum for %s", downloadURL)
+		}
+	}
+
+	return "", fmt.Errorf("python.org publishes no checksum for %s", downloadURL)
+}
+
+func getJSON(client http.Client, address string, v any) error {
+	response, err := client.Get(address)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", address, response.Status)
+	}
+
+	return json.NewDecoder(response.Body).Decode(v)
+}
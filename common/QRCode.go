@@ -0,0 +1,447 @@
+package common
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// QRCode is a QR code symbol encoding a short string in byte mode with error correction level M.
+// Versions 1 to 6 are supported, which hold up to 106 bytes: enough for a hash and a version string.
+type QRCode struct {
+	size    int
+	modules [][]bool
+}
+
+// qrVersion describes the block structure of a QR code version at error correction level M.
+type qrVersion struct {
+	dataCodewords int // per block
+	ecCodewords   int // per block
+	blocks        int
+	capacity      int // bytes in byte mode
+}
+
+var qrVersions = []qrVersion{
+	1: {dataCodewords: 16, ecCodewords: 10, blocks: 1, capacity: 14},
+	2: {dataCodewords: 28, ecCodewords: 16, blocks: 1, capacity: 26},
+	3: {dataCodewords: 44, ecCodewords: 26, blocks: 1, capacity: 42},
+	4: {dataCodewords: 32, ecCodewords: 18, blocks: 2, capacity: 62},
+	5: {dataCodewords: 43, ecCodewords: 24, blocks: 2, capacity: 84},
+	6: {dataCodewords: 27, ecCodewords: 16, blocks: 4, capacity: 106},
+}
+
+// ErrQRDataTooLong is returned by EncodeQR for data that does not fit the largest supported version.
+var ErrQRDataTooLong = errors.New("data too long for a QR code")
+
+// EncodeQR encodes data in the smallest supported QR code version that holds it.
+func EncodeQR(data string) (*QRCode, error) {
+	version := 0
+	for v := 1; v < len(qrVersions); v++ {
+		if len(data) <= qrVersions[v].capacity {
+			version = v
+			break
+		}
+	}
+
+	if version == 0 {
+		return nil, ErrQRDataTooLong
+	}
+
+	codewords := qrCodewords([]byte(data), qrVersions[version])
+
+	size := 17 + 4*version
+	best := (*QRCode)(nil)
+	bestPenalty := 0
+
+	// every mask is tried and the one that is easiest to scan is kept
+	for mask := 0; mask < 8; mask++ {
+		code := &QRCode{size: size, modules: make([][]bool, size)}
+		function := make([][]bool, size)
+		for y := range code.modules {
+			code.modules[y] = make([]bool, size)
+			function[y] = make([]bool, size)
+		}
+
+		code.drawFunctionPatterns(function, version)
+		code.drawFormatBits(function, mask)
+		code.drawCodewords(function, codewords)
+		code.applyMask(function, mask)
+
+		if penalty := code.penalty(); best == nil || penalty < bestPenalty {
+			best, bestPenalty = code, penalty
+		}
+	}
+
+	return best, nil
+}
+
+// Size returns the number of modules along each side of the symbol, excluding the quiet zone.
+func (code *QRCode) Size() int {
+	return code.size
+}
+
+// Dark reports whether the module at column x and row y is dark. Modules outside the symbol are light.
+func (code *QRCode) Dark(x, y int) bool {
+	return x >= 0 && y >= 0 && x < code.size && y < code.size && code.modules[y][x]
+}
+
+// qrQuietZone is the light border, in modules, that scanners need around the symbol.
+const qrQuietZone = 4
+
+// String renders the symbol with block characters, two module rows per line, for a terminal with a dark
+// background: light modules are drawn and dark modules are left blank.
+func (code *QRCode) String() string {
+	var builder strings.Builder
+
+	for y := -qrQuietZone; y < code.size+qrQuietZone; y += 2 {
+		for x := -qrQuietZone; x < code.size+qrQuietZone; x++ {
+			top, bottom := !code.Dark(x, y), !code.Dark(x, y+1) && y+1 < code.size+qrQuietZone
+
+			switch {
+			case top && bottom:
+				builder.WriteRune('█')
+			case top:
+				builder.WriteRune('▀')
+			case bottom:
+				builder.WriteRune('▄')
+			default:
+				builder.WriteRune(' ')
+			}
+		}
+		builder.WriteByte('\n')
+	}
+
+	return builder.String()
+}
+
+// Image renders the symbol with its quiet zone, scale pixels per module.
+func (code *QRCode) Image(scale int) image.Image {
+	side := (code.size + 2*qrQuietZone) * scale
+	img := image.NewGray(image.Rect(0, 0, side, side))
+
+	for py := 0; py < side; py++ {
+		for px := 0; px < side; px++ {
+			shade := color.Gray{Y: 0xff}
+			if code.Dark(px/scale-qrQuietZone, py/scale-qrQuietZone) {
+				shade = color.Gray{Y: 0}
+			}
+			img.SetGray(px, py, shade)
+		}
+	}
+
+	return img
+}
+
+// qrCodewords encodes data in byte mode and returns the interleaved data and error correction codewords.
+func qrCodewords(data []byte, version qrVersion) []byte {
+	capacity := version.dataCodewords * version.blocks
+
+	var bits qrBitBuffer
+	bits.append(0b0100, 4) // byte mode
+	bits.append(len(data), 8)
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+
+	// terminator, then padding to a whole byte
+	bits.append(0, min(4, capacity*8-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+
+	encoded := bits.bytes()
+	for pad := byte(0xec); len(encoded) < capacity; pad ^= 0xec ^ 0x11 {
+		encoded = append(encoded, pad)
+	}
+
+	generator := qrGenerator(version.ecCodewords)
+
+	var dataBlocks, ecBlocks [][]byte
+	for block := 0; block < version.blocks; block++ {
+		blockData := encoded[block*version.dataCodewords : (block+1)*version.dataCodewords]
+		dataBlocks = append(dataBlocks, blockData)
+		ecBlocks = append(ecBlocks, qrRemainder(blockData, generator))
+	}
+
+	var codewords []byte
+	for i := 0; i < version.dataCodewords; i++ {
+		for _, block := range dataBlocks {
+			codewords = append(codewords, block[i])
+		}
+	}
+	for i := 0; i < version.ecCodewords; i++ {
+		for _, block := range ecBlocks {
+			codewords = append(codewords, block[i])
+		}
+	}
+
+	return codewords
+}
+
+type qrBitBuffer []bool
+
+func (bits *qrBitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*bits = append(*bits, (value>>i)&1 == 1)
+	}
+}
+
+func (bits qrBitBuffer) bytes() []byte {
+	result := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			result[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return result
+}
+
+// qrMultiply multiplies in GF(2^8) modulo the QR code polynomial x^8 + x^4 + x^3 + x^2 + 1.
+func qrMultiply(x, y byte) byte {
+	var product byte
+	for i := 7; i >= 0; i-- {
+		carry := product&0x80 != 0
+		product <<= 1
+		if carry {
+			product ^= 0x1d
+		}
+		if (y>>i)&1 == 1 {
+			product ^= x
+		}
+	}
+	return product
+}
+
+// qrGenerator returns the Reed-Solomon generator polynomial of the given degree, highest coefficient first,
+// without its leading 1.
+func qrGenerator(degree int) []byte {
+	generator := make([]byte, degree)
+	generator[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range generator {
+			generator[j] = qrMultiply(generator[j], root)
+			if j+1 < len(generator) {
+				generator[j] ^= generator[j+1]
+			}
+		}
+		root = qrMultiply(root, 0x02)
+	}
+
+	return generator
+}
+
+// qrRemainder returns the Reed-Solomon error correction codewords of data.
+func qrRemainder(data, generator []byte) []byte {
+	remainder := make([]byte, len(generator))
+
+	for _, b := range data {
+		factor := b ^ remainder[0]
+		copy(remainder, remainder[1:])
+		remainder[len(remainder)-1] = 0
+
+		for i := range remainder {
+			remainder[i] ^= qrMultiply(generator[i], factor)
+		}
+	}
+
+	return remainder
+}
+
+func (code *QRCode) set(function [][]bool, x, y int, dark bool) {
+	code.modules[y][x] = dark
+	function[y][x] = true
+}
+
+func (code *QRCode) drawFunctionPatterns(function [][]bool, version int) {
+	for i := 0; i < code.size; i++ {
+		code.set(function, 6, i, i%2 == 0)
+		code.set(function, i, 6, i%2 == 0)
+	}
+
+	// finder patterns with their separators
+	for _, center := range [][2]int{{3, 3}, {code.size - 4, 3}, {3, code.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0]+dx, center[1]+dy
+				if x < 0 || y < 0 || x >= code.size || y >= code.size {
+					continue
+				}
+				distance := max(abs(dx), abs(dy))
+				code.set(function, x, y, distance != 2 && distance != 4)
+			}
+		}
+	}
+
+	// versions 2 to 6 have a single alignment pattern, near the bottom right corner
+	if version >= 2 {
+		center := code.size - 7
+		for dy := -2; dy <= 2; dy++ {
+			for dx := -2; dx <= 2; dx++ {
+				code.set(function, center+dx, center+dy, max(abs(dx), abs(dy)) != 1)
+			}
+		}
+	}
+}
+
+func (code *QRCode) drawFormatBits(function [][]bool, mask int) {
+	// error correction level M is encoded as 00
+	data := mask
+	remainder := data
+	for i := 0; i < 10; i++ {
+		remainder = (remainder << 1) ^ ((remainder >> 9) * 0x537)
+	}
+	bits := (data<<10 | remainder) ^ 0x5412
+
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		code.set(function, 8, i, bit(i))
+	}
+	code.set(function, 8, 7, bit(6))
+	code.set(function, 8, 8, bit(7))
+	code.set(function, 7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		code.set(function, 14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		code.set(function, code.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		code.set(function, 8, code.size-15+i, bit(i))
+	}
+	code.set(function, 8, code.size-8, true)
+}
+
+// drawCodewords places the codewords in the zigzag order of the standard, skipping function modules.
+func (code *QRCode) drawCodewords(function [][]bool, codewords []byte) {
+	i := 0
+	for right := code.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+
+		for vertical := 0; vertical < code.size; vertical++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vertical
+				if (right+1)&2 == 0 {
+					y = code.size - 1 - vertical
+				}
+
+				if function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+
+				code.modules[y][x] = (codewords[i/8]>>(7-i%8))&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+func (code *QRCode) applyMask(function [][]bool, mask int) {
+	for y := 0; y < code.size; y++ {
+		for x := 0; x < code.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+
+			if invert && !function[y][x] {
+				code.modules[y][x] = !code.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the symbol is to scan, following the four rules of the standard.
+func (code *QRCode) penalty() int {
+	penalty := 0
+	dark := 0
+
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+
+	for _, transpose := range []bool{false, true} {
+		at := func(a, b int) bool {
+			if transpose {
+				return code.modules[b][a]
+			}
+			return code.modules[a][b]
+		}
+
+		for a := 0; a < code.size; a++ {
+			run := 1
+			for b := 1; b <= code.size; b++ {
+				if b < code.size && at(a, b) == at(a, b-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += 3 + run - 5
+				}
+				run = 1
+			}
+
+			for b := 0; b+11 <= code.size; b++ {
+				for _, pattern := range finderLike {
+					matches := true
+					for k, value := range pattern {
+						if at(a, b+k) != value {
+							matches = false
+							break
+						}
+					}
+					if matches {
+						penalty += 40
+					}
+				}
+			}
+		}
+	}
+
+	for y := 0; y < code.size; y++ {
+		for x := 0; x < code.size; x++ {
+			if code.modules[y][x] {
+				dark++
+			}
+			if x+1 < code.size && y+1 < code.size {
+				shade := code.modules[y][x]
+				if code.modules[y][x+1] == shade && code.modules[y+1][x] == shade && code.modules[y+1][x+1] == shade {
+					penalty += 3
+				}
+			}
+		}
+	}
+
+	total := code.size * code.size
+	penalty += abs(dark*100/total-50) / 5 * 10
+
+	return penalty
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,247 @@
+package common
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestQRRemainder(t *testing.T) {
+	// the data and error correction codewords of HELLO WORLD at version 1-M, from the worked example of the standard
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	if got := qrRemainder(data, qrGenerator(len(want))); !bytes.Equal(got, want) {
+		t.Errorf("qrRemainder() = %v, want %v", got, want)
+	}
+}
+
+func TestQRFormatBits(t *testing.T) {
+	// the format information strings of error correction level M, by mask, from the standard
+	want := []string{
+		"101010000010010",
+		"101000100100101",
+		"101111001111100",
+		"101101101001011",
+		"100010111111001",
+		"100000011001110",
+		"100111110010111",
+		"100101010100000",
+	}
+
+	for mask, format := range want {
+		code := &QRCode{size: 21, modules: make([][]bool, 21)}
+		function := make([][]bool, 21)
+		for y := range code.modules {
+			code.modules[y] = make([]bool, 21)
+			function[y] = make([]bool, 21)
+		}
+
+		code.drawFormatBits(function, mask)
+
+		if got := readQRFormat(code); got != format {
+			t.Errorf("format bits of mask %d = %s, want %s", mask, got, format)
+		}
+	}
+}
+
+// readQRFormat reads the format information next to the top left finder pattern, most significant bit first:
+// along row 8 from the left, skipping the timing pattern, then up column 8.
+func readQRFormat(code *QRCode) string {
+	var positions [][2]int
+	for x := 0; x <= 5; x++ {
+		positions = append(positions, [2]int{x, 8})
+	}
+	positions = append(positions, [2]int{7, 8}, [2]int{8, 8}, [2]int{8, 7})
+	for y := 5; y >= 0; y-- {
+		positions = append(positions, [2]int{8, y})
+	}
+
+	var format strings.Builder
+	for _, position := range positions {
+		if code.Dark(position[0], position[1]) {
+			format.WriteByte('1')
+		} else {
+			format.WriteByte('0')
+		}
+	}
+
+	return format.String()
+}
+
+// qrGolden is the symbol EncodeQR produces for "exepy", one row per line with # for dark modules. It decodes back
+// to "exepy" with decodeQRVersion1, which does not share code with the encoder.
+const qrGolden = `
+#######..##.#.#######
+#.....#...#.#.#.....#
+#.###.#.#...#.#.###.#
+#.###.#.#.##..#.###.#
+#.###.#.###.#.#.###.#
+#.....#.#..#..#.....#
+#######.#.#.#.#######
+........#.#..........
+#.#####..#.#..#####..
+.####...#.#####....##
+###..##.##..#.##..##.
+.......#...####..###.
+#.######.##.#..#...#.
+........#...#..#.##.#
+#######....#.#..####.
+#.....#.#.#....#####.
+#.###.#.####.#..##.#.
+#.###.#.##.#####..#..
+#.###.#.###.#.##..#..
+#.....#..######..##..
+#######.##..#..###.#.
+`
+
+func TestEncodeQR(t *testing.T) {
+	code, err := EncodeQR("exepy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rendered strings.Builder
+	rendered.WriteByte('\n')
+	for y := 0; y < code.Size(); y++ {
+		for x := 0; x < code.Size(); x++ {
+			if code.Dark(x, y) {
+				rendered.WriteByte('#')
+			} else {
+				rendered.WriteByte('.')
+			}
+		}
+		rendered.WriteByte('\n')
+	}
+
+	if rendered.String() != qrGolden {
+		t.Errorf("EncodeQR(%q) =%s\nwant%s", "exepy", rendered.String(), qrGolden)
+	}
+
+	if got, err := decodeQRVersion1(code); err != "" || got != "exepy" {
+		t.Errorf("symbol decodes to %q (%s), want %q", got, err, "exepy")
+	}
+}
+
+func TestEncodeQRVersions(t *testing.T) {
+	tests := []struct {
+		length int
+		size   int
+	}{
+		{14, 21},
+		{15, 25},
+		{106, 41},
+	}
+
+	for _, test := range tests {
+		code, err := EncodeQR(strings.Repeat("a", test.length))
+		if err != nil {
+			t.Fatalf("EncodeQR() of %d bytes: %v", test.length, err)
+		}
+		if code.Size() != test.size {
+			t.Errorf("EncodeQR() of %d bytes has size %d, want %d", test.length, code.Size(), test.size)
+		}
+	}
+
+	if _, err := EncodeQR(strings.Repeat("a", 107)); err != ErrQRDataTooLong {
+		t.Errorf("EncodeQR() of 107 bytes: %v, want ErrQRDataTooLong", err)
+	}
+}
+
+// decodeQRVersion1 reads a version 1-M symbol in byte mode back to its data, following the standard independently
+// of the encoder: it checks the finder and timing patterns, finds the mask from the format information, and
+// reads the codewords in zigzag order around the function modules. It returns a description of the first
+// problem found.
+func decodeQRVersion1(code *QRCode) (string, string) {
+	const size = 21
+	if code.Size() != size {
+		return "", "not version 1"
+	}
+
+	reserved := func(x, y int) bool {
+		return (x <= 8 && y <= 8) || (x >= size-8 && y <= 8) || (x <= 8 && y >= size-8) || x == 6 || y == 6
+	}
+
+	for _, corner := range [][2]int{{0, 0}, {size - 7, 0}, {0, size - 7}} {
+		for dy := 0; dy < 7; dy++ {
+			for dx := 0; dx < 7; dx++ {
+				ring := max(abs(dx-3), abs(dy-3))
+				if code.Dark(corner[0]+dx, corner[1]+dy) != (ring != 2) {
+					return "", "damaged finder pattern"
+				}
+			}
+		}
+	}
+	for i := 8; i < size-8; i++ {
+		if code.Dark(i, 6) != (i%2 == 0) || code.Dark(6, i) != (i%2 == 0) {
+			return "", "damaged timing pattern"
+		}
+	}
+
+	format := readQRFormat(code)
+	masks := map[string]int{
+		"101010000010010": 0, "101000100100101": 1, "101111001111100": 2, "101101101001011": 3,
+		"100010111111001": 4, "100000011001110": 5, "100111110010111": 6, "100101010100000": 7,
+	}
+	mask, ok := masks[format]
+	if !ok {
+		return "", "format information " + format + " is not level M"
+	}
+
+	inverted := []func(x, y int) bool{
+		func(x, y int) bool { return (x+y)%2 == 0 },
+		func(x, y int) bool { return y%2 == 0 },
+		func(x, y int) bool { return x%3 == 0 },
+		func(x, y int) bool { return (x+y)%3 == 0 },
+		func(x, y int) bool { return (x/3+y/2)%2 == 0 },
+		func(x, y int) bool { return x*y%2+x*y%3 == 0 },
+		func(x, y int) bool { return (x*y%2+x*y%3)%2 == 0 },
+		func(x, y int) bool { return ((x+y)%2+x*y%3)%2 == 0 },
+	}[mask]
+
+	var bits []bool
+	upward := true
+	for right := size - 1; right > 0; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		for i := 0; i < size; i++ {
+			y := i
+			if upward {
+				y = size - 1 - i
+			}
+			for x := right; x > right-2; x-- {
+				if !reserved(x, y) {
+					bits = append(bits, code.Dark(x, y) != inverted(x, y))
+				}
+			}
+		}
+		upward = !upward
+	}
+
+	codewords := make([]byte, 26)
+	for i := range codewords {
+		for _, bit := range bits[i*8 : i*8+8] {
+			codewords[i] <<= 1
+			if bit {
+				codewords[i] |= 1
+			}
+		}
+	}
+
+	if !bytes.Equal(qrRemainder(codewords[:16], qrGenerator(10)), codewords[16:]) {
+		return "", "error correction codewords do not match"
+	}
+
+	if codewords[0]>>4 != 0b0100 {
+		return "", "not byte mode"
+	}
+	length := int(codewords[0]&0x0f)<<4 | int(codewords[1]>>4)
+
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = codewords[1+i]<<4 | codewords[2+i]>>4
+	}
+
+	return string(data), ""
+}
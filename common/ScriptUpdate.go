@@ -0,0 +1,127 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScriptUpdateManifestFilename is the signed manifest at the root of a script update bundle.
+const ScriptUpdateManifestFilename = "exepy-script-update.json"
+
+// ScriptUpdateManifest describes a scripts-only update: the payload files that changed since the release it was
+// built against, without Python or the wheels.
+type ScriptUpdateManifest struct {
+	Product string `json:"product"`
+	Version string `json:"version"`
+	// Hotfix marks an urgent fix, which is signed by a key holding the hotfix role instead of the update role.
+	Hotfix bool `json:"hotfix,omitempty"`
+	// BasePayloadHash is the hash of the payload attachment of the release the update was built against, as
+	// recorded in its hash manifest. The update only applies over that payload.
+	BasePayloadHash string `json:"basePayloadHash"`
+	// Algorithm is the hash algorithm of Files.
+	Algorithm string `json:"algorithm"`
+	// Files are the hashes of the new and changed files in the bundle, keyed by their path in the payload.
+	Files map[string]string `json:"files"`
+	// Removed lists the payload files the update deletes.
+	Removed   []string  `json:"removed,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SignedScriptUpdate is a script update manifest as stored in a bundle: the manifest, and the base64-encoded
+// Ed25519 signature of its compact JSON encoding.
+type SignedScriptUpdate struct {
+	Manifest  json.RawMessage `json:"manifest"`
+	Signature string          `json:"signature"`
+}
+
+// AppliedScriptUpdate records a script update applied to an installation, with the hashes of the files it
+// installed, so the installed scripts can be checked against what was signed.
+type AppliedScriptUpdate struct {
+	Version         string            `json:"version"`
+	BasePayloadHash string            `json:"basePayloadHash"`
+	AppliedAt       time.Time         `json:"appliedAt"`
+	Algorithm       string            `json:"algorithm"`
+	Files           map[string]string `json:"files"`
+	Removed         []string          `json:"removed,omitempty"`
+}
+
+// SignScriptUpdate signs manifest with the base64-encoded Ed25519 private key and returns the signed manifest.
+func SignScriptUpdate(manifest ScriptUpdateManifest, privateKey string) ([]byte, error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	compact, signature, err := signJSON(data, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(SignedScriptUpdate{Manifest: compact, Signature: signature}, "", "  ")
+}
+
+// VerifyScriptUpdate checks that the signed manifest in data is signed by a key of store holding the update role,
+// or the hotfix role for hotfixes, and returns the manifest.
+func VerifyScriptUpdate(data []byte, store *TrustStore) (*ScriptUpdateManifest, error) {
+	var signed SignedScriptUpdate
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, err
+	}
+
+	// the manifest is only trusted once the signature over it has been checked against the role it claims
+	var manifest ScriptUpdateManifest
+	if err := json.Unmarshal(signed.Manifest, &manifest); err != nil {
+		return nil, err
+	}
+
+	role := TrustRoleUpdate
+	if manifest.Hotfix {
+		role = TrustRoleHotfix
+	}
+
+	if _, err := store.Verify(signed.Manifest, signed.Signature, role, time.Now()); err != nil {
+		return nil, fmt.Errorf("script update %w", err)
+	}
+
+	if _, err := newHash(manifest.Algorithm); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// CompareVersions compares two dotted version strings such as "1.2.10" and "1.3", returning -1, 0 or 1. Numeric
+// components compare as numbers and others as text, missing components count as zero, and a leading "v" is
+// ignored.
+func CompareVersions(a, b string) int {
+	aParts := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bParts := strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		aPart, bPart := "0", "0"
+		if i < len(aParts) {
+			aPart = aParts[i]
+		}
+		if i < len(bParts) {
+			bPart = bParts[i]
+		}
+
+		aNumber, aErr := strconv.Atoi(aPart)
+		bNumber, bErr := strconv.Atoi(bPart)
+
+		switch {
+		case aErr == nil && bErr == nil && aNumber != bNumber:
+			if aNumber < bNumber {
+				return -1
+			}
+			return 1
+		case (aErr != nil || bErr != nil) && aPart != bPart:
+			return strings.Compare(aPart, bPart)
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// SecretsFilename is where bootstrap keeps the secrets entered at install time. Each value is protected for
+// the current user with ProtectSecret.
+const SecretsFilename = "exepy-secrets.json"
+
+// ErrNoKeystore is returned by ProtectSecret and UnprotectSecret where there is no OS keystore to protect secrets
+// with, so they are not stored.
+var ErrNoKeystore = errors.New("no OS keystore is available to protect secrets")
+
+// LoadSecrets reads the secrets stored in filename, keyed by name. A missing file holds no secrets.
+func LoadSecrets(filename string) (map[string]string, error) {
+	secrets := make(map[string]string)
+
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored map[string]string
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+
+	for name, encoded := range stored {
+		protected, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := UnprotectSecret(protected)
+		if err != nil {
+			return nil, err
+		}
+
+		secrets[name] = string(value)
+	}
+
+	return secrets, nil
+}
+
+// SaveSecrets protects each of secrets and writes them to filename, readable only by the current user.
+func SaveSecrets(filename string, secrets map[string]string) error {
+	stored := make(map[string]string, len(secrets))
+
+	for name, value := range secrets {
+		protected, err := ProtectSecret([]byte(value))
+		if err != nil {
+			return err
+		}
+
+		stored[name] = base64.StdEncoding.EncodeToString(protected)
+	}
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, data, 0600)
+}
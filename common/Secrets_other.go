@@ -0,0 +1,30 @@
+//go:build !windows
+
+package common
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// ProtectSecret returns ErrNoKeystore. Outside Windows there is no DPAPI, and secrets are not written to disk in
+// plain text.
+func ProtectSecret(data []byte) ([]byte, error) {
+	return nil, ErrNoKeystore
+}
+
+// UnprotectSecret returns ErrNoKeystore, like ProtectSecret.
+func UnprotectSecret(data []byte) ([]byte, error) {
+	return nil, ErrNoKeystore
+}
+
+// ReadSecret reads a line from standard input. The input is shown as it is typed.
+func ReadSecret() (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
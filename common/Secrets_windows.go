@@ -0,0 +1,90 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procCryptProtectData   = syscall.NewLazyDLL("crypt32.dll").NewProc("CryptProtectData")
+	procCryptUnprotectData = syscall.NewLazyDLL("crypt32.dll").NewProc("CryptUnprotectData")
+	procLocalFree          = syscall.NewLazyDLL("kernel32.dll").NewProc("LocalFree")
+	procGetConsoleMode     = syscall.NewLazyDLL("kernel32.dll").NewProc("GetConsoleMode")
+	procSetConsoleMode     = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+)
+
+const (
+	cryptProtectUIForbidden = 0x1
+	enableEchoInput         = 0x4
+)
+
+type dataBlob struct {
+	cbData uint32
+	pbData *byte
+}
+
+func newDataBlob(data []byte) *dataBlob {
+	if len(data) == 0 {
+		return &dataBlob{}
+	}
+	return &dataBlob{cbData: uint32(len(data)), pbData: &data[0]}
+}
+
+// bytes copies the blob returned by DPAPI and frees it.
+func (blob *dataBlob) bytes() []byte {
+	data := make([]byte, blob.cbData)
+	copy(data, unsafe.Slice(blob.pbData, blob.cbData))
+	procLocalFree.Call(uintptr(unsafe.Pointer(blob.pbData)))
+	return data
+}
+
+// ProtectSecret encrypts data with DPAPI so only the current user on this machine can decrypt it.
+func ProtectSecret(data []byte) ([]byte, error) {
+	var out dataBlob
+
+	r, _, err := procCryptProtectData.Call(uintptr(unsafe.Pointer(newDataBlob(data))), 0, 0, 0, 0,
+		cryptProtectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, fmt.Errorf("CryptProtectData: %w", err)
+	}
+
+	return out.bytes(), nil
+}
+
+// UnprotectSecret decrypts data encrypted by ProtectSecret.
+func UnprotectSecret(data []byte) ([]byte, error) {
+	var out dataBlob
+
+	r, _, err := procCryptUnprotectData.Call(uintptr(unsafe.Pointer(newDataBlob(data))), 0, 0, 0, 0,
+		cryptProtectUIForbidden, uintptr(unsafe.Pointer(&out)))
+	if r == 0 {
+		return nil, fmt.Errorf("CryptUnprotectData: %w", err)
+	}
+
+	return out.bytes(), nil
+}
+
+// ReadSecret reads a line from standard input without echoing it when input comes from a console.
+func ReadSecret() (string, error) {
+	handle := uintptr(syscall.Stdin)
+
+	var mode uint32
+	if r, _, _ := procGetConsoleMode.Call(handle, uintptr(unsafe.Pointer(&mode))); r != 0 {
+		procSetConsoleMode.Call(handle, uintptr(mode&^enableEchoInput))
+		defer func() {
+			procSetConsoleMode.Call(handle, uintptr(mode))
+			fmt.Println()
+		}()
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
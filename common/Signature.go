@@ -0,0 +1,49 @@
+package common
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// CertificateThumbprint returns the SHA-1 thumbprint of cert, in upper case hex as Windows shows it.
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha1.Sum(cert.Raw)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// SignerTrusted reports whether cert is one of trusted, whose entries are SHA-1 or SHA-256 certificate thumbprints
+// in hex, with or without spaces and colons. Subject names are not matched, since a certificate with the same name
+// can be issued to anyone.
+func SignerTrusted(cert *x509.Certificate, trusted []string) bool {
+	sha256Sum := sha256.Sum256(cert.Raw)
+	thumbprints := []string{CertificateThumbprint(cert), strings.ToUpper(hex.EncodeToString(sha256Sum[:]))}
+
+	for _, entry := range trusted {
+		normalized := normalizeThumbprint(entry)
+		for _, thumbprint := range thumbprints {
+			if normalized == thumbprint {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// CheckThumbprint returns an error unless entry is a SHA-1 or SHA-256 certificate thumbprint in hex, with or
+// without spaces and colons.
+func CheckThumbprint(entry string) error {
+	decoded, err := hex.DecodeString(normalizeThumbprint(entry))
+	if err != nil || (len(decoded) != sha1.Size && len(decoded) != sha256.Size) {
+		return fmt.Errorf("%q is not a SHA-1 or SHA-256 certificate thumbprint", entry)
+	}
+	return nil
+}
+
+func normalizeThumbprint(entry string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", ":", "").Replace(entry))
+}
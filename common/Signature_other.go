@@ -0,0 +1,13 @@
+//go:build !windows
+
+package common
+
+import (
+	"crypto/x509"
+	"errors"
+)
+
+// VerifySignature is only supported on Windows, where Authenticode signatures can be checked.
+func VerifySignature(path string) (*x509.Certificate, error) {
+	return nil, errors.New("signature verification is only supported on Windows")
+}
@@ -0,0 +1,73 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+func testCertificate(t *testing.T) *x509.Certificate {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Example Publisher"}}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, public, private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestSignerTrusted(t *testing.T) {
+	cert := testCertificate(t)
+	sha256Sum := sha256.Sum256(cert.Raw)
+	sha1Thumbprint := CertificateThumbprint(cert)
+
+	tests := []struct {
+		name    string
+		trusted []string
+		want    bool
+	}{
+		{name: "sha1", trusted: []string{sha1Thumbprint}, want: true},
+		{name: "sha256 in lower case", trusted: []string{hex.EncodeToString(sha256Sum[:])}, want: true},
+		{name: "sha1 with colons", trusted: []string{sha1Thumbprint[:2] + ":" + sha1Thumbprint[2:]}, want: true},
+		{name: "common name", trusted: []string{"Example Publisher"}, want: false},
+		{name: "other thumbprint", trusted: []string{"0123456789ABCDEF0123456789ABCDEF01234567"}, want: false},
+	}
+
+	for _, test := range tests {
+		if got := SignerTrusted(cert, test.trusted); got != test.want {
+			t.Errorf("%s: SignerTrusted() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckThumbprint(t *testing.T) {
+	valid := []string{"0123456789ABCDEF0123456789ABCDEF01234567", "01 23 45 67 89 ab cd ef 01 23 45 67 89 ab cd ef 01 23 45 67", "5d7b5313d81195e4caf90aa52719f240eb93d50d2b38288a85ef6724af80c97a"}
+	for _, entry := range valid {
+		if err := CheckThumbprint(entry); err != nil {
+			t.Errorf("CheckThumbprint(%q) = %v", entry, err)
+		}
+	}
+
+	invalid := []string{"Example Publisher", "0123456789ABCDEF", ""}
+	for _, entry := range invalid {
+		if err := CheckThumbprint(entry); err == nil {
+			t.Errorf("CheckThumbprint(%q) accepted it", entry)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package common
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procWinVerifyTrust                 = syscall.NewLazyDLL("wintrust.dll").NewProc("WinVerifyTrust")
+	procWTHelperProvDataFromStateData  = syscall.NewLazyDLL("wintrust.dll").NewProc("WTHelperProvDataFromStateData")
+	procWTHelperGetProvSignerFromChain = syscall.NewLazyDLL("wintrust.dll").NewProc("WTHelperGetProvSignerFromChain")
+	procWTHelperGetProvCertFromChain   = syscall.NewLazyDLL("wintrust.dll").NewProc("WTHelperGetProvCertFromChain")
+)
+
+// WINTRUST_ACTION_GENERIC_VERIFY_V2
+var genericVerifyV2 = syscall.GUID{
+	Data1: 0xaac56b,
+	Data2: 0xcd44,
+	Data3: 0x11d0,
+	Data4: [8]byte{0x8c, 0xc2, 0x00, 0xc0, 0x4f, 0xc2, 0x95, 0xee},
+}
+
+const (
+	wtdUINone               = 2
+	wtdRevokeWholeChain     = 1
+	wtdChoiceFile           = 1
+	wtdStateActionVerify    = 1
+	wtdStateActionClose     = 2
+	wtdRevocationCheckChain = 0x40
+	certERevoked            = 0x800b010c
+	certERevocationFailure  = 0x800b010e
+)
+
+type wintrustFileInfo struct {
+	cbStruct       uint32
+	pcwszFilePath  *uint16
+	hFile          syscall.Handle
+	pgKnownSubject *syscall.GUID
+}
+
+type wintrustData struct {
+	cbStruct            uint32
+	pPolicyCallbackData uintptr
+	pSIPClientData      uintptr
+	dwUIChoice          uint32
+	fdwRevocationChecks uint32
+	dwUnionChoice       uint32
+	pFile               *wintrustFileInfo
+	dwStateAction       uint32
+	hWVTStateData       syscall.Handle
+	pwszURLReference    *uint16
+	dwProvFlags         uint32
+	dwUIContext         uint32
+	pSignatureSettings  uintptr
+}
+
+// cryptProviderCert is the start of CRYPT_PROVIDER_CERT.
+type cryptProviderCert struct {
+	cbStruct uint32
+	pCert    *certContext
+}
+
+// certContext is the start of CERT_CONTEXT.
+type certContext struct {
+	dwCertEncodingType uint32
+	pbCertEncoded      *byte
+	cbCertEncoded      uint32
+}
+
+// VerifySignature checks the Authenticode signature of the file at path using WinVerifyTrust, including the
+// revocation status of every certificate in its chain, and returns the certificate of its signer.
+func VerifySignature(path string) (*x509.Certificate, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo := wintrustFileInfo{pcwszFilePath: pathPtr}
+	fileInfo.cbStruct = uint32(unsafe.Sizeof(fileInfo))
+
+	data := wintrustData{
+		dwUIChoice:          wtdUINone,
+		fdwRevocationChecks: wtdRevokeWholeChain,
+		dwUnionChoice:       wtdChoiceFile,
+		pFile:               &fileInfo,
+		dwStateAction:       wtdStateActionVerify,
+		dwProvFlags:         wtdRevocationCheckChain,
+	}
+	data.cbStruct = uint32(unsafe.Sizeof(data))
+
+	result, _, _ := procWinVerifyTrust.Call(uintptr(syscall.InvalidHandle), uintptr(unsafe.Pointer(&genericVerifyV2)), uintptr(unsafe.Pointer(&data)))
+
+	// the signer is read from the state WinVerifyTrust keeps until it is released
+	var signer *x509.Certificate
+	if result == 0 {
+		signer, err = signerCertificate(data.hWVTStateData)
+	}
+
+	// release the state WinVerifyTrust allocated during verification
+	data.dwStateAction = wtdStateActionClose
+	_, _, _ = procWinVerifyTrust.Call(uintptr(syscall.InvalidHandle), uintptr(unsafe.Pointer(&genericVerifyV2)), uintptr(unsafe.Pointer(&data)))
+
+	// revocation is checked online for the whole chain, and a signature whose revocation status cannot be
+	// determined is rejected, so a revoked publisher certificate is never trusted
+	switch uint32(result) {
+	case 0:
+	case certERevoked:
+		return nil, errors.New("a certificate in the signature's chain has been revoked")
+	case certERevocationFailure:
+		return nil, errors.New("the revocation status of the signature's certificates could not be checked. Connect to the internet and try again")
+	default:
+		return nil, fmt.Errorf("WinVerifyTrust failed with status 0x%08x", uint32(result))
+	}
+
+	return signer, err
+}
+
+// signerCertificate returns the certificate of the first signer in the state of a successful WinVerifyTrust call.
+func signerCertificate(state syscall.Handle) (*x509.Certificate, error) {
+	providerData, _, _ := procWTHelperProvDataFromStateData.Call(uintptr(state))
+	if providerData == 0 {
+		return nil, errors.New("signature has no provider data")
+	}
+
+	providerSigner, _, _ := procWTHelperGetProvSignerFromChain.Call(providerData, 0, 0, 0)
+	if providerSigner == 0 {
+		return nil, errors.New("signature has no signer")
+	}
+
+	providerCert, _, _ := procWTHelperGetProvCertFromChain.Call(providerSigner, 0)
+	if providerCert == 0 {
+		return nil, errors.New("signature has no signer certificate")
+	}
+
+	// the pointers are owned by WinVerifyTrust, which keeps them valid until its state is released
+	cert := *(**cryptProviderCert)(unsafe.Pointer(&providerCert))
+	if cert.pCert == nil {
+		return nil, errors.New("signature has no signer certificate")
+	}
+
+	encoded := unsafe.Slice(cert.pCert.pbCertEncoded, cert.pCert.cbCertEncoded)
+	return x509.ParseCertificate(append([]byte(nil), encoded...))
+}
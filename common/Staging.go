@@ -0,0 +1,121 @@
+package common
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ReplaceDirectory moves the fully extracted directory staged into place at target with renames, so target is
+// never seen partially written. Any existing target is moved aside first and removed afterwards.
+// staged and target must be on the same volume.
+func ReplaceDirectory(staged, target string) error {
+	// replacing the working directory, or a parent of it, would remove the installation
+	if clean := filepath.Clean(target); target == "" || clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("refusing to replace %q", target)
+	}
+
+	previous := target + ".exepy-old"
+	RemoveIfExists(previous)
+
+	if DoesPathExist(target) {
+		if err := os.Rename(target, previous); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+
+	if err := os.Rename(staged, target); err != nil {
+		// put the previous installation back rather than leaving nothing
+		if DoesPathExist(previous) {
+			os.Rename(previous, target)
+		}
+		return err
+	}
+
+	return os.RemoveAll(previous)
+}
+
+// MergeDirectory moves every file below staged to the same relative path below target, renaming each file into
+// place. Existing files with different contents are handled by resolver as in DecompressIOStreamWithConflicts;
+// a nil resolver replaces them. staged and target must be on the same volume.
+func MergeDirectory(staged, target string, resolver ConflictResolver, backupDir string) error {
+	return filepath.WalkDir(staged, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(staged, path)
+		if err != nil {
+			return err
+		}
+
+		outPath := filepath.Join(target, relativePath)
+
+		if d.IsDir() {
+			return os.MkdirAll(outPath, os.ModePerm)
+		}
+
+		if resolver != nil && DoesPathExist(outPath) {
+			return resolveConflict(path, outPath, resolver, filepath.Join(backupDir, relativePath))
+		}
+
+		return os.Rename(path, outPath)
+	})
+}
+
+// CheckStagedTree checks the files below dir against hashes, recorded with ExtractOptions.Hashes while they were
+// extracted there: every file must exist with the contents it was extracted with, and no other file may be there.
+func CheckStagedTree(dir string, hashes map[string]string) error {
+	found := make(map[string]bool, len(hashes))
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(relativePath)
+
+		expected, ok := hashes[name]
+		if !ok || !d.Type().IsRegular() {
+			return fmt.Errorf("%s was not extracted from the installer", path)
+		}
+
+		actual, err := HashFile(path)
+		if err != nil {
+			return err
+		}
+		if actual != expected {
+			return fmt.Errorf("%s does not match the packaged file", path)
+		}
+
+		found[name] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for name := range hashes {
+		if !found[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("%s is missing from %s", missing[0], dir)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package common
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckStagedTree(t *testing.T) {
+	source := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(source, "pkg"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "main.py"), []byte("print('hi')"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "pkg", "module.py"), []byte("x = 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := CompressDirToStream(source, CompressionGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	tests := []struct {
+		name    string
+		modify  func(dir string) error
+		wantErr string
+	}{
+		{name: "unchanged", modify: func(dir string) error { return nil }},
+		{name: "damaged file", wantErr: "does not match", modify: func(dir string) error {
+			return os.WriteFile(filepath.Join(dir, "main.py"), []byte("print('bye')"), 0644)
+		}},
+		{name: "missing file", wantErr: "missing", modify: func(dir string) error {
+			return os.Remove(filepath.Join(dir, "pkg", "module.py"))
+		}},
+		{name: "unexpected file", wantErr: "was not extracted", modify: func(dir string) error {
+			return os.WriteFile(filepath.Join(dir, "pkg", "extra.py"), []byte("import os"), 0644)
+		}},
+	}
+
+	for _, test := range tests {
+		if _, err := archive.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+
+		dir := t.TempDir()
+		hashes := make(map[string]string)
+		if err := DecompressIOStreamWithOptions(archive, dir, CompressionGzip, ExtractOptions{Hashes: hashes}); err != nil {
+			t.Fatal(err)
+		}
+		if len(hashes) != 2 {
+			t.Fatalf("extraction recorded %v, want the hashes of main.py and pkg/module.py", hashes)
+		}
+
+		if err := test.modify(dir); err != nil {
+			t.Fatal(err)
+		}
+
+		err := CheckStagedTree(dir, hashes)
+		if test.wantErr == "" && err != nil {
+			t.Errorf("%s: CheckStagedTree() = %v", test.name, err)
+		}
+		if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+			t.Errorf("%s: CheckStagedTree() = %v, want an error containing %q", test.name, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package common
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+const StateFilename = "exepy-state.json"
+const BootstrapMarkerFilename = "bootstrapped"
+
+// LegacyToolVersion is reported for installations set up by releases that wrote a plain text bootstrap marker.
+const LegacyToolVersion = "legacy"
+
+// BootstrapMarker is written once first time setup has completed, describing the executable that performed it.
+type BootstrapMarker struct {
+	Algorithm      string    `json:"algorithm"`
+	ExecutableHash string    `json:"executableHash"`
+	ExecutableSize int64     `json:"executableSize"`
+	Timestamp      time.Time `json:"timestamp"`
+	ToolVersion    string    `json:"toolVersion"`
+}
+
+// InstallState records what bootstrap has already done for this installation.
+type InstallState struct {
+	ExecutableHash      string   `json:"executableHash"`
+	StubHash            string   `json:"stubHash,omitempty"`
+	AttachmentsVerified bool     `json:"attachmentsVerified"`
+	Prerequisites       []string `json:"prerequisites,omitempty"`
+	HashChangePolicy    string   `json:"hashChangePolicy,omitempty"`
+	AdminTokenHash      string   `json:"adminTokenHash,omitempty"`
+	TrustedSigners      []string `json:"trustedSigners,omitempty"`
+	Overrides           []string `json:"overrides,omitempty"`
+	// Capabilities are the payload capabilities accepted before the last first time setup.
+	Capabilities []string `json:"capabilities,omitempty"`
+	// License records the acceptance of the embedded license.
+	License *LicenseAcceptance `json:"license,omitempty"`
+	// Migration records the upgrade of an installation set up by an earlier release to the current layout.
+	Migration *Migration `json:"migration,omitempty"`
+	// ScriptUpdate records the scripts-only update applied over the installed payload, if any.
+	ScriptUpdate *AppliedScriptUpdate `json:"scriptUpdate,omitempty"`
+	// Parameters are the values of install-time parameters given during first time setup.
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// PayloadRoot is the hash of the embedded attachment hashes of the last accepted executable. Kept outside the
+	// executable, it stops the attachments and their hashes from being rewritten together unnoticed.
+	PayloadRoot string `json:"payloadRoot,omitempty"`
+}
+
+// Migration describes what was changed to bring an installation set up by an earlier release up to date.
+type Migration struct {
+	Timestamp time.Time `json:"timestamp"`
+	Changes   []string  `json:"changes"`
+}
+
+// LicenseAcceptance records who accepted the license embedded in an installer, and when.
+type LicenseAcceptance struct {
+	User             string    `json:"user"`
+	Timestamp        time.Time `json:"timestamp"`
+	Product          string    `json:"product"`
+	InstallerVersion string    `json:"installerVersion,omitempty"`
+	ToolVersion      string    `json:"toolVersion"`
+	ExecutableHash   string    `json:"executableHash"`
+	LicenseHash      string    `json:"licenseHash"`
+	// Reported is set once the acceptance has been sent to the license endpoint.
+	Reported bool `json:"reported,omitempty"`
+}
+
+// LoadState reads the state store, returning an empty state if it does not exist yet.
+func LoadState(filename string) (*InstallState, error) {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return &InstallState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state InstallState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+func SaveState(filename string, state *InstallState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, data, 0644)
+}
+
+// ReadBootstrapMarker reads the bootstrap marker. A plain text marker written by an earlier release is returned
+// with LegacyToolVersion and the time it was written.
+func ReadBootstrapMarker(filename string) (*BootstrapMarker, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(data) {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		return &BootstrapMarker{Timestamp: info.ModTime().UTC(), ToolVersion: LegacyToolVersion}, nil
+	}
+
+	var marker BootstrapMarker
+	err = json.Unmarshal(data, &marker)
+	if err != nil {
+		return nil, err
+	}
+
+	return &marker, nil
+}
+
+func WriteBootstrapMarker(filename string, marker *BootstrapMarker) error {
+	data, err := json.MarshalIndent(marker, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, data, 0644)
+}
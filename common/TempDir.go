@@ -0,0 +1,50 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// errFreeSpaceUnknown is returned by freeSpace on platforms where it cannot be determined.
+var errFreeSpaceUnknown = errors.New("free space cannot be determined on this platform")
+
+// SetTempDir makes dir the temporary directory of this process and of the commands it runs, creating it if needed.
+func SetTempDir(dir string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(absDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	// TMPDIR is read on Unix, TMP and TEMP on Windows
+	for _, variable := range []string{"TMPDIR", "TMP", "TEMP"} {
+		if err := os.Setenv(variable, absDir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckFreeSpace returns an error if the volume holding dir has less than required bytes available.
+// Platforms that cannot report free space always pass.
+func CheckFreeSpace(dir string, required int64) error {
+	available, err := freeSpace(dir)
+	if errors.Is(err, errFreeSpaceUnknown) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if required > 0 && available < uint64(required) {
+		return fmt.Errorf("%s needs %d MiB free but only %d MiB are available", dir, required>>20, available>>20)
+	}
+
+	return nil
+}
@@ -0,0 +1,25 @@
+package common
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceExW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+func freeSpace(dir string) (uint64, error) {
+	dirPtr, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var available, total, totalFree uint64
+
+	ok, _, err := procGetDiskFreeSpaceExW.Call(uintptr(unsafe.Pointer(dirPtr)),
+		uintptr(unsafe.Pointer(&available)), uintptr(unsafe.Pointer(&total)), uintptr(unsafe.Pointer(&totalFree)))
+	if ok == 0 {
+		return 0, err
+	}
+
+	return available, nil
+}
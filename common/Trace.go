@@ -0,0 +1,248 @@
+package common
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment variables that enable tracing. Spans are written in the OTLP/JSON format, so the trace file can be
+// imported into any OpenTelemetry backend, and are also posted to an OTLP/HTTP collector when an endpoint is set.
+const (
+	TraceFileEnv     = "EXEPY_TRACE_FILE"
+	TraceEndpointEnv = "EXEPY_OTLP_ENDPOINT"
+)
+
+// Tracer collects the spans of one run. A nil Tracer records nothing, so call sites do not need to check
+// whether tracing is enabled.
+type Tracer struct {
+	service  string
+	file     string
+	endpoint string
+	traceID  string
+
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// Span is a timed phase of a run.
+type Span struct {
+	tracer     *Tracer
+	id         string
+	parentID   string
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        error
+}
+
+// NewTracerFromEnv returns a tracer for service if tracing is enabled in the environment, and nil otherwise.
+func NewTracerFromEnv(service string) *Tracer {
+	file := os.Getenv(TraceFileEnv)
+	endpoint := os.Getenv(TraceEndpointEnv)
+
+	if file == "" && endpoint == "" {
+		return nil
+	}
+
+	return &Tracer{service: service, file: file, endpoint: endpoint, traceID: randomID(16)}
+}
+
+// Start begins a span named name below parent, which may be nil for a root span.
+func (tracer *Tracer) Start(name string, parent *Span) *Span {
+	if tracer == nil {
+		return nil
+	}
+
+	span := &Span{tracer: tracer, id: randomID(8), name: name, start: time.Now(), attributes: map[string]string{}}
+	if parent != nil {
+		span.parentID = parent.id
+	}
+
+	tracer.mu.Lock()
+	tracer.spans = append(tracer.spans, span)
+	tracer.mu.Unlock()
+
+	return span
+}
+
+// SetAttribute records a key-value pair on the span.
+func (span *Span) SetAttribute(key, value string) {
+	if span == nil {
+		return
+	}
+	span.attributes[key] = value
+}
+
+// SetError marks the span as failed with err. A nil err leaves the span unchanged.
+func (span *Span) SetError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+	span.err = err
+}
+
+// End finishes the span. Spans that are never ended are exported with the time of the flush as their end.
+func (span *Span) End() {
+	if span == nil || !span.end.IsZero() {
+		return
+	}
+	span.end = time.Now()
+}
+
+// Flush writes the collected spans to the trace file and posts them to the collector.
+func (tracer *Tracer) Flush() error {
+	if tracer == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(tracer.export())
+	if err != nil {
+		return err
+	}
+
+	if tracer.file != "" {
+		if err := os.WriteFile(tracer.file, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	if tracer.endpoint != "" {
+		url := strings.TrimSuffix(tracer.endpoint, "/") + "/v1/traces"
+
+		client := http.Client{Timeout: 10 * time.Second}
+		response, err := client.Post(url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+
+		if response.StatusCode/100 != 2 {
+			return fmt.Errorf("trace collector returned %s", response.Status)
+		}
+	}
+
+	return nil
+}
+
+// The types below follow the OTLP/JSON encoding of ExportTraceServiceRequest.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusOk         = 1
+	otlpStatusError      = 2
+)
+
+func (tracer *Tracer) export() otlpRequest {
+	now := time.Now()
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+
+	spans := make([]otlpSpan, 0, len(tracer.spans))
+	for _, span := range tracer.spans {
+		end := span.end
+		if end.IsZero() {
+			end = now
+		}
+
+		exported := otlpSpan{
+			TraceID:           tracer.traceID,
+			SpanID:            span.id,
+			ParentSpanID:      span.parentID,
+			Name:              span.name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+			Status:            otlpStatus{Code: otlpStatusOk},
+		}
+
+		for key, value := range span.attributes {
+			exported.Attributes = append(exported.Attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: value}})
+		}
+
+		if span.err != nil {
+			exported.Status = otlpStatus{Code: otlpStatusError, Message: span.err.Error()}
+		}
+
+		spans = append(spans, exported)
+	}
+
+	hostname, _ := os.Hostname()
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: otlpValue{StringValue: tracer.service}},
+			{Key: "service.version", Value: otlpValue{StringValue: Version}},
+			{Key: "host.name", Value: otlpValue{StringValue: hostname}},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "exepy", Version: Version},
+			Spans: spans,
+		}},
+	}}}
+}
+
+func randomID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
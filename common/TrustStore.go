@@ -0,0 +1,131 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Roles a trusted key can hold. A signed document is only accepted from a key holding the role for its kind.
+const (
+	// TrustRoleUpdate keys sign script updates.
+	TrustRoleUpdate = "update"
+	// TrustRoleHotfix keys sign script updates marked as hotfixes, and nothing else, so a key handed to whoever
+	// ships urgent fixes cannot sign regular updates.
+	TrustRoleHotfix = "hotfix"
+	// TrustRoleOverrides keys sign organization override documents.
+	TrustRoleOverrides = "overrides"
+)
+
+var trustRoles = map[string]bool{TrustRoleUpdate: true, TrustRoleHotfix: true, TrustRoleOverrides: true}
+
+// TrustedKey is a publisher key embedded in an installer, with the roles it may sign for and when it is valid.
+type TrustedKey struct {
+	// Name identifies the key in messages, such as "updates-2025".
+	Name string `json:"name"`
+	// PublicKey is the base64-encoded Ed25519 public key.
+	PublicKey string   `json:"publicKey"`
+	Roles     []string `json:"roles"`
+	// NotBefore is when the key starts being accepted, so its successor can be embedded ahead of a rotation.
+	NotBefore time.Time `json:"notBefore,omitempty"`
+	// Expires is when the key stops being accepted. Setting it retires the key: documents it signed, including
+	// ones signed before it expired, are rejected from then on. The zero time never expires.
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// TrustStore is the set of publisher keys an installer accepts signed documents from.
+type TrustStore struct {
+	Keys []TrustedKey `json:"keys"`
+}
+
+// Check returns an error if a key of the store is invalid, has an unknown role, or shares its name with another.
+func (store *TrustStore) Check() error {
+	names := make(map[string]bool)
+
+	for _, key := range store.Keys {
+		if key.Name == "" {
+			return errors.New("trusted keys must have a name")
+		}
+		if names[key.Name] {
+			return fmt.Errorf("trusted key %q is listed more than once", key.Name)
+		}
+		names[key.Name] = true
+
+		if _, err := ParseOverrideKey(key.PublicKey); err != nil {
+			return fmt.Errorf("trusted key %q: %w", key.Name, err)
+		}
+
+		if len(key.Roles) == 0 {
+			return fmt.Errorf("trusted key %q has no roles", key.Name)
+		}
+		for _, role := range key.Roles {
+			if !trustRoles[role] {
+				return fmt.Errorf("trusted key %q has unknown role %q", key.Name, role)
+			}
+		}
+
+		if !key.Expires.IsZero() && !key.NotBefore.IsZero() && !key.Expires.After(key.NotBefore) {
+			return fmt.Errorf("trusted key %q expires before it becomes valid", key.Name)
+		}
+	}
+
+	return nil
+}
+
+// HasRole reports whether any key of the store holds role, regardless of when it is valid.
+func (store *TrustStore) HasRole(role string) bool {
+	if store == nil {
+		return false
+	}
+
+	for _, key := range store.Keys {
+		if key.hasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks that signature is a valid signature of the compact encoding of document by a key of the store
+// that holds role and is valid at now, and returns that key.
+func (store *TrustStore) Verify(document json.RawMessage, signature, role string, now time.Time) (*TrustedKey, error) {
+	if store == nil {
+		return nil, fmt.Errorf("no keys are trusted to sign for the %s role", role)
+	}
+
+	var invalid error
+	for i, key := range store.Keys {
+		if !key.hasRole(role) {
+			continue
+		}
+
+		publicKey, err := ParseOverrideKey(key.PublicKey)
+		if err != nil || !verifyJSON(document, signature, publicKey) {
+			continue
+		}
+
+		switch {
+		case !key.NotBefore.IsZero() && now.Before(key.NotBefore):
+			invalid = fmt.Errorf("signed by key %q, which is not valid until %s", key.Name, key.NotBefore.Format(time.RFC3339))
+		case !key.Expires.IsZero() && !now.Before(key.Expires):
+			invalid = fmt.Errorf("signed by key %q, which expired on %s", key.Name, key.Expires.Format(time.RFC3339))
+		default:
+			return &store.Keys[i], nil
+		}
+	}
+
+	if invalid != nil {
+		return nil, invalid
+	}
+	return nil, fmt.Errorf("not signed by a trusted key with the %s role", role)
+}
+
+func (key *TrustedKey) hasRole(role string) bool {
+	for _, held := range key.Roles {
+		if held == role {
+			return true
+		}
+	}
+	return false
+}
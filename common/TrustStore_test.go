@@ -0,0 +1,148 @@
+package common
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testKey returns a trusted key with roles and its base64-encoded private key.
+func testKey(t *testing.T, name string, roles ...string) (TrustedKey, string) {
+	t.Helper()
+
+	publicKey, privateKey, err := GenerateOverrideKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return TrustedKey{Name: name, PublicKey: publicKey, Roles: roles}, privateKey
+}
+
+func TestTrustStoreVerify(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	update, updatePrivate := testKey(t, "updates", TrustRoleUpdate)
+	hotfix, hotfixPrivate := testKey(t, "hotfixes", TrustRoleHotfix)
+	retired, retiredPrivate := testKey(t, "retired", TrustRoleUpdate)
+	retired.Expires = now.Add(-time.Hour)
+	successor, successorPrivate := testKey(t, "successor", TrustRoleUpdate)
+	successor.NotBefore = now.Add(time.Hour)
+	_, strangerPrivate := testKey(t, "stranger", TrustRoleUpdate)
+
+	store := &TrustStore{Keys: []TrustedKey{update, hotfix, retired, successor}}
+	if err := store.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		privateKey string
+		role       string
+		want       string
+		wantErr    string
+	}{
+		{name: "role held", privateKey: updatePrivate, role: TrustRoleUpdate, want: "updates"},
+		{name: "hotfix key for a hotfix", privateKey: hotfixPrivate, role: TrustRoleHotfix, want: "hotfixes"},
+		{name: "hotfix key for an update", privateKey: hotfixPrivate, role: TrustRoleUpdate, wantErr: "not signed by a trusted key"},
+		{name: "update key for overrides", privateKey: updatePrivate, role: TrustRoleOverrides, wantErr: "not signed by a trusted key"},
+		{name: "expired key", privateKey: retiredPrivate, role: TrustRoleUpdate, wantErr: "expired"},
+		{name: "key not yet valid", privateKey: successorPrivate, role: TrustRoleUpdate, wantErr: "not valid until"},
+		{name: "unknown key", privateKey: strangerPrivate, role: TrustRoleUpdate, wantErr: "not signed by a trusted key"},
+	}
+
+	for _, test := range tests {
+		document, signature, err := signJSON([]byte(`{"version": "1.0"}`), test.privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key, err := store.Verify(document, signature, test.role, now)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: Verify() error = %v, want %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || key.Name != test.want {
+			t.Errorf("%s: Verify() = %v, %v, want key %q", test.name, key, err, test.want)
+		}
+	}
+
+	// the successor is accepted once it becomes valid
+	document, signature, _ := signJSON([]byte(`{}`), successorPrivate)
+	if _, err := store.Verify(document, signature, TrustRoleUpdate, successor.NotBefore); err != nil {
+		t.Errorf("Verify() with the successor key once valid: %v", err)
+	}
+
+	var empty *TrustStore
+	if _, err := empty.Verify(document, signature, TrustRoleUpdate, now); err == nil {
+		t.Errorf("Verify() without a trust store accepted a document")
+	}
+}
+
+func TestTrustStoreCheck(t *testing.T) {
+	key, _ := testKey(t, "key", TrustRoleUpdate)
+
+	tests := []struct {
+		name   string
+		modify func(*TrustedKey)
+	}{
+		{name: "no name", modify: func(key *TrustedKey) { key.Name = "" }},
+		{name: "invalid public key", modify: func(key *TrustedKey) { key.PublicKey = "not a key" }},
+		{name: "no roles", modify: func(key *TrustedKey) { key.Roles = nil }},
+		{name: "unknown role", modify: func(key *TrustedKey) { key.Roles = []string{"release"} }},
+		{name: "expires before valid", modify: func(key *TrustedKey) {
+			key.NotBefore = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+			key.Expires = key.NotBefore.Add(-time.Hour)
+		}},
+	}
+
+	for _, test := range tests {
+		invalid := key
+		test.modify(&invalid)
+		if err := (&TrustStore{Keys: []TrustedKey{invalid}}).Check(); err == nil {
+			t.Errorf("%s: Check() accepted the key", test.name)
+		}
+	}
+
+	if err := (&TrustStore{Keys: []TrustedKey{key, key}}).Check(); err == nil {
+		t.Errorf("Check() accepted a key listed twice")
+	}
+}
+
+func TestVerifyScriptUpdateRoles(t *testing.T) {
+	update, updatePrivate := testKey(t, "updates", TrustRoleUpdate)
+	hotfix, hotfixPrivate := testKey(t, "hotfixes", TrustRoleHotfix)
+	store := &TrustStore{Keys: []TrustedKey{update, hotfix}}
+
+	for _, test := range []struct {
+		name       string
+		hotfix     bool
+		privateKey string
+		wantErr    bool
+	}{
+		{name: "update signed by an update key", privateKey: updatePrivate},
+		{name: "hotfix signed by a hotfix key", hotfix: true, privateKey: hotfixPrivate},
+		{name: "update signed by a hotfix key", privateKey: hotfixPrivate, wantErr: true},
+		{name: "hotfix signed by an update key", hotfix: true, privateKey: updatePrivate, wantErr: true},
+	} {
+		signed, err := SignScriptUpdate(ScriptUpdateManifest{Product: "demo", Version: "1.0.1", Hotfix: test.hotfix, Algorithm: HashAlgorithm}, test.privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := VerifyScriptUpdate(signed, store); (err != nil) != test.wantErr {
+			t.Errorf("%s: VerifyScriptUpdate() error = %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+
+	// a hotfix key cannot turn an update into a hotfix by editing the manifest after it was signed
+	signed, _ := SignScriptUpdate(ScriptUpdateManifest{Product: "demo", Version: "1.0.1", Algorithm: HashAlgorithm}, updatePrivate)
+	var document SignedScriptUpdate
+	_ = json.Unmarshal(signed, &document)
+	document.Manifest = json.RawMessage(strings.Replace(string(document.Manifest), `"version"`, `"hotfix":true,"version"`, 1))
+	tampered, _ := json.Marshal(document)
+	if _, err := VerifyScriptUpdate(tampered, store); err == nil {
+		t.Errorf("VerifyScriptUpdate() accepted a manifest changed after signing")
+	}
+}
@@ -0,0 +1,75 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// VerifyKitManifestFilename is the signed manifest of a verification kit.
+const VerifyKitManifestFilename = "kit.signed.json"
+
+// VerifyKitManifest lists the hash of every file of a verification kit, so a signature over it vouches for the
+// installer and attachment hashes in the kit, not just their consistency with each other.
+type VerifyKitManifest struct {
+	Installer string `json:"installer"`
+	// Algorithm is the hash algorithm of Files.
+	Algorithm string `json:"algorithm"`
+	// Files are the hashes of the kit's files, keyed by their name in the kit.
+	Files     map[string]string `json:"files"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// SignedVerifyKit is a verification kit manifest as stored in the kit: the manifest, and the base64-encoded
+// Ed25519 signature of its compact JSON encoding.
+type SignedVerifyKit struct {
+	Manifest  json.RawMessage `json:"manifest"`
+	Signature string          `json:"signature"`
+}
+
+// SignVerifyKit signs manifest with the base64-encoded Ed25519 private key and returns the signed manifest.
+func SignVerifyKit(manifest VerifyKitManifest, privateKey string) ([]byte, error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	compact, signature, err := signJSON(data, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(SignedVerifyKit{Manifest: compact, Signature: signature}, "", "  ")
+}
+
+// VerifyVerifyKit checks the signature of the signed kit manifest in data against the base64-encoded Ed25519
+// public key and returns the manifest.
+func VerifyVerifyKit(data []byte, publicKey string) (*VerifyKitManifest, error) {
+	key, err := ParseOverrideKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var signed SignedVerifyKit
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, err
+	}
+
+	if !verifyJSON(signed.Manifest, signed.Signature, key) {
+		return nil, errors.New("verification kit is not signed by the given key")
+	}
+
+	var manifest VerifyKitManifest
+	if err := json.Unmarshal(signed.Manifest, &manifest); err != nil {
+		return nil, err
+	}
+
+	if manifest.Algorithm == "md5" {
+		return nil, errors.New("verification kit manifest uses md5")
+	}
+	if _, err := newHash(manifest.Algorithm); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
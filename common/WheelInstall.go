@@ -0,0 +1,272 @@
+package common
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrPlatformWheel is returned by InstallWheel for wheels that are not pure Python, which need pip to check
+// that they match the interpreter.
+var ErrPlatformWheel = errors.New("wheel is not pure Python")
+
+// wheelInstaller is recorded in the INSTALLER file of wheels installed by InstallWheel.
+const wheelInstaller = "exepy"
+
+// InstallWheel unpacks the pure-Python wheel at wheelPath into the Python installation at prefix, without pip.
+// Every file must be listed in the wheel's RECORD with a hash, and is written to a temporary file that is only
+// renamed into place once it matches. Files under .data directories are moved to their install scheme, and RECORD
+// is rewritten with the installed paths, so pip can later upgrade or uninstall the package. Console script
+// launchers are not generated.
+func InstallWheel(wheelPath, prefix string) error {
+	reader, err := zip.OpenReader(wheelPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	distInfo, err := findDistInfo(reader.File)
+	if err != nil {
+		return err
+	}
+
+	wheelMetadata, err := readZipFile(reader.File, distInfo+"/WHEEL")
+	if err != nil {
+		return err
+	}
+	if !isPureWheel(wheelMetadata) {
+		return ErrPlatformWheel
+	}
+
+	recordData, err := readZipFile(reader.File, distInfo+"/RECORD")
+	if err != nil {
+		return err
+	}
+
+	hashes, err := readRecord(recordData)
+	if err != nil {
+		return err
+	}
+
+	sitePackages := filepath.Join(prefix, "Lib", "site-packages")
+	dataDir := strings.TrimSuffix(distInfo, ".dist-info") + ".data"
+
+	var installed [][]string
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() || file.Name == distInfo+"/RECORD" {
+			continue
+		}
+
+		if !isLocalZipPath(file.Name) {
+			return fmt.Errorf("unsafe path in wheel: %s", file.Name)
+		}
+
+		// signatures of RECORD cannot be listed in it
+		expected := hashes[file.Name]
+		if expected == "" && !isRecordSignature(file.Name, distInfo) {
+			return fmt.Errorf("%s is not listed in RECORD with a hash", file.Name)
+		}
+
+		target := wheelFileTarget(file.Name, dataDir, prefix, sitePackages)
+
+		size, err := installWheelFile(file, target, expected)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.Name, err)
+		}
+
+		installed = append(installed, []string{recordPath(target, sitePackages), expected, fmt.Sprint(size)})
+	}
+
+	installerPath := filepath.Join(sitePackages, filepath.FromSlash(distInfo), "INSTALLER")
+	if err := os.WriteFile(installerPath, []byte(wheelInstaller+"\n"), 0644); err != nil {
+		return err
+	}
+	installerHash, installerSize := recordHash([]byte(wheelInstaller + "\n"))
+	installed = append(installed, []string{distInfo + "/INSTALLER", installerHash, fmt.Sprint(installerSize)})
+	installed = append(installed, []string{distInfo + "/RECORD", "", ""})
+
+	var record bytes.Buffer
+	if err := csv.NewWriter(&record).WriteAll(installed); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(sitePackages, filepath.FromSlash(distInfo), "RECORD"), record.Bytes(), 0644)
+}
+
+// findDistInfo returns the name of the top-level .dist-info directory of a wheel.
+func findDistInfo(files []*zip.File) (string, error) {
+	for _, file := range files {
+		if dir, name := path.Split(file.Name); name == "WHEEL" && strings.Count(dir, "/") == 1 && strings.HasSuffix(dir, ".dist-info/") {
+			return strings.TrimSuffix(dir, "/"), nil
+		}
+	}
+
+	return "", errors.New("wheel has no .dist-info directory")
+}
+
+func readZipFile(files []*zip.File, name string) ([]byte, error) {
+	for _, file := range files {
+		if file.Name == name {
+			reader, err := file.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer reader.Close()
+
+			return io.ReadAll(reader)
+		}
+	}
+
+	return nil, fmt.Errorf("wheel has no %s", name)
+}
+
+// isPureWheel reports whether the WHEEL metadata declares the wheel's root as purelib.
+func isPureWheel(metadata []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(metadata))
+	for scanner.Scan() {
+		if name, value, found := strings.Cut(scanner.Text(), ":"); found && strings.TrimSpace(name) == "Root-Is-Purelib" {
+			return strings.EqualFold(strings.TrimSpace(value), "true")
+		}
+	}
+
+	return false
+}
+
+// readRecord returns the hashes listed in a RECORD file, keyed by path.
+func readRecord(data []byte) (map[string]string, error) {
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]string, len(rows))
+	for _, row := range rows {
+		if len(row) >= 2 {
+			hashes[row[0]] = row[1]
+		}
+	}
+
+	return hashes, nil
+}
+
+// isLocalZipPath reports whether name stays inside the directory it is extracted to.
+func isLocalZipPath(name string) bool {
+	return filepath.IsLocal(filepath.FromSlash(name)) && !strings.Contains(name, "\\")
+}
+
+// wheelFileTarget returns where the wheel entry name is installed. Entries under dataDir are placed by their
+// install scheme; everything else goes to site-packages.
+func wheelFileTarget(name, dataDir, prefix, sitePackages string) string {
+	rest, isData := strings.CutPrefix(name, dataDir+"/")
+	if !isData {
+		return filepath.Join(sitePackages, filepath.FromSlash(name))
+	}
+
+	scheme, rest, _ := strings.Cut(rest, "/")
+	switch scheme {
+	case "scripts":
+		return filepath.Join(prefix, "Scripts", filepath.FromSlash(rest))
+	case "data":
+		return filepath.Join(prefix, filepath.FromSlash(rest))
+	case "headers":
+		return filepath.Join(prefix, "Include", path.Base(dataDir), filepath.FromSlash(rest))
+	default:
+		// purelib and platlib are both site-packages on Windows
+		return filepath.Join(sitePackages, filepath.FromSlash(rest))
+	}
+}
+
+// isRecordSignature reports whether name is one of the signatures of RECORD a wheel may carry.
+func isRecordSignature(name, distInfo string) bool {
+	return name == distInfo+"/RECORD.jws" || name == distInfo+"/RECORD.p7s"
+}
+
+// recordHashes are the algorithms RECORD hashes may use. Weaker ones are not accepted.
+var recordHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// installWheelFile writes a wheel entry to a temporary file next to target, checking it against its RECORD hash
+// as it is written, and renames it to target only if it matches. It returns the size of the file. An empty
+// expected hash is only given for signatures, which are installed unchecked.
+func installWheelFile(file *zip.File, target, expected string) (int64, error) {
+	var hasher hash.Hash
+	var expectedDigest string
+	if expected != "" {
+		algorithm, digest, _ := strings.Cut(expected, "=")
+		newHash, ok := recordHashes[algorithm]
+		if !ok {
+			return 0, fmt.Errorf("unsupported RECORD hash %q", expected)
+		}
+		hasher, expectedDigest = newHash(), digest
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return 0, err
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	output, err := os.CreateTemp(filepath.Dir(target), ".exepy-wheel-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(output.Name())
+	defer output.Close()
+
+	writer := io.Writer(output)
+	if hasher != nil {
+		writer = io.MultiWriter(output, hasher)
+	}
+
+	size, err := io.Copy(writer, reader)
+	if err != nil {
+		return 0, err
+	}
+	if err := output.Close(); err != nil {
+		return 0, err
+	}
+
+	if hasher != nil {
+		if actual := base64.RawURLEncoding.EncodeToString(hasher.Sum(nil)); actual != expectedDigest {
+			return 0, fmt.Errorf("hash %s does not match RECORD %s", actual, expected)
+		}
+	}
+
+	return size, os.Rename(output.Name(), target)
+}
+
+// recordHash returns the RECORD hash and size of data.
+func recordHash(data []byte) (string, int) {
+	sum := sha256.Sum256(data)
+	return "sha256=" + base64.RawURLEncoding.EncodeToString(sum[:]), len(data)
+}
+
+// recordPath returns the path of an installed file as written in RECORD: relative to site-packages, with
+// forward slashes.
+func recordPath(target, sitePackages string) string {
+	relative, err := filepath.Rel(sitePackages, target)
+	if err != nil {
+		return filepath.ToSlash(target)
+	}
+
+	return filepath.ToSlash(relative)
+}
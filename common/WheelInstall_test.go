@@ -0,0 +1,125 @@
+package common
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testWheelMetadata = "Wheel-Version: 1.0\nRoot-Is-Purelib: true\n"
+
+// writeTestWheel writes a wheel holding files, with record as its RECORD, and returns its path.
+func writeTestWheel(t *testing.T, files map[string]string, record string) string {
+	t.Helper()
+
+	wheelPath := filepath.Join(t.TempDir(), "demo-1.0-py3-none-any.whl")
+	file, err := os.Create(wheelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	writer := zip.NewWriter(file)
+	files["demo-1.0.dist-info/WHEEL"] = testWheelMetadata
+	files["demo-1.0.dist-info/RECORD"] = record
+	for name, contents := range files {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entry.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return wheelPath
+}
+
+// recordLine returns the RECORD line of a file with contents.
+func recordLine(name, contents string) string {
+	hash, size := recordHash([]byte(contents))
+	return name + "," + hash + "," + strconv.Itoa(size) + "\n"
+}
+
+func TestInstallWheel(t *testing.T) {
+	const module = "print('demo')\n"
+
+	tests := []struct {
+		name    string
+		record  string
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			record: recordLine("demo/__init__.py", module) + recordLine("demo-1.0.dist-info/WHEEL", testWheelMetadata) + "demo-1.0.dist-info/RECORD,,\n",
+		},
+		{
+			name:    "hash mismatch",
+			record:  recordLine("demo/__init__.py", "print('other')\n") + recordLine("demo-1.0.dist-info/WHEEL", testWheelMetadata) + "demo-1.0.dist-info/RECORD,,\n",
+			wantErr: "does not match RECORD",
+		},
+		{
+			name:    "missing from RECORD",
+			record:  recordLine("demo-1.0.dist-info/WHEEL", testWheelMetadata) + "demo-1.0.dist-info/RECORD,,\n",
+			wantErr: "not listed in RECORD",
+		},
+		{
+			name:    "empty hash",
+			record:  "demo/__init__.py,,14\n" + recordLine("demo-1.0.dist-info/WHEEL", testWheelMetadata) + "demo-1.0.dist-info/RECORD,,\n",
+			wantErr: "not listed in RECORD",
+		},
+		{
+			name:    "weak hash",
+			record:  "demo/__init__.py,md5=AAAA,14\n" + recordLine("demo-1.0.dist-info/WHEEL", testWheelMetadata) + "demo-1.0.dist-info/RECORD,,\n",
+			wantErr: "unsupported RECORD hash",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wheelPath := writeTestWheel(t, map[string]string{"demo/__init__.py": module}, test.record)
+			prefix := t.TempDir()
+
+			err := InstallWheel(wheelPath, prefix)
+
+			installedPath := filepath.Join(prefix, "Lib", "site-packages", "demo", "__init__.py")
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("InstallWheel() error = %v, want one containing %q", err, test.wantErr)
+				}
+				if DoesPathExist(installedPath) {
+					t.Errorf("file that failed its check was installed")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("InstallWheel() error = %v", err)
+			}
+
+			contents, err := os.ReadFile(installedPath)
+			if err != nil || string(contents) != module {
+				t.Errorf("installed file = %q, %v, want %q", contents, err, module)
+			}
+
+			record, err := os.ReadFile(filepath.Join(prefix, "Lib", "site-packages", "demo-1.0.dist-info", "RECORD"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(record), "demo-1.0.dist-info/INSTALLER,") {
+				t.Errorf("RECORD does not list INSTALLER:\n%s", record)
+			}
+
+			leftovers, _ := filepath.Glob(filepath.Join(prefix, "Lib", "site-packages", "demo", ".exepy-wheel-*"))
+			if len(leftovers) > 0 {
+				t.Errorf("temporary files left behind: %v", leftovers)
+			}
+		})
+	}
+}
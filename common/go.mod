@@ -23,3 +23,7 @@ require (
 	go4.org v0.0.0-20200411211856-f5505b9728dd // indirect
 	golang.org/x/text v0.3.8 // indirect
 )
+
+require github.com/maja42/ember v1.2.0
+
+replace github.com/maja42/ember => github.com/lukasgolson/ember v0.0.0-20240222203012-16dfde8ef5de
@@ -0,0 +1,215 @@
+// Package inspect reads the settings and manifest of exepy-built installers, and the state of their installations,
+// without running them. It is meant for inventory tools and keeps its exported API stable between releases.
+package inspect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/maja42/ember"
+	"io"
+	"lukasolson.net/common"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotInstaller is returned by OpenInstaller for executables without exepy attachments.
+var ErrNotInstaller = errors.New("not an exepy installer")
+
+// Installer is an exepy-built executable opened for reading.
+type Installer struct {
+	Path        string
+	attachments *ember.Attachments
+}
+
+// Manifest lists the hash of every attachment and, for installers that record it, their metadata.
+type Manifest struct {
+	Algorithm string                               `json:"algorithm"`
+	Hashes    map[string]string                    `json:"hashes"`
+	Metadata  map[string]common.AttachmentMetadata `json:"metadata,omitempty"`
+}
+
+// AttachmentResult is the outcome of verifying a single attachment against the manifest.
+type AttachmentResult struct {
+	Name     string `json:"name"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// Valid reports whether the attachment matched its manifest hash.
+func (result AttachmentResult) Valid() bool {
+	return result.Expected != "" && result.Expected == result.Actual
+}
+
+// Installation describes a directory an installer has been run in.
+type Installation struct {
+	Dir    string                  `json:"dir"`
+	Marker *common.BootstrapMarker `json:"marker"`
+	State  *common.InstallState    `json:"state"`
+}
+
+// OpenInstaller opens the installer at path. The caller must Close it.
+func OpenInstaller(path string) (*Installer, error) {
+	attachments, err := ember.OpenExe(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if attachments.Count() == 0 {
+		attachments.Close()
+		return nil, fmt.Errorf("%s: %w", path, ErrNotInstaller)
+	}
+
+	return &Installer{Path: path, attachments: attachments}, nil
+}
+
+// Close releases the installer file.
+func (installer *Installer) Close() error {
+	return installer.attachments.Close()
+}
+
+// Attachments returns the names of the installer's attachments.
+func (installer *Installer) Attachments() []string {
+	return installer.attachments.List()
+}
+
+// Size returns the size of the named attachment in bytes, or zero if it does not exist.
+func (installer *Installer) Size(name string) int64 {
+	return installer.attachments.Size(name)
+}
+
+// Hash returns the hash of the named attachment, computed with common.HashAlgorithm.
+func (installer *Installer) Hash(name string) (string, error) {
+	reader := installer.attachments.Reader(name)
+	if reader == nil {
+		return "", fmt.Errorf("installer has no %s attachment", name)
+	}
+
+	return common.HashReadSeeker(reader)
+}
+
+// HashWith returns the hash of the named attachment, computed with algorithm.
+func (installer *Installer) HashWith(name, algorithm string) (string, error) {
+	reader := installer.attachments.Reader(name)
+	if reader == nil {
+		return "", fmt.Errorf("installer has no %s attachment", name)
+	}
+
+	return common.HashReadSeekerWith(algorithm, reader)
+}
+
+// ReadSettings returns the settings the installer was built with, resolved as bootstrap resolves them.
+func ReadSettings(installer *Installer) (common.PythonSetupSettings, error) {
+	data, err := readAttachment(installer, common.GetConfigEmbedName())
+	if err != nil {
+		return common.PythonSetupSettings{}, err
+	}
+
+	return common.DecodeSettings(data)
+}
+
+// ReadManifest returns the attachment hashes and metadata of the installer.
+// Installers built before the algorithm was recorded use MD5, and metadata is nil for those built before it was recorded.
+func ReadManifest(installer *Installer) (Manifest, error) {
+	var manifest Manifest
+
+	data, err := readAttachment(installer, common.HashesEmbedName)
+	if err != nil {
+		return manifest, err
+	}
+
+	hashes, err := common.ReadHashManifest(data, installer.attachments.Reader(common.MetadataEmbedName) == nil)
+	if err != nil {
+		return manifest, err
+	}
+	manifest.Algorithm, manifest.Hashes = hashes.Algorithm, hashes.Hashes
+
+	if installer.attachments.Reader(common.MetadataEmbedName) != nil {
+		if err := readJSON(installer, common.MetadataEmbedName, &manifest.Metadata); err != nil {
+			return manifest, err
+		}
+	}
+
+	return manifest, nil
+}
+
+// VerifyAttachments hashes every attachment listed in the manifest and returns the result for each of them.
+// Attachments that are listed but missing have an empty Actual hash.
+func VerifyAttachments(installer *Installer) ([]AttachmentResult, error) {
+	manifest, err := ReadManifest(installer)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []AttachmentResult
+
+	for name, expected := range manifest.Hashes {
+		result := AttachmentResult{Name: name, Expected: expected}
+
+		if installer.attachments.Reader(name) != nil {
+			result.Actual, err = installer.HashWith(name, manifest.Algorithm)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// VerifyArchive reads the named archive attachment through to the end without extracting it, and reports the
+// files it holds and any entries that would not extract cleanly. An error means the archive is damaged.
+func VerifyArchive(installer *Installer, name string) (common.ArchiveReport, error) {
+	settings, err := ReadSettings(installer)
+	if err != nil {
+		return common.ArchiveReport{}, err
+	}
+
+	reader := installer.attachments.Reader(name)
+	if reader == nil {
+		return common.ArchiveReport{}, fmt.Errorf("installer has no %s attachment", name)
+	}
+
+	return common.VerifyArchive(reader, settings.CompressionFormat)
+}
+
+// ReadInstallation returns the bootstrap marker and install state recorded in dir.
+// Either is nil if the installer has not written it.
+func ReadInstallation(dir string) (Installation, error) {
+	installation := Installation{Dir: dir}
+
+	marker, err := common.ReadBootstrapMarker(filepath.Join(dir, common.BootstrapMarkerFilename))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return installation, err
+	}
+	installation.Marker = marker
+
+	if common.DoesPathExist(filepath.Join(dir, common.StateFilename)) {
+		installation.State, err = common.LoadState(filepath.Join(dir, common.StateFilename))
+		if err != nil {
+			return installation, err
+		}
+	}
+
+	return installation, nil
+}
+
+func readJSON(installer *Installer, name string, v any) error {
+	data, err := readAttachment(installer, name)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func readAttachment(installer *Installer, name string) ([]byte, error) {
+	reader := installer.attachments.Reader(name)
+	if reader == nil {
+		return nil, fmt.Errorf("installer has no %s attachment", name)
+	}
+
+	return io.ReadAll(reader)
+}
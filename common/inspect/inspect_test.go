@@ -0,0 +1,62 @@
+package inspect
+
+import (
+	"lukasolson.net/common"
+	"path/filepath"
+	"testing"
+)
+
+// The golden installers were built by earlier releases, with a stub that is not an executable, and must keep
+// opening with this build. See common/Golden_test.go.
+func TestGoldenInstallers(t *testing.T) {
+	tests := []struct {
+		file          string
+		algorithm     string
+		hasMetadata   bool
+		pythonVersion string
+	}{
+		// built before hashes recorded their algorithm and before attachment metadata
+		{file: "legacy-installer.exe", algorithm: common.HashAlgorithmMD5, pythonVersion: "3.11.7"},
+		// built with attachment metadata that does not record its format version
+		{file: "v1-installer.exe", algorithm: common.HashAlgorithmSHA256, hasMetadata: true, pythonVersion: "3.12.4"},
+	}
+
+	for _, test := range tests {
+		installer, err := OpenInstaller(filepath.Join("..", "testdata", "golden", test.file))
+		if err != nil {
+			t.Fatalf("%s: OpenInstaller() = %v", test.file, err)
+		}
+
+		manifest, err := ReadManifest(installer)
+		if err != nil || manifest.Algorithm != test.algorithm || (manifest.Metadata != nil) != test.hasMetadata {
+			t.Errorf("%s: ReadManifest() = %+v, %v, want algorithm %s and metadata %v", test.file, manifest, err, test.algorithm, test.hasMetadata)
+		}
+		for name, metadata := range manifest.Metadata {
+			if err := metadata.CheckCompatibility(); err != nil {
+				t.Errorf("%s: %s: CheckCompatibility() = %v", test.file, name, err)
+			}
+		}
+
+		results, err := VerifyAttachments(installer)
+		if err != nil || len(results) == 0 {
+			t.Errorf("%s: VerifyAttachments() = %v, %v", test.file, results, err)
+		}
+		for _, result := range results {
+			if !result.Valid() {
+				t.Errorf("%s: attachment %s has hash %s, want %s", test.file, result.Name, result.Actual, result.Expected)
+			}
+		}
+
+		settings, err := ReadSettings(installer)
+		if err != nil || settings.MainScript != "main.py" || settings.PythonVersion != test.pythonVersion || settings.PythonExtractDir != "python" {
+			t.Errorf("%s: ReadSettings() = %+v, %v", test.file, settings, err)
+		}
+
+		report, err := VerifyArchive(installer, common.PayloadFilename)
+		if err != nil || report.Files != 3 {
+			t.Errorf("%s: VerifyArchive() = %+v, %v, want 3 files", test.file, report, err)
+		}
+
+		installer.Close()
+	}
+}
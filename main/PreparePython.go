@@ -6,36 +6,60 @@ import (
 	common "lukasolson.net/common"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
-func PreparePython(settings common.PythonSetupSettings) (io.ReadSeeker, io.ReadSeeker, error) {
+func PreparePython(settings common.PythonSetupSettings) (io.ReadSeeker, io.ReadSeeker, map[string]common.AttachmentMetadata, error) {
+
+	// build in the configured temporary directory rather than the working directory
+	if settings.TempDir != "" {
+		settings.PythonExtractDir = filepath.Join(settings.TempDir, settings.PythonExtractDir)
+		settings.PythonDownloadZip = filepath.Join(settings.TempDir, settings.PythonDownloadZip)
+	}
 
 	cleanDirectory(&settings)
 
+	defer common.AddCleanup(func() { cleanDirectory(&settings) })()
 	defer cleanDirectory(&settings)
 
 	// CREATE THE EXTRACTION DIRECTORY
 	common.RemoveIfExists(settings.PythonExtractDir)
 	if err := os.Mkdir(settings.PythonExtractDir, os.ModePerm); err != nil {
 		fmt.Println("Error creating extraction directory:", err)
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	// DOWNLOAD PYTHON ZIP FILE
 	if err := common.DownloadFile(settings.PythonDownloadURL, settings.PythonDownloadZip); err != nil {
 		fmt.Println("Error downloading Python zip file:", err)
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+
+	if settings.PythonChecksum != "" {
+		checksum := strings.ToLower(settings.PythonChecksum)
+
+		actualHash, err := common.HashFileWith(common.HashAlgorithmSHA256, settings.PythonDownloadZip)
+		if err != nil {
+			fmt.Println("Error hashing Python zip file:", err)
+			return nil, nil, nil, err
+		}
+
+		if actualHash != checksum {
+			err := fmt.Errorf("checksum mismatch -> Expected: %s Actual: %s", checksum, actualHash)
+			fmt.Println("Error verifying Python zip file:", err)
+			return nil, nil, nil, err
+		}
 	}
 
 	// DOWNLOAD PIP FILE
 	if err := common.DownloadFile(settings.PipDownloadURL, common.GetPipName(settings.PythonExtractDir)); err != nil {
 		fmt.Println("Error downloading pip module:", err)
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	if err := createBasePythonInstallation(&settings, settings.PythonDownloadZip); err != nil {
 		fmt.Println("Error creating base Python installation:", err)
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	common.RemoveIfExists(settings.PythonDownloadZip)
@@ -44,22 +68,33 @@ func PreparePython(settings common.PythonSetupSettings) (io.ReadSeeker, io.ReadS
 	destRequirements := filepath.Join(settings.PythonExtractDir, settings.RequirementsFile)
 	common.CopyFile(originRequirements, destRequirements)
 
-	pythonStream, err := common.CompressDirToStream(settings.PythonExtractDir)
+	metadata := make(map[string]common.AttachmentMetadata)
+	archiveOptions := common.ArchiveOptions{StoreCompressed: settings.StoreCompressedFiles}
 
-	if err != nil {
-		fmt.Println("Error zipping Python directory:", err)
-		return nil, nil, err
+	// a portable build archives Python once the requirements are installed into it
+	var pythonStream *common.SpooledArchive
+	if !settings.Portable {
+		var err error
+		pythonStream, err = common.CompressDirToStreamWithOptions(settings.PythonExtractDir, settings.CompressionFormat, archiveOptions)
+
+		if err != nil {
+			fmt.Println("Error zipping Python directory:", err)
+			return nil, nil, nil, err
+		}
+		metadata[common.PythonFilename] = common.NewArchiveMetadata(common.AttachmentTypePython, pythonStream)
 	}
 
 	wheelsPath := filepath.Join(settings.PythonExtractDir, "wheels")
 	os.Mkdir(wheelsPath, os.ModePerm)
 
-	if settings.RequirementsFile != "" {
+	_, prebuiltWheels := settings.AttachmentSources[common.WheelsFilename]
+
+	if settings.RequirementsFile != "" && !prebuiltWheels {
 
 		if common.DoesPathExist(originRequirements) {
 			fmt.Println("Requirements file found:", originRequirements)
 			if err := buildRequirementWheels(settings.PythonExtractDir, originRequirements, wheelsPath); err != nil {
-				return nil, nil, err
+				return nil, nil, nil, err
 			}
 		} else {
 			fmt.Println("Requirements file not found but is specified in configuration:", originRequirements)
@@ -67,9 +102,34 @@ func PreparePython(settings common.PythonSetupSettings) (io.ReadSeeker, io.ReadS
 
 	}
 
-	wheelsStream, _ := common.CompressDirToStream(wheelsPath)
+	if settings.Portable {
+		requirements := ""
+		if settings.RequirementsFile != "" {
+			requirements = originRequirements
+		}
+
+		if err := installPortablePackages(settings.PythonExtractDir, requirements, wheelsPath); err != nil {
+			return nil, nil, nil, err
+		}
 
-	return pythonStream, wheelsStream, nil
+		var err error
+		pythonStream, err = common.CompressDirToStreamWithOptions(settings.PythonExtractDir, settings.CompressionFormat, archiveOptions)
+		if err != nil {
+			fmt.Println("Error zipping Python directory:", err)
+			return nil, nil, nil, err
+		}
+		metadata[common.PythonFilename] = common.NewArchiveMetadata(common.AttachmentTypePython, pythonStream)
+	}
+
+	wheelsStream, err := common.CompressDirToStreamWithOptions(wheelsPath, settings.CompressionFormat, archiveOptions)
+	if err != nil {
+		fmt.Println("Error zipping wheels directory:", err)
+		pythonStream.Close()
+		return nil, nil, nil, err
+	}
+	metadata[common.WheelsFilename] = common.NewArchiveMetadata(common.AttachmentTypeWheels, wheelsStream)
+
+	return pythonStream, wheelsStream, metadata, nil
 }
 
 func createBasePythonInstallation(settings *common.PythonSetupSettings, pythonZip string) error {
@@ -98,54 +158,116 @@ func createBasePythonInstallation(settings *common.PythonSetupSettings, pythonZi
 		return err
 	}
 
+	if err := addRuntimeComponents(settings); err != nil {
+		fmt.Println("Error adding runtime components:", err)
+		return err
+	}
+
 	return nil
 }
 
+// extractInteriorPythonArchive extracts the interior standard library zip into the Python directory and records
+// the size and hash of its files, so bootstrap can check them before Python is used.
 func extractInteriorPythonArchive(settings *common.PythonSetupSettings) error {
 	// EXTRACT THE EMBEDDED PYTHON INTERIOR ZIP FILE
+	interiorZip := filepath.Join(settings.PythonExtractDir, settings.PythonInteriorZip)
 
-	if err := common.ExtractZip(filepath.Join(settings.PythonExtractDir, settings.PythonInteriorZip), settings.PythonExtractDir, 0); err != nil {
+	manifest, err := common.NewInteriorManifest(interiorZip)
+	if err != nil {
+		fmt.Println("Error reading the interiorPython zip file:", err)
+		return err
+	}
+
+	if err := common.ExtractZip(interiorZip, settings.PythonExtractDir, 0); err != nil {
 		fmt.Println("Error extracting the interiorPython zip file:", err)
 		return err
 	}
 
-	common.RemoveIfExists(filepath.Join(settings.PythonExtractDir, settings.PythonInteriorZip))
+	if err := common.SaveInteriorManifest(filepath.Join(settings.PythonExtractDir, common.InteriorManifestFilename), manifest); err != nil {
+		fmt.Println("Error saving the interiorPython manifest:", err)
+		return err
+	}
+
+	common.RemoveIfExists(interiorZip)
 	return nil
 }
 
+// siteCustomizeContents returns the sitecustomize module for Python extracted to pythonExtractDir. It makes the
+// installation root importable by its absolute path, found from sys.prefix, rather than the working directory,
+// which is wherever the script happens to be started from and may hold modules that must not shadow the payload.
+func siteCustomizeContents(pythonExtractDir string) string {
+	depth := len(strings.Split(filepath.ToSlash(filepath.Clean(pythonExtractDir)), "/"))
+	parents := strings.TrimSuffix(strings.Repeat("'..', ", depth), ", ")
+
+	return `# Generated by exepy
+import os
+import sys
+
+# make the installation root, where the payload scripts live, importable from anywhere
+_install_root = os.path.normpath(os.path.join(sys.prefix, ` + parents + `))
+if _install_root not in sys.path:
+    sys.path.append(_install_root)
+`
+}
+
 func createSiteCustomFile(settings *common.PythonSetupSettings) error {
-	sitecustomizeFile, err := os.Create(filepath.Join(settings.PythonExtractDir, "sitecustomize.py"))
+	return common.SaveContentsToFile(filepath.Join(settings.PythonExtractDir, "sitecustomize.py"), siteCustomizeContents(settings.PythonExtractDir))
+}
+
+// updatePTHFile patches the distribution's ._pth file: the interior zip entry is dropped because the zip is
+// extracted, DLLs, site-packages and the installation root are added, and "import site" is enabled.
+func updatePTHFile(settings *common.PythonSetupSettings) error {
+	pthPath := filepath.Join(settings.PythonExtractDir, settings.PthFile)
 
+	contents, err := os.ReadFile(pthPath)
 	if err != nil {
+		fmt.Println("Error reading ._pth file:", err)
 		return err
 	}
 
-	_, err = sitecustomizeFile.WriteString("import sys\nsys.path.append('.')")
+	patched := patchPTHContents(string(contents), settings.PythonInteriorZip)
 
-	if err != nil {
+	if err := common.SaveContentsToFile(pthPath, patched); err != nil {
+		fmt.Println("Error writing to ._pth file:", err)
 		return err
 	}
 
 	return nil
 }
 
-func updatePTHFile(settings *common.PythonSetupSettings) error {
-	common.RemoveIfExists(filepath.Join(settings.PythonExtractDir, settings.PthFile))
+// patchPTHContents returns the ._pth contents with the exepy entries applied. Entries are relative to the
+// Python directory; ".." is the installation root where the payload is extracted.
+func patchPTHContents(contents, interiorZip string) string {
+	requiredPaths := []string{".", "DLLs", "Lib\\site-packages", "Scripts", ".."}
 
-	// write to ._pth file
-	pthFile, err := os.Create(filepath.Join(settings.PythonExtractDir, settings.PthFile))
-	if err != nil {
-		fmt.Println("Error creating ._pth file:", err)
-		return nil
+	var paths []string
+	seen := make(map[string]bool)
+
+	for _, line := range strings.Split(strings.ReplaceAll(contents, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "", strings.HasPrefix(line, "#"), strings.HasPrefix(line, "import "):
+			// comments and the site import are rewritten below
+			continue
+		case strings.EqualFold(line, interiorZip):
+			continue
+		}
+
+		if !seen[strings.ToLower(line)] {
+			seen[strings.ToLower(line)] = true
+			paths = append(paths, line)
+		}
 	}
 
-	// change python311 to pythonExtractDir
-	_, err = pthFile.WriteString(".\\" + settings.PythonExtractDir + "\n.\\Scripts\n.\n.\\Lib\\site-packages\nimport site")
-	if err != nil {
-		fmt.Println("Error writing to ._pth file:", err)
-		return nil
+	for _, requiredPath := range requiredPaths {
+		if !seen[strings.ToLower(requiredPath)] {
+			seen[strings.ToLower(requiredPath)] = true
+			paths = append(paths, requiredPath)
+		}
 	}
-	return err
+
+	return "# Patched by exepy\r\n" + strings.Join(paths, "\r\n") + "\r\nimport site\r\n"
 }
 
 func buildRequirementWheels(extractDir, requirementsFile, wheelDir string) error {
@@ -165,6 +287,26 @@ func buildRequirementWheels(extractDir, requirementsFile, wheelDir string) error
 	return nil
 }
 
+// installPortablePackages installs the wheels built from requirementsFile into the Python installation at
+// extractDir, if there is one, and empties wheelDir, since a portable installer ships its packages already installed.
+func installPortablePackages(extractDir, requirementsFile, wheelDir string) error {
+	if requirementsFile != "" && common.DoesPathExist(requirementsFile) {
+		pythonPath := filepath.Join(extractDir, "python.exe")
+
+		if err := common.RunCommand(pythonPath, []string{common.GetPipName(extractDir), "install", "--no-index", "--find-links", wheelDir, "-r", requirementsFile}); err != nil {
+			fmt.Println("Error installing requirements into portable Python:", err)
+			return err
+		}
+	}
+
+	if err := os.RemoveAll(wheelDir); err != nil {
+		fmt.Println("Error removing installed wheels:", err)
+		return err
+	}
+
+	return os.Mkdir(wheelDir, os.ModePerm)
+}
+
 func cleanDirectory(settings *common.PythonSetupSettings) {
 	common.RemoveIfExists(settings.PythonExtractDir)
 	common.RemoveIfExists(settings.PythonDownloadZip)
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"lukasolson.net/common"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	// antivirusProbeFiles is how many files the probe writes to measure how long each takes.
+	antivirusProbeFiles = 40
+	// slowFileThreshold is the time to write, rename and remove one small file above which real-time scanning is
+	// taken to be intercepting extraction. Unscanned, it takes well under a millisecond.
+	slowFileThreshold = 10 * time.Millisecond
+	// lockedFileRetry is how long the probe waits before retrying a file a scanner still holds open.
+	lockedFileRetry = 50 * time.Millisecond
+)
+
+// probeContents resembles the Python sources extraction writes, which is what scanners inspect.
+const probeContents = "import os\nimport sys\n\n\ndef main():\n    print(sys.argv, os.getcwd())\n\n\nif __name__ == '__main__':\n    main()\n"
+
+// scanProbe is what the antivirus probe measured.
+type scanProbe struct {
+	perFile time.Duration
+	locked  int
+}
+
+// intercepted reports whether the probe saw real-time scanning slow down or lock the files it wrote.
+func (probe scanProbe) intercepted() bool {
+	return probe.perFile > slowFileThreshold || probe.locked > 0
+}
+
+// validateAntivirusCheck checks that mode is a known antivirus check mode.
+func validateAntivirusCheck(mode string) error {
+	switch mode {
+	case "", common.AntivirusCheckWarn, common.AntivirusCheckPause:
+		return nil
+	}
+
+	return errors.New("unknown antivirus check: " + mode)
+}
+
+// checkAntivirus looks for real-time scanning slowing down writes to the installation directory before first time
+// setup extracts files into it, and prints guidance on excluding the directory when it does. In pause mode the
+// user is given the chance to add an exclusion before setup continues. The check never stops the installation.
+func checkAntivirus(settings common.PythonSetupSettings, options bootstrapOptions) {
+	if settings.AntivirusCheck == "" {
+		return
+	}
+
+	installDir, err := filepath.Abs(".")
+	if err != nil {
+		fmt.Println("Error checking for antivirus scanning:", err)
+		return
+	}
+
+	for {
+		probe, err := probeScanning(installDir)
+		if err != nil {
+			fmt.Println("Error checking for antivirus scanning:", err)
+			return
+		}
+
+		if !probe.intercepted() {
+			return
+		}
+
+		printAntivirusGuidance(probe, installDir)
+
+		// unattended runs have nobody to add the exclusion
+		if settings.AntivirusCheck != common.AntivirusCheckPause || options.prewarm {
+			return
+		}
+
+		fmt.Print("Press Enter to check again once the exclusion is added, or type skip to continue now: ")
+		answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			fmt.Println()
+			return
+		}
+		if strings.EqualFold(strings.TrimSpace(answer), "skip") {
+			return
+		}
+	}
+}
+
+// probeScanning writes, renames and removes small files in dir, as extraction does, and measures how long each
+// takes and how many a scanner held open.
+func probeScanning(dir string) (scanProbe, error) {
+	probeDir, err := os.MkdirTemp(dir, ".exepy-avprobe-")
+	if err != nil {
+		return scanProbe{}, err
+	}
+	defer os.RemoveAll(probeDir)
+
+	var probe scanProbe
+	start := time.Now()
+
+	for i := 0; i < antivirusProbeFiles; i++ {
+		probePath := filepath.Join(probeDir, fmt.Sprintf("probe%d.py", i))
+		if err := os.WriteFile(probePath, []byte(probeContents), 0644); err != nil {
+			return probe, err
+		}
+
+		renamed := probePath + "c"
+		if err := os.Rename(probePath, renamed); err != nil {
+			// a scanner that opened the file without sharing blocks the rename until it is done
+			probe.locked++
+			time.Sleep(lockedFileRetry)
+			if err := os.Rename(probePath, renamed); err != nil {
+				return probe, err
+			}
+		}
+
+		if err := os.Remove(renamed); err != nil {
+			probe.locked++
+			time.Sleep(lockedFileRetry)
+			if err := os.Remove(renamed); err != nil {
+				return probe, err
+			}
+		}
+	}
+
+	probe.perFile = time.Since(start) / antivirusProbeFiles
+
+	return probe, nil
+}
+
+// printAntivirusGuidance explains that real-time scanning is slowing down the installation and how to exclude
+// installDir from it.
+func printAntivirusGuidance(probe scanProbe, installDir string) {
+	fmt.Printf("Real-time antivirus scanning appears to be slowing down installation: each file took %s to write", probe.perFile.Round(time.Millisecond/10))
+	if probe.locked > 0 {
+		fmt.Printf(" and %d were held open after writing", probe.locked)
+	}
+	fmt.Println(".")
+
+	products := antivirusProducts()
+	if len(products) > 0 {
+		fmt.Println("Antivirus products found:", strings.Join(products, ", "))
+	}
+
+	fmt.Println("Setup can take several times longer than usual. To speed it up, exclude this directory from real-time scanning:")
+	fmt.Println(" ", installDir)
+
+	for _, product := range products {
+		if strings.Contains(strings.ToLower(product), "defender") {
+			fmt.Println("For Microsoft Defender, an administrator can run in PowerShell:")
+			fmt.Println("  Add-MpPreference -ExclusionPath", common.QuotePowerShellLiteral(installDir))
+			break
+		}
+	}
+
+	fmt.Println("Where antivirus settings are managed centrally, ask your IT department to add the exclusion.")
+}
+
+// antivirusProducts returns the names of the antivirus products registered with Windows Security Center. Windows
+// Server has no Security Center, so nothing is found there.
+func antivirusProducts() []string {
+	if runtime.GOOS != "windows" {
+		return nil
+	}
+
+	output, err := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command",
+		"Get-CimInstance -Namespace root/SecurityCenter2 -ClassName AntiVirusProduct | ForEach-Object { $_.displayName }").Output()
+	if err != nil {
+		return nil
+	}
+
+	var products []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if product := strings.TrimSpace(line); product != "" {
+			products = append(products, product)
+		}
+	}
+
+	return products
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"lukasolson.net/common"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// listBackups returns the timestamps of the available backups, oldest first.
+func listBackups() ([]string, error) {
+	entries, err := os.ReadDir(backupsDirectory)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var timestamps []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			timestamps = append(timestamps, entry.Name())
+		}
+	}
+
+	// timestamps are formatted so that lexical order is chronological order
+	sort.Strings(timestamps)
+
+	return timestamps, nil
+}
+
+// pruneBackups removes the oldest backups so that at most retention remain. A retention of 0 keeps everything.
+func pruneBackups(retention int) error {
+	if retention <= 0 {
+		return nil
+	}
+
+	timestamps, err := listBackups()
+	if err != nil {
+		return err
+	}
+
+	for len(timestamps) > retention {
+		if err := os.RemoveAll(filepath.Join(backupsDirectory, timestamps[0])); err != nil {
+			return err
+		}
+
+		fmt.Println("Removed old backup:", timestamps[0])
+		timestamps = timestamps[1:]
+	}
+
+	return nil
+}
+
+// restoreBackup copies the files of the given backup back into the installation.
+// The files being replaced are themselves backed up first, so a restore can be undone.
+func restoreBackup(timestamp string) error {
+	backupDir := filepath.Join(backupsDirectory, timestamp)
+
+	if timestamp == "" || filepath.Base(timestamp) != timestamp || !common.DoesPathExist(backupDir) {
+		return errors.New("no backup found for " + timestamp + ". Use --list-backups to see available backups")
+	}
+
+	undoDir := newBackupDir()
+	if undoDir == backupDir {
+		return errors.New("backup was created less than a second ago. Try again")
+	}
+
+	return filepath.WalkDir(backupDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(backupDir, path)
+		if err != nil {
+			return err
+		}
+
+		if common.DoesPathExist(relativePath) {
+			undoPath := filepath.Join(undoDir, relativePath)
+			if err := os.MkdirAll(filepath.Dir(undoPath), os.ModePerm); err != nil {
+				return err
+			}
+
+			if err := common.CopyFile(relativePath, undoPath); err != nil {
+				return err
+			}
+		}
+
+		if err := os.MkdirAll(filepath.Dir(relativePath), os.ModePerm); err != nil {
+			return err
+		}
+
+		fmt.Println("Restoring:", relativePath)
+		return common.CopyFile(path, relativePath)
+	})
+}
@@ -2,208 +2,757 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/maja42/ember"
 	"io"
 	"lukasolson.net/common"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"strings"
 	"time"
 )
 
-func bootstrap() {
+// bootstrap installs and runs the embedded payload and returns the exit code of the installer.
+func bootstrap() int {
 
-	exit := ValidateExecutableHash()
-	if exit {
-		return
+	options, payloadArgs, err := parseBootstrapArgs(os.Args[1:])
+	if err != nil {
+		fmt.Println("Error:", err)
+		return exitCodeUsage
+	}
+	warnInjectedFaults()
+
+	if options.showID {
+		if err := showIdentity(); err != nil {
+			fmt.Println("Error showing installer identity:", err)
+			return exitCodeFailure
+		}
+		return exitCodeSuccess
+	}
+
+	installRoot, err := chooseInstallRoot()
+	if err != nil {
+		fmt.Println("Error: The installer is running from a read-only location and no writable directory could be found:", err)
+		return exitCodeExtractionFailure
+	}
+	if installRoot != "" {
+		fmt.Println("The installer is running from a read-only location. Installing to", installRoot)
+	}
+
+	tracer := common.NewTracerFromEnv("exepy-bootstrap")
+	defer func() {
+		if err := tracer.Flush(); err != nil {
+			fmt.Println("Error writing trace:", err)
+		}
+	}()
+
+	rootSpan := tracer.Start("bootstrap", nil)
+	defer rootSpan.End()
+
+	state, err := common.LoadState(common.StateFilename)
+	if err != nil {
+		fmt.Println("Error reading state, starting fresh:", err)
+		state = &common.InstallState{}
+	}
+
+	if err := migrateLegacyInstall(state); err != nil {
+		fmt.Println("Error migrating installation from an earlier release:", err)
+	}
+
+	if options.listBackups {
+		timestamps, err := listBackups()
+		if err != nil {
+			fmt.Println("Error listing backups:", err)
+			return exitCodeFailure
+		}
+
+		fmt.Println("Available backups:")
+		for _, timestamp := range timestamps {
+			fmt.Println(" ", timestamp)
+		}
+		return exitCodeSuccess
+	}
+
+	if options.restoreBackup != "" {
+		if err := restoreBackup(options.restoreBackup); err != nil {
+			fmt.Println("Error restoring backup:", err)
+			return exitCodeFailure
+		}
+
+		fmt.Println("Backup restored:", options.restoreBackup)
+		return exitCodeSuccess
+	}
+
+	// record the troubleshooting flags of the latest run, clearing those of earlier runs
+	if overrides := options.overrides(); len(overrides) > 0 || len(state.Overrides) > 0 {
+		if len(overrides) > 0 {
+			fmt.Println("Troubleshooting overrides in effect:", strings.Join(overrides, " "))
+		}
+
+		state.Overrides = overrides
+		if err := common.SaveState(common.StateFilename, state); err != nil {
+			fmt.Println("Error saving state:", err)
+		}
 	}
 
 	attachments, err := ember.Open()
 	if err != nil {
 		fmt.Println("Error opening attachments:", err)
-		return
+		return exitCodeFailure
 	}
 	defer attachments.Close()
 
-	if ValidateHashes(attachments) {
+	settings, err := GetSettings(attachments)
+	if err != nil {
+		fmt.Println("Error reading settings:", err)
+		return exitCodeFailure
+	}
+
+	if options.extractTo == "" && options.exportEnv == "" {
+		if err := openInstallLog(settings); err != nil {
+			fmt.Println("Error opening install log:", err)
+		}
+		defer closeInstallLog()
+	}
+
+	if options.extractTo != "" {
+		if err := extractAttachments(attachments, settings, options.extractTo, options.extractFilter); err != nil {
+			fmt.Println("Error extracting attachments:", err)
+			return exitCodeFailure
+		}
+
+		fmt.Println("Attachments written to", options.extractTo)
+		return exitCodeSuccess
+	}
+
+	if options.exportEnv != "" {
+		if err := exportEnvironment(attachments, settings, options.exportEnv); err != nil {
+			fmt.Println("Error exporting environment:", err)
+			return exitCodeFailure
+		}
+
+		fmt.Println("Environment exported to", options.exportEnv)
+		return exitCodeSuccess
+	}
+
+	// first time setup replaces the Python directory and uninstalling removes it, so it must not be the
+	// installation directory
+	if err := common.CheckPythonExtractDir(settings.PythonExtractDir); err != nil {
+		fmt.Println("Error in the embedded settings:", err)
+		return exitCodeFailure
+	}
+
+	report, closeEventLog := newEventReporter(settings)
+	defer closeEventLog()
+
+	policy := effectiveHashPolicy(settings, state)
+
+	span := tracer.Start("validate-executable", rootSpan)
+	exeHash, exeAccepted, code := ValidateExecutableHash(policy, state.StubHash, report)
+	span.End()
+	if code != exitCodeSuccess {
+		return code
+	}
+
+	payloadRoot, code := ValidatePayloadRoot(attachments, policy, state.PayloadRoot, exeAccepted || options.skipIntegrity, exeHash, report)
+	if code != exitCodeSuccess {
+		return code
+	}
+
+	// skip re-reading every attachment once a successful install has verified this exact executable
+	span = tracer.Start("validate-attachments", rootSpan)
+	if options.skipIntegrity {
+		fmt.Println("Skipping hash validation (--skip-integrity).")
+	} else if state.AttachmentsVerified && state.ExecutableHash == exeHash {
+		fmt.Println("Attachments previously verified. Skipping hash validation.")
+	} else if ValidateHashes(attachments) {
 		fmt.Println("Hashes validated successfully.")
 	} else {
 		fmt.Println("Error validating hashes.")
-		return
+		report(eventIntegrityFailure, exeHash, "embedded attachments do not match their hashes")
+		span.SetError(errors.New("attachment hash mismatch"))
+		runRecovery(attachments)
+		return exitCodeIntegrityFailure
 	}
+	span.End()
 
-	// for each hash, compare the hash of the file to the hash in the map
-	// if any of the hashes do not match, return an error
+	if err := CheckAttachmentCompatibility(attachments); err != nil {
+		fmt.Println("Error: This installer was built by an incompatible version of exepy:", err)
+		return exitCodeFailure
+	}
 
-	// settings hash
+	if options.uninstall {
+		installLock, err := acquireInstallLock(settings)
+		if errors.Is(err, common.ErrLocked) {
+			fmt.Println("Another installation of", productName(settings), "is in progress. Try again once it has finished.")
+			return exitCodeInstallLocked
+		}
+		if err != nil {
+			fmt.Println("Error acquiring install lock:", err)
+			return exitCodeFailure
+		}
+		defer installLock.Release()
 
-	settings, err := GetSettings(attachments)
+		if err := uninstall(attachments, settings); err != nil {
+			fmt.Println("Error uninstalling:", err)
+			return exitCodeFailure
+		}
+
+		fmt.Println(productName(settings), "uninstalled.")
+		return exitCodeSuccess
+	}
+
+	needsSetup := options.forceExtract || !common.DoesPathExist(common.BootstrapMarkerFilename)
+
+	// overrides only apply to first time setup, and only once the executable they are applied to is verified
+	if needsSetup {
+		if err := applyOverrides(&settings); err != nil {
+			fmt.Println("Error applying configuration overrides:", err)
+			if settings.OverrideConfig.Required {
+				return exitCodeFailure
+			}
+			fmt.Println("Continuing with the embedded settings.")
+		}
+	}
+
+	if !confirmLicense(attachments, settings, options, state, exeHash, report) {
+		fmt.Println("Installation cancelled: the license was not accepted.")
+		return exitCodeLicenseDeclined
+	}
+
+	if err := provideSecrets(settings, options); err != nil {
+		fmt.Println("Error providing secrets:", err)
+		return exitCodeFailure
+	}
+
+	parameterArgs, err := provideParameters(settings, options, state, needsSetup)
 	if err != nil {
-		fmt.Println("Error reading settings:", err)
-		return
+		fmt.Println("Error providing parameters:", err)
+		return exitCodeFailure
+	}
+
+	// serialise first time setup with other installers of the same product
+	if needsSetup {
+		installLock, err := acquireInstallLock(settings)
+		if errors.Is(err, common.ErrLocked) {
+			fmt.Println("Another installation of", productName(settings), "is in progress. Try again once it has finished.")
+			return exitCodeInstallLocked
+		}
+		if err != nil {
+			fmt.Println("Error acquiring install lock:", err)
+			return exitCodeFailure
+		}
+		defer installLock.Release()
 	}
 
 	// check if the bootstrap has already been run
-	if _, err := os.Stat("bootstrapped"); os.IsNotExist(err) {
+	if needsSetup {
 		// if the bootstrap has not been run, extract the Python and program files
 
+		capabilities, accepted := confirmCapabilities(settings, options)
+		if !accepted {
+			fmt.Println("Installation cancelled: the declared capabilities were not accepted.")
+			return exitCodeCapabilitiesDeclined
+		}
+
+		if len(capabilities) > 0 {
+			report(eventCapabilitiesAccepted, exeHash, strings.Join(capabilities, "; "))
+
+			state.Capabilities = capabilities
+			if err := common.SaveState(common.StateFilename, state); err != nil {
+				fmt.Println("Error saving state:", err)
+			}
+		}
+
 		fmt.Println("Performing first time setup...")
+		report(eventInstallStarted, exeHash, "")
+
+		setupSpan := tracer.Start("setup", rootSpan)
+
+		if settings.TempDir != "" {
+			if err := common.SetTempDir(settings.TempDir); err != nil {
+				fmt.Println("Error setting temporary directory:", err)
+				return exitCodeExtractionFailure
+			}
+		}
+
+		if err := checkInstallSpace(attachments); err != nil {
+			fmt.Println("Error: Not enough free space to install:", err)
+			return exitCodeExtractionFailure
+		}
+
+		checkAntivirus(settings, options)
+
+		// every early return below is a failed installation
+		installed := false
+		defer func() {
+			if !installed {
+				report(eventInstallFailed, exeHash, "first time setup did not complete")
+
+				if err := runPlugins(attachments, settings, common.PluginOnFailure); err != nil {
+					fmt.Println("Error running failure plugins:", err)
+				}
+			}
+		}()
+
+		if err := runPlugins(attachments, settings, common.PluginPreInstall); err != nil {
+			fmt.Println("Error running pre-install plugins:", err)
+			return exitCodePluginFailure
+		}
 
 		PythonReader := attachments.Reader(common.PythonFilename)
 
 		if PythonReader == nil {
 			fmt.Println("Error reading Python. Ensure it is embedded in the binary.")
-			return
+			return exitCodeExtractionFailure
 		}
 
-		PayloadReader := attachments.Reader(common.PayloadFilename)
+		PayloadReader := attachmentReader(attachments, common.PayloadFilename)
 
 		if PayloadReader == nil {
 			fmt.Println("Error reading payload. Ensure it is embedded in the binary.")
-			return
+			return exitCodeExtractionFailure
 		}
 
 		// EXTRACT THE WHEELS ZIP FILE
 		wheelsReader := attachments.Reader(common.WheelsFilename)
 		if wheelsReader == nil {
 			fmt.Println("Error reading wheels. Ensure it is embedded in the binary.")
-			return
+			return exitCodeExtractionFailure
 		}
 
+		// extract everything to a staging directory first, so an interrupted install leaves nothing half written
+		common.RemoveIfExists(stagingDirectory)
+		defer common.RemoveIfExists(stagingDirectory)
+
+		stagedPython := filepath.Join(stagingDirectory, common.PythonFilename)
+		stagedPayload := filepath.Join(stagingDirectory, common.PayloadFilename)
+		extractOptions := common.ExtractOptions{RestoreAttributes: settings.PreserveAttributes, DiscardModTimes: settings.DiscardModTimes}
+		hashes := newStagedHashes()
+
+		// Ctrl+C stops extraction at the next chunk, and the staging directory is removed on the way out
+		extraction, stopExtraction := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stopExtraction()
+
 		// EXTRACT THE PYTHON ZIP FILE
-		err = common.DecompressIOStream(PythonReader, settings.PythonExtractDir)
+		span = tracer.Start("extract-python", setupSpan)
+		progress := common.NewProgressReader(PythonReader, attachments.Size(common.PythonFilename), "Python", os.Stdout)
+		extractOptions.Hashes = hashes.python
+		err = common.DecompressIOStreamContext(extraction, progress, stagedPython, settings.CompressionFormat, extractOptions)
+		progress.Finish(err)
+		span.SetError(err)
+		span.End()
 		if err != nil {
 			fmt.Println("Error extracting Python zip file:", err)
-			return
+			return extractionExitCode(err)
+		}
+
+		// EXTRACT THE WHEELS ZIP FILE
+		span = tracer.Start("extract-wheels", setupSpan)
+		progress = common.NewProgressReader(wheelsReader, attachments.Size(common.WheelsFilename), "Wheels", os.Stdout)
+		extractOptions.Hashes = hashes.wheels
+		err = common.DecompressIOStreamContext(extraction, progress, filepath.Join(stagedPython, common.WheelsFilename), settings.CompressionFormat, extractOptions)
+		progress.Finish(err)
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			fmt.Println("Error extracting wheels zip file:", err)
+			return extractionExitCode(err)
 		}
 
 		// EXTRACT THE PIPELINE ZIP FILE
-		err = common.DecompressIOStream(PayloadReader, "")
+		span = tracer.Start("extract-payload", setupSpan)
+		progress = common.NewProgressReader(PayloadReader, attachments.Size(common.PayloadFilename), "Payload", os.Stdout)
+		err = injectFault(faultDiskFull)
+		if err == nil {
+			extractOptions.Hashes = hashes.payload
+			err = common.DecompressIOStreamContext(extraction, progress, stagedPayload, settings.CompressionFormat, extractOptions)
+		}
+		progress.Finish(err)
+		span.SetError(err)
+		span.End()
 		if err != nil {
 			fmt.Println("Error extracting payload zip file:", err)
-			return
+			return extractionExitCode(err)
+		}
+
+		if err := verifyStagedFiles(hashes, stagedPython, stagedPayload); err != nil {
+			fmt.Println("Error verifying extracted files:", err)
+			return exitCodeExtractionFailure
+		}
+		stopExtraction()
+
+		span = tracer.Start("move-into-place", setupSpan)
+		err = common.ReplaceDirectory(stagedPython, settings.PythonExtractDir)
+		if err == nil {
+			err = injectFault(faultFailMove)
+		}
+		if err == nil {
+			err = common.MergeDirectory(stagedPayload, ".", payloadConflictResolver(settings, options), newBackupDir())
+		}
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			fmt.Println("Error moving extracted files into place:", err)
+			return exitCodeExtractionFailure
+		}
+
+		if err := pruneBackups(settings.BackupRetention); err != nil {
+			fmt.Println("Error removing old backups:", err)
 		}
 
 		wheelsDir := path.Join(settings.PythonExtractDir, common.WheelsFilename)
 
-		// EXTRACT THE WHEELS ZIP FILE
-		err = common.DecompressIOStream(wheelsReader, wheelsDir)
+		span = tracer.Start("prerequisites", setupSpan)
+		err = runPrerequisites(settings, state)
+		span.SetError(err)
+		span.End()
 		if err != nil {
-			fmt.Println("Error extracting wheels zip file:", err)
-			return
+			fmt.Println("Error running prerequisites:", err)
+			return exitCodePrerequisiteFailure
 		}
 
 		pythonPath := filepath.Join(settings.PythonExtractDir, "python.exe")
 
-		if err := common.RunCommand(pythonPath, []string{common.GetPipName(settings.PythonExtractDir), "install", "pip", "setuptools", "wheel"}); err != nil {
+		// wheels installed without pip, or already installed by a portable build, need no pip install of the
+		// requirements
+		installedWithoutPip := false
+
+		span = tracer.Start("install-pip", setupSpan)
+		if options.skipPip {
+			fmt.Println("Skipping package installation (--skip-pip).")
+		} else if settings.Portable {
+			fmt.Println("Packages were installed when the installer was built (portable).")
+			installedWithoutPip = true
+		} else if settings.DirectWheelInstall {
+			fmt.Println("Installing embedded wheels without pip (directWheelInstall).")
+			if err := installWheelsDirectly(settings); err != nil {
+				fmt.Println("Error installing wheels without pip:", err)
+				span.SetError(err)
+				return exitCodePipFailure
+			}
+			installedWithoutPip = true
+		} else if err := runPip(settings, "install", "pip", "setuptools", "wheel"); err != nil {
 			fmt.Println("Error building wheels:", err)
-			return
+			fmt.Println("Falling back to installing the embedded wheels without pip...")
+			if err := installWheelsDirectly(settings); err != nil {
+				fmt.Println("Error installing wheels without pip:", err)
+				span.SetError(err)
+				return exitCodePipFailure
+			}
+			installedWithoutPip = true
 		}
+		span.End()
 
 		// if requirements.txt exists, install the requirements
-		if _, err := os.Stat(settings.RequirementsFile); err == nil {
-			if err := common.RunCommand(pythonPath, []string{common.GetPipName(settings.PythonExtractDir), "install", "--find-links", path.Join(wheelsDir) + "/", "--only-binary=:all:", "-r", settings.RequirementsFile}); err != nil {
-				fmt.Println("Error while installing requirements from disk... Continuing...", err)
+		if _, err := os.Stat(settings.RequirementsFile); err == nil && !options.skipPip && !installedWithoutPip {
+			span = tracer.Start("install-requirements", setupSpan)
+			if err := runPip(settings, "install", "--find-links", path.Join(wheelsDir)+"/", "--only-binary=:all:", "-r", settings.RequirementsFile); err != nil {
+				fmt.Println("Error while installing requirements from disk, falling back to installing the embedded wheels without pip...", err)
+				if err := installWheelsDirectly(settings); err != nil {
+					fmt.Println("Error installing wheels without pip... Continuing...", err)
+				}
+				span.SetError(err)
 			}
+			span.End()
+		}
+
+		if err := writePipConfig(settings); err != nil {
+			fmt.Println("Error writing pip configuration:", err)
 		}
 
 		// run the setup.py file if configured
 
-		if settings.SetupScript != "" {
-			if err := common.RunCommand(pythonPath, []string{settings.SetupScript}); err != nil {
+		if settings.SetupScript != "" && options.skipSetupScript {
+			fmt.Println("Skipping setup script (--skip-setup-script).")
+		} else if settings.SetupScript != "" {
+			span = tracer.Start("setup-script", setupSpan)
+			err = common.RunCommand(pythonPath, []string{settings.SetupScript})
+			span.SetError(err)
+			span.End()
+			if err != nil {
 				fmt.Println("Error running "+settings.SetupScript+":", err)
-				return
+				return exitCodeSetupScriptFailure
+			}
+		}
+
+		if settings.PowerShellModule != "" {
+			if err := writePowerShellModule(settings); err != nil {
+				fmt.Println("Error writing PowerShell module:", err)
+				return exitCodeFailure
+			}
+		}
+
+		// save a marker to the current directory to indicate that the bootstrap has been run
+		if err := writeBootstrapMarker(exeHash); err != nil {
+			fmt.Println("Error saving bootstrap marker:", err)
+			return exitCodeFailure
+		}
+
+		if settings.HardenPermissions {
+			span = tracer.Start("harden-permissions", setupSpan)
+			err = hardenInstallation(attachments, settings)
+			span.SetError(err)
+			span.End()
+			if err != nil {
+				fmt.Println("Error hardening permissions of the installation:", err)
 			}
 		}
 
-		// save a text file to the current directory to indicate that the bootstrap has been run
-		if err := os.WriteFile("bootstrapped", []byte("Bootstrap has been run"), os.ModePerm); err != nil {
-			fmt.Println("Error saving bootstrap text file:", err)
-			return
+		setupSpan.End()
+		installed = true
+		report(eventInstallSucceeded, exeHash, "")
+
+		if installRoot != "" {
+			fmt.Println(productName(settings), "was installed to", installRoot)
 		}
 	}
 
+	if !options.skipIntegrity && (!state.AttachmentsVerified || state.ExecutableHash != exeHash) {
+		if state.ExecutableHash != "" && state.ExecutableHash != exeHash {
+			report(eventUpdateApplied, exeHash, "previous executable hash "+state.ExecutableHash)
+		}
+
+		state.ExecutableHash = exeHash
+		state.PayloadRoot = payloadRoot
+		state.AttachmentsVerified = true
+		state.HashChangePolicy = settings.HashChangePolicy
+		state.AdminTokenHash = settings.AdminTokenHash
+		state.TrustedSigners = settings.TrustedSigners
+
+		if executablePath, err := os.Executable(); err == nil {
+			if state.StubHash, err = hashStub(executablePath, common.HashAlgorithm); err != nil {
+				fmt.Println("Error hashing executable stub:", err)
+			}
+		}
+
+		if err := common.SaveState(common.StateFilename, state); err != nil {
+			fmt.Println("Error saving state:", err)
+		}
+	}
+
+	// a damaged standard library makes Python fail to start with errors that do not point at the cause
+	if !options.skipIntegrity {
+		if err := repairInteriorFiles(attachments, settings, needsSetup); err != nil {
+			fmt.Println("Error checking the Python standard library:", err)
+			return exitCodeIntegrityFailure
+		}
+	}
+
+	// a script update that cannot be applied leaves the installed scripts as they are
+	if err := applyScriptUpdate(attachments, settings, state, needsSetup, needsSetup); err != nil {
+		fmt.Println("Error applying script update:", err)
+	}
+
 	attachments.Close()
 
+	if options.prewarm {
+		pythonPath := filepath.Join(settings.PythonExtractDir, "python.exe")
+
+		// byte-compile everything now so the first interactive launch does not pay for it
+		if err := common.RunCommand(pythonPath, []string{"-m", "compileall", "-q", "."}); err != nil {
+			fmt.Println("Error byte-compiling installation:", err)
+			return exitCodeFailure
+		}
+
+		fmt.Println("Prewarm completed. Skipping script launch.")
+		return exitCodeSuccess
+	}
+
 	// run the payload script
 
 	fmt.Println("Running script...")
 
-	appendedArguments := append([]string{settings.MainScript}, os.Args[1:]...)
+	// configured defaults come first so arguments given on the command line can override them
+	appendedArguments := append([]string{settings.MainScript}, settings.MainScriptArgs...)
+	appendedArguments = append(appendedArguments, parameterArgs...)
+	appendedArguments = append(appendedArguments, payloadArgs...)
 
-	if err := common.RunCommand(filepath.Join(settings.PythonExtractDir, "python.exe"), appendedArguments); err != nil {
+	if err := exposeEmbeddedWheels(settings); err != nil {
+		fmt.Println("Error exposing embedded wheels:", err)
+	}
+
+	for name, value := range settings.Environment {
+		if err := os.Setenv(name, value); err != nil {
+			fmt.Println("Error setting environment variable", name+":", err)
+			return exitCodeFailure
+		}
+	}
+
+	pythonPath := filepath.Join(settings.PythonExtractDir, "python.exe")
+
+	span = tracer.Start("run-script", rootSpan)
+	span.SetAttribute("script", settings.MainScript)
+
+	if settings.UsageLog != "" {
+		err = runScriptWithUsageLog(pythonPath, appendedArguments, settings.UsageLog)
+	} else {
+		err = common.RunCommand(pythonPath, appendedArguments)
+	}
+
+	span.SetError(err)
+	span.End()
+
+	if err != nil {
 		fmt.Println("Error running Python script:", err)
-		return
+		return exitCodeScriptFailure
 	}
 
 	fmt.Println("Script completed.")
 	PressButtonToContinue("Press enter to exit")
 
+	return exitCodeSuccess
+}
+
+// runPip runs the bundled pip with args.
+func runPip(settings common.PythonSetupSettings, args ...string) error {
+	if err := injectFault(faultFailPip); err != nil {
+		return err
+	}
+
+	pythonPath := filepath.Join(settings.PythonExtractDir, "python.exe")
+	return common.RunCommand(pythonPath, append([]string{common.GetPipName(settings.PythonExtractDir)}, args...))
 }
 
-func ValidateExecutableHash() (exit bool) {
+// ValidateExecutableHash checks the executable against the hash accepted on an earlier run, applying the hash
+// change policy if it differs. accepted is set when a changed executable was accepted by this run. exitCode is
+// exitCodeSuccess unless bootstrap must stop, returning it so deferred cleanup still runs.
+func ValidateExecutableHash(policy hashPolicy, acceptedStubHash string, report eventReporter) (myHash string, accepted bool, exitCode int) {
 	executablePath, err := os.Executable()
 	if err != nil {
 		fmt.Println("Error getting executable path:", err)
-		return true
+		return "", false, exitCodeIntegrityFailure
 	}
-	myHash, err := common.Md5SumFile(executablePath)
+	myHash, err = common.HashFile(executablePath)
 
 	if err != nil {
 		fmt.Println("Error getting hash of executable:", err)
-		return true
+		return "", false, exitCodeIntegrityFailure
 	}
 
-	if common.DoesPathExist("hash.txt") {
+	if hashPath := acceptedHashFile(executablePath); hashPath != "" {
 		// read the hash from the file and compare it to the hash of the executable
-		fileHash, err := os.ReadFile("hash.txt")
+		fileHash, err := os.ReadFile(hashPath)
 		if err != nil {
 			fmt.Println("Error reading hash file:", err)
-			return true
+			return myHash, accepted, exitCodeIntegrityFailure
 		}
 
-		if strings.TrimSpace(string(fileHash)) != myHash {
-			fmt.Println("Error: Executable hash does not match previously accepted hash. File may have been tampered with.")
+		acceptedHash := strings.TrimSpace(string(fileHash))
 
-			fmt.Println("Expected:", string(fileHash))
-			fmt.Println("Actual:", myHash)
+		// hash.txt written by earlier releases holds an MD5 hash; it is compared as such and then replaced
+		if algorithm := common.HashAlgorithmOf(acceptedHash); algorithm != common.HashAlgorithm {
+			legacyHash, err := common.HashFileWith(algorithm, executablePath)
+			if err != nil {
+				fmt.Println("Error getting hash of executable:", err)
+				return myHash, accepted, exitCodeIntegrityFailure
+			}
 
-			fmt.Println("Please validate my Md5 hash with the one supplied by my distributor before continuing")
+			if legacyHash == acceptedHash {
+				acceptedHash = myHash
+				if err := common.SaveContentsToFile("hash.txt", myHash); err != nil {
+					fmt.Println("Error saving hash to file:", err)
+					return myHash, accepted, exitCodeIntegrityFailure
+				}
+			}
+		}
 
-			PressButtonToContinue("Press enter to accept the new hash and continue...")
+		if acceptedHash != myHash {
+			// the stub hash only tells what changed; the policy decides whether the change is accepted either way,
+			// since the embedded attachment hashes can be rewritten along with the attachments
+			stubHash, err := hashStub(executablePath, common.HashAlgorithmOf(acceptedStubHash))
+			if err == nil && acceptedStubHash != "" && stubHash == acceptedStubHash {
+				fmt.Println("Executable stub unchanged. Only the embedded attachments differ.")
+			} else {
+				fmt.Println("Error: Executable hash does not match previously accepted hash. File may have been tampered with.")
+			}
 
-			err = common.SaveContentsToFile("hash", myHash)
+			fmt.Println("Expected:", acceptedHash)
+			fmt.Println("Actual:", myHash)
+
+			if err := acceptChangedHash(policy, executablePath, myHash); err != nil {
+				fmt.Println("Error: New hash rejected:", err)
+				report(eventIntegrityFailure, myHash, "changed executable rejected: "+err.Error())
+				return myHash, accepted, exitCodeHashRejected
+			}
+			accepted = true
+
+			err = common.SaveContentsToFile("hash.txt", myHash)
 			if err != nil {
 				fmt.Println("Error saving hash to file:", err)
-				return true
+				return myHash, accepted, exitCodeIntegrityFailure
 			}
 
 		} else {
 			fmt.Println("Hashes match. File integrity validated.")
+
+			// keep the accepted hash with the installation when it was shipped next to the executable
+			if hashPath != "hash.txt" {
+				if err := common.SaveContentsToFile("hash.txt", myHash); err != nil {
+					fmt.Println("Error saving hash to file:", err)
+					return myHash, accepted, exitCodeIntegrityFailure
+				}
+			}
 		}
 
 	} else {
 
-		fmt.Println("Please validate my Md5 hash with the one supplied by my distributor before continuing")
-		fmt.Println("While the hash is not a guarantee of safety, it is a good indicator of file integrity.")
-		fmt.Println("You can validate my hash by running the following command in the command line:")
-		fmt.Println("certutil -hashfile", os.Args[0], "MD5")
-		fmt.Println("It should also match my self-reported hash:", myHash)
-		fmt.Println("")
-		fmt.Println("Note: If three hash values do not match, the file may have been tampered with.")
+		printHashInstructions(executablePath, myHash)
 
 		PressButtonToContinue("Press enter to continue...")
 
-		err = common.SaveContentsToFile("hash", myHash)
+		err = common.SaveContentsToFile("hash.txt", myHash)
 		if err != nil {
 			fmt.Println("Error saving hash to file:", err)
-			return true
+			return myHash, accepted, exitCodeIntegrityFailure
 		}
 	}
-	return false
+	return myHash, accepted, exitCodeSuccess
+}
+
+func writeBootstrapMarker(exeHash string) error {
+	executablePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(executablePath)
+	if err != nil {
+		return err
+	}
+
+	return common.WriteBootstrapMarker(common.BootstrapMarkerFilename, &common.BootstrapMarker{
+		Algorithm:      common.HashAlgorithm,
+		ExecutableHash: exeHash,
+		ExecutableSize: info.Size(),
+		Timestamp:      time.Now().UTC(),
+		ToolVersion:    common.Version,
+	})
+}
+
+func acquireInstallLock(settings common.PythonSetupSettings) (*common.InstallLock, error) {
+	timeout := time.Duration(settings.InstallLockTimeout) * time.Second
+
+	installLock, err := common.AcquireInstallLock(productName(settings), 0)
+	if errors.Is(err, common.ErrLocked) && timeout > 0 {
+		fmt.Println("Waiting for another installation of", productName(settings), "to finish...")
+		installLock, err = common.AcquireInstallLock(productName(settings), timeout)
+	}
+
+	return installLock, err
+}
+
+// productName identifies the product for machine-wide resources, falling back to the executable name.
+func productName(settings common.PythonSetupSettings) string {
+	if settings.ProductName != "" {
+		return settings.ProductName
+	}
+
+	return strings.TrimSuffix(filepath.Base(os.Args[0]), filepath.Ext(os.Args[0]))
 }
 
 func PressButtonToContinue(continueMessage string) {
@@ -248,42 +797,135 @@ func GetSettings(attachments *ember.Attachments) (common.PythonSetupSettings, er
 		return common.PythonSetupSettings{}, fmt.Errorf("error reading config. Ensure it is embedded in the binary")
 	}
 	config, err := io.ReadAll(ConfigReader)
+	if err != nil {
+		return common.PythonSetupSettings{}, err
+	}
+
+	return common.DecodeSettings(config)
+}
+
+// CheckAttachmentCompatibility verifies that every attachment described in the metadata can be unpacked by this build.
+// Installers without metadata predate it and are assumed compatible.
+func CheckAttachmentCompatibility(attachments *ember.Attachments) error {
+	metadataReader := attachments.Reader(common.MetadataEmbedName)
+	if metadataReader == nil {
+		return nil
+	}
+
+	metadata, err := common.ReadMetadata(metadataReader)
+	if err != nil {
+		return err
+	}
+
+	for name, attachmentMetadata := range metadata {
+		if err := attachmentMetadata.CheckCompatibility(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
 
-	var settings common.PythonSetupSettings
-	err = json.Unmarshal(config, &settings)
-	return settings, err
+// extractionExitCode returns the exit code for extraction that failed with err, which is cancelled when it was
+// interrupted.
+func extractionExitCode(err error) int {
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("Installation cancelled. Nothing was installed.")
+		return exitCodeInterrupted
+	}
+
+	return exitCodeExtractionFailure
+}
+
+// checkInstallSpace checks that the installation directory can hold the extracted attachments.
+// Installers without metadata do not record their uncompressed sizes and are not checked.
+func checkInstallSpace(attachments *ember.Attachments) error {
+	metadataReader := attachments.Reader(common.MetadataEmbedName)
+	if metadataReader == nil {
+		return nil
+	}
+
+	metadata, err := common.ReadMetadata(metadataReader)
+	if err != nil {
+		return err
+	}
+
+	var required int64
+	for _, name := range []string{common.PythonFilename, common.PayloadFilename, common.WheelsFilename} {
+		required += metadata[name].UncompressedSize
+	}
+
+	return common.CheckFreeSpace(".", required)
 }
 
-func GetHashmap(attachments *ember.Attachments) (map[string]string, error) {
+func GetHashmap(attachments *ember.Attachments) (common.HashManifest, error) {
 	HashReader := attachments.Reader(common.HashesEmbedName)
 	if HashReader == nil {
 		fmt.Println("Error reading hash. Ensure it is embedded in the binary.")
 
 		// throw a new error to prevent further execution
-		return nil, fmt.Errorf("error reading hash. Ensure it is embedded in the binary")
+		return common.HashManifest{}, fmt.Errorf("error reading hash. Ensure it is embedded in the binary")
 	}
 
 	hash, err := io.ReadAll(HashReader)
 
 	if err != nil {
 		fmt.Println("Error reading hash:", err)
-		return nil, err
+		return common.HashManifest{}, err
 	}
 
-	var hashMap map[string]string
-
-	err = json.Unmarshal(hash, &hashMap)
+	// only installers without metadata predate recording the hash algorithm
+	legacy := attachments.Reader(common.MetadataEmbedName) == nil
+	manifest, err := common.ReadHashManifest(hash, legacy)
 
 	if err != nil {
 		fmt.Println("Error unmarshalling hash:", err)
-		return nil, err
+		return manifest, err
+	}
+
+	return manifest, nil
+}
+
+// ValidatePayloadRoot hashes the embedded attachment hashes, which in turn cover every attachment, and compares
+// them with the root recorded in the state store when the executable was last accepted. A different root means the
+// attachments and their hashes were rewritten although hash.txt still matches, and the hash change policy decides
+// whether to continue, unless accepted is set because the policy has already accepted this executable.
+func ValidatePayloadRoot(attachments *ember.Attachments, policy hashPolicy, acceptedRoot string, accepted bool, exeHash string, report eventReporter) (root string, exitCode int) {
+	reader := attachments.Reader(common.HashesEmbedName)
+	if reader == nil {
+		fmt.Println("Error reading hash. Ensure it is embedded in the binary.")
+		return "", exitCodeIntegrityFailure
+	}
+
+	root, err := common.HashReadSeekerWith(common.HashAlgorithm, reader)
+	if err != nil {
+		fmt.Println("Error reading hash:", err)
+		return "", exitCodeIntegrityFailure
+	}
+
+	if accepted || acceptedRoot == "" || acceptedRoot == root {
+		return root, exitCodeSuccess
+	}
+
+	fmt.Println("Error: Embedded attachment hashes do not match the ones previously accepted. File may have been tampered with.")
+
+	executablePath, err := os.Executable()
+	if err != nil {
+		fmt.Println("Error getting executable path:", err)
+		return root, exitCodeIntegrityFailure
+	}
+
+	if err := acceptChangedHash(policy, executablePath, exeHash); err != nil {
+		fmt.Println("Error: New hash rejected:", err)
+		report(eventIntegrityFailure, exeHash, "changed attachments rejected: "+err.Error())
+		return root, exitCodeHashRejected
 	}
 
-	return hashMap, nil
+	return root, exitCodeSuccess
 }
 
-func ValidateHash(seeker io.ReadSeeker, expectedHash string) (actualHash string, equal bool) {
-	actualHash, err := common.HashReadSeeker(seeker)
+func ValidateHash(seeker io.ReadSeeker, algorithm, expectedHash string) (actualHash string, equal bool) {
+	actualHash, err := common.HashReadSeekerWith(algorithm, seeker)
 	if err != nil {
 		fmt.Println("Error reading hash:", err)
 		return "", false
@@ -300,7 +942,7 @@ func ValidateHashes(attachments *ember.Attachments) bool {
 
 	attachmentList := attachments.List()
 
-	hashMap, err := GetHashmap(attachments)
+	manifest, err := GetHashmap(attachments)
 	if err != nil {
 		return false
 	}
@@ -312,20 +954,20 @@ func ValidateHashes(attachments *ember.Attachments) bool {
 			continue
 		}
 
-		attachmentReader := attachments.Reader(attachment)
+		reader := attachmentReader(attachments, attachment)
 
-		if attachmentReader == nil {
+		if reader == nil {
 			fmt.Println("Error reading attachment:", attachment)
 			return false
 		}
 
-		actualHash, hashesMatch := ValidateHash(attachmentReader, hashMap[attachment])
+		actualHash, hashesMatch := ValidateHash(reader, manifest.Algorithm, manifest.Hashes[attachment])
 
 		if !hashesMatch {
-			fmt.Println("Error validating hash for:", attachment, " -> Expected:", hashMap[attachment], "Actual:", actualHash)
+			fmt.Println("Error validating hash for:", attachment, " -> Expected:", manifest.Hashes[attachment], "Actual:", actualHash)
 			allHashesMatch = false
 		} else {
-			fmt.Println("Hash validated for:", attachment, " -> Expected:", hashMap[attachment], "Actual:", actualHash)
+			fmt.Println("Hash validated for:", attachment, " -> Expected:", manifest.Hashes[attachment], "Actual:", actualHash)
 		}
 	}
 
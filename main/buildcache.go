@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"lukasolson.net/common"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cachedMetadataFilename holds the attachment metadata and hashes of a build cache entry, next to the python and
+// wheels archives. Each entry is a directory, or an S3 prefix, named after its key. The metadata is stored last,
+// so an entry without it is incomplete.
+const cachedMetadataFilename = "metadata.json"
+
+// buildCacheLockTimeout is how long a build waits for another build preparing the same entry to finish.
+const buildCacheLockTimeout = 30 * time.Minute
+
+// cachedEntry is the metadata file of a build cache entry.
+type cachedEntry struct {
+	Metadata map[string]common.AttachmentMetadata `json:"metadata"`
+	// Hashes of the python and wheels archives, checked when the entry is loaded, so a damaged or partly
+	// overwritten entry on a shared cache is rebuilt instead of embedded.
+	Hashes map[string]string `json:"hashes"`
+}
+
+// buildCacheStore holds the files of build cache entries: a local directory, a network share, or an S3 bucket.
+type buildCacheStore interface {
+	// get opens the named file of an entry. It returns an error wrapping fs.ErrNotExist if there is no such file.
+	get(key, name string) (io.ReadCloser, error)
+	// put stores the named file of an entry, replacing it as a whole.
+	put(key, name string, contents io.Reader, size int64) error
+	// lock stops other builds from preparing the entry until the returned function is called.
+	lock(key string) (func(), error)
+}
+
+// openBuildCache returns the store for buildCacheDir: an S3 bucket for s3://bucket/prefix locations, and a
+// directory, which can be on a network share, for anything else.
+func openBuildCache(location string) (buildCacheStore, error) {
+	if strings.HasPrefix(location, "s3://") {
+		return newS3CacheStore(location)
+	}
+
+	return dirCacheStore{dir: location}, nil
+}
+
+// buildCacheInputs are the settings that determine the Python and wheels archives produced by PreparePython.
+type buildCacheInputs struct {
+	Version            string   `json:"version"`
+	PythonDownloadURL  string   `json:"pythonDownloadURL"`
+	PipDownloadURL     string   `json:"pipDownloadURL"`
+	PthFile            string   `json:"pthFile"`
+	PythonInteriorZip  string   `json:"pythonInteriorZip"`
+	RuntimeComponents  []string `json:"runtimeComponents"`
+	ComponentSourceDir string   `json:"componentSourceDir"`
+	CompressionFormat  string   `json:"compressionFormat"`
+	StoreCompressed    bool     `json:"storeCompressed"`
+	PrebuiltWheels     bool     `json:"prebuiltWheels"`
+	Portable           bool     `json:"portable"`
+	RequirementsHash   string   `json:"requirementsHash"`
+}
+
+// buildCacheKey returns the key of the cache entry for settings: the hash of the Python download, pip, layout and
+// compression settings and of the contents of the requirements file.
+func buildCacheKey(settings common.PythonSetupSettings) (string, error) {
+	_, prebuiltWheels := settings.AttachmentSources[common.WheelsFilename]
+
+	inputs := buildCacheInputs{
+		Version:            common.Version,
+		PythonDownloadURL:  settings.PythonDownloadURL,
+		PipDownloadURL:     settings.PipDownloadURL,
+		PthFile:            settings.PthFile,
+		PythonInteriorZip:  settings.PythonInteriorZip,
+		RuntimeComponents:  settings.RuntimeComponents,
+		ComponentSourceDir: settings.ComponentSourceDir,
+		CompressionFormat:  common.CompressionFormatName(settings.CompressionFormat),
+		StoreCompressed:    settings.StoreCompressedFiles,
+		PrebuiltWheels:     prebuiltWheels,
+		Portable:           settings.Portable,
+	}
+
+	if settings.RequirementsFile != "" {
+		requirementsHash, err := common.HashFile(filepath.Join(settings.ScriptDir, settings.RequirementsFile))
+		if err != nil {
+			return "", err
+		}
+		inputs.RequirementsHash = requirementsHash
+	}
+
+	data, err := json.Marshal(inputs)
+	if err != nil {
+		return "", err
+	}
+
+	return common.HashReader(bytes.NewReader(data))
+}
+
+// loadBuildCache opens the cached Python and wheels archives stored under key, after checking them against the
+// hashes recorded with them. ok is false if there is no complete, intact entry.
+func loadBuildCache(store buildCacheStore, key string) (python, wheels io.ReadSeeker, metadata map[string]common.AttachmentMetadata, ok bool) {
+	reader, err := store.get(key, cachedMetadataFilename)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			fmt.Println("Error reading build cache entry:", err)
+		}
+		return nil, nil, nil, false
+	}
+
+	var entry cachedEntry
+	err = json.NewDecoder(reader).Decode(&entry)
+	reader.Close()
+	if err != nil {
+		fmt.Println("Ignoring unreadable build cache entry:", err)
+		return nil, nil, nil, false
+	}
+
+	archives := make(map[string]*spooledFile)
+	for _, name := range []string{common.PythonFilename, common.WheelsFilename} {
+		archive, err := fetchCachedArchive(store, key, name, entry.Hashes[name])
+		if err != nil {
+			fmt.Println("Ignoring build cache entry:", err)
+			for _, fetched := range archives {
+				fetched.Close()
+			}
+			return nil, nil, nil, false
+		}
+		archives[name] = archive
+	}
+
+	return archives[common.PythonFilename], archives[common.WheelsFilename], entry.Metadata, true
+}
+
+// fetchCachedArchive copies the named archive of an entry to a temporary file, checking it against
+// expectedHash on the way.
+func fetchCachedArchive(store buildCacheStore, key, name, expectedHash string) (*spooledFile, error) {
+	if expectedHash == "" {
+		return nil, fmt.Errorf("%s has no recorded hash", name)
+	}
+
+	reader, err := store.get(key, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return spoolVerified(reader, name, common.HashAlgorithmOf(expectedHash), expectedHash)
+}
+
+// storeBuildCache copies the Python and wheels archives into the cache under key, followed by their metadata and
+// hashes, so concurrent builds never load a partial entry.
+func storeBuildCache(store buildCacheStore, key string, python, wheels io.ReadSeeker, metadata map[string]common.AttachmentMetadata) error {
+	entry := cachedEntry{Metadata: metadata, Hashes: make(map[string]string)}
+
+	archives := map[string]io.ReadSeeker{
+		common.PythonFilename: python,
+		common.WheelsFilename: wheels,
+	}
+
+	for name, archive := range archives {
+		hash, err := putArchive(store, key, name, archive)
+		if err != nil {
+			return err
+		}
+		entry.Hashes[name] = hash
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return store.put(key, cachedMetadataFilename, bytes.NewReader(data), int64(len(data)))
+}
+
+// putArchive stores archive from its start, rewinds it for embedding, and returns its hash.
+func putArchive(store buildCacheStore, key, name string, archive io.ReadSeeker) (string, error) {
+	size, err := archive.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	hash, err := common.HashReadSeeker(archive)
+	if err != nil {
+		return "", err
+	}
+
+	if err := store.put(key, name, archive, size); err != nil {
+		return "", err
+	}
+
+	_, err = archive.Seek(0, io.SeekStart)
+	return hash, err
+}
+
+// dirCacheStore keeps build cache entries as directories below dir.
+type dirCacheStore struct {
+	dir string
+}
+
+func (store dirCacheStore) get(key, name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(store.dir, key, name))
+}
+
+// put writes the file next to its destination and renames it into place, so readers never see a partial file.
+func (store dirCacheStore) put(key, name string, contents io.Reader, size int64) error {
+	entryDir := filepath.Join(store.dir, key)
+	if err := os.MkdirAll(entryDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(entryDir, name+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, contents)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), filepath.Join(entryDir, name))
+}
+
+func (store dirCacheStore) lock(key string) (func(), error) {
+	if err := os.MkdirAll(store.dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	lock, err := common.AcquireFileLock(filepath.Join(store.dir, key+".lock"), buildCacheLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { lock.Release() }, nil
+}
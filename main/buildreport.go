@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"lukasolson.net/common"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// attachmentSizes returns the size of every attachment, leaving each positioned at its start.
+func attachmentSizes(attachments map[string]io.ReadSeeker) (map[string]int64, error) {
+	sizes := make(map[string]int64, len(attachments))
+
+	for name, attachment := range attachments {
+		size, err := attachment.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := attachment.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		sizes[name] = size
+	}
+
+	return sizes, nil
+}
+
+// writeBuildReport describes the build of the installer at exePath, which started at start, and writes the
+// report next to it.
+func writeBuildReport(exePath string, start time.Time, sizes map[string]int64, metadata map[string]common.AttachmentMetadata, fileCounts map[string]int) (common.BuildReport, error) {
+	report := common.BuildReport{
+		Installer:   filepath.Base(exePath),
+		Attachments: make(map[string]common.AttachmentReport, len(sizes)),
+		CreatedAt:   time.Now().UTC(),
+		ToolVersion: common.Version,
+	}
+
+	info, err := os.Stat(exePath)
+	if err != nil {
+		return report, err
+	}
+	report.InstallerSize = info.Size()
+
+	for name, size := range sizes {
+		report.Attachments[name] = common.AttachmentReport{
+			Size:             size,
+			UncompressedSize: metadata[name].UncompressedSize,
+			Files:            fileCounts[name],
+		}
+	}
+
+	report.DurationSeconds = time.Since(start).Seconds()
+
+	return report, common.SaveBuildReport(filepath.Join(filepath.Dir(exePath), common.BuildReportFilename), report)
+}
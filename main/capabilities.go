@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"lukasolson.net/common"
+	"os"
+	"strings"
+)
+
+// confirmCapabilities shows the capabilities declared in settings and asks for them to be accepted before first time
+// setup. Unattended runs with --prewarm or --accept-capabilities accept them without asking. It returns the accepted
+// capabilities, and false if they were declined.
+func confirmCapabilities(settings common.PythonSetupSettings, options bootstrapOptions) ([]string, bool) {
+	if settings.Capabilities == nil {
+		return nil, true
+	}
+
+	descriptions := settings.Capabilities.Descriptions()
+	if len(descriptions) == 0 {
+		return nil, true
+	}
+
+	fmt.Println(productName(settings), "declares that it will:")
+	for _, description := range descriptions {
+		fmt.Println("  -", description)
+	}
+
+	if options.acceptCapabilities || options.prewarm {
+		fmt.Println("Capabilities accepted without prompting.")
+		return descriptions, true
+	}
+
+	fmt.Print("Continue with the installation? [y/N] ")
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Println()
+		return nil, false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return descriptions, true
+	}
+
+	return nil, false
+}
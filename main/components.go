@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"lukasolson.net/common"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runtimeComponent lists the files, relative to a full Python installation, that make up an optional part of the
+// standard library missing from the embeddable distribution, using forward slashes. They keep their relative layout when copied.
+type runtimeComponent struct {
+	paths     []string
+	smokeTest string
+}
+
+var runtimeComponents = map[string]runtimeComponent{
+	"tkinter": {
+		paths:     []string{"Lib/tkinter", "DLLs/_tkinter.pyd", "DLLs/tcl86t.dll", "DLLs/tk86t.dll", "tcl"},
+		smokeTest: "import tkinter; tkinter.Tcl()",
+	},
+	"venv": {
+		paths:     []string{"Lib/venv", "Lib/ensurepip"},
+		smokeTest: "import venv, ensurepip",
+	},
+}
+
+// addRuntimeComponents copies the configured components from a full Python installation of the same version into
+// the embedded runtime, then checks that each one imports.
+func addRuntimeComponents(settings *common.PythonSetupSettings) error {
+	if len(settings.RuntimeComponents) == 0 {
+		return nil
+	}
+
+	if err := checkComponentSource(settings); err != nil {
+		return err
+	}
+
+	for _, name := range settings.RuntimeComponents {
+		component, ok := runtimeComponents[name]
+		if !ok {
+			return fmt.Errorf("unknown runtime component %q", name)
+		}
+
+		fmt.Println("Adding runtime component:", name)
+
+		for _, componentPath := range component.paths {
+			source := filepath.Join(settings.ComponentSourceDir, filepath.FromSlash(componentPath))
+			destination := filepath.Join(settings.PythonExtractDir, embeddedComponentPath(componentPath))
+
+			if err := copyTree(source, destination); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+
+	pythonPath := filepath.Join(settings.PythonExtractDir, "python.exe")
+
+	for _, name := range settings.RuntimeComponents {
+		if err := common.RunCommand(pythonPath, []string{"-c", runtimeComponents[name].smokeTest}); err != nil {
+			return fmt.Errorf("%s failed its import test: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// embeddedComponentPath maps a path in a full installation to the embedded layout, where the standard library
+// from Lib lives at the root because the interior zip is extracted there.
+func embeddedComponentPath(componentPath string) string {
+	if rest, ok := strings.CutPrefix(componentPath, "Lib/"); ok {
+		return filepath.FromSlash(rest)
+	}
+
+	return filepath.FromSlash(componentPath)
+}
+
+// checkComponentSource makes sure the source installation is the same Python version as the embedded runtime,
+// using the version-specific DLL named like the ._pth file.
+func checkComponentSource(settings *common.PythonSetupSettings) error {
+	if settings.ComponentSourceDir == "" {
+		return errors.New("componentSourceDir must point to a full Python installation to add runtime components")
+	}
+
+	versionDLL := strings.TrimSuffix(settings.PthFile, "._pth") + ".dll"
+	if !common.DoesPathExist(filepath.Join(settings.ComponentSourceDir, versionDLL)) {
+		return fmt.Errorf("%s does not look like a Python installation matching %s (missing %s)", settings.ComponentSourceDir, settings.PthFile, versionDLL)
+	}
+
+	return nil
+}
+
+// copyTree copies a file, or a directory and everything below it.
+func copyTree(source, destination string) error {
+	return filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(destination, relativePath)
+
+		if d.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+
+		return common.CopyFile(path, target)
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"lukasolson.net/common"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const backupsDirectory = "backups"
+
+// newBackupDir returns a timestamped directory for files replaced during this run. It is only created when used.
+func newBackupDir() string {
+	return filepath.Join(backupsDirectory, time.Now().Format("20060102-150405"))
+}
+
+// payloadConflictResolver applies the configured conflict policy to payload files modified since installation.
+// Prompting falls back to backing up when there is nobody to answer, such as during --prewarm.
+func payloadConflictResolver(settings common.PythonSetupSettings, options bootstrapOptions) common.ConflictResolver {
+	policy := settings.ConflictPolicy
+	if policy == "" {
+		policy = common.ConflictPolicyPrompt
+	}
+
+	if policy == common.ConflictPolicyPrompt && options.prewarm {
+		policy = common.ConflictPolicyBackup
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+
+	return func(path string) common.ConflictAction {
+		switch policy {
+		case common.ConflictPolicyKeep:
+			return common.ConflictKeep
+		case common.ConflictPolicyOverwrite:
+			return common.ConflictOverwrite
+		case common.ConflictPolicyBackup:
+			return common.ConflictBackup
+		}
+
+		fmt.Println("File has been modified since installation:", path)
+		fmt.Print("[k]eep it, [o]verwrite it, or [b]ack it up and overwrite (default: b)? ")
+
+		answer, err := reader.ReadString('\n')
+		if err != nil {
+			fmt.Println()
+			return common.ConflictBackup
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "k", "keep":
+			return common.ConflictKeep
+		case "o", "overwrite":
+			return common.ConflictOverwrite
+		default:
+			return common.ConflictBackup
+		}
+	}
+}
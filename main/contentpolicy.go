@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"lukasolson.net/common"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// configExtensions are the file types checked for absolute paths, which only work on the machine that built the
+// installer.
+var configExtensions = []string{".json", ".ini", ".cfg", ".conf", ".toml", ".yaml", ".yml", ".properties", ".xml"}
+
+// absolutePathPattern matches Windows drive and UNC paths and paths into Unix home directories, at the start of
+// a line, a quoted string or a list item, or after an assignment. Backslashes may be escaped, as in JSON.
+var absolutePathPattern = regexp.MustCompile(`(?i)(?:^|[\s"'=:,\[(])([a-z]:[\\/][^\s"',\]]*|(?:\\\\){1,2}[^\s"'\\]+\\{1,2}[^\s"',\]]*|/(?:home|Users|root)/[^\s"',\]]*)`)
+
+// contentViolation is a file that breaks a rule of the content policy.
+type contentViolation struct {
+	path   string
+	reason string
+}
+
+// contentPolicy returns the content policy of settings, or the default policy if none is set.
+func contentPolicy(settings common.PythonSetupSettings) common.ContentPolicy {
+	if settings.ContentPolicy != nil {
+		return *settings.ContentPolicy
+	}
+	return common.DefaultContentPolicy
+}
+
+// checkContentPolicy walks dir, leaving out the entries options excludes, and returns every file that breaks
+// policy. A denied directory is reported once and not walked.
+func checkContentPolicy(dir string, policy common.ContentPolicy, options common.ArchiveOptions) ([]contentViolation, error) {
+	var violations []contentViolation
+
+	err := filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if filePath == dir {
+			return nil
+		}
+
+		if slices.Contains(options.Exclude, filePath) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+
+		if pattern := matchingPattern(policy.Deny, relativePath); pattern != "" && matchingPattern(policy.Allow, relativePath) == "" {
+			violations = append(violations, contentViolation{path: filePath, reason: "matches denied pattern " + pattern})
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		if policy.MaxFileSizeMB > 0 {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			if info.Size() > policy.MaxFileSizeMB*1024*1024 {
+				violations = append(violations, contentViolation{path: filePath,
+					reason: fmt.Sprintf("is %s, over the limit of %d MB", common.FormatBytes(info.Size()), policy.MaxFileSizeMB)})
+			}
+		}
+
+		if !policy.AllowAbsolutePaths && slices.Contains(configExtensions, strings.ToLower(filepath.Ext(filePath))) {
+			line, found, err := findAbsolutePath(filePath)
+			if err != nil {
+				return err
+			}
+
+			if found != "" {
+				violations = append(violations, contentViolation{path: filePath,
+					reason: fmt.Sprintf("contains the absolute path %s on line %d", found, line)})
+			}
+		}
+
+		return nil
+	})
+
+	return violations, err
+}
+
+// matchingPattern returns the first of patterns that matches the name or the whole of relativePath, or "" if
+// none does.
+func matchingPattern(patterns []string, relativePath string) string {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, path.Base(relativePath)); matched {
+			return pattern
+		}
+		if matched, _ := path.Match(pattern, relativePath); matched {
+			return pattern
+		}
+	}
+
+	return ""
+}
+
+// findAbsolutePath returns the first absolute path in the file at filePath and its line number, or "" if
+// there is none. Lines are read whole, however long, since minified JSON is often a single line.
+func findAbsolutePath(filePath string) (int, string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	for line := 1; ; line++ {
+		text, err := reader.ReadString('\n')
+		if match := absolutePathPattern.FindStringSubmatch(text); match != nil {
+			return line, match[1], nil
+		}
+
+		if err == io.EOF {
+			return 0, "", nil
+		}
+		if err != nil {
+			return 0, "", err
+		}
+	}
+}
+
+// enforceContentPolicy checks dir against the content policy of settings, prints every violation and fails if
+// there are any.
+func enforceContentPolicy(dir string, settings common.PythonSetupSettings, options common.ArchiveOptions) error {
+	violations, err := checkContentPolicy(dir, contentPolicy(settings), options)
+	if err != nil {
+		return err
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	for _, violation := range violations {
+		fmt.Println("Content policy violation:", violation.path, violation.reason)
+	}
+
+	return fmt.Errorf("%d files in %s break the content policy; remove them or adjust contentPolicy", len(violations), dir)
+}
@@ -3,23 +3,72 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/maja42/ember/embedding"
 	"io"
 	"lukasolson.net/common"
 	"os"
 	"path"
+	"path/filepath"
+	"time"
 )
 
-const settingsFileName = "settings.json"
+const (
+	settingsFileName = "settings.json"
+	outputFileName   = "bootstrap.exe"
+)
+
+func createInstaller(args []string) {
+
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	allowUnverifiedPython := flags.Bool("allow-unverified-python", false, "build with a Python version that is not in the support matrix")
+	settingsPath := flags.String("settings", settingsFileName, "settings file to build from")
+	outputPath := flags.String("output", outputFileName, "path of the installer to create")
+	showQR := flags.Bool("qr", false, "print the installer identity as a QR code and save it as a PNG next to the installer")
+	noCache := flags.Bool("no-cache", false, "prepare Python and wheels even if the build cache has them, and refresh the cache")
+	_ = flags.Parse(args)
 
-func createInstaller() {
+	start := time.Now()
+
+	tracer := common.NewTracerFromEnv("exepy-creator")
+	defer func() {
+		if err := tracer.Flush(); err != nil {
+			println("Error writing trace: ", err.Error())
+		}
+	}()
 
-	settings, err := common.LoadOrSaveDefault(settingsFileName)
+	rootSpan := tracer.Start("build", nil)
+	defer rootSpan.End()
+
+	settings, err := common.LoadOrSaveDefault(*settingsPath)
 	if err != nil {
 		return
 	}
 
+	if settings.PythonChecksum != "" {
+		if err := common.CheckSHA256Checksum(settings.PythonChecksum); err != nil {
+			println("Invalid pythonChecksum: ", err.Error())
+			return
+		}
+	}
+
+	if err := resolvePythonVersion(settings, *allowUnverifiedPython); err != nil {
+		println("Invalid Python version: ", err.Error())
+		return
+	}
+
+	if err := checkPythonSupport(settings, *allowUnverifiedPython); err != nil {
+		println("Unsupported Python configuration: ", err.Error())
+		return
+	}
+
+	if err := common.CheckPythonExtractDir(settings.PythonExtractDir); err != nil {
+		println("Invalid settings: ", err.Error())
+		return
+	}
+
 	pythonScriptPath := path.Join(settings.ScriptDir, settings.MainScript)
 	requirementsPath := path.Join(settings.ScriptDir, settings.RequirementsFile)
 
@@ -35,6 +84,126 @@ func createInstaller() {
 		return
 	}
 
+	// if a recovery script is configured, check that it exists
+	if settings.RecoveryScriptDir != "" {
+		recoveryScriptPath := path.Join(settings.RecoveryScriptDir, settings.RecoveryScript)
+		if settings.RecoveryScript == "" || !common.DoesPathExist(recoveryScriptPath) {
+			println("Recovery script is listed in config but does not exist: ", recoveryScriptPath)
+			return
+		}
+	}
+
+	if err := validateHashPolicy(settings); err != nil {
+		println("Invalid hash change policy: ", err.Error())
+		return
+	}
+
+	switch settings.ConflictPolicy {
+	case "", common.ConflictPolicyPrompt, common.ConflictPolicyKeep, common.ConflictPolicyOverwrite, common.ConflictPolicyBackup:
+	default:
+		println("Unknown conflict policy: ", settings.ConflictPolicy)
+		return
+	}
+
+	if err := validateUnsupportedEntryPolicy(settings.UnsupportedEntryPolicy); err != nil {
+		println("Invalid settings: ", err.Error())
+		return
+	}
+
+	// find links and unreadable files now rather than after Python has been prepared
+	payloadOptions, err := preflightDirectory(settings.ScriptDir, settings.UnsupportedEntryPolicy)
+	if err != nil {
+		println("Error checking scripts directory: ", err.Error())
+		return
+	}
+
+	if err := enforceContentPolicy(settings.ScriptDir, *settings, payloadOptions); err != nil {
+		println("Error checking scripts directory: ", err.Error())
+		return
+	}
+
+	var recoveryOptions common.ArchiveOptions
+	if settings.RecoveryScriptDir != "" {
+		recoveryOptions, err = preflightDirectory(settings.RecoveryScriptDir, settings.UnsupportedEntryPolicy)
+		if err != nil {
+			println("Error checking recovery scripts directory: ", err.Error())
+			return
+		}
+
+		if err := enforceContentPolicy(settings.RecoveryScriptDir, *settings, recoveryOptions); err != nil {
+			println("Error checking recovery scripts directory: ", err.Error())
+			return
+		}
+	}
+
+	if err := common.CheckCompressionFormat(settings.CompressionFormat); err != nil {
+		println("Invalid compression format: ", err.Error())
+		return
+	}
+
+	resources, err := executableResources(settings, *outputPath)
+	if err != nil {
+		println("Invalid icon or version settings: ", err.Error())
+		return
+	}
+
+	if settings.LicenseFile != "" && !common.DoesPathExist(settings.LicenseFile) {
+		println("License file does not exist: ", settings.LicenseFile)
+		return
+	}
+
+	if err := validateTrustStore(settings); err != nil {
+		println("Invalid trust store: ", err.Error())
+		return
+	}
+
+	if err := validateAntivirusCheck(settings.AntivirusCheck); err != nil {
+		println("Invalid antivirus check: ", err.Error())
+		return
+	}
+
+	if err := validateNotifications(settings); err != nil {
+		println("Invalid notifications: ", err.Error())
+		return
+	}
+
+	if err := validateParameters(settings); err != nil {
+		println("Invalid parameters: ", err.Error())
+		return
+	}
+
+	if err := validatePlugins(settings); err != nil {
+		println("Invalid plugins: ", err.Error())
+		return
+	}
+
+	if err := validatePowerShellModule(*settings); err != nil {
+		println("Invalid PowerShell module: ", err.Error())
+		return
+	}
+
+	// check that every prerequisite installer is part of the payload
+	for _, prerequisite := range settings.Prerequisites {
+		prerequisitePath := path.Join(settings.ScriptDir, prerequisite.Path)
+		if !common.DoesPathExist(prerequisitePath) {
+			println("Prerequisite installer does not exist: ", prerequisitePath)
+			return
+		}
+	}
+
+	for name := range settings.AttachmentSources {
+		if name != common.PythonFilename && name != common.WheelsFilename {
+			println("Attachment sources are only supported for python and wheels, not: ", name)
+			return
+		}
+	}
+
+	// a portable build installs the requirements into the Python it prepares itself
+	if settings.Portable && len(settings.AttachmentSources) > 0 {
+		println("Portable builds cannot use attachment sources: prepare Python and wheels locally instead")
+		return
+	}
+
 	// if requirements file is listed, check that it exists
 	if settings.RequirementsFile != "" {
 		if !common.DoesPathExist(requirementsPath) {
@@ -43,95 +212,430 @@ func createInstaller() {
 		}
 	}
 
-	file, err := os.Create("bootstrap.exe")
+	if settings.TempDir != "" {
+		if err := common.SetTempDir(settings.TempDir); err != nil {
+			println("Error setting temporary directory: ", err.Error())
+			return
+		}
+	}
+
+	// remove spooled archives and the Python workspace however the build ends
+	common.CleanupOnInterrupt()
+	defer common.RunCleanups()
+
+	if err := os.MkdirAll(filepath.Dir(*outputPath), os.ModePerm); err != nil {
+		panic(err)
+	}
+
+	file, err := os.Create(*outputPath)
 	if err != nil {
 		panic(err)
 	}
 
 	defer file.Close()
 
-	pythonFile, wheelsFile, err := PreparePython(*settings)
+	sources, err := loadAttachmentSources(settings)
+	if err != nil {
+		println("Error loading attachment sources: ", err.Error())
+		return
+	}
+
+	if err := checkBuildSpace(settings, sources); err != nil {
+		println("Not enough free space in the temporary directory: ", err.Error())
+		return
+	}
+
+	var pythonFile, wheelsFile io.ReadSeeker
+	metadata := make(map[string]common.AttachmentMetadata)
+
+	// Python is still needed locally to build wheels unless both are prebuilt
+	if sources[common.PythonFilename] == nil || sources[common.WheelsFilename] == nil {
+		span := tracer.Start("prepare-python", rootSpan)
+		pythonFile, wheelsFile, metadata, err = preparePythonCached(*settings, *noCache)
+		span.End()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	for name, source := range sources {
+		switch name {
+		case common.PythonFilename:
+			// a prebuilt archive replaces the one spooled by PreparePython
+			if spooled, ok := pythonFile.(io.Closer); ok {
+				spooled.Close()
+			}
+			pythonFile = source
+			metadata[name] = common.NewPrebuiltArchiveMetadata(common.AttachmentTypePython, settings.CompressionFormat)
+		case common.WheelsFilename:
+			if spooled, ok := wheelsFile.(io.Closer); ok {
+				spooled.Close()
+			}
+			wheelsFile = source
+			metadata[name] = common.NewPrebuiltArchiveMetadata(common.AttachmentTypeWheels, settings.CompressionFormat)
+		}
+	}
+
+	span := tracer.Start("compress-payload", rootSpan)
+	payloadOptions.StoreCompressed = settings.StoreCompressedFiles
+	payloadOptions.PreserveAttributes = settings.PreserveAttributes
+	PayloadFile, err := common.CompressDirToStreamWithOptions(settings.ScriptDir, settings.CompressionFormat, payloadOptions)
+	span.End()
+	if err != nil {
+		panic(err)
+	}
+	metadata[common.PayloadFilename] = common.NewArchiveMetadata(common.AttachmentTypePayload, PayloadFile)
+	fileCounts := map[string]int{common.PayloadFilename: PayloadFile.FileCount}
+
+	// embed the resolved settings, so the installer sees the same derived settings as the build
+	resolvedSettings, err := common.EncodeSettings(settings)
 	if err != nil {
 		panic(err)
 	}
+	SettingsFile := bytes.NewReader(resolvedSettings)
+	metadata[common.GetConfigEmbedName()] = common.NewFileMetadata(common.AttachmentTypeSettings, "json", int64(len(resolvedSettings)))
+
+	extras := make(map[string]io.ReadSeeker)
+
+	if settings.RecoveryScriptDir != "" {
+		span := tracer.Start("compress-recovery", rootSpan)
+		recoveryOptions.StoreCompressed = settings.StoreCompressedFiles
+		recoveryFile, err := common.CompressDirToStreamWithOptions(settings.RecoveryScriptDir, settings.CompressionFormat, recoveryOptions)
+		span.End()
+		if err != nil {
+			panic(err)
+		}
+
+		extras[common.RecoveryFilename] = recoveryFile
+
+		metadata[common.RecoveryFilename] = common.NewArchiveMetadata(common.AttachmentTypeRecovery, recoveryFile)
+		fileCounts[common.RecoveryFilename] = recoveryFile.FileCount
+	}
+
+	if settings.LicenseFile != "" {
+		licenseFile, err := os.Open(settings.LicenseFile)
+		if err != nil {
+			panic(err)
+		}
+
+		licenseInfo, err := licenseFile.Stat()
+		if err != nil {
+			panic(err)
+		}
+
+		extras[common.LicenseEmbedName] = licenseFile
+		metadata[common.LicenseEmbedName] = common.NewFileMetadata(common.AttachmentTypeLicense, "txt", licenseInfo.Size())
+	}
+
+	if err := embedPlugins(settings, extras, metadata); err != nil {
+		panic(err)
+	}
 
-	PayloadFile, err := common.CompressDirToStream(settings.ScriptDir)
+	extras[common.MetadataEmbedName], err = encodeMetadata(metadata)
 	if err != nil {
 		panic(err)
 	}
 
-	SettingsFile, err := os.Open(settingsFileName)
-	defer SettingsFile.Close()
+	span = tracer.Start("embed", rootSpan)
+	embedMap := createEmbedMap(pythonFile, PayloadFile, wheelsFile, SettingsFile, extras)
+	defer closeAttachments(embedMap)
 
-	embedMap := createEmbedMap(pythonFile, PayloadFile, wheelsFile, SettingsFile)
+	sizes, err := attachmentSizes(embedMap)
+	if err != nil {
+		panic(err)
+	}
 
-	if err := writePythonExecutable(file, embedMap); err != nil {
+	err = writePythonExecutable(file, embedMap, resources)
+	span.SetError(err)
+	span.End()
+	if err != nil {
 		return
 	}
 
 	file.Close()
 
-	outputExeHash, err := common.Md5SumFile(file.Name())
+	// embedding invalidates any signature, so sign last and hash the signed installer
+	if settings.Signing != nil {
+		span = tracer.Start("sign", rootSpan)
+		err = signExecutable(*settings.Signing, file.Name())
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			println("Error signing executable: ", err.Error())
+			panic(err)
+		}
+		println("Signed executable")
+	}
 
-	if err != nil {
+	span = tracer.Start("hash-output", rootSpan)
+	if err := saveOutputHashes(file.Name()); err != nil {
 		panic(err)
 	}
+	span.End()
 
-	println("Output executable hash: ", outputExeHash, " saved to hash.txt")
+	if *showQR {
+		if err := saveIdentityQR(file.Name()); err != nil {
+			println("Error creating identity QR code: ", err.Error())
+		}
+	}
 
-	// save the hash to a file
+	report, err := writeBuildReport(file.Name(), start, sizes, metadata, fileCounts)
+	if err != nil {
+		println("Error writing build report: ", err.Error())
+	}
 
-	if err := common.SaveContentsToFile("hash.txt", outputExeHash); err != nil {
-		println("Error saving hash to file")
+	if settings.BuildLimits != nil {
+		if err := settings.BuildLimits.Check(report); err != nil {
+			println("Build exceeds its limits:\n" + err.Error())
+			os.Exit(1)
+		}
 	}
 
 	println("Embedded payload")
 
 }
 
-func createEmbedMap(PythonRS, PayloadRS, wheelsFile, SettingsFile io.ReadSeeker) map[string]io.ReadSeeker {
+// preparePythonCached returns the Python and wheels archives from the build cache when buildCacheDir is set and
+// an entry for the current inputs exists, and otherwise prepares them and stores them in the cache.
+func preparePythonCached(settings common.PythonSetupSettings, noCache bool) (io.ReadSeeker, io.ReadSeeker, map[string]common.AttachmentMetadata, error) {
+	if settings.BuildCacheDir == "" {
+		return PreparePython(settings)
+	}
 
-	hashMap, hashBytes := HashFiles(PythonRS, PayloadRS, wheelsFile, SettingsFile)
+	key, err := buildCacheKey(settings)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
-	json.NewEncoder(hashBytes).Encode(hashMap)
+	store, err := openBuildCache(settings.BuildCacheDir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
-	embedMap := make(map[string]io.ReadSeeker)
+	// builds sharing a cache wait for the one preparing the same entry, then use it
+	if unlock, err := store.lock(key); err != nil {
+		println("Error locking build cache entry, preparing it anyway: ", err.Error())
+	} else {
+		defer unlock()
+	}
 
-	embedMap[common.HashesEmbedName] = bytes.NewReader(hashBytes.Bytes())
-	embedMap[common.PythonFilename] = PythonRS
-	embedMap[common.PayloadFilename] = PayloadRS
-	embedMap[common.WheelsFilename] = wheelsFile
-	embedMap[common.GetConfigEmbedName()] = SettingsFile
+	if !noCache {
+		if python, wheels, metadata, ok := loadBuildCache(store, key); ok {
+			println("Using cached Python and wheels: ", key)
+			return python, wheels, metadata, nil
+		}
+	}
 
-	return embedMap
+	python, wheels, metadata, err := PreparePython(settings)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := storeBuildCache(store, key, python, wheels, metadata); err != nil {
+		println("Error storing Python and wheels in the build cache: ", err.Error())
+	}
+
+	return python, wheels, metadata, nil
 }
 
-func HashFiles(PythonRS io.ReadSeeker, PayloadRS io.ReadSeeker, wheelsFile io.ReadSeeker, SettingsFile io.ReadSeeker) (map[string]string, *bytes.Buffer) {
-	PythonHash, err := common.HashReadSeeker(PythonRS)
+// resolvePythonVersion derives the Python download and layout settings from pythonVersion, and looks up the
+// checksum python.org publishes for the download unless pythonChecksum already pins one.
+func resolvePythonVersion(settings *common.PythonSetupSettings, allowUnverified bool) error {
+	if settings.PythonVersion == "" {
+		return nil
+	}
+
+	if err := common.ApplyPythonVersion(settings); err != nil {
+		return err
+	}
+
+	_, prebuiltPython := settings.AttachmentSources[common.PythonFilename]
+	_, prebuiltWheels := settings.AttachmentSources[common.WheelsFilename]
+
+	// Python is not downloaded when both archives are prebuilt
+	if settings.PythonChecksum != "" || (prebuiltPython && prebuiltWheels) {
+		return nil
+	}
+
+	checksum, err := common.PublishedChecksum(settings.PythonVersion, settings.PythonDownloadURL)
 	if err != nil {
-		panic(err)
+		if allowUnverified {
+			fmt.Println("Warning: The Python download will not be verified:", err)
+			return nil
+		}
+		return fmt.Errorf("cannot look up the published checksum: %w. Set pythonChecksum or pass --allow-unverified-python", err)
+	}
+
+	settings.PythonChecksum = checksum
+	return nil
+}
+
+// checkPythonSupport refuses Python versions outside the support matrix unless allowUnverified is set,
+// and checks that the layout settings match the version being downloaded.
+func checkPythonSupport(settings *common.PythonSetupSettings, allowUnverified bool) error {
+	version, err := common.PythonVersionFromURL(settings.PythonDownloadURL)
+	if err != nil {
+		if allowUnverified {
+			fmt.Println("Warning:", err)
+			return nil
+		}
+		return err
 	}
 
-	PayloadHash, err := common.HashReadSeeker(PayloadRS)
+	release, ok := common.LookupPythonRelease(version)
+	if !ok {
+		if allowUnverified {
+			fmt.Println("Warning: Python", version, "has not been verified with exepy. Continuing because --allow-unverified-python was passed.")
+			return nil
+		}
+		return fmt.Errorf("Python %s has not been verified with exepy. Pass --allow-unverified-python to build anyway", version)
+	}
+
+	if settings.PthFile != release.PthFile {
+		return fmt.Errorf("pthFile is %q but Python %s uses %q", settings.PthFile, version, release.PthFile)
+	}
+
+	if settings.PythonInteriorZip != release.InteriorZip {
+		return fmt.Errorf("pythonInteriorZip is %q but Python %s uses %q", settings.PythonInteriorZip, version, release.InteriorZip)
+	}
+
+	if settings.PipDownloadURL != release.PipDownloadURL {
+		fmt.Println("Warning: pipDownloadURL differs from the pip verified with Python", version+":", release.PipDownloadURL)
+	}
+
+	return nil
+}
+
+// pythonWorkspaceEstimate is the space usually taken by the embeddable distribution, pip and built wheels.
+const pythonWorkspaceEstimate = 512 << 20
+
+// checkBuildSpace checks that the temporary directory can hold the spooled archives, which are at most as
+// large as the directories they compress, and the Python workspace when Python is prepared locally.
+func checkBuildSpace(settings *common.PythonSetupSettings, sources map[string]io.ReadSeeker) error {
+	required, err := common.DirectorySize(settings.ScriptDir)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	wheelsFileHash, err := common.HashReadSeeker(wheelsFile)
+	if settings.RecoveryScriptDir != "" {
+		recoverySize, err := common.DirectorySize(settings.RecoveryScriptDir)
+		if err != nil {
+			return err
+		}
+		required += recoverySize
+	}
+
+	if sources[common.PythonFilename] == nil || sources[common.WheelsFilename] == nil {
+		required += pythonWorkspaceEstimate
+	}
+
+	return common.CheckFreeSpace(os.TempDir(), required)
+}
+
+// saveOutputHashes records the hash of the whole executable in hash.txt and the hash of the stub alone,
+// excluding attachments, in stub-hash.txt. Both are written next to the executable.
+func saveOutputHashes(exePath string) error {
+	outputExeHash, err := common.HashFile(exePath)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	SettingsFileHash, err := common.HashReadSeeker(SettingsFile)
+	stubHash, err := hashStub(exePath, common.HashAlgorithm)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	hashPath := filepath.Join(filepath.Dir(exePath), "hash.txt")
+	stubHashPath := filepath.Join(filepath.Dir(exePath), "stub-hash.txt")
+
+	println("Output executable hash: ", outputExeHash, " saved to", hashPath)
+	println("Stub hash: ", stubHash, " saved to", stubHashPath)
+
+	// save the hashes to files
+
+	if err := common.SaveContentsToFile(hashPath, outputExeHash); err != nil {
+		println("Error saving hash to file")
+	}
+
+	if err := common.SaveContentsToFile(stubHashPath, stubHash); err != nil {
+		println("Error saving stub hash to file")
+	}
+
+	return nil
+}
+
+// saveIdentityQR prints the identity of the installer at exePath as a QR code and saves it to id-qr.png next
+// to the installer.
+func saveIdentityQR(exePath string) error {
+	exeHash, err := common.HashFile(exePath)
+	if err != nil {
+		return err
+	}
+
+	if err := printIdentity(exeHash); err != nil {
+		return err
+	}
+
+	imagePath := filepath.Join(filepath.Dir(exePath), "id-qr.png")
+	if err := saveIdentityImage(exeHash, imagePath); err != nil {
+		return err
+	}
+
+	println("Identity QR code saved to", imagePath)
+	return nil
+}
+
+func encodeMetadata(metadata map[string]common.AttachmentMetadata) (io.ReadSeeker, error) {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(data), nil
+}
+
+func createEmbedMap(PythonRS, PayloadRS, wheelsFile, SettingsFile io.ReadSeeker, extras map[string]io.ReadSeeker) map[string]io.ReadSeeker {
+
+	embedMap := make(map[string]io.ReadSeeker)
+
+	embedMap[common.PythonFilename] = PythonRS
+	embedMap[common.PayloadFilename] = PayloadRS
+	embedMap[common.WheelsFilename] = wheelsFile
+	embedMap[common.GetConfigEmbedName()] = SettingsFile
+
+	for name, rs := range extras {
+		embedMap[name] = rs
 	}
 
+	hashMap, hashBytes := HashFiles(embedMap)
+
+	json.NewEncoder(hashBytes).Encode(common.NewHashManifest(hashMap))
+
+	embedMap[common.HashesEmbedName] = bytes.NewReader(hashBytes.Bytes())
+
+	return embedMap
+}
+
+// closeAttachments closes every attachment that holds a file, removing the spooled archives.
+func closeAttachments(attachments map[string]io.ReadSeeker) {
+	for _, attachment := range attachments {
+		if closer, ok := attachment.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+func HashFiles(files map[string]io.ReadSeeker) (map[string]string, *bytes.Buffer) {
 	hashMap, hashBytes := make(map[string]string), new(bytes.Buffer)
-	hashMap[common.PythonFilename] = PythonHash
-	hashMap[common.PayloadFilename] = PayloadHash
-	hashMap[common.WheelsFilename] = wheelsFileHash
-	hashMap[common.GetConfigEmbedName()] = SettingsFileHash
+
+	for name, rs := range files {
+		hash, err := common.HashReadSeeker(rs)
+		if err != nil {
+			panic(err)
+		}
+
+		hashMap[name] = hash
+	}
 
 	// print the hashes
 	for k, v := range hashMap {
@@ -145,16 +649,33 @@ func HashFiles(PythonRS io.ReadSeeker, PayloadRS io.ReadSeeker, wheelsFile io.Re
 // It takes two parameters:
 // - writer: an io.Writer where the resulting executable will be written.
 // - attachments: a map where the key is the name of the attachment and the value is an io.ReadSeeker that reads the attachment's content.
-func writePythonExecutable(writer io.Writer, attachments map[string]io.ReadSeeker) error {
-	// Load the executable file of the current running program
-	executableBytes, err := loadSelf()
+// - resources: the icon and version information to add to the executable, or nil to leave it unchanged.
+func writePythonExecutable(writer io.Writer, attachments map[string]io.ReadSeeker, resources *common.PEResources) error {
+	// Open the executable file of the current running program
+	self, executable, err := loadSelf()
 	// If an error occurred while loading the executable, return
 	if err != nil {
 		return err
 	}
+	defer self.Close()
+
+	var reader io.ReadSeeker = executable
 
-	// Create a new reader for the executable bytes
-	reader := bytes.NewReader(executableBytes)
+	// Add the icon and version information before the attachments are appended; only this needs the executable
+	// in memory
+	if resources != nil {
+		executableBytes, err := io.ReadAll(executable)
+		if err != nil {
+			return err
+		}
+
+		executableBytes, err = common.AddPEResources(executableBytes, *resources)
+		if err != nil {
+			return err
+		}
+
+		reader = bytes.NewReader(executableBytes)
+	}
 
 	// Embed the attachments into the executable
 	err = embedding.Embed(writer, reader, attachments, nil)
@@ -166,35 +687,50 @@ func writePythonExecutable(writer io.Writer, attachments map[string]io.ReadSeeke
 	return nil
 }
 
-// loadSelf is a function that retrieves the executable file of the current running program.
-// It returns the file content as a byte slice and an error if any occurred during the process.
-func loadSelf() ([]byte, error) {
+// loadSelf opens the executable file of the current running program and returns it with a reader over it up to
+// the start of any attachments, which is found by streaming through the file rather than reading it into memory.
+// The caller closes the file.
+func loadSelf() (*os.File, *io.SectionReader, error) {
 	// Get the path of the executable file
 	selfPath, err := os.Executable()
 	// If an error occurred while getting the path, return the error
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Open the executable file
 	file, err := os.Open(selfPath)
 	// If an error occurred while opening the file, return the error
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	// Ensure the file will be closed at the end of the function
-	defer file.Close()
-
-	// Create a new buffer to hold the file content
-	memSlice := new(bytes.Buffer)
 
-	// Copy the file content into the buffer
-	_, err = io.Copy(memSlice, file)
-	// If an error occurred while copying the file content, return the error
+	info, err := file.Stat()
 	if err != nil {
-		return nil, err
+		file.Close()
+		return nil, nil, err
+	}
+	size := info.Size()
+
+	// Count the bytes before the attachments, if the executable has any
+	stub := &countingWriter{}
+	err = embedding.RemoveEmbedding(stub, file, nil)
+	if err == nil {
+		size = stub.count
+	} else if !errors.Is(err, embedding.ErrNothingEmbedded) {
+		file.Close()
+		return nil, nil, err
 	}
 
-	// Return the file content as a byte slice and any error that might have occurred
-	return memSlice.Bytes(), err
+	return file, io.NewSectionReader(file, 0, size), nil
+}
+
+// countingWriter discards what is written to it and counts the bytes.
+type countingWriter struct {
+	count int64
+}
+
+func (writer *countingWriter) Write(data []byte) (int, error) {
+	writer.count += int64(len(data))
+	return len(data), nil
 }
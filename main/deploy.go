@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"lukasolson.net/common"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// silentInstallArgs run a first time setup with every prompt answered and without launching the script. Standard
+// input is empty as well, so any prompt that remains reads end of input instead of waiting.
+var silentInstallArgs = []string{"--accept-license", "--accept-capabilities", "--prewarm"}
+
+// deployInstaller copies a built installer to a remote Windows or Linux machine over SSH and runs a silent first
+// time setup there, showing its output and saving it to a local log. Authentication is left to ssh, so hosts,
+// users and keys configured in ~/.ssh/config or an SSH agent are used as they are. Passwords are not prompted for.
+// Usage: deploy installer.exe --host [user@]machine [--os windows|linux] [--dir directory] [--port port]
+// [--identity file] [--log file]
+func deployInstaller(args []string) {
+	installerPath, args := splitPositional(args)
+
+	flags := flag.NewFlagSet("deploy", flag.ExitOnError)
+	host := flags.String("host", "", "machine to install on, as [user@]host or a host configured for ssh")
+	remoteOS := flags.String("os", "windows", "operating system of the machine: windows or linux")
+	dir := flags.String("dir", "exepy-deploy", "directory on the machine to install in, relative to the user's home directory unless absolute")
+	port := flags.String("port", "", "SSH port, if not the default or the one configured for the host")
+	identity := flags.String("identity", "", "private key to authenticate with, if not the one configured for the host")
+	logPath := flags.String("log", "", "file to save the output of the installation to (default deploy-<host>.log)")
+	_ = flags.Parse(args)
+
+	if installerPath == "" || *host == "" || flags.NArg() > 0 || (*remoteOS != "windows" && *remoteOS != "linux") {
+		fmt.Println("Usage: deploy <installer.exe> --host <[user@]machine> [--os windows|linux] [--dir directory] [--port port] [--identity file] [--log file]")
+		return
+	}
+
+	if *logPath == "" {
+		*logPath = "deploy-" + logFileHost(*host) + ".log"
+	}
+
+	target := deployTarget{host: *host, windows: *remoteOS == "windows", dir: *dir, port: *port, identity: *identity}
+
+	exitCode, err := target.deploy(installerPath, *logPath)
+	if err != nil {
+		fmt.Println("Error deploying to", *host+":", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("The output of the installation is saved to", *logPath)
+
+	if exitCode != exitCodeSuccess {
+		fmt.Println("Installation on", *host, "failed with exit code", exitCode)
+		os.Exit(1)
+	}
+
+	fmt.Println("Installed on", *host)
+}
+
+// deployTarget is a remote machine and the directory the installer is copied to and run in.
+type deployTarget struct {
+	host     string
+	windows  bool
+	dir      string
+	port     string
+	identity string
+}
+
+// deploy copies installerPath to the target and runs it there, copying its output to the console and to logPath.
+// It returns the exit code of the installer; an error means the installer could not be copied or started.
+func (target deployTarget) deploy(installerPath, logPath string) (int, error) {
+	installerName := filepath.Base(installerPath)
+
+	fmt.Println("Creating", target.dir, "on", target.host)
+	if err := target.ssh(target.mkdirCommand(), nil).Run(); err != nil {
+		return 0, fmt.Errorf("creating %s: %w", target.dir, err)
+	}
+
+	fmt.Println("Copying", installerName, "to", target.host)
+	if err := target.scp(installerPath, path.Join(filepath.ToSlash(target.dir), installerName)).Run(); err != nil {
+		return 0, fmt.Errorf("copying the installer: %w", err)
+	}
+
+	log, err := os.Create(logPath)
+	if err != nil {
+		return 0, err
+	}
+	defer log.Close()
+
+	fmt.Println("Installing on", target.host)
+	cmd := target.ssh(target.installCommand(installerName), io.MultiWriter(os.Stdout, log))
+
+	err = cmd.Run()
+
+	// ssh exits with the exit code of the remote command, or 255 if ssh itself failed
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() != 255 {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("running the installer: %w", err)
+	}
+
+	return exitCodeSuccess, nil
+}
+
+// mkdirCommand returns the remote command that creates the target directory if it does not exist.
+func (target deployTarget) mkdirCommand() string {
+	if target.windows {
+		dir := common.QuoteCmdArg(target.windowsDir())
+		return "if not exist " + dir + " mkdir " + dir
+	}
+
+	return "mkdir -p " + quoteShellArg(target.dir)
+}
+
+// installCommand returns the remote command that runs the installer silently in the target directory. The
+// default shell of OpenSSH on Windows is cmd.exe, and a POSIX shell elsewhere.
+func (target deployTarget) installCommand(installerName string) string {
+	args := strings.Join(silentInstallArgs, " ")
+
+	if target.windows {
+		dir := common.QuoteCmdArg(target.windowsDir())
+		return "cd /d " + dir + " && " + common.QuoteCmdArg(installerName) + " " + args + " < nul"
+	}
+
+	installer := quoteShellArg("./" + installerName)
+	return "cd " + quoteShellArg(target.dir) + " && chmod +x " + installer + " && " + installer + " " + args + " < /dev/null"
+}
+
+// windowsDir returns the target directory with backslashes, as cmd.exe expects.
+func (target deployTarget) windowsDir() string {
+	return strings.ReplaceAll(target.dir, "/", `\`)
+}
+
+// ssh returns the command that runs command on the target, with its output written to output, or the console if
+// output is nil. Batch mode makes ssh fail instead of prompting for a password or to accept an unknown host key.
+func (target deployTarget) ssh(command string, output io.Writer) *exec.Cmd {
+	args := []string{"-o", "BatchMode=yes"}
+	if target.port != "" {
+		args = append(args, "-p", target.port)
+	}
+	if target.identity != "" {
+		args = append(args, "-i", target.identity)
+	}
+	args = append(args, target.host, command)
+
+	if output == nil {
+		output = os.Stdout
+	}
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	return cmd
+}
+
+// scp returns the command that copies localPath to remotePath on the target.
+func (target deployTarget) scp(localPath, remotePath string) *exec.Cmd {
+	args := []string{"-o", "BatchMode=yes"}
+	if target.port != "" {
+		args = append(args, "-P", target.port)
+	}
+	if target.identity != "" {
+		args = append(args, "-i", target.identity)
+	}
+	args = append(args, localPath, target.host+":"+remotePath)
+
+	cmd := exec.Command("scp", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// quoteShellArg quotes s for a POSIX shell, in which nothing inside single quotes is expanded.
+func quoteShellArg(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// logFileHost returns host without its user name, with characters that cannot be used in file names replaced.
+func logFileHost(host string) string {
+	if _, machine, found := strings.Cut(host, "@"); found {
+		host = machine
+	}
+
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`\/:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, host)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/maja42/ember"
+	"lukasolson.net/common"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// environmentManifestFilename is the file at the root of an environment bundle that describes it.
+const environmentManifestFilename = "exepy-environment.json"
+
+// environmentManifest describes an installed environment exported with --export-env.
+type environmentManifest struct {
+	ToolVersion      string             `json:"toolVersion"`
+	ExportedAt       time.Time          `json:"exportedAt"`
+	Product          string             `json:"product"`
+	PythonVersion    string             `json:"pythonVersion,omitempty"`
+	PythonExtractDir string             `json:"pythonExtractDir"`
+	MainScript       string             `json:"mainScript"`
+	MainScriptArgs   []string           `json:"mainScriptArgs,omitempty"`
+	Environment      map[string]string  `json:"environment,omitempty"`
+	Packages         []installedPackage `json:"packages"`
+}
+
+// installedPackage is a distribution found in site-packages.
+type installedPackage struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Installer string `json:"installer,omitempty"`
+	// Embedded is false for packages that were not shipped as wheels in the installer, such as those the user
+	// installed after setup.
+	Embedded bool `json:"embedded"`
+}
+
+// bundleCompression returns the compression format of an environment bundle from its file extension.
+func bundleCompression(bundlePath string) (string, error) {
+	switch strings.ToLower(filepath.Ext(bundlePath)) {
+	case ".zst", ".zstd":
+		return common.CompressionZstd, nil
+	case ".gz", ".tgz":
+		return common.CompressionGzip, nil
+	case ".xz":
+		return common.CompressionXz, nil
+	case ".bz2":
+		return common.CompressionBz2, nil
+	}
+
+	return "", fmt.Errorf("unknown bundle format %q: use .tar.zst, .tar.gz, .tar.xz or .tar.bz2", filepath.Ext(bundlePath))
+}
+
+// exportEnvironment writes the installed environment to a bundle at bundlePath: the Python installation with
+// every package in it, the payload files as they are now, the state store and the install log, with a manifest
+// listing the installed packages. Secrets are not exported.
+func exportEnvironment(attachments *ember.Attachments, settings common.PythonSetupSettings, bundlePath string) error {
+	compression, err := bundleCompression(bundlePath)
+	if err != nil {
+		return err
+	}
+
+	if !common.DoesPathExist(common.BootstrapMarkerFilename) {
+		return errors.New("nothing is installed yet: run the installer once before exporting its environment")
+	}
+
+	packages, err := installedPackages(filepath.Join(settings.PythonExtractDir, "Lib", "site-packages"))
+	if err != nil {
+		return err
+	}
+
+	embedded := make(map[string]bool)
+	if reader := attachments.Reader(common.WheelsFilename); reader != nil {
+		names, err := common.ListArchive(reader, settings.CompressionFormat)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if dist, version, ok := wheelDistribution(path.Base(name)); ok {
+				embedded[dist+"=="+version] = true
+			}
+		}
+	}
+	for i := range packages {
+		packages[i].Embedded = embedded[normalizeDistName(packages[i].Name)+"=="+packages[i].Version]
+	}
+
+	manifest := environmentManifest{
+		ToolVersion:      common.Version,
+		ExportedAt:       time.Now().UTC(),
+		Product:          productName(settings),
+		PythonVersion:    settings.PythonVersion,
+		PythonExtractDir: filepath.ToSlash(settings.PythonExtractDir),
+		MainScript:       settings.MainScript,
+		MainScriptArgs:   settings.MainScriptArgs,
+		Environment:      settings.Environment,
+		Packages:         packages,
+	}
+
+	manifestFile, err := os.CreateTemp("", "exepy-environment-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(manifestFile.Name())
+	defer manifestFile.Close()
+
+	encoder := json.NewEncoder(manifestFile)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return err
+	}
+	if err := manifestFile.Close(); err != nil {
+		return err
+	}
+
+	paths := map[string]string{
+		manifestFile.Name():       environmentManifestFilename,
+		settings.PythonExtractDir: filepath.ToSlash(settings.PythonExtractDir),
+	}
+
+	payloadNames, err := common.ListArchive(attachments.Reader(common.PayloadFilename), settings.CompressionFormat)
+	if err != nil {
+		return err
+	}
+	for _, name := range payloadNames {
+		if !strings.HasSuffix(name, "/") && common.DoesPathExist(filepath.FromSlash(name)) {
+			paths[filepath.FromSlash(name)] = name
+		}
+	}
+
+	for _, name := range []string{common.StateFilename, common.BootstrapMarkerFilename, settings.InstallLog, defaultInstallLog} {
+		if name != "" && name != installLogDisabled && common.DoesPathExist(name) {
+			paths[name] = filepath.ToSlash(name)
+		}
+	}
+
+	return common.CompressPathsToFile(paths, bundlePath, compression)
+}
+
+// installedPackages lists the distributions installed in sitePackages, sorted by name.
+func installedPackages(sitePackages string) ([]installedPackage, error) {
+	distInfos, err := filepath.Glob(filepath.Join(sitePackages, "*.dist-info"))
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []installedPackage
+	for _, distInfo := range distInfos {
+		metadata, err := readPackageMetadata(filepath.Join(distInfo, "METADATA"))
+		if err != nil {
+			fmt.Println("Error reading package metadata in", filepath.Base(distInfo)+":", err)
+			continue
+		}
+
+		installer, _ := os.ReadFile(filepath.Join(distInfo, "INSTALLER"))
+
+		packages = append(packages, installedPackage{
+			Name:      metadata["Name"],
+			Version:   metadata["Version"],
+			Installer: strings.TrimSpace(string(installer)),
+		})
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		return normalizeDistName(packages[i].Name) < normalizeDistName(packages[j].Name)
+	})
+
+	return packages, nil
+}
+
+// readPackageMetadata returns the header fields of a METADATA file, which end at the first blank line.
+func readPackageMetadata(metadataPath string) (map[string]string, error) {
+	file, err := os.Open(metadataPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() && scanner.Text() != "" {
+		if name, value, found := strings.Cut(scanner.Text(), ":"); found {
+			if _, seen := fields[name]; !seen {
+				fields[name] = strings.TrimSpace(value)
+			}
+		}
+	}
+
+	return fields, scanner.Err()
+}
+
+var distNameSeparators = regexp.MustCompile(`[-_.]+`)
+
+// normalizeDistName returns the normalized form of a distribution name, so names that differ only in case and
+// separators compare equal.
+func normalizeDistName(name string) string {
+	return distNameSeparators.ReplaceAllString(strings.ToLower(name), "-")
+}
+
+// wheelDistribution returns the normalized distribution name and the version of a wheel from its filename.
+func wheelDistribution(filename string) (string, string, bool) {
+	if !strings.EqualFold(path.Ext(filename), ".whl") {
+		return "", "", false
+	}
+
+	parts := strings.Split(strings.TrimSuffix(filename, path.Ext(filename)), "-")
+	if len(parts) < 5 {
+		return "", "", false
+	}
+
+	return normalizeDistName(parts[0]), parts[1], true
+}
+
+// importEnvironment unpacks an environment bundle exported by an installer with --export-env, so the user's
+// installed environment can be reproduced.
+// Usage: import-env bundle.tar.zst --out dir
+func importEnvironment(args []string) {
+	bundlePath, args := splitPositional(args)
+
+	flags := flag.NewFlagSet("import-env", flag.ExitOnError)
+	outDir := flags.String("out", "imported-env", "directory to unpack the environment to")
+	_ = flags.Parse(args)
+
+	if bundlePath == "" {
+		fmt.Println("Usage: import-env <bundle.tar.zst> --out <directory>")
+		return
+	}
+
+	compression, err := bundleCompression(bundlePath)
+	if err != nil {
+		fmt.Println("Error reading bundle:", err)
+		return
+	}
+
+	bundle, err := os.Open(bundlePath)
+	if err != nil {
+		fmt.Println("Error opening bundle:", err)
+		return
+	}
+	defer bundle.Close()
+
+	if err := common.DecompressIOStream(bundle, *outDir, compression); err != nil {
+		fmt.Println("Error unpacking bundle:", err)
+		return
+	}
+
+	manifestData, err := os.ReadFile(filepath.Join(*outDir, environmentManifestFilename))
+	if err != nil {
+		fmt.Println("Error reading environment manifest:", err)
+		return
+	}
+
+	var manifest environmentManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		fmt.Println("Error reading environment manifest:", err)
+		return
+	}
+
+	fmt.Println("Environment of", manifest.Product, "exported", manifest.ExportedAt.Format(time.RFC3339), "by exepy", manifest.ToolVersion)
+	if manifest.PythonVersion != "" {
+		fmt.Println("Python:", manifest.PythonVersion)
+	}
+
+	fmt.Println("Packages:")
+	for _, pkg := range manifest.Packages {
+		line := "  " + pkg.Name + "==" + pkg.Version
+		if !pkg.Embedded {
+			line += " (not embedded in the installer)"
+		}
+		fmt.Println(line)
+	}
+
+	if len(manifest.Environment) > 0 {
+		fmt.Println("Environment variables set by the installer:")
+		for name, value := range manifest.Environment {
+			fmt.Println(" ", name+"="+value)
+		}
+	}
+
+	pythonPath := path.Join(manifest.PythonExtractDir, "python.exe")
+	fmt.Println("Environment unpacked to", *outDir+". To run the script, from that directory run:")
+	fmt.Println(" ", strings.Join(append([]string{pythonPath, manifest.MainScript}, manifest.MainScriptArgs...), " "))
+}
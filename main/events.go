@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"lukasolson.net/common"
+	"time"
+)
+
+// Event IDs written to the Windows Event Log when eventLogSource is set.
+const (
+	eventInstallStarted       = 1000
+	eventInstallSucceeded     = 1001
+	eventInstallFailed        = 1002
+	eventUpdateApplied        = 1003
+	eventIntegrityFailure     = 1004
+	eventCapabilitiesAccepted = 1005
+	eventLicenseAccepted      = 1006
+)
+
+// installEvent is the message of every event log entry, as JSON so monitoring tools can parse it.
+type installEvent struct {
+	Event          string `json:"event"`
+	Product        string `json:"product"`
+	ExecutableHash string `json:"executableHash,omitempty"`
+	Detail         string `json:"detail,omitempty"`
+}
+
+var eventNames = map[uint32]string{
+	eventInstallStarted:       "install-started",
+	eventInstallSucceeded:     "install-succeeded",
+	eventInstallFailed:        "install-failed",
+	eventUpdateApplied:        "update-applied",
+	eventIntegrityFailure:     "integrity-failure",
+	eventCapabilitiesAccepted: "capabilities-accepted",
+	eventLicenseAccepted:      "license-accepted",
+}
+
+// eventReporter writes an install lifecycle event for the executable with the given hash.
+type eventReporter func(eventID uint32, exeHash, detail string)
+
+// newEventReporter returns a reporter for the event log configured in settings and a function that closes it.
+// Without an eventLogSource, events are not logged. The end of first time setup is also sent to the
+// notification channels in settings.
+func newEventReporter(settings common.PythonSetupSettings) (eventReporter, func()) {
+	eventLog := openEventLog(settings)
+
+	// notification channels are told how long first time setup took
+	var setupStarted time.Time
+
+	report := func(eventID uint32, exeHash, detail string) {
+		reportEvent(eventLog, eventID, settings, exeHash, detail)
+
+		switch eventID {
+		case eventInstallStarted:
+			setupStarted = time.Now()
+		case eventInstallSucceeded, eventInstallFailed:
+			notifyCompletion(settings, newSetupCompletion(settings, eventID == eventInstallSucceeded, time.Since(setupStarted), exeHash, detail))
+		}
+	}
+
+	return report, func() { eventLog.Close() }
+}
+
+// openEventLog returns the event log configured in settings, or nil if events are not reported.
+func openEventLog(settings common.PythonSetupSettings) *common.EventLog {
+	if settings.EventLogSource == "" {
+		return nil
+	}
+
+	eventLog, err := common.OpenEventLog(settings.EventLogSource)
+	if err != nil {
+		fmt.Println("Error opening event log:", err)
+		return nil
+	}
+
+	return eventLog
+}
+
+// reportEvent writes an event log entry; failures are printed but never stop the installer.
+func reportEvent(eventLog *common.EventLog, eventID uint32, settings common.PythonSetupSettings, exeHash, detail string) {
+	if eventLog == nil {
+		return
+	}
+
+	message, err := json.Marshal(installEvent{
+		Event:          eventNames[eventID],
+		Product:        productName(settings),
+		ExecutableHash: exeHash,
+		Detail:         detail,
+	})
+	if err != nil {
+		fmt.Println("Error encoding event:", err)
+		return
+	}
+
+	eventType := common.EventInformation
+	switch eventID {
+	case eventInstallFailed, eventIntegrityFailure:
+		eventType = common.EventError
+	}
+
+	if err := eventLog.Write(eventType, eventID, string(message)); err != nil {
+		fmt.Println("Error writing event log entry:", err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+// Exit codes reported by bootstrap so deployment tools can tell failures apart. Failures that fit none of the
+// classes below exit with exitCodeFailure.
+const (
+	exitCodeSuccess = 0
+	exitCodeFailure = 1
+	// exitCodeUsage: the command line is invalid, such as an option missing its value.
+	exitCodeUsage = 2
+
+	exitCodeInstallLocked        = 10
+	exitCodeHashRejected         = 11
+	exitCodeCapabilitiesDeclined = 12
+	exitCodeLicenseDeclined      = 13
+
+	// exitCodeIntegrityFailure: the installer or its attachments failed their hash checks, or could not be checked.
+	exitCodeIntegrityFailure = 20
+	// exitCodeExtractionFailure: the embedded archives could not be extracted or moved into place.
+	exitCodeExtractionFailure = 21
+	// exitCodePrerequisiteFailure: a prerequisite installer failed.
+	exitCodePrerequisiteFailure = 22
+	// exitCodePipFailure: pip could not be installed.
+	exitCodePipFailure = 23
+	// exitCodeSetupScriptFailure: the setup script failed.
+	exitCodeSetupScriptFailure = 24
+	// exitCodeScriptFailure: the main script could not be run or exited with an error.
+	exitCodeScriptFailure = 25
+	// exitCodePluginFailure: a pre-install plugin failed.
+	exitCodePluginFailure = 26
+
+	// exitCodeInterrupted: first time setup was stopped with Ctrl+C while extracting. It is the conventional exit
+	// code of a process stopped by Ctrl+C.
+	exitCodeInterrupted = 130
+)
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/maja42/ember"
+	"io"
+	"lukasolson.net/common"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// extractInstaller writes the attachments of an installer to a directory without installing anything.
+// Usage: extract installer.exe --out dir [--include pattern]... [--exclude pattern]...
+func extractInstaller(args []string) {
+	installerPath, args := splitPositional(args)
+
+	var filter common.EntryFilter
+
+	flags := flag.NewFlagSet("extract", flag.ExitOnError)
+	outDir := flags.String("out", "dump", "directory to write the attachments to")
+	flags.Var((*patternList)(&filter.Include), "include", "unpack only archive entries matching this glob pattern; can be repeated")
+	flags.Var((*patternList)(&filter.Exclude), "exclude", "do not unpack archive entries matching this glob pattern; can be repeated")
+	_ = flags.Parse(args)
+
+	if installerPath == "" {
+		fmt.Println("Usage: extract <installer.exe> --out <directory>")
+		return
+	}
+
+	attachments, err := ember.OpenExe(installerPath)
+	if err != nil {
+		fmt.Println("Error opening installer:", err)
+		return
+	}
+	defer attachments.Close()
+
+	if attachments.Count() == 0 {
+		fmt.Println("Installer does not contain any attachments:", installerPath)
+		return
+	}
+
+	settings, err := GetSettings(attachments)
+	if err != nil {
+		fmt.Println("Error reading settings:", err)
+		return
+	}
+
+	if err := extractAttachments(attachments, settings, *outDir, filter); err != nil {
+		fmt.Println("Error extracting attachments:", err)
+		return
+	}
+
+	fmt.Println("Attachments written to", *outDir)
+}
+
+// extractAttachments writes every attachment to outDir as it is embedded, without decompressing it.
+// Archives are given the extension of their format, and JSON attachments a .json extension. If filter selects
+// entries, the archives are instead unpacked to a directory named after them, with only the selected entries.
+func extractAttachments(attachments *ember.Attachments, settings common.PythonSetupSettings, outDir string, filter common.EntryFilter) error {
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(outDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	archiveExtension := ".tar." + common.CompressionFormatName(settings.CompressionFormat)
+
+	pluginExtensions := make(map[string]string)
+	for _, plugin := range settings.Plugins {
+		pluginExtensions[common.PluginEmbedName(plugin.Name)] = filepath.Ext(plugin.Path)
+	}
+
+	for _, name := range attachments.List() {
+		filename := name
+		switch name {
+		case common.PythonFilename, common.PayloadFilename, common.WheelsFilename, common.RecoveryFilename:
+			if !filter.IsZero() {
+				if err := common.DecompressIOStreamFiltered(attachments.Reader(name), filepath.Join(outDir, name), settings.CompressionFormat, filter); err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+
+				fmt.Println("Unpacked selected entries of", name)
+				continue
+			}
+			filename += archiveExtension
+		case common.HashesEmbedName, common.MetadataEmbedName:
+			filename += ".json"
+		case common.LicenseEmbedName:
+			filename += ".txt"
+		default:
+			filename += pluginExtensions[name]
+		}
+
+		if err := writeAttachment(attachments.Reader(name), filepath.Join(outDir, filename)); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		fmt.Println("Wrote", filename)
+	}
+
+	return nil
+}
+
+func writeAttachment(reader io.Reader, outPath string) error {
+	file, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// patternList is a flag that collects every value it is given.
+type patternList []string
+
+func (list *patternList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *patternList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"github.com/maja42/ember"
+	"io"
+	"lukasolson.net/common"
+	"os"
+	"strings"
+)
+
+// faultEnv lists failures bootstrap simulates for QA, comma separated, so repair, rollback and error reporting
+// can be exercised on demand. Only builds made with the faultinjection tag read it, so a release installer cannot
+// be made to fail by setting it.
+const faultEnv = "EXEPY_INJECT_FAULTS"
+
+// Faults that can be injected.
+const (
+	// faultCorruptAttachment flips a byte in the middle of the payload attachment whenever it is read.
+	faultCorruptAttachment = "corrupt-attachment"
+	// faultDiskFull fails extraction of the payload as if the disk had filled up.
+	faultDiskFull = "disk-full"
+	// faultFailMove fails moving the payload into place after Python has been replaced.
+	faultFailMove = "fail-move"
+	// faultFailPip fails every pip invocation.
+	faultFailPip = "fail-pip"
+)
+
+// injectedFaults returns the faults set in the environment, or none unless fault injection is built in.
+func injectedFaults() []string {
+	if !faultInjectionEnabled {
+		return nil
+	}
+
+	var faults []string
+
+	for _, fault := range strings.Split(os.Getenv(faultEnv), ",") {
+		if fault = strings.TrimSpace(fault); fault != "" {
+			faults = append(faults, fault)
+		}
+	}
+
+	return faults
+}
+
+func faultInjected(fault string) bool {
+	return contains(injectedFaults(), fault)
+}
+
+// injectFault returns an error if fault is injected, and nil otherwise.
+func injectFault(fault string) error {
+	if !faultInjected(fault) {
+		return nil
+	}
+
+	return fmt.Errorf("injected fault: %s", fault)
+}
+
+// warnInjectedFaults prints the faults in effect, so a QA run is never mistaken for a real failure.
+func warnInjectedFaults() {
+	if faults := injectedFaults(); len(faults) > 0 {
+		fmt.Println("Warning: Simulating failures for testing:", strings.Join(faults, ", "))
+	}
+}
+
+// attachmentReader returns a reader for the named attachment, or nil if it does not exist. With the
+// corrupt-attachment fault, the payload reader returns a damaged copy.
+func attachmentReader(attachments *ember.Attachments, name string) io.ReadSeeker {
+	reader := attachments.Reader(name)
+	if reader == nil {
+		return nil
+	}
+
+	if name == common.PayloadFilename && faultInjected(faultCorruptAttachment) {
+		return &corruptReader{reader: reader, offset: attachments.Size(name) / 2}
+	}
+
+	return reader
+}
+
+// corruptReader inverts the byte at offset of the underlying reader.
+type corruptReader struct {
+	reader   io.ReadSeeker
+	offset   int64
+	position int64
+}
+
+func (corrupt *corruptReader) Read(p []byte) (int, error) {
+	n, err := corrupt.reader.Read(p)
+
+	if index := corrupt.offset - corrupt.position; index >= 0 && index < int64(n) {
+		p[index] ^= 0xff
+	}
+	corrupt.position += int64(n)
+
+	return n, err
+}
+
+func (corrupt *corruptReader) Seek(offset int64, whence int) (int64, error) {
+	position, err := corrupt.reader.Seek(offset, whence)
+	if err == nil {
+		corrupt.position = position
+	}
+
+	return position, err
+}
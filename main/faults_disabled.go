@@ -0,0 +1,6 @@
+//go:build !faultinjection
+
+package main
+
+// faultInjectionEnabled is unset in release builds, which ignore faultEnv.
+const faultInjectionEnabled = false
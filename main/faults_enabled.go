@@ -0,0 +1,6 @@
+//go:build faultinjection
+
+package main
+
+// faultInjectionEnabled is set in builds made with the faultinjection tag for QA.
+const faultInjectionEnabled = true
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"lukasolson.net/common"
+	"os"
+	"strings"
+)
+
+const adminTokenEnvironmentVariable = "EXEPY_ADMIN_TOKEN"
+
+// hashPolicy is the hash change policy an executable is checked against.
+type hashPolicy struct {
+	name           string
+	adminTokenHash string
+	// trustedSigners are the signers the allow-if-signed policy accepts.
+	trustedSigners []string
+}
+
+// effectiveHashPolicy prefers the policy recorded by the last accepted installer,
+// so a modified executable cannot relax the policy it is checked against.
+func effectiveHashPolicy(settings common.PythonSetupSettings, state *common.InstallState) hashPolicy {
+	if state.HashChangePolicy != "" {
+		return hashPolicy{name: state.HashChangePolicy, adminTokenHash: state.AdminTokenHash, trustedSigners: state.TrustedSigners}
+	}
+
+	return hashPolicy{name: settings.HashChangePolicy, adminTokenHash: settings.AdminTokenHash, trustedSigners: settings.TrustedSigners}
+}
+
+// acceptChangedHash applies the hash change policy to an executable whose hash differs from the accepted one.
+// It returns an error when the new hash must not be accepted.
+func acceptChangedHash(policy hashPolicy, executablePath, hash string) error {
+	switch policy.name {
+	case common.HashPolicyDenyAndExit:
+		return errors.New("this installation does not accept hash changes. Contact your distributor")
+
+	case common.HashPolicyAllowIfSigned:
+		signer, err := common.VerifySignature(executablePath)
+		if err != nil {
+			return fmt.Errorf("executable signature could not be verified: %w", err)
+		}
+
+		// any valid signature is not enough, since code signing certificates can be bought by anyone
+		if !common.SignerTrusted(signer, policy.trustedSigners) {
+			return fmt.Errorf("executable is signed by %q (%s), which is not a trusted signer", signer.Subject.CommonName, common.CertificateThumbprint(signer))
+		}
+
+		fmt.Println("Executable signature by", signer.Subject.CommonName, "verified. Accepting new hash.")
+		return nil
+
+	case common.HashPolicyAllowWithAdminToken:
+		if !isValidAdminToken(os.Getenv(adminTokenEnvironmentVariable), policy.adminTokenHash) {
+			return fmt.Errorf("set %s to a valid administrator token to accept the new hash", adminTokenEnvironmentVariable)
+		}
+
+		fmt.Println("Administrator token accepted. Accepting new hash.")
+		return nil
+
+	default:
+		printHashInstructions(executablePath, hash)
+
+		PressButtonToContinue("Press enter to accept the new hash and continue...")
+		return nil
+	}
+}
+
+// printHashInstructions asks the user to compare the hash of the executable with the one supplied by their
+// distributor, and shows how to compute it independently of the executable.
+func printHashInstructions(executablePath, hash string) {
+	fmt.Println("Please validate my SHA-256 hash with the one supplied by my distributor before continuing")
+	fmt.Println("While the hash is not a guarantee of safety, it is a good indicator of file integrity.")
+	fmt.Println("You can validate my hash by running the following command in the command line:")
+	fmt.Println("certutil -hashfile", common.QuoteCmdArg(executablePath), strings.ToUpper(common.HashAlgorithm))
+	fmt.Println("It should also match my self-reported hash:", hash)
+	fmt.Println("")
+	fmt.Println("Note: If three hash values do not match, the file may have been tampered with.")
+}
+
+func isValidAdminToken(token, expectedHash string) bool {
+	if token == "" || expectedHash == "" {
+		return false
+	}
+
+	tokenHash := sha256.Sum256([]byte(token))
+
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(tokenHash[:])), []byte(strings.ToLower(expectedHash))) == 1
+}
+
+func validateHashPolicy(settings *common.PythonSetupSettings) error {
+	switch settings.HashChangePolicy {
+	case "", common.HashPolicyAlwaysPrompt, common.HashPolicyDenyAndExit:
+		return nil
+	case common.HashPolicyAllowIfSigned:
+		if len(settings.TrustedSigners) == 0 {
+			return errors.New("trustedSigners is required for the allow-if-signed policy")
+		}
+		for _, signer := range settings.TrustedSigners {
+			if err := common.CheckThumbprint(signer); err != nil {
+				return fmt.Errorf("trustedSigners: %w", err)
+			}
+		}
+		return nil
+	case common.HashPolicyAllowWithAdminToken:
+		if settings.AdminTokenHash == "" {
+			return errors.New("adminTokenHash is required for the allow-with-admin-token policy")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown policy %q", settings.HashChangePolicy)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"image/png"
+	"lukasolson.net/common"
+	"os"
+)
+
+// qrModuleScale is the size in pixels of a QR code module in the saved image.
+const qrModuleScale = 8
+
+// installerIdentity is the text shown by --show-id and encoded in its QR code: the version of exepy that built
+// the installer and the hash of the installer, as recorded in hash.txt.
+func installerIdentity(exeHash string) string {
+	return fmt.Sprintf("exepy %s %s:%s", common.Version, common.HashAlgorithm, exeHash)
+}
+
+// printIdentity prints the identity of the installer with the given hash, followed by it as a QR code so it
+// can be checked by scanning instead of reading out the hash.
+func printIdentity(exeHash string) error {
+	identity := installerIdentity(exeHash)
+
+	code, err := common.EncodeQR(identity)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(identity)
+	fmt.Print(code.String())
+
+	return nil
+}
+
+// saveIdentityImage writes the identity QR code of the installer with the given hash to a PNG file.
+func saveIdentityImage(exeHash, path string) error {
+	code, err := common.EncodeQR(installerIdentity(exeHash))
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := png.Encode(file, code.Image(qrModuleScale)); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// showIdentity prints the identity of the running installer.
+func showIdentity() error {
+	executablePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	exeHash, err := common.HashFile(executablePath)
+	if err != nil {
+		return err
+	}
+
+	return printIdentity(exeHash)
+}
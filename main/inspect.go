@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"lukasolson.net/common"
+	"lukasolson.net/common/inspect"
+	"os"
+	"sort"
+)
+
+// inspectInstaller prints what is embedded in an installer and checks it against the embedded hash manifest,
+// without installing anything. With --deep the archives are also read through, so damage that happened before
+// the manifest was written, or a stream that does not decompress, is found too. It exits with a non-zero code if
+// any attachment does not match.
+// Usage: inspect installer.exe [--deep]
+func inspectInstaller(args []string) {
+	installerPath, args := splitPositional(args)
+
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	deep := flags.Bool("deep", false, "read every archive through and check its entries")
+	_ = flags.Parse(args)
+
+	if installerPath == "" {
+		fmt.Println("Usage: inspect <installer.exe> [--deep]")
+		return
+	}
+
+	installer, err := inspect.OpenInstaller(installerPath)
+	if errors.Is(err, inspect.ErrNotInstaller) {
+		fmt.Println(installerPath, "does not contain any attachments.")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Println("Error opening installer:", err)
+		os.Exit(1)
+	}
+	defer installer.Close()
+
+	manifest, err := inspect.ReadManifest(installer)
+	if err != nil {
+		fmt.Println("Error reading hash manifest:", err)
+	}
+
+	if manifest.Algorithm == "" {
+		manifest.Algorithm = common.HashAlgorithm
+	}
+
+	names := installer.Attachments()
+	sort.Strings(names)
+
+	fmt.Println("Attachments:")
+
+	valid := manifest.Hashes != nil
+	for _, name := range names {
+		hash, err := installer.HashWith(name, manifest.Algorithm)
+		if err != nil {
+			fmt.Println("Error hashing", name+":", err)
+			valid = false
+			continue
+		}
+
+		status := "ok"
+		switch expected, listed := manifest.Hashes[name]; {
+		case name == common.HashesEmbedName:
+			status = "manifest"
+		case !listed:
+			status = "NOT IN MANIFEST"
+			valid = false
+		case expected != hash:
+			status = "MISMATCH (expected " + expected + ")"
+			valid = false
+		}
+
+		fmt.Printf("  %-16s %12d bytes  %s  %s\n", name, installer.Size(name), hash, status)
+	}
+
+	// attachments listed in the manifest that are no longer embedded
+	for name := range manifest.Hashes {
+		if !contains(names, name) {
+			fmt.Printf("  %-16s MISSING\n", name)
+			valid = false
+		}
+	}
+
+	archivesValid := !*deep || verifyArchives(installer, manifest)
+
+	if settings, err := inspect.ReadSettings(installer); err != nil {
+		fmt.Println("Error reading settings:", err)
+	} else if settingsJSON, err := json.MarshalIndent(settings, "", "  "); err == nil {
+		fmt.Println("Settings:")
+		fmt.Println(string(settingsJSON))
+	}
+
+	if manifest.Metadata != nil {
+		if metadataJSON, err := json.MarshalIndent(manifest.Metadata, "", "  "); err == nil {
+			fmt.Println("Metadata:")
+			fmt.Println(string(metadataJSON))
+		}
+	}
+
+	if !valid {
+		fmt.Println("Hash manifest verification FAILED.")
+	}
+	if !archivesValid {
+		fmt.Println("Archive verification FAILED.")
+	}
+	if !valid || !archivesValid {
+		os.Exit(1)
+	}
+
+	fmt.Println("Hash manifest verified.")
+	if *deep {
+		fmt.Println("Archives verified.")
+	}
+}
+
+// verifyArchives reads every archive attachment of installer through and prints what each holds. The size of the
+// files is checked against the metadata recorded at build time, when there is one. It returns whether every
+// archive is intact.
+func verifyArchives(installer *inspect.Installer, manifest inspect.Manifest) bool {
+	fmt.Println("Archives:")
+
+	valid := true
+	for _, name := range []string{common.PythonFilename, common.WheelsFilename, common.PayloadFilename, common.RecoveryFilename} {
+		if !contains(installer.Attachments(), name) {
+			continue
+		}
+
+		report, err := inspect.VerifyArchive(installer, name)
+		if err != nil {
+			fmt.Printf("  %-16s DAMAGED: %v\n", name, err)
+			valid = false
+			continue
+		}
+
+		// prebuilt archives have no recorded size
+		if metadata, ok := manifest.Metadata[name]; ok && metadata.UncompressedSize != 0 && metadata.UncompressedSize != report.Size {
+			report.Problems = append(report.Problems, fmt.Sprintf("files total %d bytes, %d recorded at build time", report.Size, metadata.UncompressedSize))
+		}
+
+		status := "ok"
+		if len(report.Problems) > 0 {
+			status = "PROBLEMS"
+			valid = false
+		}
+
+		fmt.Printf("  %-16s %6d files %6d directories %12d bytes  %s\n", name, report.Files, report.Directories, report.Size, status)
+		for _, problem := range report.Problems {
+			fmt.Println("    " + problem)
+		}
+	}
+
+	return valid
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"lukasolson.net/common"
+	"os"
+	"time"
+)
+
+const (
+	// defaultInstallLog is used when settings do not name an install log.
+	defaultInstallLog = "install.log"
+	// installLogDisabled turns the install log off.
+	installLogDisabled = "none"
+)
+
+// installLog copies everything bootstrap writes to standard output and standard error into a log file, with the
+// time of each line, while still showing it on the console. Programs bootstrap runs write to the console directly,
+// so they can prompt and detect a terminal; they are not logged.
+type installLog struct {
+	file   *os.File
+	stdout *os.File
+	stderr *os.File
+	writer *os.File
+	done   chan struct{}
+}
+
+// activeInstallLog is the install log of this run, if one is open.
+var activeInstallLog *installLog
+
+// openInstallLog starts copying the output of this process to the install log configured in settings, appending
+// to it if it exists.
+func openInstallLog(settings common.PythonSetupSettings) error {
+	path := settings.InstallLog
+	if path == installLogDisabled {
+		return nil
+	}
+	if path == "" {
+		path = defaultInstallLog
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	log := &installLog{file: file, stdout: os.Stdout, stderr: os.Stderr, writer: writer, done: make(chan struct{})}
+
+	go log.copy(reader)
+
+	os.Stdout = writer
+	os.Stderr = writer
+	activeInstallLog = log
+
+	fmt.Println("exepy", common.Version, "installer started; logging to", path)
+	return nil
+}
+
+// copy shows what is written to the pipe on the console and appends it to the log file until the pipe is closed.
+func (log *installLog) copy(reader *os.File) {
+	defer close(log.done)
+	defer reader.Close()
+
+	lines := &timestampWriter{writer: log.file, lineStart: true}
+	_, _ = io.Copy(io.MultiWriter(log.stdout, lines), reader)
+}
+
+// closeInstallLog restores standard output and standard error and closes the install log once everything
+// written so far has been copied to it.
+func closeInstallLog() {
+	log := activeInstallLog
+	if log == nil {
+		return
+	}
+	activeInstallLog = nil
+
+	os.Stdout = log.stdout
+	os.Stderr = log.stderr
+
+	log.writer.Close()
+	<-log.done
+	log.file.Close()
+}
+
+// exitBootstrap closes the install log, so its last lines are not lost, and exits with code.
+func exitBootstrap(code int) {
+	closeInstallLog()
+	os.Exit(code)
+}
+
+// timestampWriter prefixes every line written to it with the current time.
+type timestampWriter struct {
+	writer    io.Writer
+	lineStart bool
+}
+
+func (w *timestampWriter) Write(data []byte) (int, error) {
+	var out bytes.Buffer
+	written := len(data)
+
+	for len(data) > 0 {
+		if w.lineStart {
+			out.WriteString(time.Now().Format(time.RFC3339))
+			out.WriteByte(' ')
+			w.lineStart = false
+		}
+
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			out.Write(data)
+			break
+		}
+
+		out.Write(data[:end+1])
+		data = data[end+1:]
+		w.lineStart = true
+	}
+
+	if _, err := w.writer.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return written, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/maja42/ember"
+	"lukasolson.net/common"
+	"os"
+	"path/filepath"
+)
+
+// chooseInstallRoot moves the installation to a writable directory when the installer runs from a read-only
+// location, such as a CD, an ISO image or a read-only share, where the state store, logs and installed files
+// cannot be written. The location is read-only if the directory of the executable or the working directory
+// cannot be written. It returns the new root, or "" if the working directory is kept.
+func chooseInstallRoot() (string, error) {
+	executablePath, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+
+	if isWritableDir(filepath.Dir(executablePath)) && isWritableDir(".") {
+		return "", nil
+	}
+
+	root, err := fallbackInstallRoot()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	if err := os.Chdir(root); err != nil {
+		return "", err
+	}
+
+	return root, nil
+}
+
+// isWritableDir reports whether a file can be created in dir.
+func isWritableDir(dir string) bool {
+	file, err := os.CreateTemp(dir, ".exepy-write-check-*")
+	if err != nil {
+		return false
+	}
+
+	file.Close()
+	os.Remove(file.Name())
+	return true
+}
+
+// fallbackInstallRoot returns the directory installations from read-only media go to: a directory named after
+// the product in the local application data of the user, so later runs from the same media find it again.
+func fallbackInstallRoot() (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	name := productName(common.PythonSetupSettings{})
+	if attachments, err := ember.Open(); err == nil {
+		if settings, err := GetSettings(attachments); err == nil {
+			name = productName(settings)
+		}
+		attachments.Close()
+	}
+
+	return filepath.Join(base, "exepy", name), nil
+}
+
+// acceptedHashFile returns the hash.txt holding the accepted hash of the executable: the one in the installation
+// directory, or else the one shipped next to the executable, which is where it is on read-only media the
+// installation was moved away from. It returns "" if there is neither.
+func acceptedHashFile(executablePath string) string {
+	for _, hashPath := range []string{"hash.txt", filepath.Join(filepath.Dir(executablePath), "hash.txt")} {
+		if common.DoesPathExist(hashPath) {
+			return hashPath
+		}
+	}
+
+	return ""
+}
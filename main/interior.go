@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/maja42/ember"
+	"lukasolson.net/common"
+	"os"
+	"path/filepath"
+)
+
+// interiorProgressInterval is how many files are checked between progress updates.
+const interiorProgressInterval = 250
+
+// repairInteriorFiles checks the standard library files extracted from the interior zip at build time against
+// the manifest recorded then, and extracts the missing, modified or unreadable ones from the Python attachment
+// again. Hashes are only compared when checkHashes is set; otherwise sizes are, which is cheap enough for every
+// launch.
+// Installers built before the manifest was recorded are not checked.
+func repairInteriorFiles(attachments *ember.Attachments, settings common.PythonSetupSettings, checkHashes bool) error {
+	manifestPath := filepath.Join(settings.PythonExtractDir, common.InteriorManifestFilename)
+	if !common.DoesPathExist(manifestPath) {
+		return nil
+	}
+
+	manifest, err := common.LoadInteriorManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	// Missing files are extracted again. Modified and unreadable ones are removed first, so one that is locked or
+	// read-only is reported on its own instead of failing the extraction of the rest.
+	var restore []string
+	counts := make(map[common.FileStatus]int)
+	manifest.CheckFiles(settings.PythonExtractDir, checkHashes, func(check common.FileCheck) bool {
+		if check.Status != common.FileOK {
+			counts[check.Status]++
+		}
+
+		switch check.Status {
+		case common.FileMissing:
+			restore = append(restore, check.Name)
+		case common.FileModified, common.FileUnreadable:
+			if err := os.RemoveAll(filepath.Join(settings.PythonExtractDir, filepath.FromSlash(check.Name))); err != nil {
+				fmt.Println("Error removing damaged file", check.Name+":", err)
+			} else {
+				restore = append(restore, check.Name)
+			}
+		}
+
+		// Hashing the whole standard library takes a while, so show how far along it is
+		if checkHashes && (check.Checked%interiorProgressInterval == 0 || check.Checked == check.Total) {
+			fmt.Printf("\rChecking Python standard library files: %d/%d", check.Checked, check.Total)
+			if check.Checked == check.Total {
+				fmt.Println()
+			}
+		}
+		return true
+	})
+	if len(counts) == 0 {
+		return nil
+	}
+
+	if len(restore) > 0 {
+		fmt.Printf("Restoring %d Python standard library files from %s (%d missing, %d modified, %d unreadable)\n",
+			len(restore), manifest.Zip, counts[common.FileMissing], counts[common.FileModified], counts[common.FileUnreadable])
+
+		reader := attachments.Reader(common.PythonFilename)
+		if reader == nil {
+			return errors.New("python is not embedded in the installer")
+		}
+
+		if err := common.DecompressIOStreamNames(reader, settings.PythonExtractDir, settings.CompressionFormat, restore); err != nil {
+			return err
+		}
+	}
+
+	if damaged := manifest.DamagedFiles(settings.PythonExtractDir, true); len(damaged) > 0 {
+		return fmt.Errorf("%d files are still damaged after restoring them, starting with %s", len(damaged), damaged[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/maja42/ember"
+	"io"
+	"lukasolson.net/common"
+	"net/http"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// confirmLicense shows the license embedded in the installer and asks for it to be accepted, unless this
+// installation has already accepted the same text. Unattended runs accept it with --accept-license. The
+// acceptance is recorded in the state store and sent to licenseEndpoint if one is configured; a report that
+// could not be sent is retried on the next run. It returns false if the license was declined.
+func confirmLicense(attachments *ember.Attachments, settings common.PythonSetupSettings, options bootstrapOptions, state *common.InstallState, exeHash string, report eventReporter) bool {
+	reader := attachments.Reader(common.LicenseEmbedName)
+	if reader == nil {
+		return true
+	}
+
+	text, err := io.ReadAll(reader)
+	if err != nil {
+		fmt.Println("Error reading license:", err)
+		return false
+	}
+
+	licenseHash, err := common.HashReader(bytes.NewReader(text))
+	if err != nil {
+		fmt.Println("Error hashing license:", err)
+		return false
+	}
+
+	if state.License != nil && state.License.LicenseHash == licenseHash {
+		if !state.License.Reported && settings.LicenseEndpoint != "" {
+			recordLicenseReport(settings, state)
+		}
+		return true
+	}
+
+	fmt.Println(string(text))
+	fmt.Println()
+
+	if options.acceptLicense {
+		fmt.Println("License accepted with --accept-license.")
+	} else {
+		fmt.Print("Do you accept the license? [y/N] ")
+
+		answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			fmt.Println()
+			return false
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+		default:
+			return false
+		}
+	}
+
+	state.License = &common.LicenseAcceptance{
+		User:             currentUser(),
+		Timestamp:        time.Now().UTC(),
+		Product:          productName(settings),
+		InstallerVersion: settings.FileVersion,
+		ToolVersion:      common.Version,
+		ExecutableHash:   exeHash,
+		LicenseHash:      licenseHash,
+	}
+
+	report(eventLicenseAccepted, exeHash, "accepted by "+state.License.User)
+
+	if settings.LicenseEndpoint != "" {
+		recordLicenseReport(settings, state)
+	} else if err := common.SaveState(common.StateFilename, state); err != nil {
+		fmt.Println("Error saving state:", err)
+	}
+
+	return true
+}
+
+// recordLicenseReport sends the license acceptance in state to licenseEndpoint and saves whether it was sent.
+func recordLicenseReport(settings common.PythonSetupSettings, state *common.InstallState) {
+	if err := postLicenseAcceptance(settings.LicenseEndpoint, state.License); err != nil {
+		fmt.Println("Error reporting license acceptance, will retry on the next run:", err)
+	} else {
+		state.License.Reported = true
+	}
+
+	if err := common.SaveState(common.StateFilename, state); err != nil {
+		fmt.Println("Error saving state:", err)
+	}
+}
+
+// postLicenseAcceptance sends the acceptance as JSON to endpoint.
+func postLicenseAcceptance(endpoint string, acceptance *common.LicenseAcceptance) error {
+	data, err := json.Marshal(acceptance)
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: 15 * time.Second}
+	response, err := client.Post(endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("license endpoint returned %s", response.Status)
+	}
+
+	return nil
+}
+
+// currentUser returns the name of the user running the installer, including the domain on Windows.
+func currentUser() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+
+	for _, name := range []string{"USERNAME", "USER"} {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+
+	return "unknown"
+}
@@ -4,6 +4,7 @@ import (
 	_ "embed"
 	"fmt"
 	"github.com/maja42/ember"
+	"os"
 )
 
 func main() {
@@ -16,10 +17,46 @@ func main() {
 
 	if embedded {
 		fmt.Println("Embedded. Running in installer mode.")
-		bootstrap()
+		if code := bootstrap(); code != exitCodeSuccess {
+			exitBootstrap(code)
+		}
 	} else {
 		fmt.Println("Not embedded. Running in creator mode.")
-		createInstaller()
+		runCreator(os.Args[1:])
+	}
+}
+
+func runCreator(args []string) {
+	if len(args) == 0 {
+		createInstaller(args)
+		return
+	}
+
+	switch args[0] {
+	case "build":
+		createInstaller(args[1:])
+	case "replace-payload":
+		replacePayload(args[1:])
+	case "verify-kit":
+		exportVerifyKit(args[1:])
+	case "verify":
+		verifyWithKit(args[1:])
+	case "inspect":
+		inspectInstaller(args[1:])
+	case "extract":
+		extractInstaller(args[1:])
+	case "import-env":
+		importEnvironment(args[1:])
+	case "sign-overrides":
+		signOverrides(args[1:])
+	case "build-script-update":
+		buildScriptUpdate(args[1:])
+	case "test":
+		testInstaller(args[1:])
+	case "deploy":
+		deployInstaller(args[1:])
+	default:
+		createInstaller(args)
 	}
 }
 
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"lukasolson.net/common"
+	"os"
+	"strings"
+	"time"
+)
+
+// legacyHashFilename is where earlier releases saved the accepted executable hash, which they then looked for
+// in hash.txt.
+const legacyHashFilename = "hash"
+
+// migrateLegacyInstall brings an installation set up by an earlier release up to the current layout in place,
+// so it is upgraded without extracting anything again: the accepted hash is moved to hash.txt, where its MD5
+// hash is then replaced on the next check, and a plain text bootstrap marker is rewritten with the hash of the
+// executable that set it up. What was changed is recorded in the state store.
+func migrateLegacyInstall(state *common.InstallState) error {
+	var changes []string
+
+	if common.DoesPathExist(legacyHashFilename) && !common.DoesPathExist("hash.txt") {
+		if err := os.Rename(legacyHashFilename, "hash.txt"); err != nil {
+			return err
+		}
+		changes = append(changes, "moved the accepted hash from "+legacyHashFilename+" to hash.txt")
+	}
+
+	marker, err := common.ReadBootstrapMarker(common.BootstrapMarkerFilename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if marker != nil && marker.ToolVersion == common.LegacyToolVersion {
+		// earlier releases saved the hash of the executable on every run, so it is the one that set them up
+		if acceptedHash, err := os.ReadFile("hash.txt"); err == nil {
+			marker.ExecutableHash = strings.TrimSpace(string(acceptedHash))
+			marker.Algorithm = common.HashAlgorithmOf(marker.ExecutableHash)
+		}
+
+		if err := common.WriteBootstrapMarker(common.BootstrapMarkerFilename, marker); err != nil {
+			return err
+		}
+		changes = append(changes, "rewrote the plain text bootstrap marker")
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	fmt.Println("Migrated installation from an earlier exepy release:", strings.Join(changes, "; "))
+
+	state.Migration = &common.Migration{Timestamp: time.Now().UTC(), Changes: changes}
+	return common.SaveState(common.StateFilename, state)
+}
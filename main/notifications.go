@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lukasolson.net/common"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+)
+
+// setupCompletion is what notification channels are told when first time setup finishes.
+type setupCompletion struct {
+	Product         string  `json:"product"`
+	Status          string  `json:"status"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	Host            string  `json:"host"`
+	ExecutableHash  string  `json:"executableHash,omitempty"`
+	Detail          string  `json:"detail,omitempty"`
+}
+
+// Statuses of a setupCompletion.
+const (
+	setupSucceeded = "succeeded"
+	setupFailed    = "failed"
+)
+
+// notifiers send a completion to a notification channel, by channel type.
+var notifiers = map[string]func(notification common.Notification, completion setupCompletion) error{
+	common.NotificationToast:   notifyToast,
+	common.NotificationWebhook: notifyWebhook,
+	common.NotificationSlack:   notifySlack,
+}
+
+// validateNotifications checks that every notification in settings has a known type and the URL it needs.
+func validateNotifications(settings *common.PythonSetupSettings) error {
+	for _, notification := range settings.Notifications {
+		if _, ok := notifiers[notification.Type]; !ok {
+			return fmt.Errorf("unknown notification type %q", notification.Type)
+		}
+
+		if notification.Type != common.NotificationToast && notification.URL == "" {
+			return fmt.Errorf("%s notification needs a url", notification.Type)
+		}
+	}
+
+	return nil
+}
+
+// notifyCompletion tells every notification channel in settings that first time setup finished. Failures are
+// printed but never change the outcome of the installation.
+func notifyCompletion(settings common.PythonSetupSettings, completion setupCompletion) {
+	for _, notification := range settings.Notifications {
+		if notification.OnlyOnFailure && completion.Status != setupFailed {
+			continue
+		}
+		if completion.DurationSeconds < float64(notification.MinDurationSeconds) {
+			continue
+		}
+
+		notify, ok := notifiers[notification.Type]
+		if !ok {
+			fmt.Println("Error sending notification: unknown notification type", notification.Type)
+			continue
+		}
+
+		if err := notify(notification, completion); err != nil {
+			fmt.Println("Error sending", notification.Type, "notification:", err)
+		}
+	}
+}
+
+// newSetupCompletion describes a first time setup of the product in settings that took duration.
+func newSetupCompletion(settings common.PythonSetupSettings, succeeded bool, duration time.Duration, exeHash, detail string) setupCompletion {
+	status := setupFailed
+	if succeeded {
+		status = setupSucceeded
+	}
+
+	host, _ := os.Hostname()
+
+	return setupCompletion{
+		Product:         productName(settings),
+		Status:          status,
+		DurationSeconds: duration.Round(time.Second).Seconds(),
+		Host:            host,
+		ExecutableHash:  exeHash,
+		Detail:          detail,
+	}
+}
+
+// message returns a one-line summary of the completion for people.
+func (completion setupCompletion) message() string {
+	message := fmt.Sprintf("%s setup %s on %s after %s", completion.Product, completion.Status, completion.Host,
+		time.Duration(completion.DurationSeconds)*time.Second)
+	if completion.Detail != "" {
+		message += ": " + completion.Detail
+	}
+
+	return message
+}
+
+const toastScript = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode(%s)) > $null
+$text.Item(1).AppendChild($template.CreateTextNode(%s)) > $null
+$notifier = [Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe')
+$notifier.Show([Windows.UI.Notifications.ToastNotification]::new($template))
+`
+
+// notifyToast shows a Windows toast notification through PowerShell, which is registered to show them.
+func notifyToast(notification common.Notification, completion setupCompletion) error {
+	if runtime.GOOS != "windows" {
+		return errors.New("toast notifications are only supported on Windows")
+	}
+
+	title := fmt.Sprintf("%s setup %s", completion.Product, completion.Status)
+	script := fmt.Sprintf(toastScript, common.QuotePowerShellLiteral(title), common.QuotePowerShellLiteral(completion.message()))
+
+	return common.RunCommand("powershell", []string{"-NoProfile", "-NonInteractive", "-Command", script})
+}
+
+// notifyWebhook posts the completion as JSON to the notification URL.
+func notifyWebhook(notification common.Notification, completion setupCompletion) error {
+	return postNotification(notification.URL, completion)
+}
+
+// notifySlack posts the completion as a message to a Slack incoming webhook.
+func notifySlack(notification common.Notification, completion setupCompletion) error {
+	return postNotification(notification.URL, map[string]string{"text": completion.message()})
+}
+
+func postNotification(url string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: 15 * time.Second}
+	response, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("notification endpoint returned %s", response.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"lukasolson.net/common"
+	"strings"
+)
+
+// bootstrapOptions are the flags bootstrap consumes itself. Everything else is passed to the payload script.
+type bootstrapOptions struct {
+	prewarm            bool
+	skipPip            bool
+	skipSetupScript    bool
+	skipIntegrity      bool
+	forceExtract       bool
+	listBackups        bool
+	restoreBackup      string
+	extractTo          string
+	acceptCapabilities bool
+	showID             bool
+	acceptLicense      bool
+	secrets            []string
+	parameters         map[string]string
+	uninstall          bool
+	exportEnv          string
+	extractFilter      common.EntryFilter
+}
+
+// parseBootstrapArgs consumes leading bootstrap flags from args and returns the remaining payload arguments.
+// Parsing stops at the first unrecognised argument, or after an explicit "--". An option that takes a value but is
+// given none is an error, so a truncated command line does not fall through to a full install.
+func parseBootstrapArgs(args []string) (bootstrapOptions, []string, error) {
+	var options bootstrapOptions
+
+	for i := 0; i < len(args); i++ {
+		// options that take a value accept both "--name value" and "--name=value"
+		if name, value, hasValue := strings.Cut(args[i], "="); optionTakesValue(name) {
+			if !hasValue && i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+			if value == "" {
+				return options, nil, fmt.Errorf("%s requires a value", name)
+			}
+
+			switch name {
+			case "--restore-backup":
+				options.restoreBackup = value
+			case "--extract-to":
+				options.extractTo = value
+			case "--export-env":
+				options.exportEnv = value
+			case "--include":
+				options.extractFilter.Include = append(options.extractFilter.Include, value)
+			case "--exclude":
+				options.extractFilter.Exclude = append(options.extractFilter.Exclude, value)
+			case "--secret":
+				options.secrets = append(options.secrets, value)
+			case "--param":
+				if options.parameters == nil {
+					options.parameters = make(map[string]string)
+				}
+				parameterName, parameterValue, _ := strings.Cut(value, "=")
+				options.parameters[parameterName] = parameterValue
+			}
+			continue
+		}
+
+		switch args[i] {
+		case "--prewarm":
+			options.prewarm = true
+		case "--skip-pip":
+			options.skipPip = true
+		case "--skip-setup-script":
+			options.skipSetupScript = true
+		case "--skip-integrity":
+			options.skipIntegrity = true
+		case "--force-extract":
+			options.forceExtract = true
+		case "--list-backups":
+			options.listBackups = true
+		case "--accept-capabilities":
+			options.acceptCapabilities = true
+		case "--show-id":
+			options.showID = true
+		case "--accept-license":
+			options.acceptLicense = true
+		case "--uninstall":
+			options.uninstall = true
+		case "--":
+			return options, args[i+1:], nil
+		default:
+			return options, args[i:], nil
+		}
+	}
+
+	return options, nil, nil
+}
+
+func optionTakesValue(name string) bool {
+	switch name {
+	case "--restore-backup", "--extract-to", "--secret", "--param", "--export-env", "--include", "--exclude":
+		return true
+	}
+
+	return false
+}
+
+// overrides lists the troubleshooting flags in effect, for logging and the state store.
+func (options bootstrapOptions) overrides() []string {
+	var overrides []string
+
+	if options.skipPip {
+		overrides = append(overrides, "--skip-pip")
+	}
+	if options.skipSetupScript {
+		overrides = append(overrides, "--skip-setup-script")
+	}
+	if options.skipIntegrity {
+		overrides = append(overrides, "--skip-integrity")
+	}
+	if options.forceExtract {
+		overrides = append(overrides, "--force-extract")
+	}
+
+	return overrides
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"lukasolson.net/common"
+	"os"
+	"strings"
+)
+
+// applyOverrides fetches the signed override document configured in settings, verifies that it is signed for
+// this product and has not expired, and applies its overrides to settings and the environment. Nothing is changed
+// unless the document is valid. The install log is reopened if the overrides move it.
+func applyOverrides(settings *common.PythonSetupSettings) error {
+	config := settings.OverrideConfig
+	if config == nil || config.Location == "" {
+		return nil
+	}
+
+	fmt.Println("Fetching configuration overrides from", config.Location)
+
+	data, err := fetchSource(config.Location)
+	if err != nil {
+		return err
+	}
+
+	overrides, err := common.VerifyOverrides(data, settings.TrustStore, productName(*settings))
+	if err != nil {
+		return err
+	}
+
+	if overrides.Proxy != "" {
+		os.Setenv("HTTP_PROXY", overrides.Proxy)
+		os.Setenv("HTTPS_PROXY", overrides.Proxy)
+	}
+	if overrides.NoProxy != "" {
+		os.Setenv("NO_PROXY", overrides.NoProxy)
+	}
+	if overrides.LicenseEndpoint != "" {
+		settings.LicenseEndpoint = overrides.LicenseEndpoint
+	}
+	if overrides.TempDir != "" {
+		settings.TempDir = overrides.TempDir
+	}
+	if overrides.InstallLog != "" && overrides.InstallLog != settings.InstallLog {
+		settings.InstallLog = overrides.InstallLog
+
+		closeInstallLog()
+		if err := openInstallLog(*settings); err != nil {
+			fmt.Println("Error opening install log:", err)
+		}
+	}
+	if overrides.UsageLog != "" {
+		settings.UsageLog = overrides.UsageLog
+	}
+
+	if len(overrides.Environment) > 0 {
+		environment := make(map[string]string, len(settings.Environment)+len(overrides.Environment))
+		for name, value := range settings.Environment {
+			environment[name] = value
+		}
+		for name, value := range overrides.Environment {
+			environment[name] = value
+		}
+		settings.Environment = environment
+	}
+
+	fmt.Println("Configuration overrides applied.")
+	return nil
+}
+
+// validateTrustStore checks the keys of the trust store, and that it has a key for every kind of signed document
+// the settings accept, since documents signed for a role without keys would always be rejected.
+func validateTrustStore(settings *common.PythonSetupSettings) error {
+	if settings.TrustStore != nil {
+		if err := settings.TrustStore.Check(); err != nil {
+			return err
+		}
+	}
+
+	if settings.OverrideConfig != nil && !settings.TrustStore.HasRole(common.TrustRoleOverrides) {
+		return errors.New("overrideConfig needs a key with the overrides role in trustStore")
+	}
+
+	if settings.ScriptUpdates != nil && !settings.TrustStore.HasRole(common.TrustRoleUpdate) && !settings.TrustStore.HasRole(common.TrustRoleHotfix) {
+		return errors.New("scriptUpdates needs a key with the update or hotfix role in trustStore")
+	}
+
+	return nil
+}
+
+// signOverrides signs an override document for bootstrap to fetch, or generates a key pair to sign with.
+// Usage: sign-overrides overrides.json --key private.key --out signed.json, or sign-overrides --generate-key private.key
+func signOverrides(args []string) {
+	overridesPath, args := splitPositional(args)
+
+	flags := flag.NewFlagSet("sign-overrides", flag.ExitOnError)
+	keyPath := flags.String("key", "", "file holding the base64-encoded Ed25519 private key")
+	outputPath := flags.String("out", "overrides.signed.json", "file to write the signed document to")
+	generateKey := flags.String("generate-key", "", "generate a key pair, writing the private key to this file")
+	_ = flags.Parse(args)
+
+	if *generateKey != "" {
+		publicKey, privateKey, err := common.GenerateOverrideKey()
+		if err != nil {
+			println("Error generating key: ", err.Error())
+			return
+		}
+
+		if err := os.WriteFile(*generateKey, []byte(privateKey+"\n"), 0600); err != nil {
+			println("Error saving private key: ", err.Error())
+			return
+		}
+
+		fmt.Println("Private key written to", *generateKey+". Keep it secret.")
+		fmt.Println("Public key for the trust store:", publicKey)
+		return
+	}
+
+	if overridesPath == "" || *keyPath == "" {
+		fmt.Println("Usage: sign-overrides <overrides.json> --key <private key> --out <signed.json>")
+		fmt.Println("       sign-overrides --generate-key <private key>")
+		return
+	}
+
+	data, err := os.ReadFile(overridesPath)
+	if err != nil {
+		println("Error reading overrides: ", err.Error())
+		return
+	}
+
+	privateKey, err := os.ReadFile(*keyPath)
+	if err != nil {
+		println("Error reading private key: ", err.Error())
+		return
+	}
+
+	signed, err := common.SignOverrides(data, strings.TrimSpace(string(privateKey)))
+	if err != nil {
+		println("Error signing overrides: ", err.Error())
+		return
+	}
+
+	if err := os.WriteFile(*outputPath, signed, 0644); err != nil {
+		println("Error saving signed overrides: ", err.Error())
+		return
+	}
+
+	fmt.Println("Signed overrides written to", *outputPath)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"lukasolson.net/common"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// provideParameters resolves the parameters declared in settings and returns the arguments to pass to the main
+// script for them; those passed as environment variables are set for the setup and main scripts. A value is
+// taken from --param NAME=value, then, for install-time parameters outside first time setup, from the value
+// recorded at setup, and is otherwise asked for. Install-time values are recorded in the state store.
+func provideParameters(settings common.PythonSetupSettings, options bootstrapOptions, state *common.InstallState, needsSetup bool) ([]string, error) {
+	if len(settings.Parameters) == 0 {
+		return nil, nil
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	changed := false
+
+	var args []string
+	for _, parameter := range settings.Parameters {
+		install := parameter.When == common.ParameterWhenInstall
+
+		// --prewarm does not run the main script, so it has no use for run-time values
+		if options.prewarm && !install {
+			continue
+		}
+
+		value, given := options.parameters[parameter.Name]
+		stored, recorded := state.Parameters[parameter.Name]
+
+		var err error
+		switch {
+		case given:
+			value, err = checkParameterValue(parameter, value)
+			if err != nil {
+				return nil, fmt.Errorf("--param %s: %w", parameter.Name, err)
+			}
+		case install && recorded && !needsSetup:
+			value = stored
+		default:
+			value, err = promptParameter(reader, parameter)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if install && (!recorded || stored != value) {
+			if state.Parameters == nil {
+				state.Parameters = make(map[string]string)
+			}
+			state.Parameters[parameter.Name] = value
+			changed = true
+		}
+
+		if value == "" {
+			continue
+		}
+
+		env := parameter.Env
+		if env == "" && parameter.Arg == "" {
+			env = parameter.Name
+		}
+		if env != "" {
+			if err := os.Setenv(env, value); err != nil {
+				return nil, err
+			}
+		}
+
+		switch {
+		case parameter.Arg == "":
+		case parameter.Type == common.ParameterBool:
+			if value == "true" {
+				args = append(args, parameter.Arg)
+			}
+		default:
+			args = append(args, parameter.Arg, value)
+		}
+	}
+
+	if changed {
+		if err := common.SaveState(common.StateFilename, state); err != nil {
+			fmt.Println("Error saving state:", err)
+		}
+	}
+
+	return args, nil
+}
+
+// promptParameter asks for the value of parameter until a valid one is given. An empty answer takes the default.
+// If nobody can answer, the default is used, or an error returned for required parameters without one.
+func promptParameter(reader *bufio.Reader, parameter common.Parameter) (string, error) {
+	prompt := parameter.Name
+	if parameter.Description != "" {
+		prompt = parameter.Description + " (" + parameter.Name + ")"
+	}
+	if len(parameter.Choices) > 0 {
+		prompt += " {" + strings.Join(parameter.Choices, ", ") + "}"
+	} else if parameter.Type == common.ParameterBool {
+		prompt += " {yes, no}"
+	}
+	if parameter.Default != "" {
+		prompt += " [" + parameter.Default + "]"
+	} else if parameter.Optional {
+		prompt += " [optional]"
+	}
+
+	for {
+		fmt.Print(prompt + ": ")
+
+		answer, err := reader.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+
+		noAnswer := errors.Is(err, io.EOF) && answer == ""
+		if noAnswer {
+			fmt.Println()
+		}
+		if answer == "" {
+			answer = parameter.Default
+		}
+
+		if answer == "" && !parameter.Optional {
+			if noAnswer {
+				return "", fmt.Errorf("%s is required; pass --param %s=value", parameter.Name, parameter.Name)
+			}
+			fmt.Println("A value is required.")
+			continue
+		}
+
+		value, err := checkParameterValue(parameter, answer)
+		if err == nil {
+			return value, nil
+		}
+		if noAnswer {
+			return "", fmt.Errorf("%s: %w", parameter.Name, err)
+		}
+
+		fmt.Println(err)
+	}
+}
+
+// checkParameterValue checks value against the type and pattern of parameter and returns it in the form passed
+// to the payload: bools as true or false, and paths made absolute.
+func checkParameterValue(parameter common.Parameter, value string) (string, error) {
+	if value == "" {
+		if parameter.Optional {
+			return "", nil
+		}
+		return "", errors.New("a value is required")
+	}
+
+	switch parameter.Type {
+	case common.ParameterInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return "", fmt.Errorf("%q is not a whole number", value)
+		}
+	case common.ParameterBool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			switch strings.ToLower(value) {
+			case "y", "yes":
+				parsed = true
+			case "n", "no":
+				parsed = false
+			default:
+				return "", fmt.Errorf("%q is not yes or no", value)
+			}
+		}
+		value = strconv.FormatBool(parsed)
+	case common.ParameterPath:
+		path, err := filepath.Abs(os.ExpandEnv(value))
+		if err != nil {
+			return "", err
+		}
+		if !common.DoesPathExist(path) {
+			return "", fmt.Errorf("%s does not exist", path)
+		}
+		value = path
+	case common.ParameterChoice:
+		if !contains(parameter.Choices, value) {
+			return "", fmt.Errorf("%q is not one of %s", value, strings.Join(parameter.Choices, ", "))
+		}
+	}
+
+	if parameter.Pattern != "" {
+		pattern, err := regexp.Compile("^(?:" + parameter.Pattern + ")$")
+		if err != nil {
+			return "", err
+		}
+		if !pattern.MatchString(value) {
+			return "", fmt.Errorf("%q does not match %s", value, parameter.Pattern)
+		}
+	}
+
+	return value, nil
+}
+
+// validateParameters checks the parameters declared in settings at build time, including that their defaults
+// are valid values. Path defaults are not checked, since they only need to exist on the user's machine.
+func validateParameters(settings *common.PythonSetupSettings) error {
+	seen := make(map[string]bool)
+
+	for _, parameter := range settings.Parameters {
+		if parameter.Name == "" {
+			return errors.New("every parameter needs a name")
+		}
+		if seen[parameter.Name] {
+			return fmt.Errorf("parameter %s is declared more than once", parameter.Name)
+		}
+		seen[parameter.Name] = true
+
+		switch parameter.Type {
+		case "", common.ParameterString, common.ParameterInt, common.ParameterBool, common.ParameterPath:
+		case common.ParameterChoice:
+			if len(parameter.Choices) == 0 {
+				return fmt.Errorf("choice parameter %s has no choices", parameter.Name)
+			}
+		default:
+			return fmt.Errorf("parameter %s has unknown type %q", parameter.Name, parameter.Type)
+		}
+
+		switch parameter.When {
+		case "", common.ParameterWhenRun, common.ParameterWhenInstall:
+		default:
+			return fmt.Errorf("parameter %s has unknown stage %q", parameter.Name, parameter.When)
+		}
+
+		if parameter.Pattern != "" {
+			if _, err := regexp.Compile(parameter.Pattern); err != nil {
+				return fmt.Errorf("parameter %s: %w", parameter.Name, err)
+			}
+		}
+
+		if parameter.Default != "" && parameter.Type != common.ParameterPath {
+			if _, err := checkParameterValue(parameter, parameter.Default); err != nil {
+				return fmt.Errorf("default of parameter %s: %w", parameter.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
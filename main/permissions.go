@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"github.com/maja42/ember"
+	"lukasolson.net/common"
+	"path/filepath"
+	"strings"
+)
+
+// hardenInstallation tightens the permissions of what first time setup installed, the Python installation and
+// the payload files, so only administrators can change them. The directory of the installer is left as it is,
+// since the installer writes its hash, state and logs there on every run.
+func hardenInstallation(attachments *ember.Attachments, settings common.PythonSetupSettings) error {
+	names, err := common.ListArchive(attachments.Reader(common.PayloadFilename), settings.CompressionFormat)
+	if err != nil {
+		return err
+	}
+
+	paths := []string{settings.PythonExtractDir}
+	seen := map[string]bool{settings.PythonExtractDir: true}
+	for _, name := range names {
+		topLevel, _, _ := strings.Cut(name, "/")
+		if topLevel != "" && topLevel != "." && !seen[topLevel] {
+			seen[topLevel] = true
+			paths = append(paths, filepath.FromSlash(topLevel))
+		}
+	}
+
+	for _, path := range paths {
+		if !common.DoesPathExist(path) {
+			continue
+		}
+
+		if err := common.HardenPermissions(path); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"lukasolson.net/common"
+	"os"
+	"path/filepath"
+)
+
+// pipConfigFilename is the pip configuration file read from the root of the Python installation.
+const pipConfigFilename = "pip.ini"
+
+// embeddedWheelsDir returns the absolute path of the wheelhouse extracted from the installer.
+func embeddedWheelsDir(settings common.PythonSetupSettings) (string, error) {
+	return filepath.Abs(filepath.Join(settings.PythonExtractDir, common.WheelsFilename))
+}
+
+// writePipConfig points pip at the embedded wheelhouse in the site configuration of the installed Python, so
+// later pip installs reuse the embedded wheels before downloading anything.
+func writePipConfig(settings common.PythonSetupSettings) error {
+	wheelsDir, err := embeddedWheelsDir(settings)
+	if err != nil {
+		return err
+	}
+
+	config := fmt.Sprintf("[global]\nfind-links = %s\n", wheelsDir)
+	return os.WriteFile(filepath.Join(settings.PythonExtractDir, pipConfigFilename), []byte(config), 0644)
+}
+
+// exposeEmbeddedWheels sets PIP_FIND_LINKS to the embedded wheelhouse for the script, unless it is already set,
+// so virtual environments the script creates, which do not read the site configuration, reuse the wheels too.
+func exposeEmbeddedWheels(settings common.PythonSetupSettings) error {
+	if _, set := os.LookupEnv("PIP_FIND_LINKS"); set {
+		return nil
+	}
+
+	wheelsDir, err := embeddedWheelsDir(settings)
+	if err != nil || !common.DoesPathExist(wheelsDir) {
+		return err
+	}
+
+	return os.Setenv("PIP_FIND_LINKS", wheelsDir)
+}
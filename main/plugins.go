@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/maja42/ember"
+	"io"
+	"lukasolson.net/common"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pluginDir is where plugins are extracted before they run.
+const pluginDir = ".exepy-plugins"
+
+// validatePlugins checks that every plugin in settings has a unique name, a known stage and a file to embed.
+func validatePlugins(settings *common.PythonSetupSettings) error {
+	names := make(map[string]bool)
+
+	for _, plugin := range settings.Plugins {
+		if plugin.Name == "" {
+			return errors.New("plugin without a name")
+		}
+		if names[plugin.Name] {
+			return fmt.Errorf("plugin %s is listed more than once", plugin.Name)
+		}
+		names[plugin.Name] = true
+
+		switch plugin.When {
+		case common.PluginPreInstall, common.PluginOnFailure, common.PluginOnUninstall:
+		default:
+			return fmt.Errorf("plugin %s has unknown stage %q", plugin.Name, plugin.When)
+		}
+
+		if !common.DoesPathExist(plugin.Path) {
+			return fmt.Errorf("plugin %s file does not exist: %s", plugin.Name, plugin.Path)
+		}
+	}
+
+	return nil
+}
+
+// embedPlugins opens the file of every plugin in settings and adds it to extras, with its metadata.
+func embedPlugins(settings *common.PythonSetupSettings, extras map[string]io.ReadSeeker, metadata map[string]common.AttachmentMetadata) error {
+	for _, plugin := range settings.Plugins {
+		file, err := os.Open(plugin.Path)
+		if err != nil {
+			return err
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		name := common.PluginEmbedName(plugin.Name)
+		extras[name] = file
+		metadata[name] = common.NewFileMetadata(common.AttachmentTypePlugin, strings.TrimPrefix(filepath.Ext(plugin.Path), "."), info.Size())
+	}
+
+	return nil
+}
+
+// runPlugins runs the plugins of settings for stage, in the order they are listed, and stops at the first that
+// fails. Each plugin is checked against the hash manifest, extracted to pluginDir and run with its arguments;
+// .py plugins run with the installed Python. The stage and the installer path are passed to plugins in the
+// EXEPY_STAGE and EXEPY_INSTALLER environment variables.
+func runPlugins(attachments *ember.Attachments, settings common.PythonSetupSettings, stage string) error {
+	var plugins []common.Plugin
+	for _, plugin := range settings.Plugins {
+		if plugin.When == stage {
+			plugins = append(plugins, plugin)
+		}
+	}
+
+	if len(plugins) == 0 {
+		return nil
+	}
+
+	manifest, err := GetHashmap(attachments)
+	if err != nil {
+		return err
+	}
+
+	executablePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	os.Setenv("EXEPY_STAGE", stage)
+	os.Setenv("EXEPY_INSTALLER", executablePath)
+
+	if err := os.MkdirAll(pluginDir, os.ModePerm); err != nil {
+		return err
+	}
+	defer common.RemoveIfExists(pluginDir)
+
+	for _, plugin := range plugins {
+		fmt.Println("Running", stage, "plugin:", plugin.Name)
+
+		if err := runPlugin(attachments, manifest, settings, plugin); err != nil {
+			return fmt.Errorf("plugin %s: %w", plugin.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func runPlugin(attachments *ember.Attachments, manifest common.HashManifest, settings common.PythonSetupSettings, plugin common.Plugin) error {
+	name := common.PluginEmbedName(plugin.Name)
+
+	reader := attachments.Reader(name)
+	if reader == nil {
+		return errors.New("not embedded in the installer")
+	}
+
+	if actualHash, equal := ValidateHash(reader, manifest.Algorithm, manifest.Hashes[name]); !equal {
+		return fmt.Errorf("hash mismatch: expected %s, actual %s", manifest.Hashes[name], actualHash)
+	}
+
+	pluginPath := filepath.Join(pluginDir, plugin.Name+filepath.Ext(plugin.Path))
+	if err := writeAttachment(reader, pluginPath); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(pluginPath, 0755); err != nil {
+		return err
+	}
+
+	if strings.EqualFold(filepath.Ext(pluginPath), ".py") {
+		pythonPath := filepath.Join(settings.PythonExtractDir, "python.exe")
+		if !common.DoesPathExist(pythonPath) {
+			return errors.New("python plugins cannot run before Python is installed")
+		}
+
+		return common.RunCommand(pythonPath, append([]string{pluginPath}, plugin.Args...))
+	}
+
+	return common.RunCommand("."+string(filepath.Separator)+pluginPath, plugin.Args)
+}
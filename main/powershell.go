@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"lukasolson.net/common"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// powerShellNamePattern matches the module and environment variable names that can be written into the module
+// script as they are.
+var powerShellNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+const powerShellModuleTemplate = `function Invoke-%[1]s {
+    [CmdletBinding(PositionalBinding = $false)]
+    param(
+        [Parameter(ValueFromPipeline = $true)]
+        $InputObject,
+
+        [Parameter(ValueFromRemainingArguments = $true)]
+        [string[]] $Arguments
+    )
+
+    process {
+        $python = Join-Path $PSScriptRoot %[2]s
+        $script = Join-Path $PSScriptRoot %[3]s
+
+%[4]s        $scriptArguments = @(%[5]s) + @($Arguments | Where-Object { $null -ne $_ })
+        if ($PSBoundParameters.ContainsKey('InputObject')) {
+            $scriptArguments += "$InputObject"
+        }
+
+        & $python $script @scriptArguments
+
+        if ($LASTEXITCODE -ne 0) {
+            Write-Error "%[1]s exited with code $LASTEXITCODE"
+        }
+    }
+}
+
+Export-ModuleMember -Function Invoke-%[1]s
+`
+
+// validatePowerShellModule checks that the module name and the environment variables the module sets are plain
+// names, so none of them can change the generated script.
+func validatePowerShellModule(settings common.PythonSetupSettings) error {
+	if settings.PowerShellModule == "" {
+		return nil
+	}
+
+	if !powerShellNamePattern.MatchString(settings.PowerShellModule) {
+		return fmt.Errorf("module name %q must be letters, digits and underscores, not starting with a digit", settings.PowerShellModule)
+	}
+
+	for variable := range settings.Environment {
+		if !powerShellNamePattern.MatchString(variable) {
+			return fmt.Errorf("environment variable %q must be letters, digits and underscores, not starting with a digit, to be set by the module", variable)
+		}
+	}
+
+	return nil
+}
+
+// writePowerShellModule generates <name>.psm1 next to the installation, exposing the main script as
+// Invoke-<name>. The configured default arguments and environment are applied, further arguments are
+// passed through, and pipeline input is appended as a final argument.
+func writePowerShellModule(settings common.PythonSetupSettings) error {
+	name := settings.PowerShellModule
+
+	// checked again, since overrides can add environment variables after the build
+	if err := validatePowerShellModule(settings); err != nil {
+		return err
+	}
+
+	pythonPath := filepath.Join(settings.PythonExtractDir, "python.exe")
+
+	var environment strings.Builder
+	for variable, value := range settings.Environment {
+		environment.WriteString(fmt.Sprintf("        $env:%s = %s\n", variable, common.QuotePowerShellLiteral(value)))
+	}
+
+	defaultArguments := make([]string, len(settings.MainScriptArgs))
+	for i, argument := range settings.MainScriptArgs {
+		defaultArguments[i] = common.QuotePowerShellLiteral(argument)
+	}
+
+	contents := fmt.Sprintf(powerShellModuleTemplate, name, common.QuotePowerShellLiteral(pythonPath), common.QuotePowerShellLiteral(settings.MainScript),
+		environment.String(), strings.Join(defaultArguments, ", "))
+
+	// PowerShell expects CRLF line endings in script files, and Windows PowerShell
+	// needs a byte order mark to read non-ASCII paths as UTF-8
+	contents = "\ufeff" + strings.ReplaceAll(contents, "\n", "\r\n")
+
+	fmt.Println("Writing PowerShell module:", name+".psm1")
+	return common.SaveContentsToFile(name+".psm1", contents)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"lukasolson.net/common"
+	"os"
+	"path/filepath"
+)
+
+// unsupportedEntry is an entry of a directory to be packaged that cannot be archived as a regular file.
+type unsupportedEntry struct {
+	path   string
+	reason string
+	link   bool
+}
+
+// findUnsupportedEntries walks dir and returns the links, special files, and unreadable files and directories below it.
+func findUnsupportedEntries(dir string) ([]unsupportedEntry, error) {
+	var entries []unsupportedEntry
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// an unreadable directory is reported rather than stopping the walk
+			if path != dir && d != nil && d.IsDir() {
+				entries = append(entries, unsupportedEntry{path: path, reason: "cannot be read: " + err.Error()})
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		switch mode := d.Type(); {
+		case mode&fs.ModeSymlink != 0:
+			entries = append(entries, unsupportedEntry{path: path, reason: "symbolic link", link: true})
+		case mode&fs.ModeIrregular != 0:
+			// Windows reports junctions and other reparse points that are not symbolic links as irregular
+			entries = append(entries, unsupportedEntry{path: path, reason: "junction or reparse point", link: true})
+		case mode&(fs.ModeNamedPipe|fs.ModeSocket|fs.ModeDevice|fs.ModeCharDevice) != 0:
+			entries = append(entries, unsupportedEntry{path: path, reason: "special file"})
+		case mode.IsRegular():
+			file, err := os.Open(path)
+			if err != nil {
+				entries = append(entries, unsupportedEntry{path: path, reason: "cannot be read: " + err.Error()})
+				return nil
+			}
+			file.Close()
+		}
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// preflightDirectory reports the unsupported entries below dir and applies policy to them, returning the options
+// to archive dir with. It fails if an entry cannot be handled under the policy.
+func preflightDirectory(dir, policy string) (common.ArchiveOptions, error) {
+	var options common.ArchiveOptions
+
+	entries, err := findUnsupportedEntries(dir)
+	if err != nil {
+		return options, err
+	}
+
+	if len(entries) == 0 {
+		return options, nil
+	}
+
+	rejected := 0
+	for _, entry := range entries {
+		switch {
+		case policy == common.UnsupportedEntryPolicySkip:
+			fmt.Println("Skipping unsupported entry:", entry.path, "("+entry.reason+")")
+			options.Exclude = append(options.Exclude, entry.path)
+		case policy == common.UnsupportedEntryPolicyFollow && entry.link:
+			fmt.Println("Packaging the target of:", entry.path, "("+entry.reason+")")
+			options.FollowSymlinks = true
+		default:
+			fmt.Println("Unsupported entry:", entry.path, "("+entry.reason+")")
+			rejected++
+		}
+	}
+
+	if rejected > 0 {
+		return options, fmt.Errorf("%d unsupported entries in %s; remove them or set unsupportedEntryPolicy to %q or %q",
+			rejected, dir, common.UnsupportedEntryPolicyFollow, common.UnsupportedEntryPolicySkip)
+	}
+
+	return options, nil
+}
+
+func validateUnsupportedEntryPolicy(policy string) error {
+	switch policy {
+	case "", common.UnsupportedEntryPolicyError, common.UnsupportedEntryPolicyFollow, common.UnsupportedEntryPolicySkip:
+		return nil
+	}
+
+	return errors.New("unknown unsupported entry policy: " + policy)
+}
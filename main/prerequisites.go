@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"lukasolson.net/common"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+)
+
+// runPrerequisites runs each configured prerequisite installer that has not already been satisfied.
+// Completed prerequisites are recorded in the state store so reruns skip them.
+func runPrerequisites(settings common.PythonSetupSettings, state *common.InstallState) error {
+	for _, prerequisite := range settings.Prerequisites {
+		if slices.Contains(state.Prerequisites, prerequisite.Name) {
+			fmt.Println("Prerequisite already installed:", prerequisite.Name)
+			continue
+		}
+
+		if prerequisite.SkipIfExists != "" && common.DoesPathExist(os.ExpandEnv(prerequisite.SkipIfExists)) {
+			fmt.Println("Prerequisite detected on system, skipping:", prerequisite.Name)
+			continue
+		}
+
+		fmt.Println("Installing prerequisite:", prerequisite.Name)
+
+		if err := runPrerequisite(prerequisite); err != nil {
+			return fmt.Errorf("%s: %w", prerequisite.Name, err)
+		}
+
+		state.Prerequisites = append(state.Prerequisites, prerequisite.Name)
+
+		if err := common.SaveState(common.StateFilename, state); err != nil {
+			fmt.Println("Error saving state:", err)
+		}
+	}
+
+	return nil
+}
+
+func runPrerequisite(prerequisite common.Prerequisite) error {
+	expectedCodes := prerequisite.ExitCodes
+	if len(expectedCodes) == 0 {
+		expectedCodes = []int{0}
+	}
+
+	exitCode := 0
+
+	// prerequisites ship in the payload, so resolve them against the install directory rather than PATH
+	prerequisitePath := prerequisite.Path
+	if !filepath.IsAbs(prerequisitePath) {
+		prerequisitePath = "." + string(filepath.Separator) + filepath.Clean(prerequisitePath)
+	}
+
+	err := common.RunCommand(prerequisitePath, prerequisite.Args)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		return err
+	}
+
+	if !slices.Contains(expectedCodes, exitCode) {
+		return fmt.Errorf("unexpected exit code %d (expected one of %v)", exitCode, expectedCodes)
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"github.com/maja42/ember"
+	"lukasolson.net/common"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runRecovery extracts and runs the embedded recovery scripts after the main integrity check has failed.
+// Only the recovery and settings attachments are verified, so a damaged payload does not prevent recovery.
+func runRecovery(attachments *ember.Attachments) {
+	if attachments.Reader(common.RecoveryFilename) == nil {
+		return
+	}
+
+	manifest, err := GetHashmap(attachments)
+	if err != nil {
+		fmt.Println("Cannot run recovery without the hash manifest.")
+		return
+	}
+
+	for _, name := range []string{common.RecoveryFilename, common.GetConfigEmbedName()} {
+		reader := attachments.Reader(name)
+		if reader == nil {
+			fmt.Println("Cannot run recovery. Missing attachment:", name)
+			return
+		}
+
+		if actualHash, equal := ValidateHash(reader, manifest.Algorithm, manifest.Hashes[name]); !equal {
+			fmt.Println("Cannot run recovery. Hash mismatch for:", name, " -> Expected:", manifest.Hashes[name], "Actual:", actualHash)
+			return
+		}
+	}
+
+	settings, err := GetSettings(attachments)
+	if err != nil {
+		fmt.Println("Error reading settings:", err)
+		return
+	}
+
+	fmt.Println("Running recovery scripts...")
+
+	// the scripts are extracted outside the install root, so a payload folder with the same name is left alone
+	recoveryDir, err := os.MkdirTemp("", "exepy-recovery-*")
+	if err != nil {
+		fmt.Println("Error creating recovery directory:", err)
+		return
+	}
+	removeCleanup := common.AddCleanup(func() { os.RemoveAll(recoveryDir) })
+	defer func() {
+		removeCleanup()
+		os.RemoveAll(recoveryDir)
+	}()
+
+	if err := common.DecompressIOStream(attachments.Reader(common.RecoveryFilename), recoveryDir, settings.CompressionFormat); err != nil {
+		fmt.Println("Error extracting recovery scripts:", err)
+		return
+	}
+
+	executablePath, err := os.Executable()
+	if err != nil {
+		fmt.Println("Error getting executable path:", err)
+		return
+	}
+
+	// the recovery script receives the installer path so it can re-run or re-extract it
+	scriptPath := filepath.Join(recoveryDir, settings.RecoveryScript)
+	args := []string{executablePath}
+
+	if strings.HasSuffix(strings.ToLower(scriptPath), ".py") {
+		pythonPath := filepath.Join(settings.PythonExtractDir, "python.exe")
+		if !common.DoesPathExist(pythonPath) {
+			fmt.Println("Python is not installed. Cannot run recovery script:", scriptPath)
+			return
+		}
+
+		err = common.RunCommand(pythonPath, append([]string{scriptPath}, args...))
+	} else {
+		err = common.RunCommand(scriptPath, args)
+	}
+
+	if err != nil {
+		fmt.Println("Error running recovery script:", err)
+		return
+	}
+
+	fmt.Println("Recovery completed.")
+}
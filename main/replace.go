@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/maja42/ember"
+	"github.com/maja42/ember/embedding"
+	"io"
+	"lukasolson.net/common"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// replacePayload swaps the payload attachment of an existing installer for a freshly compressed
+// scripts directory, keeping the embedded Python and wheels as they are.
+// Usage: replace-payload installer.exe --scripts newdir
+func replacePayload(args []string) {
+	installerPath, args := splitPositional(args)
+
+	flags := flag.NewFlagSet("replace-payload", flag.ExitOnError)
+	scriptDir := flags.String("scripts", "", "directory containing the replacement scripts")
+	_ = flags.Parse(args)
+
+	if installerPath == "" {
+		installerPath = flags.Arg(0)
+	}
+
+	if installerPath == "" || *scriptDir == "" {
+		fmt.Println("Usage: replace-payload <installer.exe> --scripts <directory>")
+		return
+	}
+
+	if !common.DoesPathExist(*scriptDir) {
+		fmt.Println("Scripts directory does not exist:", *scriptDir)
+		return
+	}
+
+	attachments, err := ember.OpenExe(installerPath)
+	if err != nil {
+		fmt.Println("Error opening installer:", err)
+		return
+	}
+	defer attachments.Close()
+
+	if attachments.Count() == 0 {
+		fmt.Println("Installer does not contain any attachments:", installerPath)
+		return
+	}
+
+	settings, err := GetSettings(attachments)
+	if err != nil {
+		fmt.Println("Error reading settings:", err)
+		return
+	}
+
+	mainScriptPath := path.Join(*scriptDir, settings.MainScript)
+	if !common.DoesPathExist(mainScriptPath) {
+		fmt.Println("Main file does not exist in replacement scripts:", mainScriptPath)
+		return
+	}
+
+	pythonReader := attachments.Reader(common.PythonFilename)
+	wheelsReader := attachments.Reader(common.WheelsFilename)
+	settingsReader := attachments.Reader(common.GetConfigEmbedName())
+
+	if pythonReader == nil || wheelsReader == nil || settingsReader == nil {
+		fmt.Println("Installer is missing required attachments. Rebuild it instead.")
+		return
+	}
+
+	payloadOptions, err := preflightDirectory(*scriptDir, settings.UnsupportedEntryPolicy)
+	if err != nil {
+		fmt.Println("Error checking scripts directory:", err)
+		return
+	}
+
+	if err := enforceContentPolicy(*scriptDir, settings, payloadOptions); err != nil {
+		fmt.Println("Error checking scripts directory:", err)
+		return
+	}
+
+	payloadOptions.StoreCompressed = settings.StoreCompressedFiles
+	payloadOptions.PreserveAttributes = settings.PreserveAttributes
+	payloadFile, err := common.CompressDirToStreamWithOptions(*scriptDir, settings.CompressionFormat, payloadOptions)
+	if err != nil {
+		fmt.Println("Error compressing scripts directory:", err)
+		return
+	}
+	defer payloadFile.Close()
+
+	stub, err := loadStub(installerPath)
+	if err != nil {
+		fmt.Println("Error reading installer executable:", err)
+		return
+	}
+
+	// carry over optional attachments such as the recovery scripts
+	extras := make(map[string]io.ReadSeeker)
+	for _, name := range attachments.List() {
+		switch name {
+		case common.PythonFilename, common.PayloadFilename, common.WheelsFilename, common.GetConfigEmbedName(), common.HashesEmbedName:
+			continue
+		}
+
+		extras[name] = attachments.Reader(name)
+	}
+
+	// installers built before attachment metadata existed have nothing to update
+	if metadataReader := attachments.Reader(common.MetadataEmbedName); metadataReader != nil {
+		metadata, err := common.ReadMetadata(metadataReader)
+		if err != nil {
+			fmt.Println("Error reading attachment metadata:", err)
+			return
+		}
+
+		metadata[common.PayloadFilename] = common.NewArchiveMetadata(common.AttachmentTypePayload, payloadFile)
+
+		extras[common.MetadataEmbedName], err = encodeMetadata(metadata)
+		if err != nil {
+			fmt.Println("Error encoding attachment metadata:", err)
+			return
+		}
+	}
+
+	embedMap := createEmbedMap(pythonReader, payloadFile, wheelsReader, settingsReader, extras)
+
+	// write next to the installer so the finished file can be renamed over it
+	output, err := os.CreateTemp(filepath.Dir(installerPath), filepath.Base(installerPath)+".*.tmp")
+	if err != nil {
+		fmt.Println("Error creating installer:", err)
+		return
+	}
+	defer os.Remove(output.Name())
+
+	err = embedding.Embed(output, stub, embedMap, nil)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Println("Error embedding replacement payload:", err)
+		return
+	}
+
+	// keep the permissions of the installer being replaced
+	if info, err := os.Stat(installerPath); err == nil {
+		os.Chmod(output.Name(), info.Mode().Perm())
+	}
+
+	// release the installer before overwriting it
+	attachments.Close()
+
+	if err := os.Rename(output.Name(), installerPath); err != nil {
+		fmt.Println("Error writing installer:", err)
+		return
+	}
+
+	if err := saveOutputHashes(installerPath); err != nil {
+		fmt.Println("Error hashing installer:", err)
+		return
+	}
+
+	println("Replaced payload in", installerPath)
+}
+
+// splitPositional removes a leading positional argument so flags placed after it are still parsed.
+func splitPositional(args []string) (string, []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return args[0], args[1:]
+	}
+
+	return "", args
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"lukasolson.net/common"
+	"os"
+	"path/filepath"
+)
+
+// executableResources returns the icon and version information configured in settings for the installer
+// written to outputPath, or nil if none is configured.
+func executableResources(settings *common.PythonSetupSettings, outputPath string) (*common.PEResources, error) {
+	if settings.IconFile == "" && settings.FileVersion == "" && settings.CompanyName == "" {
+		return nil, nil
+	}
+
+	resources := &common.PEResources{
+		Version: settings.FileVersion,
+		Strings: map[string]string{
+			"OriginalFilename": filepath.Base(outputPath),
+		},
+	}
+
+	if settings.FileVersion != "" {
+		if _, err := common.ParseFileVersion(settings.FileVersion); err != nil {
+			return nil, err
+		}
+
+		resources.Strings["FileVersion"] = settings.FileVersion
+		resources.Strings["ProductVersion"] = settings.FileVersion
+	}
+
+	if settings.CompanyName != "" {
+		resources.Strings["CompanyName"] = settings.CompanyName
+	}
+
+	if settings.ProductName != "" {
+		resources.Strings["ProductName"] = settings.ProductName
+		resources.Strings["FileDescription"] = settings.ProductName
+	}
+
+	if settings.IconFile != "" {
+		icon, err := os.ReadFile(settings.IconFile)
+		if err != nil {
+			return nil, err
+		}
+		resources.Icon = icon
+	}
+
+	return resources, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"lukasolson.net/common"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// s3CacheStore keeps build cache entries in an S3 bucket, or any S3-compatible store, under a prefix. Requests are
+// signed with AWS Signature Version 4 using the credentials in the standard AWS environment variables.
+type s3CacheStore struct {
+	bucket      string
+	prefix      string
+	region      string
+	endpoint    string
+	credentials common.AWSCredentials
+	client      http.Client
+}
+
+// newS3CacheStore returns the store for an s3://bucket/prefix location. AWS_ENDPOINT_URL points it at an
+// S3-compatible store instead of AWS, which is then addressed path-style.
+func newS3CacheStore(location string) (*s3CacheStore, error) {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("build cache location %s has no bucket", location)
+	}
+
+	store := &s3CacheStore{
+		bucket:   parsed.Host,
+		prefix:   strings.Trim(parsed.Path, "/"),
+		region:   firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		endpoint: strings.TrimSuffix(firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"), "/"),
+		credentials: common.AWSCredentials{
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		},
+	}
+
+	if store.credentials.AccessKey == "" || store.credentials.SecretKey == "" {
+		return nil, errors.New("set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to use an S3 build cache")
+	}
+	if store.region == "" {
+		store.region = "us-east-1"
+	}
+
+	return store, nil
+}
+
+// firstEnv returns the value of the first of the environment variables that is set.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (store *s3CacheStore) get(key, name string) (io.ReadCloser, error) {
+	response, err := store.do(http.MethodGet, key, name, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return nil, fmt.Errorf("%s: %w", store.objectKey(key, name), fs.ErrNotExist)
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("%s: %s", store.objectKey(key, name), response.Status)
+	}
+
+	return response.Body, nil
+}
+
+func (store *s3CacheStore) put(key, name string, contents io.Reader, size int64) error {
+	response, err := store.do(http.MethodPut, key, name, contents, size, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", store.objectKey(key, name), response.Status)
+	}
+
+	return nil
+}
+
+// s3LockFilename is the object that holds the lock on an entry while a build prepares it.
+const s3LockFilename = "lock"
+
+// s3LockTTL is how long a lock object is honoured, so the lock of a build that died is taken over once it has
+// passed. A build takes no longer than other builds are willing to wait for it.
+const s3LockTTL = buildCacheLockTimeout
+
+// s3Lock is the contents of a lock object.
+type s3Lock struct {
+	Expires time.Time `json:"expires"`
+}
+
+// lock creates the lock object of the entry with a conditional write, which fails while another build holds it,
+// waiting up to buildCacheLockTimeout for it to be removed or to expire. Stores without conditional writes
+// return an error.
+func (store *s3CacheStore) lock(key string) (func(), error) {
+	deadline := time.Now().Add(buildCacheLockTimeout)
+
+	for {
+		created, err := store.tryLock(key)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			return func() {
+				if err := store.delete(key, s3LockFilename); err != nil {
+					fmt.Println("Error removing build cache lock:", err)
+				}
+			}, nil
+		}
+
+		// the lock of a build that did not finish is removed once it has expired
+		expired, err := store.lockExpired(key)
+		if err != nil {
+			return nil, err
+		}
+		if expired {
+			if err := store.delete(key, s3LockFilename); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, common.ErrLocked
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// tryLock creates the lock object of the entry unless it exists, and reports whether it did.
+func (store *s3CacheStore) tryLock(key string) (bool, error) {
+	data, err := json.Marshal(s3Lock{Expires: time.Now().Add(s3LockTTL).UTC()})
+	if err != nil {
+		return false, err
+	}
+
+	header := http.Header{"If-None-Match": {"*"}}
+	response, err := store.do(http.MethodPut, key, s3LockFilename, bytes.NewReader(data), int64(len(data)), header)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		// the object exists, or another build is creating it at the same time
+		return false, nil
+	case http.StatusNotImplemented:
+		return false, fmt.Errorf("%s: the store does not support conditional writes, so the entry cannot be locked", store.objectKey(key, s3LockFilename))
+	default:
+		return false, fmt.Errorf("%s: %s", store.objectKey(key, s3LockFilename), response.Status)
+	}
+}
+
+// lockExpired reports whether the lock object of the entry has expired. A lock removed in the meantime counts as
+// expired, so it is tried again.
+func (store *s3CacheStore) lockExpired(key string) (bool, error) {
+	reader, err := store.get(key, s3LockFilename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	var lock s3Lock
+	if err := json.NewDecoder(reader).Decode(&lock); err != nil {
+		// a lock that cannot be read is treated as expired rather than blocking every build
+		return true, nil
+	}
+
+	return time.Now().After(lock.Expires), nil
+}
+
+// delete removes the named file of an entry.
+func (store *s3CacheStore) delete(key, name string) error {
+	response, err := store.do(http.MethodDelete, key, name, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("%s: %s", store.objectKey(key, name), response.Status)
+	}
+
+	return nil
+}
+
+// objectKey returns the key of the object holding the named file of an entry.
+func (store *s3CacheStore) objectKey(key, name string) string {
+	return strings.TrimPrefix(path.Join(store.prefix, key, name), "/")
+}
+
+// do sends a signed request for the named file of an entry, with header added to it.
+func (store *s3CacheStore) do(method, key, name string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	objectURL := url.URL{Scheme: "https", Host: store.bucket + ".s3." + store.region + ".amazonaws.com", Path: "/" + store.objectKey(key, name)}
+	if store.endpoint != "" {
+		endpoint, err := url.Parse(store.endpoint)
+		if err != nil {
+			return nil, err
+		}
+		objectURL = url.URL{Scheme: endpoint.Scheme, Host: endpoint.Host, Path: path.Join("/", endpoint.Path, store.bucket, store.objectKey(key, name))}
+	}
+
+	request, err := http.NewRequest(method, objectURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.ContentLength = size
+	}
+	for field, values := range header {
+		request.Header[field] = values
+	}
+
+	common.SignAWSRequest(request, store.credentials, store.region, "s3", time.Now())
+
+	return store.client.Do(request)
+}
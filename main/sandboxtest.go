@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"lukasolson.net/common"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// sandboxTestDir is where the test directories are mapped inside Windows Sandbox.
+	sandboxTestDir = `C:\exepy-test`
+	// testScriptName runs the installer inside the clean machine and writes its results to the directory given as
+	// its first argument.
+	testScriptName = "run-test.cmd"
+	// testDoneFilename is written to the results directory once the test script has finished.
+	testDoneFilename = "done"
+)
+
+// testInstaller installs a built installer on a clean machine, in Windows Sandbox or with a configured runner
+// such as a VM or container, then runs it once more as a smoke test and reports both, so problems that only
+// show up away from the build machine are found before release. Arguments after -- are passed to the script for
+// the smoke test; without them only the installation is tested.
+// Usage: test installer.exe (--sandbox | --runner command) [--no-network] [-- script args]
+func testInstaller(args []string) {
+	installerPath, args := splitPositional(args)
+
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	sandbox := flags.Bool("sandbox", false, "run the test in Windows Sandbox")
+	runner := flags.String("runner", "", "command that runs the test script on a clean machine, with {in} and {out} replaced by the test and results directories")
+	noNetwork := flags.Bool("no-network", false, "disable networking in Windows Sandbox, to test an offline installation")
+	timeout := flags.Int("timeout", 30, "minutes to wait for the test to finish")
+	keep := flags.Bool("keep", false, "keep the test and results directories")
+	_ = flags.Parse(args)
+	smokeArgs := flags.Args()
+
+	if installerPath == "" || *sandbox == (*runner != "") {
+		fmt.Println("Usage: test <installer.exe> (--sandbox | --runner <command>) [--no-network] [--timeout minutes] [--keep] [-- script args]")
+		return
+	}
+
+	workDir, err := os.MkdirTemp("", "exepy-test-*")
+	if err != nil {
+		fmt.Println("Error creating test directory:", err)
+		return
+	}
+
+	passed := runInstallerTest(installerPath, workDir, *runner, smokeArgs, *noNetwork, time.Duration(*timeout)*time.Minute)
+
+	if *keep {
+		fmt.Println("Test files are kept in", workDir)
+	} else {
+		os.RemoveAll(workDir)
+	}
+
+	if !passed {
+		fmt.Println("Test FAILED.")
+		os.Exit(1)
+	}
+
+	fmt.Println("Test succeeded.")
+}
+
+// runInstallerTest runs the test in workDir, in Windows Sandbox unless a runner is given, and reports whether it
+// passed.
+func runInstallerTest(installerPath, workDir, runner string, smokeArgs []string, noNetwork bool, timeout time.Duration) bool {
+	sandbox := runner == ""
+
+	inDir := filepath.Join(workDir, "in")
+	outDir := filepath.Join(workDir, "out")
+	if err := prepareTestDirectories(installerPath, inDir, outDir, smokeArgs, sandbox); err != nil {
+		fmt.Println("Error preparing test:", err)
+		return false
+	}
+
+	var err error
+	if sandbox {
+		err = startSandboxTest(workDir, inDir, outDir, noNetwork)
+	} else {
+		err = runTestRunner(runner, inDir, outDir)
+	}
+	if err != nil {
+		fmt.Println("Error starting test:", err)
+		return false
+	}
+
+	fmt.Println("Waiting for the test to finish...")
+	if err := waitForTest(outDir, timeout); err != nil {
+		fmt.Println("Error running test:", err)
+		return false
+	}
+
+	return reportTestResults(outDir, len(smokeArgs) > 0)
+}
+
+// prepareTestDirectories copies the installer and the test script to inDir and creates the empty outDir.
+func prepareTestDirectories(installerPath, inDir, outDir string, smokeArgs []string, shutdown bool) error {
+	for _, dir := range []string{inDir, outDir} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	// the installer keeps its name, since it is the product name unless one is configured
+	installerName := filepath.Base(installerPath)
+	if err := common.CopyFile(installerPath, filepath.Join(inDir, installerName)); err != nil {
+		return err
+	}
+
+	return common.SaveContentsToFile(filepath.Join(inDir, testScriptName), testScript(installerName, smokeArgs, shutdown))
+}
+
+// testScript returns the batch file that installs installerName with every prompt answered, runs it again with
+// smokeArgs if there are any, and records the output and exit code of each run. With shutdown set the machine is
+// shut down afterwards, which closes Windows Sandbox.
+func testScript(installerName string, smokeArgs []string, shutdown bool) string {
+	installer := common.QuoteCmdArg(installerName)
+
+	lines := []string{
+		"@echo off",
+		"rem Generated by exepy test",
+		`set "OUT=%~1"`,
+		`set "WORK=%SystemDrive%\exepy-test-install"`,
+		`mkdir "%WORK%"`,
+		`copy /y "%~dp0` + installerName + `" "%WORK%" >nul`,
+		`cd /d "%WORK%"`,
+		installer + ` --accept-license --accept-capabilities --prewarm < nul > "%OUT%\install.log" 2>&1`,
+		`> "%OUT%\install.exitcode" echo %ERRORLEVEL%`,
+	}
+
+	if len(smokeArgs) > 0 {
+		quoted := make([]string, len(smokeArgs))
+		for i, arg := range smokeArgs {
+			quoted[i] = common.QuoteCmdArg(arg)
+		}
+
+		lines = append(lines,
+			installer+` --accept-license --accept-capabilities -- `+strings.Join(quoted, " ")+` < nul > "%OUT%\smoke.log" 2>&1`,
+			`> "%OUT%\smoke.exitcode" echo %ERRORLEVEL%`,
+		)
+	}
+
+	lines = append(lines, `> "%OUT%\`+testDoneFilename+`" echo done`)
+
+	if shutdown {
+		lines = append(lines, "shutdown /s /t 0")
+	}
+
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// startSandboxTest writes a Windows Sandbox configuration that maps inDir read-only and outDir writable and runs
+// the test script at logon, and starts the sandbox with it.
+func startSandboxTest(workDir, inDir, outDir string, noNetwork bool) error {
+	networking := "Default"
+	if noNetwork {
+		networking = "Disable"
+	}
+
+	guestIn := sandboxTestDir + `\in`
+	guestOut := sandboxTestDir + `\out`
+
+	config := `<Configuration>
+  <Networking>` + networking + `</Networking>
+  <MappedFolders>
+    <MappedFolder>
+      <HostFolder>` + inDir + `</HostFolder>
+      <SandboxFolder>` + guestIn + `</SandboxFolder>
+      <ReadOnly>true</ReadOnly>
+    </MappedFolder>
+    <MappedFolder>
+      <HostFolder>` + outDir + `</HostFolder>
+      <SandboxFolder>` + guestOut + `</SandboxFolder>
+      <ReadOnly>false</ReadOnly>
+    </MappedFolder>
+  </MappedFolders>
+  <LogonCommand>
+    <Command>cmd.exe /c ` + guestIn + `\` + testScriptName + ` ` + guestOut + `</Command>
+  </LogonCommand>
+</Configuration>
+`
+
+	configPath := filepath.Join(workDir, "exepy-test.wsb")
+	if err := common.SaveContentsToFile(configPath, config); err != nil {
+		return err
+	}
+
+	fmt.Println("Starting Windows Sandbox. It closes by itself when the test has finished.")
+
+	// WindowsSandbox.exe returns once the sandbox window is open, so completion is detected from the results
+	return exec.Command("WindowsSandbox.exe", configPath).Start()
+}
+
+// runTestRunner runs the configured runner command with {in} and {out} replaced by inDir and outDir. The runner
+// must run the test script from inDir on a clean machine, with the results directory as its argument, and leave
+// the results in outDir.
+func runTestRunner(runner, inDir, outDir string) error {
+	fields := strings.Fields(runner)
+	for i, field := range fields {
+		fields[i] = strings.NewReplacer("{in}", inDir, "{out}", outDir).Replace(field)
+	}
+
+	return common.RunCommand(fields[0], fields[1:])
+}
+
+// waitForTest waits until the test script has written its done file to outDir.
+func waitForTest(outDir string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for !common.DoesPathExist(filepath.Join(outDir, testDoneFilename)) {
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for the test to finish")
+		}
+		time.Sleep(5 * time.Second)
+	}
+
+	return nil
+}
+
+// reportTestResults prints the outcome of the installation and, if one was run, the smoke test, with the output
+// of any run that failed. It returns whether all of them succeeded.
+func reportTestResults(outDir string, smokeTest bool) bool {
+	runs := []string{"install"}
+	if smokeTest {
+		runs = append(runs, "smoke")
+	}
+
+	succeeded := true
+	for _, run := range runs {
+		data, err := os.ReadFile(filepath.Join(outDir, run+".exitcode"))
+		if err != nil {
+			fmt.Println("Error reading", run, "result:", err)
+			succeeded = false
+			continue
+		}
+
+		exitCode, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			fmt.Println("Error reading", run, "result:", err)
+			succeeded = false
+			continue
+		}
+
+		if exitCode == exitCodeSuccess {
+			fmt.Println(run+":", "passed")
+			continue
+		}
+
+		succeeded = false
+		fmt.Println(run+":", "failed with exit code", exitCode)
+
+		if output, err := os.ReadFile(filepath.Join(outDir, run+".log")); err == nil {
+			fmt.Println(string(output))
+		}
+	}
+
+	return succeeded
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/maja42/ember"
+	"io/fs"
+	"lukasolson.net/common"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// scriptUpdateStaging is where a script update bundle is unpacked and checked before its files are moved into
+	// the installation.
+	scriptUpdateStaging = ".exepy-script-update"
+	// scriptUpdateBackup keeps the files an update replaces until it has passed its smoke test.
+	scriptUpdateBackup = ".exepy-script-update-backup"
+)
+
+// buildScriptUpdate writes a signed scripts-only update for an installer: the files of a new scripts directory
+// that differ from the installer's payload, and the payload files that no longer exist.
+// Usage: build-script-update installer.exe --scripts newdir --version 1.2.1 --key private.key --out update.bundle [--hotfix]
+func buildScriptUpdate(args []string) {
+	installerPath, args := splitPositional(args)
+
+	flags := flag.NewFlagSet("build-script-update", flag.ExitOnError)
+	scriptDir := flags.String("scripts", "", "directory containing the updated scripts")
+	version := flags.String("version", "", "version of the update, recorded in installations that apply it")
+	keyPath := flags.String("key", "", "file holding the base64-encoded Ed25519 private key")
+	outputPath := flags.String("out", "script-update.bundle", "file to write the update bundle to")
+	hotfix := flags.Bool("hotfix", false, "mark the update as a hotfix, signed with a key holding the hotfix role")
+	_ = flags.Parse(args)
+
+	if installerPath == "" || *scriptDir == "" || *version == "" || *keyPath == "" {
+		fmt.Println("Usage: build-script-update <installer.exe> --scripts <directory> --version <version> --key <private key> --out <bundle> [--hotfix]")
+		return
+	}
+
+	attachments, err := ember.OpenExe(installerPath)
+	if err != nil {
+		fmt.Println("Error opening installer:", err)
+		return
+	}
+	defer attachments.Close()
+
+	settings, err := GetSettings(attachments)
+	if err != nil {
+		fmt.Println("Error reading settings:", err)
+		return
+	}
+
+	hashManifest, err := GetHashmap(attachments)
+	if err != nil {
+		fmt.Println("Error reading hash manifest:", err)
+		return
+	}
+
+	payloadReader := attachments.Reader(common.PayloadFilename)
+	if payloadReader == nil {
+		fmt.Println("Installer has no payload to update.")
+		return
+	}
+
+	previous, err := common.HashArchive(payloadReader, settings.CompressionFormat)
+	if err != nil {
+		fmt.Println("Error reading payload:", err)
+		return
+	}
+
+	current, err := hashScripts(*scriptDir)
+	if err != nil {
+		fmt.Println("Error reading scripts directory:", err)
+		return
+	}
+
+	manifest := common.ScriptUpdateManifest{
+		Product:         productName(settings),
+		Version:         *version,
+		Hotfix:          *hotfix,
+		BasePayloadHash: hashManifest.Hashes[common.PayloadFilename],
+		Algorithm:       common.HashAlgorithm,
+		Files:           make(map[string]string),
+		CreatedAt:       time.Now().UTC(),
+	}
+
+	paths := make(map[string]string)
+	for name, hash := range current {
+		if previous[name] != hash {
+			manifest.Files[name] = hash
+			paths[filepath.Join(*scriptDir, filepath.FromSlash(name))] = name
+		}
+	}
+	for name := range previous {
+		if _, exists := current[name]; !exists {
+			manifest.Removed = append(manifest.Removed, name)
+		}
+	}
+	sort.Strings(manifest.Removed)
+
+	if len(manifest.Files) == 0 && len(manifest.Removed) == 0 {
+		fmt.Println("The scripts are the same as in the installer. Nothing to update.")
+		return
+	}
+
+	for name := range manifest.Files {
+		if err := checkScriptUpdatePath(name, settings); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+
+	privateKey, err := os.ReadFile(*keyPath)
+	if err != nil {
+		println("Error reading private key: ", err.Error())
+		return
+	}
+
+	signed, err := common.SignScriptUpdate(manifest, strings.TrimSpace(string(privateKey)))
+	if err != nil {
+		println("Error signing script update: ", err.Error())
+		return
+	}
+
+	manifestFile, err := os.CreateTemp("", "exepy-script-update-*.json")
+	if err != nil {
+		println("Error writing script update manifest: ", err.Error())
+		return
+	}
+	defer os.Remove(manifestFile.Name())
+	defer manifestFile.Close()
+
+	if _, err := manifestFile.Write(signed); err != nil {
+		println("Error writing script update manifest: ", err.Error())
+		return
+	}
+	if err := manifestFile.Close(); err != nil {
+		println("Error writing script update manifest: ", err.Error())
+		return
+	}
+	paths[manifestFile.Name()] = common.ScriptUpdateManifestFilename
+
+	if err := common.CompressPathsToFile(paths, *outputPath, settings.CompressionFormat); err != nil {
+		println("Error writing script update bundle: ", err.Error())
+		return
+	}
+
+	fmt.Println("Script update", *version, "written to", *outputPath, "with", len(manifest.Files), "changed files and", len(manifest.Removed), "removed.")
+}
+
+// hashScripts returns the hash of every file below dir, keyed by its slash-separated path relative to dir.
+func hashScripts(dir string) (map[string]string, error) {
+	hashes := make(map[string]string)
+
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+
+		hashes[filepath.ToSlash(relativePath)], err = common.HashFile(filePath)
+		return err
+	})
+
+	return hashes, err
+}
+
+// checkScriptUpdatePath rejects payload paths a script update must not write or remove: paths outside the
+// installation, the Python installation, and the files bootstrap keeps its own state in.
+func checkScriptUpdatePath(name string, settings common.PythonSetupSettings) error {
+	if !filepath.IsLocal(filepath.FromSlash(name)) || strings.Contains(name, "\\") {
+		return fmt.Errorf("script update path %q is outside the installation", name)
+	}
+
+	pythonDir := path.Clean(filepath.ToSlash(settings.PythonExtractDir))
+	if name == pythonDir || strings.HasPrefix(name, pythonDir+"/") {
+		return fmt.Errorf("script update path %q is inside the Python installation", name)
+	}
+
+	switch name {
+	case common.StateFilename, common.BootstrapMarkerFilename, "hash.txt", common.ScriptUpdateManifestFilename:
+		return fmt.Errorf("script update path %q belongs to the installer", name)
+	}
+
+	return nil
+}
+
+// applyScriptUpdate fetches the script update bundle configured in settings and applies it if it is signed by a
+// trusted key, built for the installed payload, and newer than the update already applied. The signature is
+// checked before anything is written, the files are unpacked and checked against their signed hashes in a staging
+// directory, and the update is only kept if the configured smoke test passes. Python and the wheels are never
+// touched. A fresh setup extracted the payload again, so the update is applied again after it. lockHeld is set
+// when the caller already holds the install lock.
+func applyScriptUpdate(attachments *ember.Attachments, settings common.PythonSetupSettings, state *common.InstallState, freshSetup, lockHeld bool) error {
+	config := settings.ScriptUpdates
+	if config == nil || config.Location == "" {
+		return nil
+	}
+
+	hashManifest, err := GetHashmap(attachments)
+	if err != nil {
+		return err
+	}
+	payloadHash := hashManifest.Hashes[common.PayloadFilename]
+
+	data, err := fetchSource(config.Location)
+	if err != nil {
+		return err
+	}
+
+	signed, err := common.ReadArchiveFile(bytes.NewReader(data), settings.CompressionFormat, common.ScriptUpdateManifestFilename)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := common.VerifyScriptUpdate(signed, settings.TrustStore)
+	if err != nil {
+		return err
+	}
+
+	if manifest.Product != productName(settings) {
+		return fmt.Errorf("script update is for %s, not %s", manifest.Product, productName(settings))
+	}
+
+	if manifest.BasePayloadHash != payloadHash {
+		fmt.Println("Script update", manifest.Version, "was built for a different release. Skipping it.")
+		return nil
+	}
+
+	// the recorded version only counts while the payload it was applied over is installed
+	if applied := state.ScriptUpdate; applied != nil && applied.BasePayloadHash == payloadHash {
+		comparison := common.CompareVersions(manifest.Version, applied.Version)
+		if comparison == 0 && !freshSetup {
+			return nil
+		}
+		if comparison < 0 && !config.AllowDowngrade {
+			fmt.Println("Script update", manifest.Version, "is older than the applied update", applied.Version+". Skipping it.")
+			return nil
+		}
+	}
+
+	names := make([]string, 0, len(manifest.Files))
+	for name := range manifest.Files {
+		if err := checkScriptUpdatePath(name, settings); err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+	for _, name := range manifest.Removed {
+		if err := checkScriptUpdatePath(name, settings); err != nil {
+			return err
+		}
+	}
+
+	if !lockHeld {
+		installLock, err := common.AcquireInstallLock(productName(settings), 0)
+		if errors.Is(err, common.ErrLocked) {
+			fmt.Println("Another installation of", productName(settings), "is in progress. Checking for script updates next time.")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer installLock.Release()
+	}
+
+	os.RemoveAll(scriptUpdateStaging)
+	defer os.RemoveAll(scriptUpdateStaging)
+
+	if err := common.DecompressIOStreamNames(bytes.NewReader(data), scriptUpdateStaging, settings.CompressionFormat, names); err != nil {
+		return err
+	}
+
+	if err := checkStagedScriptUpdate(manifest); err != nil {
+		return err
+	}
+
+	fmt.Println("Applying script update", manifest.Version+"...")
+
+	if err := commitScriptUpdate(manifest, settings); err != nil {
+		return err
+	}
+
+	state.ScriptUpdate = &common.AppliedScriptUpdate{
+		Version:         manifest.Version,
+		BasePayloadHash: payloadHash,
+		AppliedAt:       time.Now().UTC(),
+		Algorithm:       manifest.Algorithm,
+		Files:           manifest.Files,
+		Removed:         manifest.Removed,
+	}
+	if err := common.SaveState(common.StateFilename, state); err != nil {
+		return err
+	}
+
+	fmt.Println("Script update", manifest.Version, "applied:", len(manifest.Files), "files changed,", len(manifest.Removed), "removed.")
+	return nil
+}
+
+// commitScriptUpdate moves the staged files of manifest into the installation and removes the files it deletes,
+// keeping the files they replace. If the smoke test in settings fails, or anything cannot be moved, the kept
+// files are put back.
+func commitScriptUpdate(manifest *common.ScriptUpdateManifest, settings common.PythonSetupSettings) error {
+	os.RemoveAll(scriptUpdateBackup)
+	defer os.RemoveAll(scriptUpdateBackup)
+
+	var replaced []string
+	merging := false
+	rollback := func() {
+		// every file the update writes was moved aside before merging, so only update files are removed
+		if merging {
+			for name := range manifest.Files {
+				os.Remove(filepath.FromSlash(name))
+			}
+		}
+		for _, name := range replaced {
+			if err := os.Rename(filepath.Join(scriptUpdateBackup, filepath.FromSlash(name)), filepath.FromSlash(name)); err != nil {
+				fmt.Println("Error restoring", name+":", err)
+			}
+		}
+	}
+
+	affected := append([]string(nil), manifest.Removed...)
+	for name := range manifest.Files {
+		affected = append(affected, name)
+	}
+
+	for _, name := range affected {
+		if !common.DoesPathExist(filepath.FromSlash(name)) {
+			continue
+		}
+
+		backupPath := filepath.Join(scriptUpdateBackup, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(backupPath), os.ModePerm); err != nil {
+			rollback()
+			return err
+		}
+		if err := os.Rename(filepath.FromSlash(name), backupPath); err != nil {
+			rollback()
+			return err
+		}
+		replaced = append(replaced, name)
+	}
+
+	merging = true
+	if err := common.MergeDirectory(scriptUpdateStaging, ".", nil, ""); err != nil {
+		rollback()
+		return err
+	}
+
+	if len(settings.ScriptUpdates.SmokeTest) > 0 {
+		fmt.Println("Running the script update smoke test...")
+
+		pythonPath := filepath.Join(settings.PythonExtractDir, "python.exe")
+		if err := common.RunCommand(pythonPath, settings.ScriptUpdates.SmokeTest); err != nil {
+			rollback()
+			return fmt.Errorf("smoke test failed, so script update %s was rolled back: %w", manifest.Version, err)
+		}
+	}
+
+	return nil
+}
+
+// checkStagedScriptUpdate checks that the unpacked bundle holds every file listed in manifest, with its signed hash.
+func checkStagedScriptUpdate(manifest *common.ScriptUpdateManifest) error {
+	for name, expected := range manifest.Files {
+		stagedPath := filepath.Join(scriptUpdateStaging, filepath.FromSlash(name))
+		if !common.DoesPathExist(stagedPath) {
+			return fmt.Errorf("script update is missing %s", name)
+		}
+
+		actual, err := common.HashFileWith(manifest.Algorithm, stagedPath)
+		if err != nil {
+			return err
+		}
+
+		if actual != expected {
+			return fmt.Errorf("script update file %s does not match its signed hash", name)
+		}
+	}
+
+	return nil
+}
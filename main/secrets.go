@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"lukasolson.net/common"
+	"os"
+	"strings"
+)
+
+// provideSecrets sets each secret declared in settings as an environment variable for the setup and main
+// scripts. Secrets named with --secret NAME are read from standard input first, one line each in the order given,
+// so values never appear on the command line. Others are taken from an environment variable already set, then
+// from the secrets stored by an earlier run, and are otherwise asked for. New values are stored, protected for the
+// current user, so later runs do not ask again; where there is no OS keystore to protect them they are not stored.
+func provideSecrets(settings common.PythonSetupSettings, options bootstrapOptions) error {
+	if len(settings.Secrets) == 0 {
+		return nil
+	}
+
+	declared := make(map[string]common.Secret, len(settings.Secrets))
+	for _, secret := range settings.Secrets {
+		declared[secret.Name] = secret
+	}
+
+	fromStdin := make(map[string]string, len(options.secrets))
+	for _, name := range options.secrets {
+		if name, _, hasValue := strings.Cut(name, "="); hasValue {
+			return fmt.Errorf("--secret takes only the name of the secret, so its value does not show in the process list or shell history; "+
+				"use --secret %s and give the value on standard input, or set the %s environment variable", name, name)
+		}
+
+		secret, ok := declared[name]
+		if !ok {
+			return fmt.Errorf("--secret %s: the installer declares no such secret", name)
+		}
+
+		value, err := promptSecret(secret)
+		if err != nil {
+			return fmt.Errorf("reading %s from standard input: %w", name, err)
+		}
+		fromStdin[name] = value
+	}
+
+	stored, err := common.LoadSecrets(common.SecretsFilename)
+	if errors.Is(err, common.ErrNoKeystore) {
+		// a file written in plain text by an earlier release
+		common.RemoveIfExists(common.SecretsFilename)
+		stored = make(map[string]string)
+	} else if err != nil {
+		fmt.Println("Error reading stored secrets, asking for them again:", err)
+		stored = make(map[string]string)
+	}
+
+	changed := false
+
+	for _, secret := range settings.Secrets {
+		value, ok := fromStdin[secret.Name]
+		if !ok {
+			value, ok = os.LookupEnv(secret.Name)
+		}
+		if !ok {
+			value, ok = stored[secret.Name]
+		}
+		if !ok {
+			value, err = promptSecret(secret)
+			if err != nil {
+				return fmt.Errorf("%s is required; pass --secret %s with the value on standard input or set the %s environment variable: %w",
+					secret.Name, secret.Name, secret.Name, err)
+			}
+		}
+
+		if value == "" && !secret.Optional {
+			return fmt.Errorf("%s is required and cannot be empty", secret.Name)
+		}
+
+		if stored[secret.Name] != value {
+			stored[secret.Name] = value
+			changed = true
+		}
+
+		if err := os.Setenv(secret.Name, value); err != nil {
+			return err
+		}
+	}
+
+	if changed {
+		err := common.SaveSecrets(common.SecretsFilename, stored)
+		if errors.Is(err, common.ErrNoKeystore) {
+			fmt.Println("WARNING: there is no OS keystore on this system to protect secrets, so they are not stored and will be asked for again on the next run.")
+			fmt.Println("WARNING: set them as environment variables, or pass --secret NAME with the value on standard input, to avoid being asked.")
+		} else if err != nil {
+			fmt.Println("Error storing secrets, they will be asked for again:", err)
+		}
+	}
+
+	return nil
+}
+
+// promptSecret asks for the value of secret without showing it as it is typed.
+func promptSecret(secret common.Secret) (string, error) {
+	prompt := secret.Name
+	if secret.Description != "" {
+		prompt += " (" + secret.Description + ")"
+	}
+	if secret.Optional {
+		prompt += " [optional]"
+	}
+
+	fmt.Print(prompt + ": ")
+	return common.ReadSecret()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"lukasolson.net/common"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultSigningTool is used when the signing settings do not name a signer.
+const defaultSigningTool = "signtool"
+
+// signExecutable signs the installer at exePath in place with the signer configured in signing. signtool signs
+// the file where it is; osslsigncode writes a signed copy, which then replaces the original. The signature is
+// appended after the attachments, which are found by searching forward for their boundary, so the installer
+// still reads them once signed.
+func signExecutable(signing common.Signing, exePath string) error {
+	tool := signing.Tool
+	if tool == "" {
+		tool = defaultSigningTool
+	}
+
+	password := ""
+	if signing.PasswordEnv != "" {
+		password = os.Getenv(signing.PasswordEnv)
+		if password == "" {
+			return fmt.Errorf("certificate password variable %s is not set", signing.PasswordEnv)
+		}
+	}
+
+	if isOsslsigncode(tool) {
+		return runOsslsigncode(tool, signing, password, exePath)
+	}
+
+	return runSigntool(tool, signing, password, exePath)
+}
+
+// isOsslsigncode reports whether tool names osslsigncode, which takes different arguments from signtool.
+func isOsslsigncode(tool string) bool {
+	name := strings.ToLower(filepath.Base(tool))
+	return strings.TrimSuffix(name, ".exe") == "osslsigncode"
+}
+
+func runSigntool(tool string, signing common.Signing, password, exePath string) error {
+	args := []string{"sign", "/fd", "SHA256"}
+
+	switch {
+	case signing.CertificateThumbprint != "":
+		args = append(args, "/sha1", signing.CertificateThumbprint)
+	case signing.KeyContainer != "":
+		if signing.CertificateFile == "" || signing.CSP == "" {
+			return errors.New("signing with a keyContainer requires its csp and a certificateFile holding the certificate")
+		}
+		args = append(args, "/f", signing.CertificateFile, "/csp", signing.CSP, "/kc", signing.KeyContainer)
+	case signing.CertificateFile != "":
+		// signtool only takes a password on its command line, where other processes can read it
+		if password != "" {
+			return errors.New("signtool cannot sign with a password-protected certificateFile without exposing the password; " +
+				"import the certificate and use certificateThumbprint, use a keyContainer, or sign with osslsigncode")
+		}
+		args = append(args, "/f", signing.CertificateFile)
+	default:
+		return errors.New("signing requires a certificateThumbprint, keyContainer or certificateFile")
+	}
+
+	if signing.TimestampURL != "" {
+		args = append(args, "/tr", signing.TimestampURL, "/td", "SHA256")
+	}
+
+	args = append(args, signing.Args...)
+	args = append(args, exePath)
+
+	return common.RunCommand(tool, args)
+}
+
+func runOsslsigncode(tool string, signing common.Signing, password, exePath string) error {
+	if signing.CertificateFile == "" {
+		return errors.New("osslsigncode requires a certificateFile")
+	}
+
+	signedPath := exePath + ".signed"
+
+	args := []string{"sign", "-h", "sha256", "-pkcs12", signing.CertificateFile}
+	if password != "" {
+		// the password goes through a file only the current user can read, not the command line
+		passwordFile, err := writePasswordFile(password)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(passwordFile)
+
+		args = append(args, "-readpass", passwordFile)
+	}
+
+	if signing.TimestampURL != "" {
+		args = append(args, "-ts", signing.TimestampURL)
+	}
+
+	args = append(args, signing.Args...)
+	args = append(args, "-in", exePath, "-out", signedPath)
+
+	if err := common.RunCommand(tool, args); err != nil {
+		os.Remove(signedPath)
+		return err
+	}
+
+	return os.Rename(signedPath, exePath)
+}
+
+// writePasswordFile writes password to a new file in the temporary directory, readable only by the current user,
+// and returns its path.
+func writePasswordFile(password string) (string, error) {
+	file, err := os.CreateTemp("", "exepy-signing-*")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := file.WriteString(password); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
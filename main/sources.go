@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"lukasolson.net/common"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// loadAttachmentSources fetches every prebuilt attachment declared in the settings and checks it against its pinned checksum.
+func loadAttachmentSources(settings *common.PythonSetupSettings) (map[string]io.ReadSeeker, error) {
+	sources := make(map[string]io.ReadSeeker)
+	stdinUsed := false
+
+	for name, source := range settings.AttachmentSources {
+		if source.Checksum == "" {
+			return nil, fmt.Errorf("%s: checksum is required for prebuilt attachments", name)
+		}
+		if err := common.CheckSHA256Checksum(source.Checksum); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		if source.Location == "-" {
+			if stdinUsed {
+				return nil, errors.New("only one attachment can be read from standard input")
+			}
+			stdinUsed = true
+		}
+
+		fmt.Println("Fetching prebuilt", name, "from", source.Location)
+
+		reader, err := openSource(source.Location)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		spooled, err := spoolVerified(reader, name, common.HashAlgorithmSHA256, strings.ToLower(source.Checksum))
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		sources[name] = spooled
+	}
+
+	return sources, nil
+}
+
+// fetchSource reads the small file at location, such as an override or a signed manifest, into memory.
+// Archives are streamed with openSource instead.
+func fetchSource(location string) ([]byte, error) {
+	reader, err := openSource(location)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// openSource opens the file at location, which is an http(s) URL, a local file, or - for standard input.
+func openSource(location string) (io.ReadCloser, error) {
+	if location == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		response, err := http.Get(location)
+		if err != nil {
+			return nil, err
+		}
+
+		if response.StatusCode != http.StatusOK {
+			response.Body.Close()
+			return nil, fmt.Errorf("download failed: %s", response.Status)
+		}
+
+		return response.Body, nil
+	}
+
+	return os.Open(location)
+}
+
+// spoolVerified copies reader to a temporary file, hashing it with algorithm on the way, and returns the copy
+// positioned at its start if the hash is expectedHash. Archives are streamed this way so they are never held in
+// memory.
+func spoolVerified(reader io.Reader, name, algorithm, expectedHash string) (*spooledFile, error) {
+	file, err := os.CreateTemp("", "exepy-*-"+name)
+	if err != nil {
+		return nil, err
+	}
+	spooled := &spooledFile{File: file}
+	spooled.removeCleanup = common.AddCleanup(func() { spooled.Close() })
+
+	hash, err := common.HashReaderWith(algorithm, io.TeeReader(reader, file))
+	if err == nil && hash != expectedHash {
+		err = fmt.Errorf("%s: checksum mismatch -> Expected: %s Actual: %s", name, expectedHash, hash)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spooled.Close()
+		return nil, err
+	}
+
+	return spooled, nil
+}
+
+// spooledFile is an archive copied to a temporary file by spoolVerified. Closing it removes the copy.
+type spooledFile struct {
+	*os.File
+	removeCleanup func()
+}
+
+func (spooled *spooledFile) Close() error {
+	spooled.removeCleanup()
+
+	err := spooled.File.Close()
+	os.Remove(spooled.Name())
+	return err
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"lukasolson.net/common"
+	"path"
+)
+
+// stagingDirectory holds the attachments while they are extracted. It is on the same volume as the installation,
+// so the extracted trees can be renamed into place once they are complete.
+const stagingDirectory = ".exepy-staging"
+
+// stagedHashes records the hash of every file of the Python, wheels and payload attachments as it is extracted to
+// the staging directory.
+type stagedHashes struct {
+	python, wheels, payload map[string]string
+}
+
+func newStagedHashes() stagedHashes {
+	return stagedHashes{python: make(map[string]string), wheels: make(map[string]string), payload: make(map[string]string)}
+}
+
+// verifyStagedFiles checks the staged Python and payload trees against the hashes recorded while they were
+// extracted, catching files that were truncated or damaged on their way to disk, and files that appeared in the
+// staging directory without being extracted, before anything is moved into place.
+func verifyStagedFiles(hashes stagedHashes, stagedPython, stagedPayload string) error {
+	// the wheels are extracted inside the Python directory
+	python := make(map[string]string, len(hashes.python)+len(hashes.wheels))
+	for name, hash := range hashes.python {
+		python[name] = hash
+	}
+	for name, hash := range hashes.wheels {
+		python[path.Join(common.WheelsFilename, name)] = hash
+	}
+
+	if err := common.CheckStagedTree(stagedPython, python); err != nil {
+		return err
+	}
+
+	return common.CheckStagedTree(stagedPayload, hashes.payload)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"github.com/maja42/ember/embedding"
+	"io"
+	"lukasolson.net/common"
+	"os"
+)
+
+// loadStub returns the executable at exePath with its attachments stripped.
+func loadStub(exePath string) (io.ReadSeeker, error) {
+	file, err := os.Open(exePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stub := new(bytes.Buffer)
+	if err := embedding.RemoveEmbedding(stub, file, nil); err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(stub.Bytes()), nil
+}
+
+// hashStub hashes the executable at exePath up to the start of its attachments with algorithm,
+// so the stub can be checked independently of the embedded payload.
+func hashStub(exePath, algorithm string) (string, error) {
+	file, err := os.Open(exePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	reader, writer := io.Pipe()
+	defer reader.Close()
+
+	go func() {
+		writer.CloseWithError(embedding.RemoveEmbedding(writer, file, nil))
+	}()
+
+	return common.HashReaderWith(algorithm, reader)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"github.com/maja42/ember"
+	"lukasolson.net/common"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// uninstall runs the on-uninstall plugins and then removes what first time setup installed: the Python
+// installation, the payload files and the setup marker. The state store, logs and backups are kept.
+func uninstall(attachments *ember.Attachments, settings common.PythonSetupSettings) error {
+	if err := runPlugins(attachments, settings, common.PluginOnUninstall); err != nil {
+		return err
+	}
+
+	names, err := common.ListArchive(attachments.Reader(common.PayloadFilename), settings.CompressionFormat)
+	if err != nil {
+		return err
+	}
+
+	var directories []string
+	for _, name := range names {
+		if strings.HasSuffix(name, "/") {
+			directories = append(directories, strings.TrimSuffix(name, "/"))
+		} else if err := os.Remove(filepath.FromSlash(name)); err != nil && !os.IsNotExist(err) {
+			fmt.Println("Error removing", name+":", err)
+		}
+
+		for dir := path.Dir(strings.TrimSuffix(name, "/")); dir != "."; dir = path.Dir(dir) {
+			directories = append(directories, dir)
+		}
+	}
+
+	// directories are removed deepest first and only once empty, so files added since the install are kept
+	slices.SortFunc(directories, func(a, b string) int {
+		if depth := strings.Count(b, "/") - strings.Count(a, "/"); depth != 0 {
+			return depth
+		}
+		return strings.Compare(a, b)
+	})
+	for _, dir := range slices.Compact(directories) {
+		os.Remove(filepath.FromSlash(dir))
+	}
+
+	common.RemoveIfExists(settings.PythonExtractDir)
+	common.RemoveIfExists(common.BootstrapMarkerFilename)
+	common.RemoveIfExists(common.SecretsFilename)
+
+	return nil
+}
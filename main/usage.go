@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"lukasolson.net/common"
+	"os"
+	"time"
+)
+
+// usageRecord is one line of the usage log, describing a single run of the payload script.
+type usageRecord struct {
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	ExitCode        int       `json:"exitCode"`
+	PeakMemoryBytes uint64    `json:"peakMemoryBytes,omitempty"`
+	ToolVersion     string    `json:"toolVersion"`
+}
+
+// runScriptWithUsageLog runs the payload script and appends a record of the run to the usage log.
+func runScriptWithUsageLog(pythonPath string, args []string, logPath string) error {
+	start := time.Now()
+
+	usage, err := common.RunCommandMeasured(pythonPath, args)
+
+	end := time.Now()
+
+	record := usageRecord{
+		Start:           start.UTC(),
+		End:             end.UTC(),
+		DurationSeconds: end.Sub(start).Seconds(),
+		ExitCode:        usage.ExitCode,
+		PeakMemoryBytes: usage.PeakMemory,
+		ToolVersion:     common.Version,
+	}
+
+	if logErr := appendUsageRecord(logPath, record); logErr != nil {
+		fmt.Println("Error writing usage log:", logErr)
+	}
+
+	return err
+}
+
+func appendUsageRecord(logPath string, record usageRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	_, err = logFile.Write(append(line, '\n'))
+	return err
+}
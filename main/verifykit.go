@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/maja42/ember"
+	"io"
+	"lukasolson.net/common"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const verifyInstructions = `Verification kit for %[1]s
+
+Created by exepy %[2]s.
+
+Contents:
+  hash.txt       %[3]s hash of the complete installer
+  stub-hash.txt  %[3]s hash of the installer without its attachments
+  hashes.json    %[3]s hash of every embedded attachment
+  metadata.json  description of every embedded attachment (if recorded)
+  settings.json  the embedded settings
+  sbom.json      Python packages contained in the embedded wheels
+  %[6]s  signature over the hashes of these files, if the kit was signed
+
+To verify the installer, run on the machine holding it, with the publisher's public key obtained separately:
+  ExePy-Creator.exe verify %[5]s --kit <this directory> --public-key <key>
+
+The installer hash can also be checked without exepy:
+  certutil -hashfile %[5]s %[4]s
+
+Checked without the signature, the kit only shows that the installer was not corrupted: anyone who can change
+the installer can also change an unsigned kit.
+`
+
+// SoftwareBillOfMaterials lists the Python packages shipped in an installer's wheels attachment.
+type SoftwareBillOfMaterials struct {
+	Installer string      `json:"installer"`
+	Packages  []SBOMEntry `json:"packages"`
+}
+
+type SBOMEntry struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Wheel   string `json:"wheel"`
+}
+
+// exportVerifyKit writes everything needed to verify an installer offline into a directory, signing the kit if
+// given a key.
+// Usage: verify-kit installer.exe --out kitdir [--key private.key]
+func exportVerifyKit(args []string) {
+	installerPath, args := splitPositional(args)
+
+	flags := flag.NewFlagSet("verify-kit", flag.ExitOnError)
+	outDir := flags.String("out", "verify-kit", "directory to write the verification kit to")
+	keyPath := flags.String("key", "", "file holding the base64-encoded Ed25519 private key to sign the kit with")
+	_ = flags.Parse(args)
+
+	if installerPath == "" {
+		fmt.Println("Usage: verify-kit <installer.exe> --out <directory> [--key <private key>]")
+		return
+	}
+
+	privateKey := ""
+	if *keyPath != "" {
+		key, err := os.ReadFile(*keyPath)
+		if err != nil {
+			fmt.Println("Error reading private key:", err)
+			return
+		}
+		privateKey = strings.TrimSpace(string(key))
+	}
+
+	attachments, err := ember.OpenExe(installerPath)
+	if err != nil {
+		fmt.Println("Error opening installer:", err)
+		return
+	}
+	defer attachments.Close()
+
+	if err := os.MkdirAll(*outDir, os.ModePerm); err != nil {
+		fmt.Println("Error creating kit directory:", err)
+		return
+	}
+
+	exeHash, err := common.HashFile(installerPath)
+	if err != nil {
+		fmt.Println("Error hashing installer:", err)
+		return
+	}
+
+	stubHash, err := hashStub(installerPath, common.HashAlgorithm)
+	if err != nil {
+		fmt.Println("Error hashing installer stub:", err)
+		return
+	}
+
+	files := map[string]string{
+		"hash.txt":      exeHash,
+		"stub-hash.txt": stubHash,
+	}
+
+	// copy the embedded records verbatim so the kit matches exactly what was shipped
+	embedded := map[string]string{
+		"hashes.json":   common.HashesEmbedName,
+		"metadata.json": common.MetadataEmbedName,
+		"settings.json": common.GetConfigEmbedName(),
+	}
+
+	for filename, attachment := range embedded {
+		reader := attachments.Reader(attachment)
+		if reader == nil {
+			continue
+		}
+
+		contents, err := io.ReadAll(reader)
+		if err != nil {
+			fmt.Println("Error reading attachment:", attachment, err)
+			return
+		}
+
+		files[filename] = string(contents)
+	}
+
+	sbom, err := buildSBOM(installerPath, attachments)
+	if err != nil {
+		fmt.Println("Error listing embedded wheels:", err)
+		return
+	}
+
+	sbomJSON, err := json.MarshalIndent(sbom, "", "  ")
+	if err != nil {
+		fmt.Println("Error encoding SBOM:", err)
+		return
+	}
+
+	files["sbom.json"] = string(sbomJSON)
+	installerName := filepath.Base(installerPath)
+	files["VERIFY.txt"] = fmt.Sprintf(verifyInstructions, installerName, common.Version, strings.ToUpper(common.HashAlgorithm), strings.ToUpper(common.HashAlgorithm), common.QuoteCmdArg(installerName), common.VerifyKitManifestFilename)
+
+	if privateKey != "" {
+		signed, err := signVerifyKit(installerName, files, privateKey)
+		if err != nil {
+			fmt.Println("Error signing kit:", err)
+			return
+		}
+		files[common.VerifyKitManifestFilename] = string(signed)
+	}
+
+	for filename, contents := range files {
+		if err := common.SaveContentsToFile(filepath.Join(*outDir, filename), contents); err != nil {
+			fmt.Println("Error writing", filename+":", err)
+			return
+		}
+	}
+
+	fmt.Println("Verification kit written to", *outDir)
+	if privateKey == "" {
+		fmt.Println("Warning: the kit is not signed, so it only detects corruption; pass --key to sign it.")
+	}
+}
+
+// signVerifyKit returns the signed manifest of the kit files, keyed by name, for the installer named installerName.
+func signVerifyKit(installerName string, files map[string]string, privateKey string) ([]byte, error) {
+	manifest := common.VerifyKitManifest{
+		Installer: installerName,
+		Algorithm: common.HashAlgorithm,
+		Files:     make(map[string]string, len(files)),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	for filename, contents := range files {
+		hash, err := common.HashReaderWith(common.HashAlgorithm, strings.NewReader(contents))
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files[filename] = hash
+	}
+
+	return common.SignVerifyKit(manifest, privateKey)
+}
+
+// checkVerifyKitSignature checks the signed manifest of the kit in kitDir against publicKey, and every kit file
+// the installer is checked with against the manifest.
+func checkVerifyKitSignature(kitDir, publicKey string) bool {
+	data, err := os.ReadFile(filepath.Join(kitDir, common.VerifyKitManifestFilename))
+	if err != nil {
+		fmt.Println("Error reading kit signature:", err)
+		return false
+	}
+
+	manifest, err := common.VerifyVerifyKit(data, publicKey)
+	if err != nil {
+		fmt.Println("Kit signature is not valid:", err)
+		return false
+	}
+
+	for _, required := range []string{"hash.txt", "hashes.json"} {
+		if _, ok := manifest.Files[required]; !ok {
+			fmt.Println("Kit signature does not cover", required)
+			return false
+		}
+	}
+
+	allHashesMatch := true
+
+	for filename, expected := range manifest.Files {
+		if !filepath.IsLocal(filename) {
+			fmt.Println("Kit signature lists a file outside the kit:", filename)
+			return false
+		}
+
+		actual, err := common.HashFileWith(manifest.Algorithm, filepath.Join(kitDir, filename))
+		if err != nil {
+			fmt.Println("Error hashing kit file:", err)
+			allHashesMatch = false
+			continue
+		}
+
+		if actual != expected {
+			fmt.Println("Kit file does not match its signature:", filename)
+			allHashesMatch = false
+		}
+	}
+
+	if allHashesMatch {
+		fmt.Println("Kit signature is valid, signed for", manifest.Installer, "on", manifest.CreatedAt.Format(time.RFC3339))
+	}
+
+	return allHashesMatch
+}
+
+func buildSBOM(installerPath string, attachments *ember.Attachments) (SoftwareBillOfMaterials, error) {
+	sbom := SoftwareBillOfMaterials{Installer: filepath.Base(installerPath), Packages: []SBOMEntry{}}
+
+	wheelsReader := attachments.Reader(common.WheelsFilename)
+	if wheelsReader == nil {
+		return sbom, nil
+	}
+
+	settings, err := GetSettings(attachments)
+	if err != nil {
+		return sbom, err
+	}
+
+	names, err := common.ListArchive(wheelsReader, settings.CompressionFormat)
+	if err != nil {
+		return sbom, err
+	}
+
+	for _, name := range names {
+		wheel := filepath.Base(name)
+		if !strings.HasSuffix(wheel, ".whl") {
+			continue
+		}
+
+		// wheel filenames are {name}-{version}(-{build})?-{python}-{abi}-{platform}.whl
+		parts := strings.Split(strings.TrimSuffix(wheel, ".whl"), "-")
+		if len(parts) < 2 {
+			continue
+		}
+
+		sbom.Packages = append(sbom.Packages, SBOMEntry{Name: parts[0], Version: parts[1], Wheel: wheel})
+	}
+
+	sort.Slice(sbom.Packages, func(i, j int) bool {
+		return sbom.Packages[i].Name < sbom.Packages[j].Name
+	})
+
+	return sbom, nil
+}
+
+// verifyWithKit checks an installer against a verification kit and exits non-zero on any mismatch. With a public
+// key, the kit must be signed with the matching private key.
+// Usage: verify installer.exe --kit kitdir [--public-key key]
+func verifyWithKit(args []string) {
+	installerPath, args := splitPositional(args)
+
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	kitDir := flags.String("kit", "verify-kit", "directory containing the verification kit")
+	publicKey := flags.String("public-key", "", "base64-encoded Ed25519 public key the kit must be signed with")
+	_ = flags.Parse(args)
+
+	if installerPath == "" {
+		fmt.Println("Usage: verify <installer.exe> --kit <directory> [--public-key <key>]")
+		return
+	}
+
+	if *publicKey != "" {
+		if !checkVerifyKitSignature(*kitDir, *publicKey) {
+			fmt.Println("Verification FAILED.")
+			os.Exit(1)
+		}
+	} else {
+		fmt.Println("Warning: the kit's signature is not checked without --public-key, so this only shows that the installer matches the kit, not who made either.")
+	}
+
+	if !verifyInstaller(installerPath, *kitDir) {
+		fmt.Println("Verification FAILED.")
+		os.Exit(1)
+	}
+
+	fmt.Println("Verification succeeded.")
+}
+
+func verifyInstaller(installerPath, kitDir string) bool {
+	expectedHash, err := os.ReadFile(filepath.Join(kitDir, "hash.txt"))
+	if err != nil {
+		fmt.Println("Error reading kit:", err)
+		return false
+	}
+
+	// kits made by earlier releases record MD5 hashes
+	exeHash, err := common.HashFileWith(common.HashAlgorithmOf(string(expectedHash)), installerPath)
+	if err != nil {
+		fmt.Println("Error hashing installer:", err)
+		return false
+	}
+
+	if strings.TrimSpace(string(expectedHash)) != exeHash {
+		fmt.Println("Installer hash mismatch -> Expected:", strings.TrimSpace(string(expectedHash)), "Actual:", exeHash)
+		return false
+	}
+
+	fmt.Println("Installer hash matches:", exeHash)
+
+	kitHashes, err := os.ReadFile(filepath.Join(kitDir, "hashes.json"))
+	if err != nil {
+		fmt.Println("Error reading kit:", err)
+		return false
+	}
+
+	attachments, err := ember.OpenExe(installerPath)
+	if err != nil {
+		fmt.Println("Error opening installer:", err)
+		return false
+	}
+	defer attachments.Close()
+
+	manifest, err := common.ReadHashManifest(kitHashes, attachments.Reader(common.MetadataEmbedName) == nil)
+	if err != nil {
+		fmt.Println("Error parsing kit hashes:", err)
+		return false
+	}
+
+	allHashesMatch := true
+
+	for _, attachment := range attachments.List() {
+		if attachment == common.HashesEmbedName {
+			continue
+		}
+
+		expected, ok := manifest.Hashes[attachment]
+		if !ok {
+			fmt.Println("Attachment not listed in kit:", attachment)
+			allHashesMatch = false
+			continue
+		}
+
+		actualHash, hashesMatch := ValidateHash(attachments.Reader(attachment), manifest.Algorithm, expected)
+		if !hashesMatch {
+			fmt.Println("Attachment hash mismatch for:", attachment, " -> Expected:", expected, "Actual:", actualHash)
+			allHashesMatch = false
+		} else {
+			fmt.Println("Attachment hash matches for:", attachment, " ->", actualHash)
+		}
+	}
+
+	for attachment := range manifest.Hashes {
+		if attachments.Reader(attachment) == nil {
+			fmt.Println("Attachment listed in kit is missing from installer:", attachment)
+			allHashesMatch = false
+		}
+	}
+
+	return allHashesMatch
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"lukasolson.net/common"
+	"path/filepath"
+	"strings"
+)
+
+// installWheelsDirectly installs every wheel in the embedded wheelhouse into the Python installation without
+// pip. It is used when pip fails or is blocked, and only installs pure-Python wheels; the first wheel that
+// cannot be installed stops it.
+func installWheelsDirectly(settings common.PythonSetupSettings) error {
+	wheelsDir := filepath.Join(settings.PythonExtractDir, common.WheelsFilename)
+
+	var wheels []string
+	err := filepath.WalkDir(wheelsDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && strings.EqualFold(filepath.Ext(path), ".whl") {
+			wheels = append(wheels, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(wheels) == 0 {
+		return errors.New("no wheels to install in " + wheelsDir)
+	}
+
+	for _, wheel := range wheels {
+		fmt.Println("Installing", filepath.Base(wheel), "without pip")
+
+		if err := common.InstallWheel(wheel, settings.PythonExtractDir); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(wheel), err)
+		}
+	}
+
+	return nil
+}